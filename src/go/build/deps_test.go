@@ -473,6 +473,7 @@ var depsRules = `
 	< net/http/httptrace;
 
 	compress/gzip,
+	encoding/json,
 	golang.org/x/net/http/httpguts,
 	golang.org/x/net/http/httpproxy,
 	golang.org/x/net/http2/hpack,
@@ -17,6 +17,11 @@ import (
 // data is too large to be processed.
 var ErrMessageTooLarge = errors.New("multipart: message too large")
 
+// ErrTooManyTempFiles is returned by ReadForm if storing the form's
+// file parts would take more temporary files than the Reader's
+// MaxTempFiles allows.
+var ErrTooManyTempFiles = errors.New("multipart: too many temporary files")
+
 // TODO(adg,bradfitz): find a way to unify the DoS-prevention strategy here
 // with that of the http package's ParseForm.
 
@@ -26,7 +31,11 @@ var ErrMessageTooLarge = errors.New("multipart: message too large")
 // in memory. File parts which can't be stored in memory will be stored on
 // disk in temporary files.
 // It returns ErrMessageTooLarge if all non-file parts can't be stored in
-// memory.
+// memory, and ErrTooManyTempFiles if the file parts need more temporary
+// files than r.MaxTempFiles permits.
+//
+// Each temporary file is closed once its part has been copied to it,
+// so ReadForm holds at most one of them open at a time.
 func (r *Reader) ReadForm(maxMemory int64) (*Form, error) {
 	return r.readForm(maxMemory)
 }
@@ -48,6 +57,7 @@ func (r *Reader) readForm(maxMemory int64) (_ *Form, err error) {
 			maxValueBytes = math.MaxInt64
 		}
 	}
+	tempFiles := 0
 	for {
 		p, err := r.NextPart()
 		if err == io.EOF {
@@ -90,6 +100,10 @@ func (r *Reader) readForm(maxMemory int64) (_ *Form, err error) {
 		}
 		if n > maxMemory {
 			// too big, write to disk and flush buffer
+			if r.MaxTempFiles > 0 && tempFiles >= r.MaxTempFiles {
+				return nil, ErrTooManyTempFiles
+			}
+			tempFiles++
 			file, err := os.CreateTemp("", "multipart-")
 			if err != nil {
 				return nil, err
@@ -234,3 +234,36 @@ Content-Disposition: form-data; name="largetext"
 		})
 	}
 }
+
+func TestReadFormMaxTempFiles(t *testing.T) {
+	var buf bytes.Buffer
+	mw := NewWriter(&buf)
+	for _, name := range []string{"a", "b", "c"} {
+		fw, err := mw.CreateFormFile(name, name+".txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(fw, "contents of "+name)
+	}
+	mw.Close()
+
+	for _, tt := range []struct {
+		maxTempFiles int
+		wantErr      error
+	}{
+		{0, nil},
+		{3, nil},
+		{2, ErrTooManyTempFiles},
+	} {
+		r := NewReader(bytes.NewReader(buf.Bytes()), mw.Boundary())
+		r.MaxTempFiles = tt.maxTempFiles
+		f, err := r.ReadForm(0)
+		if err != tt.wantErr {
+			t.Errorf("MaxTempFiles=%d: ReadForm error = %v; want %v", tt.maxTempFiles, err, tt.wantErr)
+		}
+		if err == nil {
+			testFile(t, f.File["c"][0], "c.txt", "contents of c").Close()
+			f.RemoveAll()
+		}
+	}
+}
@@ -15,6 +15,7 @@ package multipart
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"mime"
@@ -26,6 +27,18 @@ import (
 
 var emptyParams = make(map[string]string)
 
+var (
+	// ErrTooManyParts is returned by NextPart, NextRawPart, and
+	// ReadForm when the message has more parts than the Reader's
+	// MaxParts allows.
+	ErrTooManyParts = errors.New("multipart: too many parts")
+
+	// ErrPartHeaderTooLarge is returned by NextPart, NextRawPart,
+	// and ReadForm when the header of a part is longer than the
+	// Reader's MaxPartHeaderBytes allows.
+	ErrPartHeaderTooLarge = errors.New("multipart: part header too large")
+)
+
 // This constant needs to be at least 76 for this package to work correctly.
 // This is because \r\n--separator_of_len_70- would fill the buffer and it
 // wouldn't be safe to consume a single byte from it.
@@ -150,7 +163,33 @@ func newPart(mr *Reader, rawPart bool) (*Part, error) {
 }
 
 func (bp *Part) populateHeaders() error {
-	r := textproto.NewReader(bp.mr.bufReader)
+	br := bp.mr.bufReader
+	if max := bp.mr.MaxPartHeaderBytes; max > 0 {
+		// Read the header up to its blank line, within max bytes,
+		// before parsing it.
+		var buf []byte
+		atLineStart := true
+		for {
+			line, err := br.ReadSlice('\n')
+			if len(buf)+len(line) > max {
+				return ErrPartHeaderTooLarge
+			}
+			buf = append(buf, line...)
+			if err == bufio.ErrBufferFull {
+				atLineStart = false
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if atLineStart && len(bytes.TrimRight(line, "\r\n")) == 0 {
+				break
+			}
+			atLineStart = true
+		}
+		br = bufio.NewReader(bytes.NewReader(buf))
+	}
+	r := textproto.NewReader(br)
 	header, err := r.ReadMIMEHeader()
 	if err == nil {
 		bp.Header = header
@@ -293,6 +332,26 @@ func (p *Part) Close() error {
 // Reader's underlying parser consumes its input as needed. Seeking
 // isn't supported.
 type Reader struct {
+	// MaxTempFiles limits the number of file parts that ReadForm
+	// stores in temporary files on disk. ReadForm returns
+	// ErrTooManyTempFiles if a form needs more than this many.
+	// If zero, there is no limit.
+	MaxTempFiles int
+
+	// MaxParts limits the number of parts the Reader returns.
+	// Once it has returned that many, NextPart and NextRawPart
+	// return ErrTooManyParts at the next part's boundary, so that
+	// a message of many small parts is rejected as it is read.
+	// If zero, there is no limit.
+	MaxParts int
+
+	// MaxPartHeaderBytes limits the size of the header of each
+	// part, including its blank line. NextPart and NextRawPart
+	// return ErrPartHeaderTooLarge for a part with a longer header,
+	// without reading more than the limit. If zero, there is no
+	// limit.
+	MaxPartHeaderBytes int
+
 	bufReader *bufio.Reader
 
 	currentPart *Part
@@ -347,6 +406,9 @@ func (r *Reader) nextPart(rawPart bool) (*Part, error) {
 		}
 
 		if r.isBoundaryDelimiterLine(line) {
+			if r.MaxParts > 0 && r.partsRead >= r.MaxParts {
+				return nil, ErrTooManyParts
+			}
 			r.partsRead++
 			bp, err := newPart(r, rawPart)
 			if err != nil {
@@ -958,3 +958,83 @@ func TestNoBoundary(t *testing.T) {
 		t.Errorf("NextPart error = %v; want %v", got, want)
 	}
 }
+
+func TestReaderLimits(t *testing.T) {
+	var buf bytes.Buffer
+	mw := NewWriter(&buf)
+	for i := 0; i < 3; i++ {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{"X-Pad": {strings.Repeat("x", 100)}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(pw, "part %d", i)
+	}
+	mw.Close()
+
+	for _, tt := range []struct {
+		maxParts, maxHeader int
+		wantParts           int
+		wantErr             error
+	}{
+		{0, 0, 3, io.EOF},
+		{3, 0, 3, io.EOF},
+		{2, 0, 2, ErrTooManyParts},
+		{0, 200, 3, io.EOF},
+		{0, 50, 0, ErrPartHeaderTooLarge},
+	} {
+		r := NewReader(bytes.NewReader(buf.Bytes()), mw.Boundary())
+		r.MaxParts = tt.maxParts
+		r.MaxPartHeaderBytes = tt.maxHeader
+		parts := 0
+		var err error
+		for {
+			var p *Part
+			if p, err = r.NextPart(); err != nil {
+				break
+			}
+			if got := p.Header.Get("X-Pad"); len(got) != 100 {
+				t.Errorf("part %d: X-Pad header of %d bytes; want 100", parts, len(got))
+			}
+			b, _ := io.ReadAll(p)
+			if want := fmt.Sprintf("part %d", parts); string(b) != want {
+				t.Errorf("part %d: body %q; want %q", parts, b, want)
+			}
+			parts++
+		}
+		if parts != tt.wantParts || err != tt.wantErr {
+			t.Errorf("MaxParts=%d MaxPartHeaderBytes=%d: read %d parts, then %v; want %d, then %v",
+				tt.maxParts, tt.maxHeader, parts, err, tt.wantParts, tt.wantErr)
+		}
+	}
+
+	// A header line longer than the Reader's buffer.
+	buf.Reset()
+	mw = NewWriter(&buf)
+	long := strings.Repeat("y", 2*peekBufferSize)
+	pw, err := mw.CreatePart(textproto.MIMEHeader{"X-Long": {long}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(pw, "body")
+	mw.Close()
+	for _, max := range []int{3 * peekBufferSize, peekBufferSize} {
+		r := NewReader(bytes.NewReader(buf.Bytes()), mw.Boundary())
+		r.MaxPartHeaderBytes = max
+		p, err := r.NextPart()
+		if max < len(long) {
+			if err != ErrPartHeaderTooLarge {
+				t.Errorf("MaxPartHeaderBytes=%d: NextPart error = %v; want ErrPartHeaderTooLarge", max, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("MaxPartHeaderBytes=%d: %v", max, err)
+		}
+		if p.Header.Get("X-Long") != long {
+			t.Errorf("MaxPartHeaderBytes=%d: X-Long header of %d bytes; want %d", max, len(p.Header.Get("X-Long")), len(long))
+		}
+		if b, _ := io.ReadAll(p); string(b) != "body" {
+			t.Errorf("MaxPartHeaderBytes=%d: body = %q; want body", max, b)
+		}
+	}
+}
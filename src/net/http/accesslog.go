@@ -0,0 +1,106 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Sampled access logging.
+
+package http
+
+import (
+	"log"
+	"math/rand"
+	"net/http/internal"
+	"time"
+)
+
+// An AccessLogRecord describes a request served by a handler returned
+// by NewAccessLogHandler.
+type AccessLogRecord struct {
+	Method     string
+	Path       string // the request's URL.Path when the request arrived
+	RemoteAddr string
+	RequestID  string // from AccessLogConfig.RequestIDHeader, if sent
+	Status     int
+	Bytes      int64 // number of response body bytes written
+	Duration   time.Duration
+}
+
+// AccessLogConfig configures a handler returned by NewAccessLogHandler.
+type AccessLogConfig struct {
+	// Log optionally specifies a function that is called with the
+	// record of each sampled request after the handler has
+	// returned. If nil, records are written to Logger.
+	Log func(rec *AccessLogRecord)
+
+	// Logger specifies the logger used when Log is nil.
+	// If nil, the log package's standard logger is used.
+	Logger *log.Logger
+
+	// SampleRate optionally reports the fraction, from 0 to 1, of
+	// requests with the given response status code to log, such as
+	// 0.01 for 2xx responses and 1 for 5xx. If nil, every request
+	// is logged.
+	SampleRate func(status int) float64
+
+	// RequestIDHeader names the request header carrying a request
+	// ID. If empty, "X-Request-Id" is used. Requests with an ID are
+	// sampled deterministically: at a given rate, a request with
+	// the same ID is always logged or always skipped, so that all
+	// services handling a request make the same choice.
+	RequestIDHeader string
+}
+
+// NewAccessLogHandler returns a handler that runs next and logs a
+// record of each request it serves, subject to sampling by cfg.
+//
+// The ResponseWriter passed to next is made by NewMetricsResponseWriter,
+// and supports the methods described there.
+func NewAccessLogHandler(next Handler, cfg AccessLogConfig) Handler {
+	if cfg.RequestIDHeader == "" {
+		cfg.RequestIDHeader = "X-Request-Id"
+	}
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		rec := &AccessLogRecord{
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			RemoteAddr: r.RemoteAddr,
+			RequestID:  r.Header.Get(cfg.RequestIDHeader),
+		}
+		start := time.Now()
+		mw := NewMetricsResponseWriter(w)
+		next.ServeHTTP(mw, r)
+		rec.Duration = time.Since(start)
+		rec.Status = mw.Status()
+		rec.Bytes = mw.Written()
+		if !cfg.sampled(rec) {
+			return
+		}
+		if cfg.Log != nil {
+			cfg.Log(rec)
+			return
+		}
+		logf := log.Printf
+		if cfg.Logger != nil {
+			logf = cfg.Logger.Printf
+		}
+		logf("%s %s %s %d %d %v %s", rec.RemoteAddr, rec.Method, rec.Path, rec.Status, rec.Bytes, rec.Duration, rec.RequestID)
+	})
+}
+
+// sampled reports whether rec should be logged.
+func (cfg *AccessLogConfig) sampled(rec *AccessLogRecord) bool {
+	if cfg.SampleRate == nil {
+		return true
+	}
+	rate := cfg.SampleRate(rec.Status)
+	switch {
+	case rate >= 1:
+		return true
+	case rate <= 0:
+		return false
+	case rec.RequestID != "":
+		// Map the ID's hash to [0, 1).
+		return float64(internal.HashString(rec.RequestID)>>11)/(1<<53) < rate
+	}
+	return rand.Float64() < rate
+}
@@ -0,0 +1,134 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"io"
+	"log"
+	. "net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAccessLogHandler(t *testing.T) {
+	var recs []AccessLogRecord
+	h := NewAccessLogHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		code, _ := strconv.Atoi(r.FormValue("code"))
+		if code != 0 {
+			w.WriteHeader(code)
+		}
+		io.WriteString(w, "hello")
+	}), AccessLogConfig{
+		Log: func(rec *AccessLogRecord) { recs = append(recs, *rec) },
+		SampleRate: func(status int) float64 {
+			if status >= 500 {
+				return 1
+			}
+			return 0
+		},
+	})
+
+	for _, code := range []string{"", "200", "500", "503"} {
+		req := httptest.NewRequest("POST", "/p?code="+code, nil)
+		req.Header.Set("X-Request-Id", "id-"+code)
+		h.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if len(recs) != 2 {
+		t.Fatalf("got %d records; want 2 for the 5xx responses: %+v", len(recs), recs)
+	}
+	for i, want := range []int{500, 503} {
+		rec := recs[i]
+		if rec.Status != want || rec.Method != "POST" || rec.Path != "/p" || rec.Bytes != 5 || rec.RequestID != "id-"+strconv.Itoa(want) {
+			t.Errorf("record %d = %+v; want status %d for POST /p with 5 bytes", i, rec, want)
+		}
+	}
+}
+
+func TestAccessLogHandlerLogger(t *testing.T) {
+	var buf bytes.Buffer
+	h := NewAccessLogHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		NewResponseController(w).Flush()
+		io.WriteString(w, "hi")
+	}), AccessLogConfig{Logger: log.New(&buf, "", 0)})
+	req := httptest.NewRequest("GET", "/x", nil)
+	req.RemoteAddr = "192.0.2.1:1234"
+	rw := httptest.NewRecorder()
+	h.ServeHTTP(rw, req)
+	if !rw.Flushed {
+		t.Errorf("ResponseController.Flush didn't reach the underlying ResponseWriter")
+	}
+	if got := buf.String(); !strings.HasPrefix(got, "192.0.2.1:1234 GET /x 200 2 ") {
+		t.Errorf("logged %q; want record of GET /x with status 200 and 2 bytes", got)
+	}
+}
+
+func TestAccessLogHandlerSampleByRequestID(t *testing.T) {
+	logged := make(map[string]int)
+	h := NewAccessLogHandler(HandlerFunc(func(w ResponseWriter, r *Request) {}), AccessLogConfig{
+		Log:             func(rec *AccessLogRecord) { logged[rec.RequestID]++ },
+		SampleRate:      func(int) float64 { return 0.5 },
+		RequestIDHeader: "Request-Id",
+	})
+	const ids, tries = 200, 3
+	for i := 0; i < ids; i++ {
+		for j := 0; j < tries; j++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Request-Id", "req-"+strconv.Itoa(i))
+			h.ServeHTTP(httptest.NewRecorder(), req)
+		}
+	}
+	for id, n := range logged {
+		if n != tries {
+			t.Errorf("request ID %q logged %d of %d times; want all or none", id, n, tries)
+		}
+	}
+	if n := len(logged); n < ids/4 || n > ids*3/4 {
+		t.Errorf("logged %d of %d request IDs; want about half", n, ids)
+	}
+}
+
+func TestAccessLogHandlerInformational(t *testing.T) {
+	var rec AccessLogRecord
+	h := NewAccessLogHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusEarlyHints)
+		w.WriteHeader(StatusCreated)
+		io.WriteString(w, "made")
+	}), AccessLogConfig{Log: func(r *AccessLogRecord) { rec = *r }})
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if rec.Status != StatusCreated || rec.Bytes != 4 {
+		t.Errorf("after 103 and 201: record status %d, %d bytes; want 201, 4 bytes", rec.Status, rec.Bytes)
+	}
+}
+
+func TestAccessLogHandlerHijack(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	recs := make(chan AccessLogRecord, 1)
+	ts := httptest.NewServer(NewAccessLogHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		c, _, err := w.(Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+		c.Close()
+	}), AccessLogConfig{Log: func(rec *AccessLogRecord) { recs <- *rec }}))
+	defer ts.Close()
+
+	res, err := ts.Client().Get(ts.URL + "/hijack")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusNoContent {
+		t.Errorf("status %d; want the hijacker's 204", res.StatusCode)
+	}
+	if rec := <-recs; rec.Path != "/hijack" {
+		t.Errorf("got record for %s; want /hijack", rec.Path)
+	}
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Cache keys for responses, as described in RFC 7234.
+
+package http
+
+import (
+	"net/http/internal/ascii"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// CacheKey returns the key under which a cache would store resp, the
+// response to r, so that two requests with the same key may be served
+// the same stored response. The key is made of r's method and URL and
+// of the values r carries for each header named by resp's Vary header;
+// headers named by Vary but absent from r take part in the key too,
+// as absent. Header names are compared case-insensitively, and the
+// order in which Vary lists them does not matter.
+//
+// The ok result is false if resp may not be stored by a shared cache:
+// if r's method is not GET or HEAD, if r or resp has a Cache-Control
+// no-store directive, or if Vary is "*", which means that the response
+// may depend on more than the request headers.
+func CacheKey(r *Request, resp *Response) (key string, ok bool) {
+	if r.Method != "" && r.Method != "GET" && r.Method != "HEAD" {
+		return "", false
+	}
+	if hasCacheDirective(r.Header, "no-store") || hasCacheDirective(resp.Header, "no-store") {
+		return "", false
+	}
+	var vary []string
+	for _, v := range resp.Header["Vary"] {
+		for _, name := range strings.Split(v, ",") {
+			name = textproto.TrimString(name)
+			if name == "*" {
+				return "", false
+			}
+			if name != "" {
+				vary = append(vary, CanonicalHeaderKey(name))
+			}
+		}
+	}
+	sort.Strings(vary)
+
+	var b strings.Builder
+	method := r.Method
+	if method == "" {
+		method = "GET"
+	}
+	b.WriteString(method)
+	b.WriteByte(' ')
+	b.WriteString(cacheURL(r))
+	for i, name := range vary {
+		if i > 0 && name == vary[i-1] {
+			continue
+		}
+		b.WriteByte('\n')
+		b.WriteString(name)
+		if vv, ok := r.Header[name]; ok {
+			b.WriteString(": ")
+			for j, v := range vv {
+				if j > 0 {
+					b.WriteString(", ")
+				}
+				b.WriteString(textproto.TrimString(v))
+			}
+		}
+	}
+	return b.String(), true
+}
+
+// cacheURL returns the absolute URL of r, with its scheme and host
+// in lower case, for use in a cache key.
+func cacheURL(r *Request) string {
+	scheme, host := r.URL.Scheme, r.URL.Host
+	if scheme == "" {
+		scheme = "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+	}
+	if host == "" {
+		host = r.Host
+	}
+	path := r.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	if s, ok := ascii.ToLower(scheme); ok {
+		scheme = s
+	}
+	if h, ok := ascii.ToLower(host); ok {
+		host = h
+	}
+	s := scheme + "://" + host + path
+	if r.URL.RawQuery != "" {
+		s += "?" + r.URL.RawQuery
+	}
+	return s
+}
+
+// hasCacheDirective reports whether h has a Cache-Control header
+// with the given directive, ignoring case and any directive value.
+func hasCacheDirective(h Header, directive string) bool {
+	for _, v := range h["Cache-Control"] {
+		for _, d := range strings.Split(v, ",") {
+			d, _, _ = strings.Cut(d, "=")
+			if ascii.EqualFold(textproto.TrimString(d), directive) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheKey(t *testing.T) {
+	req := func(method, url string, h ...string) *Request {
+		r := httptest.NewRequest(method, url, nil)
+		for i := 0; i < len(h); i += 2 {
+			r.Header.Add(h[i], h[i+1])
+		}
+		return r
+	}
+	res := func(h ...string) *Response {
+		r := &Response{Header: Header{}}
+		for i := 0; i < len(h); i += 2 {
+			r.Header.Add(h[i], h[i+1])
+		}
+		return r
+	}
+	key := func(r *Request, resp *Response) string {
+		t.Helper()
+		k, ok := CacheKey(r, resp)
+		if !ok {
+			t.Fatalf("CacheKey(%s %v) not ok", r.Method, r.URL)
+		}
+		return k
+	}
+
+	// Headers not named by Vary don't matter.
+	if a, b := key(req("GET", "http://Example.com/a?x=1", "Accept", "text/html"), res()),
+		key(req("GET", "http://example.com/a?x=1", "Accept", "image/png"), res()); a != b {
+		t.Errorf("keys differ without Vary: %q, %q", a, b)
+	}
+	if a, b := key(req("GET", "http://example.com/a"), res()),
+		key(req("GET", "http://example.com/b"), res()); a == b {
+		t.Errorf("keys for different URLs are equal: %q", a)
+	}
+
+	// Vary names are case-insensitive and unordered.
+	vary := res("Vary", "accept-encoding, Accept-Language")
+	vary2 := res("Vary", "Accept-Language", "Vary", "Accept-Encoding")
+	r1 := req("GET", "http://example.com/", "Accept-Encoding", "gzip", "Accept-Language", "en")
+	r2 := req("GET", "http://example.com/", "Accept-Encoding", "gzip", "Accept-Language", "fr")
+	r3 := req("GET", "http://example.com/", "Accept-Encoding", "gzip")
+	if a, b := key(r1, vary), key(r1, vary2); a != b {
+		t.Errorf("keys differ for equivalent Vary headers: %q, %q", a, b)
+	}
+	if a, b := key(r1, vary), key(r2, vary); a == b {
+		t.Errorf("keys equal for different varying header values: %q", a)
+	}
+	if a, b := key(r2, vary), key(r3, vary); a == b {
+		t.Errorf("keys equal with and without a varying header: %q", a)
+	}
+	if a, b := key(r3, vary), key(req("GET", "http://example.com/", "Accept-Encoding", "gzip", "Accept-Language", ""), vary); a == b {
+		t.Errorf("keys equal for absent and empty varying header: %q", a)
+	}
+
+	for _, tt := range []struct {
+		r    *Request
+		resp *Response
+	}{
+		{req("GET", "http://example.com/"), res("Vary", "Accept, *")},
+		{req("GET", "http://example.com/"), res("Cache-Control", "max-age=60, No-Store")},
+		{req("GET", "http://example.com/", "Cache-Control", "no-store"), res()},
+		{req("POST", "http://example.com/"), res()},
+	} {
+		if k, ok := CacheKey(tt.r, tt.resp); ok {
+			t.Errorf("CacheKey(%s, %v) = %q, true; want uncacheable", tt.r.Method, tt.resp.Header, k)
+		}
+	}
+}
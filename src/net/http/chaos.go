@@ -0,0 +1,134 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Fault injection for testing clients against a flaky server.
+
+package http
+
+import (
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChaosConfig configures a handler returned by NewChaosHandler.
+type ChaosConfig struct {
+	// Rules lists the faults to inject. Each request is subject to
+	// the first rule whose Pattern matches its path, if any.
+	Rules []ChaosRule
+
+	// Rand optionally specifies the source of random fault
+	// choices, such as a seeded source in tests. It is only used
+	// with a lock held. If nil, the top-level functions of the
+	// math/rand package are used.
+	Rand *rand.Rand
+}
+
+// A ChaosRule describes the faults injected into matching requests.
+// Each probability is a fraction from 0 to 1 and is applied
+// independently. A request may first be delayed, and then either
+// dropped, answered with an error, or passed on to the next handler.
+type ChaosRule struct {
+	// Pattern optionally restricts the rule to request paths
+	// matching it, as for a ServeMux pattern without a host name:
+	// a pattern ending in a slash, such as "/api/", matches the
+	// paths it begins, and other patterns match only themselves.
+	// If empty, the rule matches every request.
+	Pattern string
+
+	// Latency is added, with probability LatencyProbability, before
+	// the request is handled. The delay ends early if the request's
+	// context is done.
+	Latency            time.Duration
+	LatencyProbability float64
+
+	// DropProbability is the probability of dropping the request
+	// without a response by panicking with ErrAbortHandler, which
+	// closes an HTTP/1 connection or resets an HTTP/2 stream.
+	DropProbability float64
+
+	// ErrorStatus is the status code of the error responses sent,
+	// with probability ErrorProbability, in place of the next
+	// handler's response. If zero, StatusInternalServerError is used.
+	ErrorStatus      int
+	ErrorProbability float64
+}
+
+func (rule *ChaosRule) matches(path string) bool {
+	p := rule.Pattern
+	if p == "" || p == path {
+		return true
+	}
+	return p[len(p)-1] == '/' && strings.HasPrefix(path, p)
+}
+
+func (rule *ChaosRule) active() bool {
+	return rule.LatencyProbability > 0 || rule.DropProbability > 0 || rule.ErrorProbability > 0
+}
+
+// NewChaosHandler returns a handler that injects the faults described
+// by cfg into the requests it passes on to next: added latency, error
+// responses, and dropped requests. It lets tests check how clients
+// cope with a slow or failing server.
+//
+// If no rule has a positive probability, NewChaosHandler returns next
+// unchanged, so the handler can be configured by a flag and left in
+// place with all probabilities zero.
+func NewChaosHandler(next Handler, cfg ChaosConfig) Handler {
+	active := false
+	for i := range cfg.Rules {
+		active = active || cfg.Rules[i].active()
+	}
+	if !active {
+		return next
+	}
+	rules := append([]ChaosRule(nil), cfg.Rules...)
+	var mu sync.Mutex // guards cfg.Rand
+	chance := func(p float64) bool {
+		if p <= 0 {
+			return false
+		}
+		if cfg.Rand == nil {
+			return rand.Float64() < p
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return cfg.Rand.Float64() < p
+	}
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		var rule *ChaosRule
+		for i := range rules {
+			if rules[i].matches(r.URL.Path) {
+				rule = &rules[i]
+				break
+			}
+		}
+		if rule == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if rule.Latency > 0 && chance(rule.LatencyProbability) {
+			t := time.NewTimer(rule.Latency)
+			select {
+			case <-t.C:
+			case <-r.Context().Done():
+				t.Stop()
+				return
+			}
+		}
+		if chance(rule.DropProbability) {
+			panic(ErrAbortHandler)
+		}
+		if chance(rule.ErrorProbability) {
+			code := rule.ErrorStatus
+			if code == 0 {
+				code = StatusInternalServerError
+			}
+			Error(w, StatusText(code), code)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
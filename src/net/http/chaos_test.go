@@ -0,0 +1,78 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	"math/rand"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChaosHandlerInactive(t *testing.T) {
+	next := RedirectHandler("/", StatusFound)
+	h := NewChaosHandler(next, ChaosConfig{Rules: []ChaosRule{{Latency: time.Hour, ErrorStatus: 503}}})
+	if h != next {
+		t.Errorf("NewChaosHandler with zero probabilities = %v; want next handler", h)
+	}
+}
+
+func TestChaosHandler(t *testing.T) {
+	const delay = 20 * time.Millisecond
+	h := NewChaosHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}), ChaosConfig{Rules: []ChaosRule{
+		{Pattern: "/slow", Latency: delay, LatencyProbability: 1},
+		{Pattern: "/api/", ErrorStatus: StatusServiceUnavailable, ErrorProbability: 1},
+		{Pattern: "/flaky", ErrorProbability: 0.5},
+	}, Rand: rand.New(rand.NewSource(1))})
+	for _, tt := range []struct {
+		path     string
+		wantCode int
+		minDelay time.Duration
+	}{
+		{"/", StatusOK, 0},
+		{"/slow", StatusOK, delay},
+		{"/slow/x", StatusOK, 0},
+		{"/api/x", StatusServiceUnavailable, 0},
+		{"/api", StatusOK, 0},
+	} {
+		rec := httptest.NewRecorder()
+		start := time.Now()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", tt.path, nil))
+		if rec.Code != tt.wantCode || time.Since(start) < tt.minDelay {
+			t.Errorf("%s: got status %d after %v; want %d after at least %v", tt.path, rec.Code, time.Since(start), tt.wantCode, tt.minDelay)
+		}
+	}
+
+	failed := 0
+	const tries = 200
+	for i := 0; i < tries; i++ {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", "/flaky", nil))
+		if rec.Code == StatusInternalServerError {
+			failed++
+		}
+	}
+	if failed < tries/4 || failed > tries*3/4 {
+		t.Errorf("%d of %d requests failed with probability 0.5", failed, tries)
+	}
+}
+
+func TestChaosHandlerDrop(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(NewChaosHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		t.Errorf("dropped request reached next handler")
+	}), ChaosConfig{Rules: []ChaosRule{{DropProbability: 1}}}))
+	defer ts.Close()
+	res, err := ts.Client().Get(ts.URL)
+	if err == nil {
+		res.Body.Close()
+		t.Fatalf("got response %q; want error for dropped request", res.Status)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"sort"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -104,6 +105,118 @@ type Client struct {
 	// RoundTripper implementations should use the Request's Context
 	// for cancellation instead of implementing CancelRequest.
 	Timeout time.Duration
+
+	// FollowMetaRefresh specifies whether the Client follows HTML
+	// meta refresh redirects, such as
+	//
+	//	<meta http-equiv="refresh" content="0; url=/next">
+	//
+	// in addition to HTTP redirects. If true, the body of a 200 OK
+	// response with a Content-Type of text/html and a size of at most
+	// 32 KB is searched for a refresh. A refresh with a URL and a
+	// delay of at most 5 seconds is followed immediately, as a GET
+	// redirect subject to CheckRedirect. Larger responses and other
+	// refreshes are returned unchanged.
+	FollowMetaRefresh bool
+
+	// Retry optionally specifies the policy for retrying requests
+	// that fail or get a response the policy deems transient, such
+	// as an IdempotentRetryPolicy. Each request of a redirect chain
+	// is retried separately. A request with a body is only retried
+	// if its GetBody field is set, so that the body can be sent
+	// again, and the Client doesn't wait for a retry past the
+	// deadline of the request's context or its own Timeout. If nil,
+	// requests are not retried.
+	Retry RetryPolicy
+
+	// RetryBudget optionally limits the retries made under Retry to
+	// a fraction of the requests sent, so that retries can't
+	// multiply the load on servers that are failing. Once the
+	// budget is spent, requests the policy would retry return
+	// their last response or error instead. A RetryBudget may be
+	// shared by multiple Clients. If nil, retries are not limited.
+	RetryBudget *RetryBudget
+
+	// PropagateContextHeaders optionally maps header names to
+	// functions returning their values for a request's context,
+	// such as a request ID stored in the context by a server
+	// handler. The Client sets each header for which the function
+	// returns a non-empty value on each request it sends, unless
+	// the request already has the header. Redirected requests get
+	// the headers only if they have the same scheme, host, and port
+	// as the initial request, so that values are not sent to
+	// other origins. The request passed to Do is not modified.
+	PropagateContextHeaders map[string]func(ctx context.Context) string
+
+	// TrackRequests specifies whether the Client keeps track of the
+	// requests in flight on it, so that CancelAll and Close can
+	// cancel them. To make them cancelable, each request is sent
+	// with a context derived from its own, so the RoundTripper is
+	// passed a copy of the Request rather than the Request itself.
+	// If false, CancelAll does nothing.
+	TrackRequests bool
+
+	state atomic.Value // of *clientState; created on first use
+}
+
+// ErrClientClosed is returned by the Client's Do, Get, Head, Post,
+// and PostForm methods after a call to the Client's Close method.
+var ErrClientClosed = errors.New("http: Client closed")
+
+// clientState records whether a Client is closed and, if it tracks
+// requests, the requests in flight on it, so that CancelAll can
+// interrupt them.
+type clientState struct {
+	closed atomicBool
+
+	mu       sync.Mutex // guards inflight and nextID, and setting closed
+	nextID   uint64
+	inflight map[uint64]context.CancelFunc
+}
+
+func (c *Client) clientState() *clientState {
+	if st, ok := c.state.Load().(*clientState); ok {
+		return st
+	}
+	c.state.CompareAndSwap(nil, &clientState{inflight: make(map[uint64]context.CancelFunc)})
+	return c.state.Load().(*clientState)
+}
+
+// track registers a request made with the context ctx as in flight,
+// if the Client tracks requests. It returns the context the request
+// should use instead and a func to call once the request and its
+// response are finished, or a nil context and func if the request is
+// not tracked.
+func (c *Client) track(ctx context.Context) (_ context.Context, done func(), err error) {
+	if !c.TrackRequests {
+		if st, _ := c.state.Load().(*clientState); st != nil && st.closed.isSet() {
+			return nil, nil, ErrClientClosed
+		}
+		return nil, nil, nil
+	}
+	st := c.clientState()
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.closed.isSet() {
+		return nil, nil, ErrClientClosed
+	}
+	parentCancelable := ctx.Done() != nil
+	ctx, cancel := context.WithCancel(ctx)
+	id := st.nextID
+	st.nextID++
+	st.inflight[id] = cancel
+	done = func() {
+		st.mu.Lock()
+		delete(st.inflight, id)
+		st.mu.Unlock()
+		// Canceling ctx also aborts any dial the Transport is still
+		// completing for its idle pool on this request's behalf.
+		// Only do so when needed to release ctx from its parent.
+		if parentCancelable {
+			cancel()
+		}
+	}
+	return ctx, done, nil
 }
 
 // DefaultClient is the default Client and is used by Get, Head, and Post.
@@ -167,22 +280,116 @@ func refererForURL(lastReq, newReq *url.URL) string {
 }
 
 // didTimeout is non-nil only if err != nil.
-func (c *Client) send(req *Request, deadline time.Time) (resp *Response, didTimeout func() bool, err error) {
+// The request is sent using tctx, if non-nil, which is derived from
+// req's context. If propagate is set, the headers in
+// PropagateContextHeaders are added.
+func (c *Client) send(req *Request, tctx context.Context, deadline time.Time, propagate bool) (resp *Response, didTimeout func() bool, err error) {
 	if c.Jar != nil {
 		for _, cookie := range c.Jar.Cookies(req.URL) {
 			req.AddCookie(cookie)
 		}
 	}
-	resp, didTimeout, err = send(req, c.transport(), deadline)
-	if err != nil {
-		return nil, didTimeout, err
+	ctx := req.Context()
+	if tctx != nil {
+		ctx = tctx
 	}
-	if c.Jar != nil {
-		if rc := resp.Cookies(); len(rc) > 0 {
-			c.Jar.SetCookies(req.URL, rc)
+	var header Header // req.Header with the propagated headers, if any
+	if propagate {
+		header = c.propagatedHeader(req)
+	}
+	for attempt := 1; ; attempt++ {
+		// treq is req, or a shallow clone of it if what is sent
+		// must differ.
+		treq := req
+		if tctx != nil || header != nil || attempt > 1 {
+			treq = new(Request)
+			*treq = *req
+			treq.ctx = ctx
+			if header != nil {
+				treq.Header = header
+			}
 		}
+		if attempt > 1 && req.Body != nil && req.Body != NoBody {
+			if treq.Body, err = req.GetBody(); err != nil {
+				return nil, alwaysFalse, err
+			}
+		}
+		if attempt == 1 {
+			c.RetryBudget.deposit()
+		}
+		resp, didTimeout, err = send(treq, c.transport(), deadline)
+		if err == nil {
+			if resp.Request == treq {
+				// Hide the clone from the caller.
+				resp.Request = req
+			}
+			if c.Jar != nil {
+				if rc := resp.Cookies(); len(rc) > 0 {
+					c.Jar.SetCookies(req.URL, rc)
+				}
+			}
+		}
+		if c.Retry != nil {
+			retry, rerr := c.waitRetry(attempt, req, resp, err, ctx, deadline)
+			if rerr != nil {
+				return nil, alwaysFalse, rerr
+			}
+			if retry {
+				continue
+			}
+		}
+		if err != nil {
+			return nil, didTimeout, err
+		}
+		return resp, nil, nil
+	}
+}
+
+// waitRetry asks c.Retry whether to retry req after the given attempt
+// ended with resp or err, and if so waits for the delay it returns.
+// It reports false without asking if req's body can't be sent again,
+// and if the delay would end after ctx or the Client's deadline or
+// c.RetryBudget is spent.
+// If it decides to retry, it closes resp's body. The error is non-nil
+// if ctx is done while waiting.
+func (c *Client) waitRetry(attempt int, req *Request, resp *Response, err error, ctx context.Context, deadline time.Time) (bool, error) {
+	if req.Body != nil && req.Body != NoBody && req.GetBody == nil {
+		return false, nil
+	}
+	delay, retry := c.Retry.ShouldRetry(attempt, req, resp, err)
+	if !retry {
+		return false, nil
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	end := time.Now().Add(delay)
+	if !deadline.IsZero() && end.After(deadline) {
+		return false, nil
+	}
+	if d, ok := ctx.Deadline(); ok && end.After(d) {
+		return false, nil
+	}
+	if !c.RetryBudget.withdraw() {
+		return false, nil
+	}
+	if resp != nil {
+		// As for redirects, read a little of the body so the
+		// connection can be reused if it's small.
+		const maxBodySlurpSize = 2 << 10
+		if resp.ContentLength == -1 || resp.ContentLength <= maxBodySlurpSize {
+			io.CopyN(io.Discard, resp.Body, maxBodySlurpSize)
+		}
+		resp.Body.Close()
+	}
+	t := time.NewTimer(delay)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true, nil
+	case <-ctx.Done():
+		return false, ctx.Err()
 	}
-	return resp, nil, nil
 }
 
 func (c *Client) deadline() time.Time {
@@ -607,6 +814,26 @@ func (c *Client) do(req *Request) (retres *Response, reterr error) {
 		}
 	}
 
+	ctx, done, err := c.track(req.Context())
+	if err != nil {
+		req.closeBody()
+		return nil, &url.Error{
+			Op:  urlErrorOp(req.Method),
+			URL: stripPassword(req.URL),
+			Err: err,
+		}
+	}
+	if done != nil {
+		defer func() {
+			if reterr != nil || retres.Body == NoBody || retres.isProtocolSwitch() {
+				done()
+				return
+			}
+			// Keep the request tracked until its body is consumed.
+			retres.Body = &inFlightBody{rc: retres.Body, done: done}
+		}()
+	}
+
 	var (
 		deadline      = c.deadline()
 		reqs          []*Request
@@ -617,6 +844,7 @@ func (c *Client) do(req *Request) (retres *Response, reterr error) {
 		// Redirect behavior:
 		redirectMethod string
 		includeBody    bool
+		refreshURL     string // target of a meta refresh being followed
 	)
 	uerr := func(err error) error {
 		// the body may have been closed already by c.send()
@@ -640,6 +868,9 @@ func (c *Client) do(req *Request) (retres *Response, reterr error) {
 		// request hop and replace req.
 		if len(reqs) > 0 {
 			loc := resp.Header.Get("Location")
+			if refreshURL != "" {
+				loc = refreshURL
+			}
 			if loc == "" {
 				resp.closeBody()
 				return nil, uerr(fmt.Errorf("%d response missing Location header", resp.StatusCode))
@@ -722,7 +953,8 @@ func (c *Client) do(req *Request) (retres *Response, reterr error) {
 		reqs = append(reqs, req)
 		var err error
 		var didTimeout func() bool
-		if resp, didTimeout, err = c.send(req, deadline); err != nil {
+		propagate := len(reqs) == 1 || sameOrigin(reqs[0].URL, req.URL)
+		if resp, didTimeout, err = c.send(req, ctx, deadline, propagate); err != nil {
 			// c.send() always closes req.Body
 			reqBodyClosed = true
 			if !deadline.IsZero() && didTimeout() {
@@ -736,6 +968,12 @@ func (c *Client) do(req *Request) (retres *Response, reterr error) {
 
 		var shouldRedirect bool
 		redirectMethod, shouldRedirect, includeBody = redirectBehavior(req.Method, resp, reqs[0])
+		refreshURL = ""
+		if !shouldRedirect && c.FollowMetaRefresh {
+			if refreshURL = metaRefreshURL(resp); refreshURL != "" {
+				redirectMethod, shouldRedirect, includeBody = "GET", true, false
+			}
+		}
 		if !shouldRedirect {
 			return resp, nil
 		}
@@ -950,6 +1188,40 @@ func (c *Client) CloseIdleConnections() {
 	}
 }
 
+// CancelAll cancels all requests currently in flight on the Client,
+// as if each of their contexts had been canceled, if the Client's
+// TrackRequests field is set. This includes requests whose responses
+// have been returned but whose bodies are still being read. Requests
+// started afterwards are not affected.
+func (c *Client) CancelAll() {
+	st, _ := c.state.Load().(*clientState)
+	if st == nil {
+		return
+	}
+	st.mu.Lock()
+	cancels := make([]context.CancelFunc, 0, len(st.inflight))
+	for _, cancel := range st.inflight {
+		cancels = append(cancels, cancel)
+	}
+	st.mu.Unlock()
+	for _, cancel := range cancels {
+		cancel()
+	}
+}
+
+// Close cancels all requests in flight on the Client, as CancelAll
+// does, and closes its idle connections, as CloseIdleConnections does.
+// Subsequent requests made with the Client fail with ErrClientClosed.
+func (c *Client) Close() error {
+	st := c.clientState()
+	st.mu.Lock()
+	st.closed.setTrue()
+	st.mu.Unlock()
+	c.CancelAll()
+	c.CloseIdleConnections()
+	return nil
+}
+
 // cancelTimerBody is an io.ReadCloser that wraps rc with two features:
 // 1) On Read error or close, the stop func is called.
 // 2) On Read failure, if reqDidTimeout is true, the error is wrapped and
@@ -983,6 +1255,52 @@ func (b *cancelTimerBody) Close() error {
 	return err
 }
 
+// inFlightBody is a response body that calls done once the body
+// has been read to EOF, failed, or been closed.
+type inFlightBody struct {
+	rc   io.ReadCloser
+	once sync.Once
+	done func()
+}
+
+func (b *inFlightBody) Read(p []byte) (n int, err error) {
+	n, err = b.rc.Read(p)
+	if err != nil {
+		b.once.Do(b.done)
+	}
+	return n, err
+}
+
+func (b *inFlightBody) Close() error {
+	err := b.rc.Close()
+	b.once.Do(b.done)
+	return err
+}
+
+// propagatedHeader returns a copy of req.Header with the headers of
+// PropagateContextHeaders it is missing set to their values for its
+// context, or nil if none are to be set.
+func (c *Client) propagatedHeader(req *Request) Header {
+	var h Header
+	for k, fn := range c.PropagateContextHeaders {
+		if req.Header.Get(k) != "" {
+			continue
+		}
+		if v := fn(req.Context()); v != "" {
+			if h == nil {
+				h = cloneOrMakeHeader(req.Header)
+			}
+			h.Set(k, v)
+		}
+	}
+	return h
+}
+
+// sameOrigin reports whether u and v have the same scheme, host, and port.
+func sameOrigin(u, v *url.URL) bool {
+	return ascii.EqualFold(u.Scheme, v.Scheme) && canonicalAddr(u) == canonicalAddr(v)
+}
+
 func shouldCopyHeaderOnRedirect(headerKey string, initial, dest *url.URL) bool {
 	switch CanonicalHeaderKey(headerKey) {
 	case "Authorization", "Www-Authenticate", "Cookie", "Cookie2":
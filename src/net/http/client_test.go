@@ -584,6 +584,149 @@ func TestClientRedirect308NoGetBody(t *testing.T) {
 	}
 }
 
+func TestClientPropagateContextHeaders(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	type ctxKey struct{}
+	var mu sync.Mutex
+	got := make(map[string]string) // path to Request-Id received
+	record := HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		got[r.URL.Path] = r.Header.Get("Request-Id")
+		mu.Unlock()
+	})
+	other := httptest.NewServer(record)
+	defer other.Close()
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/same":
+			Redirect(w, r, "/final", StatusFound)
+		case "/other":
+			Redirect(w, r, other.URL+"/elsewhere", StatusFound)
+		default:
+			record(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	c := ts.Client()
+	c.PropagateContextHeaders = map[string]func(context.Context) string{
+		"Request-Id": func(ctx context.Context) string {
+			id, _ := ctx.Value(ctxKey{}).(string)
+			return id
+		},
+	}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "id-1")
+	for _, path := range []string{"/same", "/other", "/plain"} {
+		req, _ := NewRequestWithContext(ctx, "GET", ts.URL+path, nil)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if v := req.Header.Get("Request-Id"); v != "" {
+			t.Errorf("%s: Do modified the request's header, adding Request-Id %q", path, v)
+		}
+	}
+	req, _ := NewRequestWithContext(ctx, "GET", ts.URL+"/explicit", nil)
+	req.Header.Set("Request-Id", "mine")
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	res, err = c.Get(ts.URL + "/nocontext")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	want := map[string]string{
+		"/final":     "id-1",
+		"/elsewhere": "",
+		"/plain":     "id-1",
+		"/explicit":  "mine",
+		"/nocontext": "",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Request-Id received = %v; want %v", got, want)
+	}
+}
+
+func TestClientFollowMetaRefresh(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	pages := map[string]string{
+		"/zero":     `<html><head><meta http-equiv="refresh" content="0; url=/done"></head></html>`,
+		"/caps":     `<HTML><META CONTENT='1;URL="/done"' HTTP-EQUIV=Refresh></HTML>`,
+		"/unquoted": `<meta http-equiv=refresh content=0;url=/done>`,
+		"/slow":     `<meta http-equiv="refresh" content="30; url=/done">`,
+		"/reload":   `<meta http-equiv="refresh" content="0">`,
+		"/name":     `<meta name="refresh" content="0; url=/done">`,
+		"/large":    `<meta http-equiv="refresh" content="0; url=/done">` + strings.Repeat(" ", 32<<10),
+		"/text":     `<meta http-equiv="refresh" content="0; url=/done">`,
+	}
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/done" {
+			io.WriteString(w, "done")
+			return
+		}
+		if r.URL.Path == "/text" {
+			w.Header().Set("Content-Type", "text/plain")
+		} else {
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		}
+		io.WriteString(w, pages[r.URL.Path])
+	}))
+	defer ts.Close()
+
+	c := ts.Client()
+	c.FollowMetaRefresh = true
+	var redirects []string
+	c.CheckRedirect = func(req *Request, via []*Request) error {
+		redirects = append(redirects, via[len(via)-1].URL.Path+" -> "+req.URL.Path)
+		return nil
+	}
+	for _, path := range []string{"/zero", "/caps", "/unquoted"} {
+		redirects = nil
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != "done" || res.Request.URL.Path != "/done" {
+			t.Errorf("%s: got body %q from %s; want \"done\" from /done", path, body, res.Request.URL.Path)
+		}
+		if want := []string{path + " -> /done"}; !reflect.DeepEqual(redirects, want) {
+			t.Errorf("%s: CheckRedirect calls = %q; want %q", path, redirects, want)
+		}
+	}
+	for _, path := range []string{"/slow", "/reload", "/name", "/large", "/text"} {
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if string(body) != pages[path] {
+			t.Errorf("%s: body changed or refresh followed; got %d bytes from %s", path, len(body), res.Request.URL.Path)
+		}
+	}
+
+	c.FollowMetaRefresh = false
+	res, err := c.Get(ts.URL + "/zero")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.Request.URL.Path != "/zero" {
+		t.Errorf("followed refresh to %s with FollowMetaRefresh unset", res.Request.URL.Path)
+	}
+}
+
 var expectedCookies = []*Cookie{
 	{Name: "ChocolateChip", Value: "tasty"},
 	{Name: "First", Value: "Hit"},
@@ -1967,6 +2110,105 @@ func TestClientCloseIdleConnections(t *testing.T) {
 	}
 }
 
+func TestClientCancelAll_h1(t *testing.T) { testClientCancelAll(t, h1Mode) }
+func TestClientCancelAll_h2(t *testing.T) { testClientCancelAll(t, h2Mode) }
+
+func testClientCancelAll(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	started := make(chan bool, 1)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/ok":
+			return
+		case "/headers":
+			started <- true
+		case "/body":
+			w.WriteHeader(StatusOK)
+			w.(Flusher).Flush()
+		}
+		<-r.Context().Done()
+	}))
+	defer cst.close()
+	cst.c.TrackRequests = true
+
+	// A request waiting for response headers.
+	errc := make(chan error, 1)
+	go func() {
+		_, err := cst.c.Get(cst.ts.URL + "/headers")
+		errc <- err
+	}()
+
+	// A request whose body is being read.
+	res, err := cst.c.Get(cst.ts.URL + "/body")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	bodyErrc := make(chan error, 1)
+	go func() {
+		_, err := io.ReadAll(res.Body)
+		bodyErrc <- err
+	}()
+
+	<-started
+	cst.c.CancelAll()
+	if err := <-errc; !errors.Is(err, context.Canceled) {
+		t.Errorf("Get after CancelAll: err = %v; want context.Canceled", err)
+	}
+	if err := <-bodyErrc; err == nil {
+		t.Errorf("reading body after CancelAll: got nil error")
+	}
+
+	// The Client is still usable after CancelAll.
+	res, err = cst.c.Get(cst.ts.URL + "/ok")
+	if err != nil {
+		t.Fatalf("Get after CancelAll: %v", err)
+	}
+	res.Body.Close()
+}
+
+func TestClientClose(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	c := &Client{Transport: ts.Client().Transport}
+
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if err := c.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	_, err = c.Get(ts.URL)
+	if !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Get after Close: err = %v; want ErrClientClosed", err)
+	}
+
+	// A Client that tracks requests is closed the same way.
+	c = &Client{Transport: ts.Client().Transport, TrackRequests: true}
+	c.Close()
+	if _, err = c.Get(ts.URL); !errors.Is(err, ErrClientClosed) {
+		t.Errorf("Get after Close with TrackRequests: err = %v; want ErrClientClosed", err)
+	}
+}
+
+// Tests that a Client not tracking requests passes the Request to its
+// RoundTripper itself.
+func TestClientPassesRequestThrough(t *testing.T) {
+	tr := &recordingTransport{}
+	c := &Client{Transport: tr}
+	req, _ := NewRequest("GET", "http://example.com/", nil)
+	c.Do(req)
+	if tr.req != req {
+		t.Errorf("RoundTripper got a copy of the Request")
+	}
+	c.CancelAll() // does nothing
+}
+
 func TestClientPropagatesTimeoutToContext(t *testing.T) {
 	errDial := errors.New("not actually dialing")
 	c := &Client{
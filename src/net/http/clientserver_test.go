@@ -1616,3 +1616,66 @@ func testIdentityTransferEncoding(t *testing.T, h2 bool) {
 		t.Errorf("got response body = %q; want %q", got, want)
 	}
 }
+
+func TestRequestIsHTTP2_h1(t *testing.T) { testRequestIsHTTP2(t, h1Mode) }
+func TestRequestIsHTTP2_h2(t *testing.T) { testRequestIsHTTP2(t, h2Mode) }
+func testRequestIsHTTP2(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.IsHTTP2() != h2 {
+			t.Errorf("IsHTTP2 = %v for %s request", r.IsHTTP2(), r.Proto)
+		}
+		// Go's Transport disables server push.
+		if r.SupportsServerPush() {
+			t.Errorf("SupportsServerPush = true for %s request from a client with push disabled", r.Proto)
+		}
+	}))
+	defer cst.close()
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if r := httptest.NewRequest("GET", "/", nil); r.IsHTTP2() || r.SupportsServerPush() {
+		t.Errorf("HTTP/1.1 request created by httptest: IsHTTP2 = %v, SupportsServerPush = %v; want false", r.IsHTTP2(), r.SupportsServerPush())
+	}
+}
+
+// Tests that each cookie a handler sets is sent as its own Set-Cookie
+// field, never joined with the others, whose commas would make them
+// ambiguous.
+func TestSetCookieFields_h1(t *testing.T) { testSetCookieFields(t, h1Mode) }
+func TestSetCookieFields_h2(t *testing.T) { testSetCookieFields(t, h2Mode) }
+func testSetCookieFields(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	expires := time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)
+	want := []string{
+		"a=1; Expires=Wed, 02 Jan 2030 03:04:05 GMT",
+		"b=2; Path=/x",
+		"c=3, d=4",
+	}
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		SetCookie(w, &Cookie{Name: "a", Value: "1", Expires: expires})
+		SetCookie(w, &Cookie{Name: "b", Value: "2", Path: "/x"})
+		w.Header().Add("Set-Cookie", "c=3, d=4")
+		var names []string
+		for _, c := range w.Header().Cookies() {
+			names = append(names, c.Name)
+		}
+		if got := strings.Join(names, " "); got != "a b c" {
+			t.Errorf("Header.Cookies names = %q; want \"a b c\"", got)
+		}
+	}))
+	defer cst.close()
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if got := res.Header["Set-Cookie"]; !reflect.DeepEqual(got, want) {
+		t.Errorf("Set-Cookie fields = %q; want %q", got, want)
+	}
+}
@@ -0,0 +1,383 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Response compression.
+
+package http
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net/http/internal/ascii"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// CompressConfig configures a handler returned by NewCompressHandler.
+type CompressConfig struct {
+	// MinSize is the size in bytes below which response bodies are
+	// sent uncompressed. Bodies whose size isn't known in advance
+	// are buffered until MinSize bytes are written, the handler
+	// returns, or it flushes the response. If zero, 1024 is used;
+	// if negative, all bodies are compressed.
+	MinSize int
+
+	// Level is the compression level, as for compress/flate, from
+	// flate.BestSpeed to flate.BestCompression. If zero,
+	// flate.DefaultCompression is used.
+	Level int
+
+	// ContentTypes lists the media types of the responses to
+	// compress, such as "application/json", or families of types
+	// ending in "/*", such as "text/*". They are matched ignoring
+	// case and parameters, and "*/*" matches every type. If nil,
+	// text/*, application/json, application/javascript,
+	// application/xml, image/svg+xml, and application/wasm
+	// responses are compressed, and those of types that are usually
+	// compressed already, such as images, video, and zip archives,
+	// are not.
+	ContentTypes []string
+}
+
+var defaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"image/svg+xml",
+	"application/wasm",
+}
+
+// CompressHandler returns a handler that runs h, compressing its
+// responses for clients that accept compressed responses, as
+// NewCompressHandler does with the default configuration.
+func CompressHandler(h Handler) Handler {
+	return NewCompressHandler(h, CompressConfig{})
+}
+
+// NewCompressHandler returns a handler that runs h, compressing its
+// responses with gzip or deflate, in that order of preference, if the
+// request's Accept-Encoding header accepts them. Compressed responses
+// have their Content-Encoding set and any Content-Length removed, and
+// a strong ETag is made weak. All responses get a Vary header naming
+// Accept-Encoding.
+//
+// Responses are sent unchanged if they already have a
+// Content-Encoding, if their body is smaller than cfg.MinSize, if their
+// content type isn't one of cfg.ContentTypes, if they are for HEAD
+// requests, or if their status code is 206 Partial Content or does not
+// allow a body. A response without a Content-Type has its content type
+// sniffed from the uncompressed body, as the server would do without
+// compression; if the handler flushes before writing, such a response
+// is not compressed.
+//
+// The ResponseWriter passed to h implements Flusher, flushing the
+// compressed data written so far, and it implements Hijacker and
+// Pusher if the original ResponseWriter does. It also has an Unwrap
+// method returning the original.
+//
+// NewCompressHandler panics if cfg.Level is not a valid level.
+func NewCompressHandler(h Handler, cfg CompressConfig) Handler {
+	if cfg.MinSize == 0 {
+		cfg.MinSize = 1024
+	}
+	if cfg.Level == 0 {
+		cfg.Level = flate.DefaultCompression
+	}
+	if cfg.Level < flate.HuffmanOnly || cfg.Level > flate.BestCompression {
+		panic("http: invalid compression level " + strconv.Itoa(cfg.Level))
+	}
+	types := cfg.ContentTypes
+	if types == nil {
+		types = defaultCompressTypes
+	}
+	lower := make([]string, len(types))
+	for i, t := range types {
+		lower[i], _ = ascii.ToLower(textproto.TrimString(t))
+	}
+	var gzipPool, flatePool sync.Pool
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		hdr := w.Header()
+		if !headerListsToken(hdr["Vary"], "Accept-Encoding") {
+			hdr.Add("Vary", "Accept-Encoding")
+		}
+		enc := acceptedEncoding(r.Header["Accept-Encoding"])
+		if enc == "" || r.Method == "HEAD" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{rw: w, enc: enc, minSize: cfg.MinSize, types: lower}
+		cw.newWriter = func(dst io.Writer) compressor {
+			if enc == "gzip" {
+				if z, ok := gzipPool.Get().(*gzip.Writer); ok {
+					z.Reset(dst)
+					return z
+				}
+				z, _ := gzip.NewWriterLevel(dst, cfg.Level)
+				return z
+			}
+			if z, ok := flatePool.Get().(*flate.Writer); ok {
+				z.Reset(dst)
+				return z
+			}
+			z, _ := flate.NewWriter(dst, cfg.Level)
+			return z
+		}
+		h.ServeHTTP(cw.wrap(), r)
+		if z := cw.close(); z != nil {
+			if enc == "gzip" {
+				gzipPool.Put(z)
+			} else {
+				flatePool.Put(z)
+			}
+		}
+	})
+}
+
+// acceptedEncoding returns the content coding, "gzip" or "deflate",
+// to use for a request with the given Accept-Encoding header values,
+// or "" if the request accepts neither.
+func acceptedEncoding(accept []string) string {
+	var gzipQ, deflateQ, anyQ float64 = -1, -1, -1
+	for _, v := range accept {
+		for _, elem := range strings.Split(v, ",") {
+			coding, params, _ := strings.Cut(elem, ";")
+			q := 1.0
+			for _, p := range strings.Split(params, ";") {
+				k, v, _ := strings.Cut(p, "=")
+				if ascii.EqualFold(textproto.TrimString(k), "q") {
+					var err error
+					if q, err = strconv.ParseFloat(textproto.TrimString(v), 64); err != nil {
+						q = 0
+					}
+				}
+			}
+			switch coding = textproto.TrimString(coding); {
+			case ascii.EqualFold(coding, "gzip"), ascii.EqualFold(coding, "x-gzip"):
+				gzipQ = q
+			case ascii.EqualFold(coding, "deflate"):
+				deflateQ = q
+			case coding == "*":
+				anyQ = q
+			}
+		}
+	}
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return "gzip"
+	case deflateQ > 0:
+		return "deflate"
+	}
+	return ""
+}
+
+// headerListsToken reports whether the comma-separated lists in
+// values contain token, ignoring case.
+func headerListsToken(values []string, token string) bool {
+	for _, v := range values {
+		for _, t := range strings.Split(v, ",") {
+			if ascii.EqualFold(textproto.TrimString(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// A compressor is a *gzip.Writer or a *flate.Writer.
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// compressWriter is the ResponseWriter passed to handlers by
+// NewCompressHandler. It buffers the start of the body until it
+// decides whether to compress the response.
+type compressWriter struct {
+	rw        ResponseWriter
+	enc       string // "gzip" or "deflate"
+	minSize   int
+	types     []string // CompressConfig.ContentTypes, in lower case
+	newWriter func(dst io.Writer) compressor
+
+	code    int    // status code passed to WriteHeader, or 0
+	buf     []byte // body written before deciding
+	decided bool   // whether the header has been written to rw
+	z       compressor
+}
+
+// wrap returns cw as a ResponseWriter implementing the optional
+// interfaces of cw.rw.
+func (cw *compressWriter) wrap() ResponseWriter {
+	hj, isHijacker := cw.rw.(Hijacker)
+	p, isPusher := cw.rw.(Pusher)
+	switch {
+	case isHijacker && isPusher:
+		return &struct {
+			*compressWriter
+			Hijacker
+			Pusher
+		}{cw, hj, p}
+	case isHijacker:
+		return &struct {
+			*compressWriter
+			Hijacker
+		}{cw, hj}
+	case isPusher:
+		return &struct {
+			*compressWriter
+			Pusher
+		}{cw, p}
+	}
+	return cw
+}
+
+func (cw *compressWriter) Header() Header { return cw.rw.Header() }
+
+func (cw *compressWriter) WriteHeader(code int) {
+	if cw.decided {
+		cw.rw.WriteHeader(code) // let the server report the superfluous call
+		return
+	}
+	if cw.code != 0 {
+		return // superfluous
+	}
+	if code >= 100 && code <= 199 && code != StatusSwitchingProtocols {
+		cw.rw.WriteHeader(code) // informational
+		return
+	}
+	cw.code = code
+	if !bodyAllowedForStatus(code) || code == StatusPartialContent || cw.Header().Get("Content-Encoding") != "" {
+		cw.start(false)
+		return
+	}
+	if cl, err := strconv.ParseInt(cw.Header().Get("Content-Length"), 10, 64); err == nil && cl < int64(cw.minSize) {
+		cw.start(false)
+		return
+	}
+	if ct := cw.Header().Get("Content-Type"); ct != "" && !cw.compressible(ct) {
+		cw.start(false)
+	}
+}
+
+// compressible reports whether responses of content type ct are
+// compressed.
+func (cw *compressWriter) compressible(ct string) bool {
+	mt, _, _ := strings.Cut(ct, ";")
+	mt, ok := ascii.ToLower(textproto.TrimString(mt))
+	if !ok || mt == "" {
+		return false
+	}
+	for _, t := range cw.types {
+		switch {
+		case t == "*/*" || t == mt:
+			return true
+		case strings.HasSuffix(t, "/*") && strings.HasPrefix(mt, t[:len(t)-1]):
+			return true
+		}
+	}
+	return false
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.decided {
+		if cw.code == 0 {
+			cw.WriteHeader(StatusOK)
+		}
+		if !cw.decided {
+			cw.buf = append(cw.buf, p...)
+			if len(cw.buf) >= cw.minSize {
+				cw.start(true)
+			}
+			return len(p), nil
+		}
+	}
+	if cw.z != nil {
+		return cw.z.Write(p)
+	}
+	return cw.rw.Write(p)
+}
+
+// Flush writes the response header, if it hasn't been written, and
+// the data written so far to the client. A response of undecided
+// size is compressed, as its handler is likely streaming.
+func (cw *compressWriter) Flush() {
+	if !cw.decided {
+		if cw.code == 0 {
+			cw.WriteHeader(StatusOK)
+		}
+		if !cw.decided {
+			cw.start(true)
+		}
+	}
+	if cw.z != nil {
+		cw.z.Flush()
+	}
+	if f, ok := cw.rw.(Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Unwrap() ResponseWriter { return cw.rw }
+
+// start writes the response header to cw.rw, compressing the rest of
+// the response if compress is set, then the buffered body.
+func (cw *compressWriter) start(compress bool) {
+	cw.decided = true
+	h := cw.Header()
+	if compress {
+		ct := h.Get("Content-Type")
+		if _, haveType := h["Content-Type"]; !haveType && len(cw.buf) > 0 {
+			ct = DetectContentType(cw.buf)
+			h.Set("Content-Type", ct)
+		}
+		compress = cw.compressible(ct)
+	}
+	if compress {
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", cw.enc)
+		if etag := h.Get("Etag"); strings.HasPrefix(etag, `"`) {
+			h.Set("Etag", "W/"+etag)
+		}
+	}
+	if cw.code != 0 {
+		cw.rw.WriteHeader(cw.code)
+	}
+	if compress {
+		cw.z = cw.newWriter(cw.rw)
+	}
+	if len(cw.buf) > 0 {
+		buf := cw.buf
+		cw.buf = nil
+		if cw.z != nil {
+			cw.z.Write(buf)
+		} else {
+			cw.rw.Write(buf)
+		}
+	}
+}
+
+// close finishes the response after the handler has returned, and
+// returns the compressor used, if any, for reuse.
+func (cw *compressWriter) close() compressor {
+	if !cw.decided {
+		if cw.code == 0 && len(cw.buf) == 0 {
+			return nil // nothing written; leave the response to the server
+		}
+		cw.start(false)
+	}
+	if cw.z != nil {
+		cw.z.Close()
+	}
+	return cw.z
+}
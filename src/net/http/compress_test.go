@@ -0,0 +1,173 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCompressHandler(t *testing.T) {
+	big := strings.Repeat("hello, world\n", 200)
+	h := CompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/big":
+			w.Header().Set("Content-Length", "2600")
+			w.Header().Set("Etag", `"v1"`)
+			io.WriteString(w, big[:100])
+			io.WriteString(w, big[100:])
+		case "/small":
+			io.WriteString(w, "small")
+		case "/encoded":
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, big)
+		case "/partial":
+			w.WriteHeader(StatusPartialContent)
+			io.WriteString(w, big)
+		case "/nobody":
+			w.WriteHeader(StatusNoContent)
+		case "/json":
+			w.Header().Set("Content-Type", "Application/JSON; charset=utf-8")
+			io.WriteString(w, big)
+		case "/png":
+			w.Header().Set("Content-Type", "image/png")
+			io.WriteString(w, big)
+		case "/zip":
+			io.WriteString(w, "PK\x03\x04"+big)
+		}
+	}))
+	for _, tt := range []struct {
+		path, accept string
+		wantEnc      string
+	}{
+		{"/big", "gzip, deflate", "gzip"},
+		{"/big", "deflate, gzip;q=0.5", "deflate"},
+		{"/big", "gzip;q=0, *", "deflate"},
+		{"/big", "br", ""},
+		{"/big", "", ""},
+		{"/small", "gzip", ""},
+		{"/encoded", "gzip", "br"},
+		{"/partial", "gzip", ""},
+		{"/nobody", "gzip", ""},
+		{"/json", "gzip", "gzip"},
+		{"/png", "gzip", ""},
+		{"/zip", "gzip", ""},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		if tt.accept != "" {
+			req.Header.Set("Accept-Encoding", tt.accept)
+		}
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		res := rw.Result()
+		desc := tt.path + " with Accept-Encoding " + tt.accept
+		if got := res.Header.Get("Content-Encoding"); got != tt.wantEnc {
+			t.Errorf("%s: Content-Encoding = %q; want %q", desc, got, tt.wantEnc)
+		}
+		if got := res.Header.Get("Vary"); got != "Accept-Encoding" {
+			t.Errorf("%s: Vary = %q; want Accept-Encoding", desc, got)
+		}
+		var body io.Reader = res.Body
+		switch tt.wantEnc {
+		case "gzip":
+			zr, err := gzip.NewReader(body)
+			if err != nil {
+				t.Fatalf("%s: %v", desc, err)
+			}
+			body = zr
+		case "deflate":
+			body = flate.NewReader(body)
+		}
+		if tt.wantEnc == "gzip" || tt.wantEnc == "deflate" {
+			if cl := res.Header.Get("Content-Length"); cl != "" {
+				t.Errorf("%s: compressed response has Content-Length %s", desc, cl)
+			}
+			if etag := res.Header.Get("Etag"); tt.path == "/big" && etag != `W/"v1"` {
+				t.Errorf("%s: Etag = %s; want W/\"v1\"", desc, etag)
+			}
+			if ct := res.Header.Get("Content-Type"); tt.path == "/big" && ct != "text/plain; charset=utf-8" {
+				t.Errorf("%s: Content-Type = %q; want sniffed text/plain", desc, ct)
+			}
+		}
+		b, err := io.ReadAll(body)
+		if err != nil {
+			t.Fatalf("%s: reading body: %v", desc, err)
+		}
+		want := big
+		switch tt.path {
+		case "/small":
+			want = "small"
+		case "/nobody":
+			want = ""
+		case "/zip":
+			want = "PK\x03\x04" + big
+		}
+		if string(b) != want {
+			t.Errorf("%s: got body of %d bytes; want %d", desc, len(b), len(want))
+		}
+	}
+}
+
+func TestCompressHandlerFlush(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	unblock := make(chan bool)
+	ts := httptest.NewServer(CompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if _, ok := w.(Hijacker); !ok {
+			t.Errorf("ResponseWriter is not a Hijacker")
+		}
+		io.WriteString(w, "hello\n")
+		w.(Flusher).Flush()
+		<-unblock
+	})))
+	defer ts.Close()
+	defer close(unblock)
+
+	// The Transport asks for gzip and decompresses the response.
+	res, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if !res.Uncompressed {
+		t.Errorf("response wasn't compressed")
+	}
+	line, err := bufio.NewReader(res.Body).ReadString('\n')
+	if line != "hello\n" || err != nil {
+		t.Errorf("read %q, %v before the handler returned; want flushed line", line, err)
+	}
+}
+
+func TestCompressHandlerContentTypes(t *testing.T) {
+	big := strings.Repeat("hello, world\n", 200)
+	h := NewCompressHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Content-Type", r.FormValue("type"))
+		io.WriteString(w, big)
+	}), CompressConfig{ContentTypes: []string{"image/*", "Application/X-Custom"}})
+	for _, tt := range []struct {
+		ct       string
+		compress bool
+	}{
+		{"image/bmp", true},
+		{"application/x-custom; v=1", true},
+		{"text/html", false},
+		{"images/foo", false},
+	} {
+		req := httptest.NewRequest("GET", "/?type="+url.QueryEscape(tt.ct), nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rw := httptest.NewRecorder()
+		h.ServeHTTP(rw, req)
+		if got := rw.Header().Get("Content-Encoding") == "gzip"; got != tt.compress {
+			t.Errorf("Content-Type %q: compressed = %v; want %v", tt.ct, got, tt.compress)
+		}
+	}
+}
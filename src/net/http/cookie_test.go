@@ -81,6 +81,10 @@ var writeSetCookiesTests = []struct {
 		&Cookie{Name: "cookie-15", Value: "samesite-none", SameSite: SameSiteNoneMode},
 		"cookie-15=samesite-none; SameSite=None",
 	},
+	{
+		&Cookie{Name: "cookie-16", Value: "partitioned", Secure: true, SameSite: SameSiteNoneMode, Partitioned: true},
+		"cookie-16=partitioned; Secure; SameSite=None; Partitioned",
+	},
 	// The "special" cookies have values containing commas or spaces which
 	// are disallowed by RFC 6265 but are common in the wild.
 	{
@@ -318,6 +322,18 @@ var readSetCookiesTests = []struct {
 			Raw:      "samesitenone=foo; SameSite=None",
 		}},
 	},
+	{
+		Header{"Set-Cookie": {"__Host-partitioned=foo; Path=/; Secure; SameSite=None; Partitioned"}},
+		[]*Cookie{{
+			Name:        "__Host-partitioned",
+			Value:       "foo",
+			Path:        "/",
+			Secure:      true,
+			SameSite:    SameSiteNoneMode,
+			Partitioned: true,
+			Raw:         "__Host-partitioned=foo; Path=/; Secure; SameSite=None; Partitioned",
+		}},
+	},
 	// Make sure we can properly read back the Set-Cookie headers we create
 	// for values containing spaces or commas:
 	{
@@ -360,6 +376,36 @@ var readSetCookiesTests = []struct {
 	// Header{"Set-Cookie": {"ASP.NET_SessionId=foo; path=/; HttpOnly, .ASPXAUTH=7E3AA; expires=Wed, 07-Mar-2012 14:25:06 GMT; path=/; HttpOnly"}},
 }
 
+func TestParseSetCookie(t *testing.T) {
+	for _, c := range []*Cookie{
+		{Name: "a", Value: "b", Path: "/x", Domain: "example.com", MaxAge: 60, HttpOnly: true, Secure: true, SameSite: SameSiteLaxMode},
+		{Name: "c", Value: "d", SameSite: SameSiteStrictMode},
+		{Name: "e", Value: "f", Expires: time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC)},
+		{Name: "chips", Value: "g", Path: "/", Secure: true, SameSite: SameSiteNoneMode, Partitioned: true},
+	} {
+		s := c.String()
+		got, err := ParseSetCookie(s)
+		if err != nil {
+			t.Errorf("ParseSetCookie(%q): %v", s, err)
+			continue
+		}
+		want := *c
+		want.Raw = s
+		if !want.Expires.IsZero() {
+			want.RawExpires = want.Expires.Format(TimeFormat)
+		}
+		if !reflect.DeepEqual(got, &want) {
+			t.Errorf("ParseSetCookie(%q) =\n%s\nwant\n%s", s, toJSON(got), toJSON(&want))
+		}
+	}
+
+	for _, line := range []string{"", " ", "noequals", "bad name=x", `a="b`} {
+		if c, err := ParseSetCookie(line); err == nil {
+			t.Errorf("ParseSetCookie(%q) = %+v; want error", line, c)
+		}
+	}
+}
+
 func toJSON(v any) string {
 	b, err := json.Marshal(v)
 	if err != nil {
@@ -540,6 +586,8 @@ func TestCookieValid(t *testing.T) {
 		{&Cookie{Name: "invalid-value", Value: "foo\"bar"}, false},
 		{&Cookie{Name: "invalid-path", Path: "/foo;bar/"}, false},
 		{&Cookie{Name: "invalid-domain", Domain: "example.com:80"}, false},
+		{&Cookie{Name: "insecure-partitioned", Value: "foo", Partitioned: true}, false},
+		{&Cookie{Name: "partitioned", Value: "foo", Expires: time.Unix(0, 0), Secure: true, SameSite: SameSiteNoneMode, Partitioned: true}, true},
 		{&Cookie{Name: "valid", Value: "foo", Path: "/bar", Domain: "example.com", Expires: time.Unix(0, 0)}, true},
 	}
 
@@ -0,0 +1,157 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Saving and loading the cookies of a Jar.
+
+package cookiejar
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// fileVersion is the version of the format written by Save. Load
+// rejects other versions, so a change to the format must increment it.
+const fileVersion = 1
+
+// jarFile is the JSON document written by Save.
+type jarFile struct {
+	Version int           `json:"version"`
+	Cookies []savedCookie `json:"cookies"`
+}
+
+// savedCookie is a cookie as written by Save. It is kept apart from
+// entry so that changes to the Jar's internals don't change the format.
+type savedCookie struct {
+	Name       string    `json:"name"`
+	Value      string    `json:"value"`
+	Domain     string    `json:"domain"`
+	Path       string    `json:"path"`
+	SameSite   string    `json:"sameSite,omitempty"`
+	Secure     bool      `json:"secure,omitempty"`
+	HttpOnly   bool      `json:"httpOnly,omitempty"`
+	Persistent bool      `json:"persistent,omitempty"`
+	HostOnly   bool      `json:"hostOnly,omitempty"`
+	Expires    time.Time `json:"expires"`
+	Creation   time.Time `json:"creation"`
+	LastAccess time.Time `json:"lastAccess"`
+}
+
+// Save writes the cookies in j that have not expired to w as a JSON
+// document, which Load can read back, for example into a Jar created
+// when a program is run again. Session cookies, which have no
+// expiration time, are saved too; a program that should forget them
+// between runs can clear them before calling Save.
+//
+// Each cookie is saved with its name, value, domain, path, SameSite,
+// Secure, and HttpOnly attributes and its expiration, creation, and
+// last access times. The document includes a version number for the
+// format.
+func (j *Jar) Save(w io.Writer) error {
+	return j.save(w, time.Now())
+}
+
+// save is like Save but takes the current time as a parameter.
+func (j *Jar) save(w io.Writer, now time.Time) error {
+	var entries []entry
+	j.mu.Lock()
+	for _, submap := range j.entries {
+		for _, e := range submap {
+			if e.Persistent && !e.Expires.After(now) {
+				continue
+			}
+			entries = append(entries, e)
+		}
+	}
+	j.mu.Unlock()
+	// Save in the order the cookies were set, so that Load keeps
+	// their order for cookies of equal path length and creation time.
+	sort.Slice(entries, func(i, k int) bool {
+		return entries[i].seqNum < entries[k].seqNum
+	})
+	f := jarFile{Version: fileVersion, Cookies: make([]savedCookie, len(entries))}
+	for i, e := range entries {
+		c := savedCookie{
+			Name:       e.Name,
+			Value:      e.Value,
+			Domain:     e.Domain,
+			Path:       e.Path,
+			SameSite:   e.SameSite,
+			Secure:     e.Secure,
+			HttpOnly:   e.HttpOnly,
+			Persistent: e.Persistent,
+			HostOnly:   e.HostOnly,
+			Creation:   e.Creation,
+			LastAccess: e.LastAccess,
+		}
+		if e.Persistent {
+			c.Expires = e.Expires
+		}
+		f.Cookies[i] = c
+	}
+	return json.NewEncoder(w).Encode(&f)
+}
+
+// Load reads cookies saved by Save from r and adds them to j,
+// replacing any cookies in j with the same name, domain, and path.
+// Cookies that have expired are dropped. Load returns an error, and
+// leaves j unchanged, if r does not hold a document written by Save,
+// or holds one of a format version Load doesn't support.
+func (j *Jar) Load(r io.Reader) error {
+	return j.load(r, time.Now())
+}
+
+// load is like Load but takes the current time as a parameter.
+func (j *Jar) load(r io.Reader, now time.Time) error {
+	var f jarFile
+	if err := json.NewDecoder(r).Decode(&f); err != nil {
+		return fmt.Errorf("cookiejar: loading cookies: %v", err)
+	}
+	if f.Version != fileVersion {
+		return fmt.Errorf("cookiejar: unsupported format version %d", f.Version)
+	}
+	for _, c := range f.Cookies {
+		if c.Name == "" || c.Domain == "" || c.Path == "" || c.Path[0] != '/' {
+			return errors.New("cookiejar: loading cookies: invalid cookie")
+		}
+	}
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	for _, c := range f.Cookies {
+		e := entry{
+			Name:       c.Name,
+			Value:      c.Value,
+			Domain:     c.Domain,
+			Path:       c.Path,
+			SameSite:   c.SameSite,
+			Secure:     c.Secure,
+			HttpOnly:   c.HttpOnly,
+			Persistent: c.Persistent,
+			HostOnly:   c.HostOnly,
+			Expires:    c.Expires,
+			Creation:   c.Creation,
+			LastAccess: c.LastAccess,
+		}
+		if !e.Persistent {
+			e.Expires = endOfTime
+		} else if !e.Expires.After(now) {
+			continue
+		}
+		key := jarKey(e.Domain, j.psList)
+		submap := j.entries[key]
+		if submap == nil {
+			submap = make(map[string]entry)
+			j.entries[key] = submap
+		}
+		e.seqNum = j.nextSeqNum
+		j.nextSeqNum++
+		submap[e.id()] = e
+	}
+	return nil
+}
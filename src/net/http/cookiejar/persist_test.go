@@ -0,0 +1,94 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package cookiejar
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSaveLoad(t *testing.T) {
+	jar := newTestJar()
+	u := mustParseURL("https://www.example.com/dir/")
+	jar.setCookies(u, []*http.Cookie{
+		{Name: "session", Value: "s1"},
+		{Name: "long", Value: "l1", Path: "/", Domain: "example.com", Expires: tNow.Add(24 * time.Hour), Secure: true, HttpOnly: true, SameSite: http.SameSiteStrictMode},
+		{Name: "short", Value: "x", MaxAge: 60},
+	}, tNow)
+
+	var buf bytes.Buffer
+	if err := jar.save(&buf, tNow); err != nil {
+		t.Fatal(err)
+	}
+
+	// An hour later, the short-lived cookie has expired.
+	later := tNow.Add(time.Hour)
+	jar2 := newTestJar()
+	if err := jar2.load(bytes.NewReader(buf.Bytes()), later); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"session=s1", "long=l1"}
+	var got []string
+	for _, c := range jar2.cookies(u, later) {
+		got = append(got, c.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("cookies after Load = %q; want %q", got, want)
+	}
+	e := jar2.entries["example.com"]["example.com;/;long"]
+	if !e.Secure || !e.HttpOnly || e.SameSite != "SameSite=Strict" || !e.Expires.Equal(tNow.Add(24*time.Hour)) || e.HostOnly {
+		t.Errorf("loaded entry = %+v; attributes not preserved", e)
+	}
+	if got := jar2.cookies(mustParseURL("http://www.example.com/dir/"), later); len(got) != 1 || got[0].Name != "session" {
+		t.Errorf("cookies for http URL = %v; want only session cookie", got)
+	}
+
+	// Loading again, after a day, replaces the cookies.
+	if err := jar2.load(bytes.NewReader(buf.Bytes()), tNow.Add(48*time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if n := len(jar2.entries["example.com"]); n != 2 {
+		t.Errorf("%d entries after second Load; want 2", n)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"not json",
+		`{"version":2,"cookies":[]}`,
+		`{"cookies":[]}`,
+		`{"version":1,"cookies":[{"name":"a","domain":"","path":"/"}]}`,
+		`{"version":1,"cookies":[{"name":"a","domain":"example.com","path":"x"}]}`,
+	} {
+		jar := newTestJar()
+		if err := jar.Load(strings.NewReader(in)); err == nil {
+			t.Errorf("Load(%q) = nil; want error", in)
+		}
+		if len(jar.entries) != 0 {
+			t.Errorf("Load(%q) changed the jar", in)
+		}
+	}
+}
+
+// TestLoadFormat loads a document in the saved format, so that a change
+// to the names of its fields doesn't go unnoticed.
+func TestLoadFormat(t *testing.T) {
+	const in = `{"version":1,"cookies":[{"name":"a","value":"1","domain":"www.example.com","path":"/",` +
+		`"secure":true,"persistent":true,"hostOnly":true,"expires":"2013-01-02T15:04:05Z",` +
+		`"creation":"2013-01-01T15:04:05Z","lastAccess":"2013-01-01T15:04:05Z"}]}`
+	jar := newTestJar()
+	if err := jar.load(strings.NewReader(in), tNow); err != nil {
+		t.Fatal(err)
+	}
+	e, ok := jar.entries["example.com"]["www.example.com;/;a"]
+	if !ok || e.Value != "1" || !e.Secure || !e.Persistent || !e.HostOnly || e.Expires.Year() != 2013 {
+		t.Errorf("loaded entry = %+v, %t", e, ok)
+	}
+}
@@ -0,0 +1,281 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Content-Digest support (RFC 9530).
+
+package http
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"hash"
+	"io"
+	"net/http/internal/ascii"
+	"net/textproto"
+	"strconv"
+	"strings"
+)
+
+// contentDigestAlgs lists the supported Content-Digest algorithms,
+// strongest first.
+var contentDigestAlgs = []struct {
+	name string
+	new  func() hash.Hash
+}{
+	{"sha-512", sha512.New},
+	{"sha-256", sha256.New},
+}
+
+func contentDigestHash(alg string) func() hash.Hash {
+	for _, a := range contentDigestAlgs {
+		if a.name == alg {
+			return a.new
+		}
+	}
+	return nil
+}
+
+// A ContentDigestError is returned by reads from a request body
+// being verified by VerifyContentDigest when the digest of the body
+// does not match the value in the request's Content-Digest header.
+type ContentDigestError struct {
+	Algorithm string // the Content-Digest algorithm, such as "sha-256"
+}
+
+func (e *ContentDigestError) Error() string {
+	return "http: Content-Digest " + e.Algorithm + " mismatch"
+}
+
+// A ChecksumMismatchError is returned by DownloadVerified when the
+// digest of a downloaded body does not match the expected one.
+type ChecksumMismatchError struct {
+	Algorithm string // the algorithm, such as "sha-256"
+	Want, Got []byte // the expected and actual digests
+}
+
+func (e *ChecksumMismatchError) Error() string {
+	return "http: " + e.Algorithm + " checksum mismatch"
+}
+
+// parseContentDigest parses a Content-Digest header value into a map
+// of lowercase algorithm names to decoded digests. Members it cannot
+// parse are ignored.
+func parseContentDigest(v string) map[string][]byte {
+	digests := make(map[string][]byte)
+	for _, member := range strings.Split(v, ",") {
+		alg, val, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		val, _, _ = strings.Cut(val, ";") // drop parameters
+		val = textproto.TrimString(val)
+		if len(val) < 2 || val[0] != ':' || val[len(val)-1] != ':' {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(val[1 : len(val)-1])
+		if err != nil {
+			continue
+		}
+		if alg, ok := ascii.ToLower(textproto.TrimString(alg)); ok {
+			digests[alg] = sum
+		}
+	}
+	return digests
+}
+
+// parseDigest parses a Digest header value (RFC 3230), which
+// Content-Digest replaces, into a map like parseContentDigest.
+func parseDigest(v string) map[string][]byte {
+	digests := make(map[string][]byte)
+	for _, member := range strings.Split(v, ",") {
+		alg, val, ok := strings.Cut(member, "=")
+		if !ok {
+			continue
+		}
+		sum, err := base64.StdEncoding.DecodeString(textproto.TrimString(val))
+		if err != nil {
+			continue
+		}
+		if alg, ok := ascii.ToLower(textproto.TrimString(alg)); ok {
+			digests[alg] = sum
+		}
+	}
+	return digests
+}
+
+// VerifyContentDigest arranges for the request body to be checked
+// against the request's Content-Digest header as it is read.
+// The body is hashed incrementally and not buffered. If the header lists
+// several supported algorithms, the strongest one is used.
+//
+// Once verification is set up, a read from r.Body that reaches the end
+// of a body whose digest does not match returns a *ContentDigestError
+// instead of io.EOF. Handlers must therefore read the body to
+// completion, and check the error, before trusting its contents.
+//
+// VerifyContentDigest returns an error if the request has no
+// Content-Digest header or if the header contains no digest using a
+// supported algorithm ("sha-256" or "sha-512").
+func (r *Request) VerifyContentDigest() error {
+	v := r.Header.Get("Content-Digest")
+	if v == "" {
+		return errors.New("http: missing Content-Digest header")
+	}
+	digests := parseContentDigest(v)
+	for _, a := range contentDigestAlgs {
+		want, ok := digests[a.name]
+		if !ok {
+			continue
+		}
+		body := r.Body
+		if body == nil {
+			body = NoBody
+		}
+		r.Body = &digestReader{ReadCloser: body, alg: a.name, h: a.new(), want: want}
+		return nil
+	}
+	return errors.New("http: no supported algorithm in Content-Digest header")
+}
+
+// SetContentDigest computes the digest of the request body using
+// the named algorithm, which must be "sha-256" or "sha-512", and
+// sets the request's Content-Digest header to the result.
+//
+// The body is read through GetBody, so r.Body itself is left unread.
+// For a request with a non-nil Body, GetBody must be set, as it is for
+// requests created by NewRequest with a *bytes.Buffer, *bytes.Reader,
+// or *strings.Reader body.
+func (r *Request) SetContentDigest(alg string) error {
+	newHash := contentDigestHash(alg)
+	if newHash == nil {
+		return errors.New("http: unsupported Content-Digest algorithm " + alg)
+	}
+	h := newHash()
+	if r.Body != nil && r.Body != NoBody {
+		if r.GetBody == nil {
+			return errors.New("http: SetContentDigest requires a Request with GetBody")
+		}
+		body, err := r.GetBody()
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(h, body)
+		body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	r.Header.Set("Content-Digest", alg+"=:"+base64.StdEncoding.EncodeToString(h.Sum(nil))+":")
+	return nil
+}
+
+// digestReader hashes the data read from a request body and
+// reports a *ContentDigestError at EOF if it doesn't match want.
+type digestReader struct {
+	io.ReadCloser
+	alg  string
+	h    hash.Hash
+	want []byte
+	err  error // sticky error, set once EOF has been reached
+}
+
+func (d *digestReader) Read(p []byte) (n int, err error) {
+	if d.err != nil {
+		return 0, d.err
+	}
+	n, err = d.ReadCloser.Read(p)
+	d.h.Write(p[:n])
+	if err == io.EOF {
+		if !bytes.Equal(d.h.Sum(nil), d.want) {
+			err = &ContentDigestError{Algorithm: d.alg}
+		}
+		d.err = err
+	}
+	return n, err
+}
+
+// A ChecksumSpec is the checksum expected of a body downloaded by
+// DownloadVerified.
+type ChecksumSpec struct {
+	// Algorithm is the hash algorithm, named as in Content-Digest
+	// headers: "sha-256" or "sha-512". It may be empty only if Sum
+	// is nil, to use the strongest algorithm of the response's
+	// digest headers.
+	Algorithm string
+
+	// Sum is the expected digest. If nil, it is taken from the
+	// response's Content-Digest header, or from its older Digest
+	// header if it has no Content-Digest.
+	Sum []byte
+}
+
+// DownloadVerified copies the body of resp to w, hashing it as it is
+// copied, and returns the number of bytes copied. The body is read to
+// the end but not closed. If its digest does not match the expected
+// one, DownloadVerified returns a *ChecksumMismatchError, after the
+// whole body has been written to w, so that the caller should discard
+// what was written.
+//
+// The expected digest is expected.Sum, or the one in the response's
+// Content-Digest or Digest header for expected.Algorithm, or for the
+// strongest supported algorithm if expected.Algorithm is empty. A
+// header digest is of the body as sent, so it can't be used for a
+// response whose body the Transport decompressed, as reported by
+// resp.Uncompressed. DownloadVerified returns an error without
+// reading the body if there is no expected digest to check against,
+// or if the algorithm is not supported.
+func DownloadVerified(resp *Response, w io.Writer, expected ChecksumSpec) (int64, error) {
+	alg, want := expected.Algorithm, expected.Sum
+	if want == nil {
+		var err error
+		if alg, want, err = responseDigest(resp, alg); err != nil {
+			return 0, err
+		}
+	}
+	newHash := contentDigestHash(alg)
+	if newHash == nil {
+		return 0, errors.New("http: unsupported checksum algorithm " + strconv.Quote(alg))
+	}
+	h := newHash()
+	n, err := io.Copy(w, io.TeeReader(resp.Body, h))
+	if err != nil {
+		return n, err
+	}
+	if got := h.Sum(nil); !bytes.Equal(got, want) {
+		return n, &ChecksumMismatchError{Algorithm: alg, Want: want, Got: got}
+	}
+	return n, nil
+}
+
+// responseDigest returns the digest of the body of resp, and its
+// algorithm, from its Content-Digest or Digest header. If alg is
+// empty, the strongest supported algorithm is used.
+func responseDigest(resp *Response, alg string) (string, []byte, error) {
+	var digests map[string][]byte
+	if v := resp.Header.Get("Content-Digest"); v != "" {
+		digests = parseContentDigest(v)
+	} else if v := resp.Header.Get("Digest"); v != "" {
+		digests = parseDigest(v)
+	} else {
+		return "", nil, errors.New("http: no checksum for download: response has no Content-Digest header")
+	}
+	if resp.Uncompressed {
+		return "", nil, errors.New("http: can't verify Content-Digest of a decompressed response")
+	}
+	if alg != "" {
+		if want, ok := digests[alg]; ok {
+			return alg, want, nil
+		}
+		return "", nil, errors.New("http: no " + alg + " digest in response header")
+	}
+	for _, a := range contentDigestAlgs {
+		if want, ok := digests[a.name]; ok {
+			return a.name, want, nil
+		}
+	}
+	return "", nil, errors.New("http: no supported algorithm in response digest header")
+}
@@ -0,0 +1,184 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSetContentDigest(t *testing.T) {
+	tests := []struct {
+		alg  string
+		body io.Reader
+		want string
+	}{
+		{"sha-256", strings.NewReader("hello"), "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:"},
+		{"sha-256", nil, "sha-256=:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=:"},
+		{"sha-512", strings.NewReader("hello"), "sha-512=:m3HSJL1i83hdltRq0+o9czGb+8KJDKra4t/3JRlnPKcjI8PZm6XBHXx6zG4UuMXaDEZjR1wuXDre9G9zvN7AQw==:"},
+	}
+	for _, tt := range tests {
+		req, err := NewRequest("POST", "http://example.com/", tt.body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := req.SetContentDigest(tt.alg); err != nil {
+			t.Fatalf("SetContentDigest(%q): %v", tt.alg, err)
+		}
+		if got := req.Header.Get("Content-Digest"); got != tt.want {
+			t.Errorf("SetContentDigest(%q) header = %q; want %q", tt.alg, got, tt.want)
+		}
+	}
+
+	req, _ := NewRequest("POST", "http://example.com/", strings.NewReader("x"))
+	if err := req.SetContentDigest("md5"); err == nil {
+		t.Error("SetContentDigest(md5) succeeded; want error")
+	}
+	req.GetBody = nil
+	if err := req.SetContentDigest("sha-256"); err == nil {
+		t.Error("SetContentDigest without GetBody succeeded; want error")
+	}
+}
+
+func TestVerifyContentDigest(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if err := r.VerifyContentDigest(); err != nil {
+			Error(w, err.Error(), StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		var de *ContentDigestError
+		if errors.As(err, &de) {
+			Error(w, de.Algorithm+" mismatch", StatusBadRequest)
+			return
+		}
+		if err != nil {
+			t.Errorf("reading body: %v", err)
+		}
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		digest   string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "match",
+			body:     "hello",
+			digest:   "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:",
+			wantCode: 200,
+			wantBody: "hello",
+		},
+		{
+			name:     "mismatch",
+			body:     "hellO",
+			digest:   "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:",
+			wantCode: 400,
+			wantBody: "sha-256 mismatch\n",
+		},
+		{
+			name:     "strongest wins",
+			body:     "hello",
+			digest:   "sha-256=:LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ=:, SHA-512=:AAAA:",
+			wantCode: 400,
+			wantBody: "sha-512 mismatch\n",
+		},
+		{
+			name:     "unsupported",
+			body:     "hello",
+			digest:   "md5=:XUFAKrxLKna5cZ2REBfFkg==:",
+			wantCode: 400,
+			wantBody: "http: no supported algorithm in Content-Digest header\n",
+		},
+		{
+			name:     "missing",
+			body:     "hello",
+			wantCode: 400,
+			wantBody: "http: missing Content-Digest header\n",
+		},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("POST", ts.URL, strings.NewReader(tt.body))
+		if tt.digest != "" {
+			req.Header.Set("Content-Digest", tt.digest)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.wantCode || string(body) != tt.wantBody {
+			t.Errorf("%s: got %d %q; want %d %q", tt.name, res.StatusCode, body, tt.wantCode, tt.wantBody)
+		}
+	}
+}
+
+func TestDownloadVerified(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	// The sha-256 digest of "hello".
+	const sum = "LPJNul+wow4m6DsqxbninhsWHlwfp0JecwQzYpOLmCQ="
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if k, v, ok := strings.Cut(r.Header.Get("X-Response-Header"), "="); ok {
+			w.Header().Set(k, v)
+		}
+		io.WriteString(w, "hello")
+	}))
+	defer ts.Close()
+
+	want, _ := base64.StdEncoding.DecodeString(sum)
+	tests := []struct {
+		name     string
+		header   string // name=value of the response header
+		spec     ChecksumSpec
+		wantErr  string
+		wantBody string
+	}{
+		{name: "content-digest", header: "Content-Digest=sha-256=:" + sum + ":", wantBody: "hello"},
+		{name: "legacy digest", header: "Digest=SHA-256=" + sum, wantBody: "hello"},
+		{name: "given sum", spec: ChecksumSpec{Algorithm: "sha-256", Sum: want}, wantBody: "hello"},
+		{name: "given algorithm", header: "Content-Digest=sha-256=:" + sum + ":", spec: ChecksumSpec{Algorithm: "sha-256"}, wantBody: "hello"},
+		{name: "mismatch", header: "Content-Digest=sha-256=:" + sum + ":, sha-512=:AAAA:", wantErr: "http: sha-512 checksum mismatch", wantBody: "hello"},
+		{name: "given mismatch", spec: ChecksumSpec{Algorithm: "sha-256", Sum: []byte("x")}, wantErr: "http: sha-256 checksum mismatch", wantBody: "hello"},
+		{name: "no header", wantErr: "http: no checksum for download: response has no Content-Digest header"},
+		{name: "missing algorithm", header: "Content-Digest=sha-256=:" + sum + ":", spec: ChecksumSpec{Algorithm: "sha-512"}, wantErr: "http: no sha-512 digest in response header"},
+		{name: "unsupported", spec: ChecksumSpec{Algorithm: "md5", Sum: []byte("x")}, wantErr: `http: unsupported checksum algorithm "md5"`},
+	}
+	for _, tt := range tests {
+		req, _ := NewRequest("GET", ts.URL, nil)
+		req.Header.Set("X-Response-Header", tt.header)
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var buf strings.Builder
+		n, err := DownloadVerified(res, &buf, tt.spec)
+		res.Body.Close()
+		gotErr := ""
+		if err != nil {
+			gotErr = err.Error()
+		}
+		if gotErr != tt.wantErr || buf.String() != tt.wantBody || n != int64(len(tt.wantBody)) {
+			t.Errorf("%s: got %d %q, error %q; want %q, error %q", tt.name, n, buf.String(), gotErr, tt.wantBody, tt.wantErr)
+		}
+		var me *ChecksumMismatchError
+		if errors.As(err, &me) && (me.Got == nil || bytes.Equal(me.Got, me.Want)) {
+			t.Errorf("%s: mismatch error has Got %x, Want %x", tt.name, me.Got, me.Want)
+		}
+	}
+}
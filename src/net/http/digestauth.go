@@ -0,0 +1,358 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Client-side Digest access authentication (RFC 7616).
+
+package http
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http/internal/ascii"
+	"net/textproto"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// NewDigestTransport returns a RoundTripper that authenticates its
+// requests with the given username and password using HTTP Digest
+// access authentication, sending them with rt, or DefaultTransport if
+// rt is nil.
+//
+// When a response is a 401 Unauthorized with a Digest challenge in its
+// WWW-Authenticate header, the transport retries the request once with
+// an Authorization header answering the challenge. The SHA-256 and MD5
+// algorithms, and their "-sess" variants, are supported, with a qop of
+// "auth" or none. Only requests whose body can be sent again are
+// retried: those with no body, or with GetBody set. If the request
+// can't be retried, or the server offers only unsupported algorithms,
+// the 401 response is returned as it is.
+//
+// The last challenge from each host is remembered, so that later
+// requests to it are authenticated without waiting for another 401,
+// with the nonce count incremented for each.
+func NewDigestTransport(rt RoundTripper, username, password string) RoundTripper {
+	if rt == nil {
+		rt = DefaultTransport
+	}
+	return &digestTransport{
+		rt:         rt,
+		username:   username,
+		password:   password,
+		challenges: make(map[string]*digestChallenge),
+	}
+}
+
+type digestTransport struct {
+	rt                 RoundTripper
+	username, password string
+
+	mu         sync.Mutex
+	challenges map[string]*digestChallenge // by scheme://host
+}
+
+// A digestChallenge is a Digest challenge from a server, and the
+// number of requests authenticated with its nonce.
+type digestChallenge struct {
+	realm, nonce, opaque string
+	algorithm            string // as sent, or "MD5" if absent
+	newHash              func() hash.Hash
+	sess                 bool // a "-sess" algorithm
+	qop                  bool // qop=auth offered
+	nc                   uint32
+}
+
+func (t *digestTransport) RoundTrip(req *Request) (*Response, error) {
+	space := req.URL.Scheme + "://" + req.URL.Host
+	t.mu.Lock()
+	c := t.challenges[space]
+	t.mu.Unlock()
+
+	rewindable := req.Body == nil || req.Body == NoBody || req.GetBody != nil
+	orig := req
+	if c != nil {
+		req = t.authorize(orig, c)
+	}
+	resp, err := t.rt.RoundTrip(req)
+	if err != nil || resp.StatusCode != StatusUnauthorized || !rewindable {
+		return resp, err
+	}
+	c = selectDigestChallenge(resp.Header.Values("Www-Authenticate"))
+	if c == nil {
+		return resp, nil
+	}
+	req = t.authorize(orig, c)
+	if orig.GetBody != nil && orig.Body != nil && orig.Body != NoBody {
+		if req.Body, err = orig.GetBody(); err != nil {
+			return resp, nil
+		}
+	}
+	t.mu.Lock()
+	t.challenges[space] = c
+	t.mu.Unlock()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4<<10))
+	resp.Body.Close()
+	return t.rt.RoundTrip(req)
+}
+
+// authorize returns a copy of req with an Authorization header
+// answering challenge c, counting the use of its nonce.
+func (t *digestTransport) authorize(req *Request, c *digestChallenge) *Request {
+	t.mu.Lock()
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+	t.mu.Unlock()
+
+	var b [16]byte
+	if _, err := io.ReadFull(rand.Reader, b[:]); err != nil {
+		panic("http: can't read random cnonce: " + err.Error())
+	}
+	cnonce := hex.EncodeToString(b[:])
+	uri := req.URL.RequestURI()
+
+	h := func(s string) string {
+		hh := c.newHash()
+		io.WriteString(hh, s)
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	ha1 := h(t.username + ":" + c.realm + ":" + t.password)
+	if c.sess {
+		ha1 = h(ha1 + ":" + c.nonce + ":" + cnonce)
+	}
+	ha2 := h(req.Method + ":" + uri)
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Digest username=%s, realm=%s, nonce=%s, uri=%s, algorithm=%s",
+		quoteAuthParam(t.username), quoteAuthParam(c.realm), quoteAuthParam(c.nonce),
+		quoteAuthParam(uri), c.algorithm)
+	if c.qop {
+		fmt.Fprintf(&sb, ", response=%q, qop=auth, nc=%s, cnonce=%q",
+			h(ha1+":"+c.nonce+":"+nc+":"+cnonce+":auth:"+ha2), nc, cnonce)
+	} else {
+		fmt.Fprintf(&sb, ", response=%q", h(ha1+":"+c.nonce+":"+ha2))
+	}
+	if c.opaque != "" {
+		fmt.Fprintf(&sb, ", opaque=%s", quoteAuthParam(c.opaque))
+	}
+
+	r2 := req.Clone(req.Context())
+	r2.Header.Set("Authorization", sb.String())
+	return r2
+}
+
+// quoteAuthParam returns s as a quoted-string.
+func quoteAuthParam(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' || s[i] == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(s[i])
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// selectDigestChallenge returns the first supported Digest challenge
+// in the WWW-Authenticate header values, or nil if there is none.
+func selectDigestChallenge(vals []string) *digestChallenge {
+	for _, v := range vals {
+		for _, ch := range parseAuthChallenges(v) {
+			if !ascii.EqualFold(ch.scheme, "Digest") {
+				continue
+			}
+			if c := newDigestChallenge(ch.params); c != nil {
+				return c
+			}
+		}
+	}
+	return nil
+}
+
+// newDigestChallenge returns the Digest challenge with the given
+// parameters, or nil if it can't be answered.
+func newDigestChallenge(params map[string]string) *digestChallenge {
+	nonce, ok := params["nonce"]
+	if !ok {
+		return nil
+	}
+	c := &digestChallenge{
+		realm:     params["realm"],
+		nonce:     nonce,
+		opaque:    params["opaque"],
+		algorithm: params["algorithm"],
+	}
+	if c.algorithm == "" {
+		c.algorithm = "MD5"
+	}
+	alg, ok := ascii.ToLower(c.algorithm)
+	if !ok {
+		return nil
+	}
+	if strings.HasSuffix(alg, "-sess") {
+		alg = alg[:len(alg)-len("-sess")]
+		c.sess = true
+	}
+	switch alg {
+	case "sha-256":
+		c.newHash = sha256.New
+	case "md5":
+		c.newHash = md5.New
+	default:
+		return nil
+	}
+	if qop, ok := params["qop"]; ok {
+		for _, q := range strings.Split(qop, ",") {
+			if ascii.EqualFold(textproto.TrimString(q), "auth") {
+				c.qop = true
+			}
+		}
+		if !c.qop {
+			return nil // only auth-int, or unknown qops
+		}
+	} else if c.sess {
+		return nil // the -sess algorithms need a cnonce
+	}
+	return c
+}
+
+// An authChallenge is a challenge from a WWW-Authenticate header.
+type authChallenge struct {
+	scheme string
+	params map[string]string // by lowercase name
+}
+
+// parseAuthChallenges parses the comma-separated challenges of a
+// WWW-Authenticate header value (RFC 9110, Section 11.6.1). Challenges
+// with a token68 rather than parameters are returned with no
+// parameters.
+func parseAuthChallenges(v string) []authChallenge {
+	var chs []authChallenge
+	p := authParser{s: v}
+	for p.i < len(p.s) {
+		p.skip(", \t")
+		scheme := p.token()
+		if scheme == "" {
+			if p.i < len(p.s) {
+				p.i++ // skip a byte that starts nothing
+			}
+			continue
+		}
+		ch := authChallenge{scheme: scheme, params: make(map[string]string)}
+		p.skip(" \t")
+		if !p.token68() {
+			for {
+				p.skip(", \t")
+				start := p.i
+				name := p.token()
+				p.skip(" \t")
+				if name == "" || !p.consume('=') {
+					p.i = start // the next challenge's scheme
+					break
+				}
+				p.skip(" \t")
+				var val string
+				if p.peek() == '"' {
+					val = p.quoted()
+				} else {
+					val = p.token()
+				}
+				if lname, ok := ascii.ToLower(name); ok {
+					ch.params[lname] = val
+				}
+			}
+		}
+		chs = append(chs, ch)
+	}
+	return chs
+}
+
+type authParser struct {
+	s string
+	i int
+}
+
+func (p *authParser) peek() byte {
+	if p.i < len(p.s) {
+		return p.s[p.i]
+	}
+	return 0
+}
+
+func (p *authParser) consume(c byte) bool {
+	if p.i < len(p.s) && p.s[p.i] == c {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *authParser) skip(chars string) {
+	for p.i < len(p.s) && strings.IndexByte(chars, p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *authParser) token() string {
+	start := p.i
+	for p.i < len(p.s) && httpguts.IsTokenRune(rune(p.s[p.i])) {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// token68 skips the token68 (RFC 9110, Section 11.2) at p.i, if
+// it is one, and reports whether it was.
+func (p *authParser) token68() bool {
+	start := p.i
+	for p.i < len(p.s) && isToken68Byte(p.s[p.i]) {
+		p.i++
+	}
+	if p.i == start {
+		return false
+	}
+	for p.consume('=') {
+	}
+	end := p.i
+	p.skip(" \t")
+	if p.i < len(p.s) && p.s[p.i] != ',' {
+		p.i = start
+		return false
+	}
+	p.i = end
+	return true
+}
+
+func isToken68Byte(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' ||
+		strings.IndexByte("-._~+/", c) >= 0
+}
+
+// quoted returns the unquoted value of the quoted-string at p.i.
+func (p *authParser) quoted() string {
+	var b strings.Builder
+	p.i++ // opening quote
+	for p.i < len(p.s) {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '"':
+			return b.String()
+		case c == '\\' && p.i < len(p.s):
+			b.WriteByte(p.s[p.i])
+			p.i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
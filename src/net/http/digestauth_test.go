@@ -0,0 +1,178 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// digestServer is a handler requiring Digest authentication of
+// user "Mufasa" with password "Circle of Life".
+type digestServer struct {
+	challenge string // the WWW-Authenticate header sent
+	newHash   func() hash.Hash
+	qop       bool
+
+	mu       sync.Mutex
+	requests int
+	ncs      []string // the nonce counts of authorized requests
+}
+
+var digestParamRE = regexp.MustCompile(`(\w+)=(?:"([^"]*)"|([^,\s]*))`)
+
+func (s *digestServer) ServeHTTP(w ResponseWriter, r *Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests++
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Digest ") {
+		w.Header().Set("Www-Authenticate", s.challenge)
+		w.WriteHeader(StatusUnauthorized)
+		return
+	}
+	p := make(map[string]string)
+	for _, m := range digestParamRE.FindAllStringSubmatch(auth, -1) {
+		p[m[1]] = m[2] + m[3]
+	}
+	h := func(v string) string {
+		hh := s.newHash()
+		io.WriteString(hh, v)
+		return hex.EncodeToString(hh.Sum(nil))
+	}
+	ha1 := h("Mufasa:" + p["realm"] + ":Circle of Life")
+	ha2 := h(r.Method + ":" + r.URL.RequestURI())
+	want := h(ha1 + ":" + p["nonce"] + ":" + ha2)
+	if s.qop {
+		want = h(ha1 + ":" + p["nonce"] + ":" + p["nc"] + ":" + p["cnonce"] + ":auth:" + ha2)
+	}
+	if p["username"] != "Mufasa" || p["realm"] != "test" || p["nonce"] != "n0nce" ||
+		p["uri"] != r.URL.RequestURI() || p["opaque"] != "0paque" || p["response"] != want {
+		w.Header().Set("Www-Authenticate", s.challenge)
+		w.WriteHeader(StatusUnauthorized)
+		return
+	}
+	s.ncs = append(s.ncs, p["nc"])
+	body, _ := io.ReadAll(r.Body)
+	w.Write(body)
+}
+
+func TestDigestTransport(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	tests := []struct {
+		name      string
+		challenge string
+		newHash   func() hash.Hash
+		qop       bool
+	}{
+		{
+			name:      "sha-256",
+			challenge: `Digest realm="test", qop="auth, auth-int", algorithm=SHA-256, nonce="n0nce", opaque="0paque"`,
+			newHash:   sha256.New,
+			qop:       true,
+		},
+		{
+			name:      "md5 default",
+			challenge: `Digest realm="test", qop="auth", nonce="n0nce", opaque="0paque"`,
+			newHash:   md5.New,
+			qop:       true,
+		},
+		{
+			name:      "no qop",
+			challenge: `Digest realm="test", nonce="n0nce", opaque="0paque", algorithm=MD5`,
+			newHash:   md5.New,
+		},
+		{
+			name:      "after other challenges",
+			challenge: `Negotiate, Basic realm="test", Digest realm="test", qop=auth, algorithm=SHA-512-256, nonce="x", Digest realm="test", qop=auth, algorithm=SHA-256, nonce="n0nce", opaque="0paque"`,
+			newHash:   sha256.New,
+			qop:       true,
+		},
+	}
+	for _, tt := range tests {
+		s := &digestServer{challenge: tt.challenge, newHash: tt.newHash, qop: tt.qop}
+		ts := httptest.NewServer(s)
+		c := &Client{Transport: NewDigestTransport(ts.Client().Transport, "Mufasa", "Circle of Life")}
+		for i, path := range []string{"/dir/index.html", "/a?b=c", "/"} {
+			res, err := c.Post(ts.URL+path, "text/plain", strings.NewReader("body"+path))
+			if err != nil {
+				t.Fatal(err)
+			}
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			if res.StatusCode != 200 || string(body) != "body"+path {
+				t.Errorf("%s: request %d: got %d %q; want 200 %q", tt.name, i, res.StatusCode, body, "body"+path)
+			}
+		}
+		ts.Close()
+		// Only the first request waits for a challenge.
+		if s.requests != 4 {
+			t.Errorf("%s: server got %d requests; want 4", tt.name, s.requests)
+		}
+		want := []string{"00000001", "00000002", "00000003"}
+		if !tt.qop {
+			want = []string{"", "", ""}
+		}
+		if strings.Join(s.ncs, ",") != strings.Join(want, ",") {
+			t.Errorf("%s: nonce counts = %q; want %q", tt.name, s.ncs, want)
+		}
+	}
+}
+
+func TestDigestTransportNotRetried(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	tests := []struct {
+		name      string
+		challenge string
+		body      io.Reader
+	}{
+		{
+			name:      "unsupported algorithm",
+			challenge: `Digest realm="test", qop="auth", algorithm=SHA-512-256, nonce="n0nce", opaque="0paque"`,
+		},
+		{
+			name:      "unsupported qop",
+			challenge: `Digest realm="test", qop="auth-int", nonce="n0nce", opaque="0paque"`,
+		},
+		{
+			name:      "not digest",
+			challenge: `Basic realm="test"`,
+		},
+		{
+			name:      "body not rewindable",
+			challenge: `Digest realm="test", qop="auth", nonce="n0nce", opaque="0paque"`,
+			body:      io.MultiReader(strings.NewReader("body")),
+		},
+	}
+	for _, tt := range tests {
+		s := &digestServer{challenge: tt.challenge, newHash: md5.New, qop: true}
+		ts := httptest.NewServer(s)
+		c := &Client{Transport: NewDigestTransport(ts.Client().Transport, "Mufasa", "Circle of Life")}
+		req, _ := NewRequest("POST", ts.URL, tt.body)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		ts.Close()
+		if res.StatusCode != StatusUnauthorized || res.Header.Get("Www-Authenticate") != tt.challenge {
+			t.Errorf("%s: got %d, WWW-Authenticate %q; want the original 401", tt.name, res.StatusCode, res.Header.Get("Www-Authenticate"))
+		}
+		if s.requests != 1 {
+			t.Errorf("%s: server got %d requests; want 1", tt.name, s.requests)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Caching of host name lookups for Transport.
+
+package http
+
+import (
+	"context"
+	"internal/singleflight"
+	"net"
+	"sync"
+	"time"
+)
+
+// A DNSCache resolves the host names a Transport dials, caching the
+// results so that requests to the same hosts don't each wait for a
+// lookup. It must be safe for concurrent use by multiple goroutines.
+type DNSCache interface {
+	// Lookup returns the IP addresses of host, in the order they
+	// should be dialed. The caller must not modify the returned
+	// slice.
+	Lookup(ctx context.Context, host string) ([]net.IPAddr, error)
+
+	// Invalidate discards any addresses cached for host. The
+	// Transport calls it when dialing one of the addresses fails,
+	// so that the next Lookup doesn't return a stale address.
+	Invalidate(host string)
+}
+
+// TTLDNSCache is a DNSCache that keeps the addresses of each host for
+// a fixed time after looking them up. Failed lookups are not cached.
+// The zero value is ready to use. A TTLDNSCache must not be copied
+// after first use.
+type TTLDNSCache struct {
+	// TTL is how long the addresses of a host are used before they
+	// are looked up again. If zero, one minute is used.
+	TTL time.Duration
+
+	// Resolver looks up hosts. If nil, net.DefaultResolver is used.
+	Resolver *net.Resolver
+
+	group singleflight.Group // lookups in progress, by host
+
+	mu        sync.Mutex
+	entries   map[string]dnsCacheEntry
+	lastSweep time.Time
+}
+
+type dnsCacheEntry struct {
+	addrs   []net.IPAddr
+	expires time.Time
+}
+
+var (
+	testHookLookupIPAddr func(ctx context.Context, host string) ([]net.IPAddr, error)
+	testHookDialIP       func(ctx context.Context, network, addr string) (net.Conn, error)
+)
+
+// Lookup implements DNSCache. Concurrent lookups of a host that isn't
+// cached share one query to the Resolver, which is not canceled with
+// the context of any one caller; a caller whose context is done stops
+// waiting for it.
+func (c *TTLDNSCache) Lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	now := time.Now()
+	c.mu.Lock()
+	e, ok := c.entries[host]
+	c.mu.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.addrs, nil
+	}
+
+	ch, _ := c.group.DoChan(host, func() (any, error) {
+		return c.lookup(host)
+	})
+	select {
+	case r := <-ch:
+		if r.Err != nil {
+			return nil, r.Err
+		}
+		return r.Val.([]net.IPAddr), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// lookup looks up host and caches its addresses.
+func (c *TTLDNSCache) lookup(host string) ([]net.IPAddr, error) {
+	ctx := context.Background()
+	var addrs []net.IPAddr
+	var err error
+	if fn := testHookLookupIPAddr; fn != nil {
+		addrs, err = fn(ctx, host)
+	} else {
+		r := c.Resolver
+		if r == nil {
+			r = net.DefaultResolver
+		}
+		addrs, err = r.LookupIPAddr(ctx, host)
+	}
+	if err != nil {
+		return nil, err
+	}
+	ttl := c.TTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+
+	now := time.Now()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.entries == nil {
+		c.entries = make(map[string]dnsCacheEntry)
+	}
+	c.sweep(now, ttl)
+	c.entries[host] = dnsCacheEntry{addrs, now.Add(ttl)}
+	return addrs, nil
+}
+
+// sweep drops the expired entries once each TTL, so that the cache
+// only holds hosts in use. c.mu must be held.
+func (c *TTLDNSCache) sweep(now time.Time, ttl time.Duration) {
+	if now.Sub(c.lastSweep) < ttl {
+		return
+	}
+	c.lastSweep = now
+	for h, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, h)
+		}
+	}
+}
+
+// Invalidate implements DNSCache.
+func (c *TTLDNSCache) Invalidate(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, host)
+}
+
+// dialCached dials addr with d using the addresses t.DNSCache returns
+// for its host. Like net.Dialer, it races connections to the addresses of
+// the two IP families, as described by RFC 8305, unless
+// t.FallbackDelay is negative.
+func (t *Transport) dialCached(ctx context.Context, d *net.Dialer, network, addr string) (net.Conn, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || net.ParseIP(host) != nil {
+		return d.DialContext(ctx, network, addr)
+	}
+	ips, err := t.DNSCache.Lookup(ctx, host)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: err}
+	}
+	var primaries, fallbacks []net.IPAddr
+	for _, ip := range ips {
+		switch {
+		case network == "tcp4" && ip.IP.To4() == nil,
+			network == "tcp6" && ip.IP.To4() != nil:
+		case len(primaries) == 0 || (ip.IP.To4() != nil) == (primaries[0].IP.To4() != nil):
+			primaries = append(primaries, ip)
+		default:
+			fallbacks = append(fallbacks, ip)
+		}
+	}
+	if len(primaries) == 0 {
+		return nil, &net.OpError{Op: "dial", Net: network, Err: &net.AddrError{Err: "no suitable address found", Addr: host}}
+	}
+	if len(fallbacks) == 0 {
+		return t.dialSerial(ctx, d, network, host, port, primaries)
+	}
+	if t.FallbackDelay < 0 {
+		return t.dialSerial(ctx, d, network, host, port, append(primaries, fallbacks...))
+	}
+	return t.dialParallel(ctx, d, network, host, port, primaries, fallbacks)
+}
+
+// dialSerial dials the addresses ips of host with d in order until one
+// succeeds, returning the first error if none does.
+func (t *Transport) dialSerial(ctx context.Context, d *net.Dialer, network, host, port string, ips []net.IPAddr) (net.Conn, error) {
+	var firstErr error
+	for _, ip := range ips {
+		dial := d.DialContext
+		if fn := testHookDialIP; fn != nil {
+			dial = fn
+		}
+		c, err := dial(ctx, network, net.JoinHostPort(ip.String(), port))
+		if err == nil || ctx.Err() != nil {
+			// A dial stopped by ctx says nothing about the address.
+			return c, err
+		}
+		if firstErr == nil {
+			firstErr = err
+			t.DNSCache.Invalidate(host)
+		}
+	}
+	return nil, firstErr
+}
+
+// dialParallel races dialSerial over primaries against dialSerial
+// over fallbacks, which starts after the fallback delay or once the
+// primaries have failed, and returns the first connection made.
+func (t *Transport) dialParallel(ctx context.Context, d *net.Dialer, network, host, port string, primaries, fallbacks []net.IPAddr) (net.Conn, error) {
+	type dialResult struct {
+		c       net.Conn
+		err     error
+		primary bool
+		done    bool
+	}
+	results := make(chan dialResult) // unbuffered
+	returned := make(chan struct{})
+	defer close(returned)
+
+	startRacer := func(ctx context.Context, primary bool) {
+		ips := primaries
+		if !primary {
+			ips = fallbacks
+		}
+		c, err := t.dialSerial(ctx, d, network, host, port, ips)
+		select {
+		case results <- dialResult{c: c, err: err, primary: primary, done: true}:
+		case <-returned:
+			if c != nil {
+				c.Close()
+			}
+		}
+	}
+
+	var primary, fallback dialResult
+
+	primaryCtx, primaryCancel := context.WithCancel(ctx)
+	defer primaryCancel()
+	go startRacer(primaryCtx, true)
+
+	delay := t.FallbackDelay
+	if delay == 0 {
+		delay = 300 * time.Millisecond // the default of net.Dialer
+	}
+	fallbackTimer := time.NewTimer(delay)
+	defer fallbackTimer.Stop()
+
+	for {
+		select {
+		case <-fallbackTimer.C:
+			fallbackCtx, fallbackCancel := context.WithCancel(ctx)
+			defer fallbackCancel()
+			go startRacer(fallbackCtx, false)
+
+		case res := <-results:
+			if res.err == nil {
+				return res.c, nil
+			}
+			if res.primary {
+				primary = res
+			} else {
+				fallback = res
+			}
+			if primary.done && fallback.done {
+				return nil, primary.err
+			}
+			if res.primary && fallbackTimer.Stop() {
+				// The primaries failed before the fallback
+				// delay, so start the fallbacks now.
+				fallbackTimer.Reset(0)
+			}
+		}
+	}
+}
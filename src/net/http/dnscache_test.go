@@ -0,0 +1,250 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeDNSCache is a DNSCache returning fixed addresses and recording
+// the calls made to it.
+type fakeDNSCache struct {
+	addrs []net.IPAddr
+
+	mu          sync.Mutex
+	lookups     []string
+	invalidated []string
+}
+
+func (c *fakeDNSCache) Lookup(ctx context.Context, host string) ([]net.IPAddr, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lookups = append(c.lookups, host)
+	return c.addrs, nil
+}
+
+func (c *fakeDNSCache) Invalidate(host string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.invalidated = append(c.invalidated, host)
+}
+
+func TestTransportDNSCache(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, r.Host)
+	}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+	host := "example.test:" + u.Port()
+
+	get := func(tr *Transport) string {
+		t.Helper()
+		defer tr.CloseIdleConnections()
+		res, err := (&Client{Transport: tr}).Get("http://" + host + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return string(b)
+	}
+
+	// Nothing listens on 127.0.0.2, so the dialer falls back to the
+	// second address and invalidates the host.
+	cache := &fakeDNSCache{addrs: []net.IPAddr{
+		{IP: net.ParseIP("127.0.0.2")},
+		{IP: net.ParseIP("127.0.0.1")},
+	}}
+	if got := get(&Transport{DNSCache: cache}); got != host {
+		t.Errorf("got Host %q; want %q", got, host)
+	}
+	if len(cache.lookups) != 1 || cache.lookups[0] != "example.test" {
+		t.Errorf("lookups = %q; want [example.test]", cache.lookups)
+	}
+	if len(cache.invalidated) != 1 || cache.invalidated[0] != "example.test" {
+		t.Errorf("invalidated = %q; want [example.test]", cache.invalidated)
+	}
+
+	// The DialContext of DefaultTransport doesn't keep a clone of it
+	// from using the cache.
+	cache = &fakeDNSCache{addrs: []net.IPAddr{{IP: net.ParseIP("127.0.0.1")}}}
+	tr := DefaultTransport.(*Transport).Clone()
+	tr.Proxy = nil
+	tr.DNSCache = cache
+	if got := get(tr); got != host {
+		t.Errorf("DefaultTransport clone: got Host %q; want %q", got, host)
+	}
+	if len(cache.lookups) != 1 || cache.lookups[0] != "example.test" {
+		t.Errorf("DefaultTransport clone: lookups = %q; want [example.test]", cache.lookups)
+	}
+
+	// A DialContext of the caller's takes precedence over the cache.
+	cache = &fakeDNSCache{}
+	tr = &Transport{
+		DNSCache: cache,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return net.Dial(network, u.Host)
+		},
+	}
+	get(tr)
+	if len(cache.lookups) != 0 {
+		t.Errorf("cache used with DialContext set: lookups = %q", cache.lookups)
+	}
+}
+
+func TestTTLDNSCache(t *testing.T) {
+	var lookups int
+	SetTestHookLookupIPAddr(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		lookups++
+		if host == "bad.test" {
+			return nil, errors.New("no such host")
+		}
+		return []net.IPAddr{{IP: net.IPv4(192, 0, 2, byte(lookups))}}, nil
+	})
+	defer SetTestHookLookupIPAddr(nil)
+
+	c := &TTLDNSCache{TTL: time.Hour}
+	ctx := context.Background()
+	lookup := func(host string) string {
+		t.Helper()
+		addrs, err := c.Lookup(ctx, host)
+		if err != nil || len(addrs) != 1 {
+			t.Fatalf("Lookup(%q) = %v, %v", host, addrs, err)
+		}
+		return addrs[0].String()
+	}
+	if got := lookup("a.test"); got != "192.0.2.1" {
+		t.Errorf("first lookup = %s; want 192.0.2.1", got)
+	}
+	if got := lookup("a.test"); got != "192.0.2.1" || lookups != 1 {
+		t.Errorf("cached lookup = %s after %d lookups; want 192.0.2.1 after 1", got, lookups)
+	}
+	c.Invalidate("a.test")
+	if got := lookup("a.test"); got != "192.0.2.2" {
+		t.Errorf("lookup after Invalidate = %s; want 192.0.2.2", got)
+	}
+	for i := 0; i < 2; i++ {
+		if _, err := c.Lookup(ctx, "bad.test"); err == nil {
+			t.Errorf("Lookup(bad.test) = nil error")
+		}
+	}
+	if lookups != 4 {
+		t.Errorf("%d lookups; want 4, as failures are not cached", lookups)
+	}
+
+	c = &TTLDNSCache{TTL: time.Nanosecond}
+	lookup("a.test")
+	time.Sleep(time.Millisecond)
+	lookup("a.test")
+	if lookups != 6 {
+		t.Errorf("%d lookups; want 6, as entries expire", lookups)
+	}
+}
+
+func TestTTLDNSCacheConcurrent(t *testing.T) {
+	var lookups int32
+	started := make(chan bool, 1)
+	release := make(chan bool)
+	SetTestHookLookupIPAddr(func(ctx context.Context, host string) ([]net.IPAddr, error) {
+		if atomic.AddInt32(&lookups, 1) == 1 {
+			started <- true
+		}
+		<-release
+		return []net.IPAddr{{IP: net.IPv4(192, 0, 2, 1)}}, nil
+	})
+	defer SetTestHookLookupIPAddr(nil)
+
+	c := &TTLDNSCache{TTL: time.Hour}
+	// A caller that gives up doesn't cancel the lookup for the others.
+	ctx, cancel := context.WithCancel(context.Background())
+	errc := make(chan error, 1)
+	go func() {
+		_, err := c.Lookup(ctx, "a.test")
+		errc <- err
+	}()
+	<-started
+	cancel()
+	if err := <-errc; err != context.Canceled {
+		t.Errorf("Lookup with canceled context = %v; want context.Canceled", err)
+	}
+
+	const n = 5
+	var wg sync.WaitGroup
+	wg.Add(n)
+	for i := 0; i < n; i++ {
+		go func() {
+			defer wg.Done()
+			if addrs, err := c.Lookup(context.Background(), "a.test"); err != nil || len(addrs) != 1 {
+				t.Errorf("Lookup = %v, %v", addrs, err)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&lookups); n != 1 {
+		t.Errorf("%d lookups for concurrent callers; want 1", n)
+	}
+}
+
+func TestTransportFallbackDelay(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer ts.Close()
+	u, _ := url.Parse(ts.URL)
+
+	// The IPv6 address hangs, as on a host with broken IPv6.
+	SetTestHookDialIP(func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if strings.HasPrefix(addr, "[") {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+		return net.Dial(network, u.Host)
+	})
+	defer SetTestHookDialIP(nil)
+	// Wait for dials outliving their requests before the hook is reset.
+	var dials sync.WaitGroup
+	SetPendingDialHooks(func() { dials.Add(1) }, dials.Done)
+	defer SetPendingDialHooks(nil, nil)
+	cache := &fakeDNSCache{addrs: []net.IPAddr{
+		{IP: net.ParseIP("2001:db8::1")},
+		{IP: net.ParseIP("192.0.2.1")},
+	}}
+	get := func(delay time.Duration) error {
+		tr := &Transport{DNSCache: cache, FallbackDelay: delay}
+		defer tr.CloseIdleConnections()
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		req, _ := NewRequestWithContext(ctx, "GET", "http://example.test:"+u.Port(), nil)
+		res, err := tr.RoundTrip(req)
+		if err != nil {
+			return err
+		}
+		res.Body.Close()
+		return nil
+	}
+	if err := get(10 * time.Millisecond); err != nil {
+		t.Errorf("with FallbackDelay: %v", err)
+	}
+	if err := get(-1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("with negative FallbackDelay: %v; want context.DeadlineExceeded", err)
+	}
+	dials.Wait()
+	if len(cache.invalidated) != 0 {
+		t.Errorf("invalidated = %q after canceled dials; want none", cache.invalidated)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Parallel downloads of byte ranges.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// maxDownloadAttempts is the number of times ParallelDownload starts
+// a download before giving up on a resource that keeps changing.
+const maxDownloadAttempts = 3
+
+var errResourceChanged = errors.New("http: resource changed during parallel download")
+
+// ParallelDownload downloads the resource at url into w, fetching the
+// given number of byte ranges of it concurrently, and returns the
+// number of bytes written. The requests are sent with ctx, so
+// canceling ctx stops the download.
+//
+// ParallelDownload first sends a HEAD request. If the response does
+// not give the resource's Content-Length or advertise support for
+// byte ranges with "Accept-Ranges: bytes", or if parts is less than 2,
+// the resource is downloaded with a single GET request instead.
+// Otherwise, one GET request for each range runs concurrently, and
+// its body is written at the range's offset in w.
+//
+// If the resource's ETag changes between the requests, the download
+// starts over, a few times at most. Responses with a strong ETag are
+// requested with If-Range, so the server can report the change.
+//
+// On error, w may hold any part of the resource.
+func (c *Client) ParallelDownload(ctx context.Context, url string, w io.WriterAt, parts int) (int64, error) {
+	var err error
+	for attempt := 0; attempt < maxDownloadAttempts; attempt++ {
+		var n int64
+		n, err = c.parallelDownload(ctx, url, w, parts)
+		if err != errResourceChanged {
+			return n, err
+		}
+	}
+	return 0, err
+}
+
+func (c *Client) parallelDownload(ctx context.Context, url string, w io.WriterAt, parts int) (int64, error) {
+	req, err := NewRequestWithContext(ctx, "HEAD", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusOK {
+		return 0, fmt.Errorf("http: unexpected status %q for HEAD %s", res.Status, url)
+	}
+	size, etag := res.ContentLength, res.Header.get("Etag")
+	if parts < 2 || size < 2 || !hasToken(res.Header.get("Accept-Ranges"), "bytes") {
+		return c.downloadStream(ctx, url, w)
+	}
+	if int64(parts) > size {
+		parts = int(size)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	errc := make(chan error, parts)
+	partSize := size / int64(parts)
+	for i := 0; i < parts; i++ {
+		start, end := int64(i)*partSize, int64(i+1)*partSize-1
+		if i == parts-1 {
+			end = size - 1
+		}
+		go func() {
+			errc <- c.downloadRange(ctx, url, w, start, end, size, etag)
+		}()
+	}
+	var firstErr error
+	for i := 0; i < parts; i++ {
+		if err := <-errc; err != nil && firstErr == nil {
+			firstErr = err
+			cancel() // stop the other ranges
+		}
+	}
+	if firstErr != nil {
+		return 0, firstErr
+	}
+	return size, nil
+}
+
+// downloadRange downloads bytes start through end of the resource at
+// url, which should have the given size and etag, into w.
+func (c *Client) downloadRange(ctx context.Context, url string, w io.WriterAt, start, end, size int64, etag string) error {
+	req, err := NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+	if etag != "" && !strings.HasPrefix(etag, "W/") {
+		req.Header.Set("If-Range", etag)
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.Header.get("Etag") != etag {
+		return errResourceChanged
+	}
+	if res.StatusCode != StatusPartialContent {
+		if res.StatusCode == StatusOK && req.Header.has("If-Range") {
+			return errResourceChanged
+		}
+		return fmt.Errorf("http: unexpected status %q for range of %s", res.Status, url)
+	}
+	if res.Header.get("Content-Range") != fmt.Sprintf("bytes %d-%d/%d", start, end, size) {
+		return errResourceChanged
+	}
+	n, err := io.Copy(&offsetWriter{w, start}, io.LimitReader(res.Body, end-start+1))
+	if err == nil && n < end-start+1 {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// downloadStream downloads the resource at url into w with a single
+// request.
+func (c *Client) downloadStream(ctx context.Context, url string, w io.WriterAt) (int64, error) {
+	req, err := NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return 0, err
+	}
+	res, err := c.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != StatusOK {
+		return 0, fmt.Errorf("http: unexpected status %q for GET %s", res.Status, url)
+	}
+	return io.Copy(&offsetWriter{w, 0}, res.Body)
+}
+
+// offsetWriter writes to w sequentially from offset off.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
@@ -0,0 +1,138 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writerAtBuffer is an io.WriterAt writing into a growable byte slice.
+type writerAtBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if end := int(off) + len(p); end > len(b.buf) {
+		b.buf = append(b.buf, make([]byte, end-len(b.buf))...)
+	}
+	return copy(b.buf[off:], p), nil
+}
+
+func TestClientParallelDownload(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	content := strings.Repeat("0123456789abcdef", 1000)
+	var mu sync.Mutex
+	var ranges int
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		if r.Header.Get("Range") != "" {
+			ranges++
+		}
+		mu.Unlock()
+		w.Header().Set("Etag", `"v1"`)
+		ServeContent(w, r, "", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	for _, parts := range []int{1, 4, 7} {
+		mu.Lock()
+		ranges = 0
+		mu.Unlock()
+		var b writerAtBuffer
+		n, err := ts.Client().ParallelDownload(context.Background(), ts.URL, &b, parts)
+		if err != nil {
+			t.Fatalf("parts=%d: %v", parts, err)
+		}
+		if n != int64(len(content)) || !bytes.Equal(b.buf, []byte(content)) {
+			t.Errorf("parts=%d: downloaded %d bytes, equal=%v; want all %d", parts, n, bytes.Equal(b.buf, []byte(content)), len(content))
+		}
+		wantRanges := parts
+		if parts == 1 {
+			wantRanges = 0
+		}
+		mu.Lock()
+		got := ranges
+		mu.Unlock()
+		if got != wantRanges {
+			t.Errorf("parts=%d: server got %d range requests; want %d", parts, got, wantRanges)
+		}
+	}
+}
+
+func TestClientParallelDownloadNoRanges(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	const content = "no ranges here"
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.Header.Get("Range") != "" {
+			t.Errorf("got Range request")
+		}
+		io.WriteString(w, content)
+	}))
+	defer ts.Close()
+	var b writerAtBuffer
+	n, err := ts.Client().ParallelDownload(context.Background(), ts.URL, &b, 4)
+	if err != nil || n != int64(len(content)) || string(b.buf) != content {
+		t.Errorf("ParallelDownload = %d, %v with %q; want %d, nil with %q", n, err, b.buf, len(content), content)
+	}
+}
+
+func TestClientParallelDownloadChanged(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	versions := []string{strings.Repeat("a", 1000), strings.Repeat("b", 1200)}
+	var mu sync.Mutex
+	requests := 0
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		requests++
+		v := 0
+		if requests > 2 { // the HEAD and the first range see version 0
+			v = 1
+		}
+		mu.Unlock()
+		w.Header().Set("Etag", `"v`+string(rune('0'+v))+`"`)
+		ServeContent(w, r, "", time.Time{}, strings.NewReader(versions[v]))
+	}))
+	defer ts.Close()
+	var b writerAtBuffer
+	n, err := ts.Client().ParallelDownload(context.Background(), ts.URL, &b, 4)
+	if err != nil || n != int64(len(versions[1])) || string(b.buf) != versions[1] {
+		t.Errorf("ParallelDownload = %d, %v; want %d bytes of the new version", n, err, len(versions[1]))
+	}
+}
+
+func TestClientParallelDownloadCanceled(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Accept-Ranges", "bytes")
+		w.Header().Set("Content-Length", "1000")
+		if r.Method == "HEAD" {
+			return
+		}
+		cancel()
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+	var b writerAtBuffer
+	if _, err := ts.Client().ParallelDownload(ctx, ts.URL, &b, 4); !errors.Is(err, context.Canceled) {
+		t.Errorf("ParallelDownload after cancel = %v; want context.Canceled", err)
+	}
+}
@@ -28,7 +28,6 @@ var (
 	ExportErrRequestCanceled          = errRequestCanceled
 	ExportErrRequestCanceledConn      = errRequestCanceledConn
 	ExportErrServerClosedIdle         = errServerClosedIdle
-	ExportServeFile                   = serveFile
 	ExportScanETag                    = scanETag
 	ExportHttp2ConfigureServer        = http2ConfigureServer
 	Export_shouldCopyHeaderOnRedirect = shouldCopyHeaderOnRedirect
@@ -88,6 +87,14 @@ func SetPendingDialHooks(before, after func()) {
 
 func SetTestHookServerServe(fn func(*Server, net.Listener)) { testHookServerServe = fn }
 
+func SetTestHookLookupIPAddr(fn func(context.Context, string) ([]net.IPAddr, error)) {
+	testHookLookupIPAddr = fn
+}
+
+func SetTestHookDialIP(fn func(ctx context.Context, network, addr string) (net.Conn, error)) {
+	testHookDialIP = fn
+}
+
 func NewTestTimeoutHandler(handler Handler, ctx context.Context) Handler {
 	return &timeoutHandler{
 		handler:     handler,
@@ -306,3 +313,19 @@ func ExportCloseTransportConnsAbruptly(tr *Transport) {
 	}
 	tr.idleMu.Unlock()
 }
+
+func ExportServeFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirect bool) {
+	(&fileHandler{root: fs}).serveFile(w, r, name, redirect)
+}
+
+func (l *TokenBucketLimiter) ExportLen() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.buckets)
+}
+
+func (s *MemoryIdempotencyStore) ExportLen() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.entries)
+}
@@ -0,0 +1,253 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// The Forwarded header field, RFC 7239.
+
+package http
+
+import (
+	"errors"
+	"net"
+	"net/http/internal/ascii"
+	"strings"
+)
+
+// A ForwardedElement is one element of a Forwarded header, describing
+// one hop of a request through a chain of proxies, as defined by
+// RFC 7239. Its fields hold parameter values without any quoting, and
+// are empty if the parameter is absent.
+//
+// The For and By fields identify nodes: an IPv4 address, an IPv6
+// address in square brackets, "unknown", or an obfuscated identifier
+// beginning with an underscore, such as "_hidden", optionally followed
+// by a colon and a port number or obfuscated port, as in
+// "[2001:db8::1]:4711" or "192.0.2.60:_port".
+type ForwardedElement struct {
+	For   string // the client, or the proxy it connected to
+	By    string // the interface of the proxy that received the request
+	Host  string // the Host header field the proxy received
+	Proto string // the protocol the proxy received, such as "https"
+}
+
+var errMalformedForwarded = errors.New("http: malformed Forwarded header")
+
+// ParseForwarded parses the value of a Forwarded header into its
+// elements, first to last. Values of multiple Forwarded header lines
+// may be joined with commas. Parameter names are matched
+// case-insensitively, and parameters other than for, by, host, and
+// proto are ignored. ParseForwarded returns an error if the value is
+// malformed, if a parameter appears more than once in an element, or
+// if a for or by parameter is not a valid node.
+func ParseForwarded(header string) ([]ForwardedElement, error) {
+	var elems []ForwardedElement
+	s := header
+	for {
+		var e ForwardedElement
+		empty := true
+		for {
+			s = trimForwardedOWS(s)
+			if s != "" && s[0] != ',' && s[0] != ';' {
+				var name, value string
+				var err error
+				if name, value, s, err = parseForwardedPair(s); err != nil {
+					return nil, err
+				}
+				if err := e.set(name, value); err != nil {
+					return nil, err
+				}
+				empty = false
+			}
+			s = trimForwardedOWS(s)
+			if s == "" || s[0] != ';' {
+				break
+			}
+			s = s[1:]
+		}
+		if !empty {
+			elems = append(elems, e)
+		}
+		if s == "" {
+			return elems, nil
+		}
+		if s[0] != ',' {
+			return nil, errMalformedForwarded
+		}
+		s = s[1:]
+	}
+}
+
+// parseForwardedPair parses a name=value pair at the start of s,
+// unquoting the value, and returns the rest of s.
+func parseForwardedPair(s string) (name, value, rest string, err error) {
+	i := strings.IndexByte(s, '=')
+	if i <= 0 || strings.IndexFunc(s[:i], isNotToken) >= 0 {
+		return "", "", "", errMalformedForwarded
+	}
+	name, s = s[:i], s[i+1:]
+	if s != "" && s[0] == '"' {
+		var b strings.Builder
+		for i := 1; i < len(s); i++ {
+			switch c := s[i]; {
+			case c == '"':
+				return name, b.String(), s[i+1:], nil
+			case c == '\\' && i+1 < len(s):
+				i++
+				b.WriteByte(s[i])
+			case c < ' ' && c != '\t' || c == 0x7f:
+				return "", "", "", errMalformedForwarded
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", "", "", errMalformedForwarded // unterminated
+	}
+	n := strings.IndexFunc(s, isNotToken)
+	if n < 0 {
+		n = len(s)
+	}
+	if n == 0 {
+		return "", "", "", errMalformedForwarded
+	}
+	return name, s[:n], s[n:], nil
+}
+
+// set sets the field of e for the parameter name to value.
+func (e *ForwardedElement) set(name, value string) error {
+	var f *string
+	node := false
+	switch {
+	case ascii.EqualFold(name, "for"):
+		f, node = &e.For, true
+	case ascii.EqualFold(name, "by"):
+		f, node = &e.By, true
+	case ascii.EqualFold(name, "host"):
+		f = &e.Host
+	case ascii.EqualFold(name, "proto"):
+		f = &e.Proto
+	default:
+		return nil
+	}
+	if *f != "" {
+		return errors.New("http: repeated Forwarded parameter " + name)
+	}
+	if value == "" {
+		return errMalformedForwarded
+	}
+	if node && !validForwardedNode(value) {
+		return badStringError("http: invalid Forwarded node", value)
+	}
+	*f = value
+	return nil
+}
+
+// validForwardedNode reports whether s is a node of RFC 7239,
+// section 6.
+func validForwardedNode(s string) bool {
+	var host, port string
+	hasPort := false
+	if strings.HasPrefix(s, "[") {
+		i := strings.IndexByte(s, ']')
+		if i < 0 || !strings.Contains(s[1:i], ":") || net.ParseIP(s[1:i]) == nil {
+			return false
+		}
+		if rest := s[i+1:]; rest != "" {
+			if rest[0] != ':' {
+				return false
+			}
+			port, hasPort = rest[1:], true
+		}
+	} else {
+		host, port, hasPort = strings.Cut(s, ":")
+		if host != "unknown" && !isObfuscatedForwarded(host) && net.ParseIP(host) == nil {
+			return false
+		}
+	}
+	if !hasPort || isObfuscatedForwarded(port) {
+		return true
+	}
+	if len(port) == 0 || len(port) > 5 {
+		return false
+	}
+	for i := 0; i < len(port); i++ {
+		if port[i] < '0' || port[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// isObfuscatedForwarded reports whether s is an obfuscated node name
+// or port, an underscore followed by letters, digits, '.', '_', or '-'.
+func isObfuscatedForwarded(s string) bool {
+	if len(s) < 2 || s[0] != '_' {
+		return false
+	}
+	for i := 1; i < len(s); i++ {
+		switch c := s[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9',
+			c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func trimForwardedOWS(s string) string {
+	return strings.TrimLeft(s, " \t")
+}
+
+// FormatForwarded returns the value of a Forwarded header listing
+// elems, quoting parameter values as needed. A proxy appends an element
+// for itself to those parsed from the Forwarded header it received.
+// Empty fields, and elements with no fields set, are omitted.
+func FormatForwarded(elems []ForwardedElement) string {
+	var b strings.Builder
+	for _, e := range elems {
+		if e == (ForwardedElement{}) {
+			continue
+		}
+		if b.Len() > 0 {
+			b.WriteString(", ")
+		}
+		sep := ""
+		for _, p := range [...]struct{ name, value string }{
+			{"for", e.For},
+			{"by", e.By},
+			{"host", e.Host},
+			{"proto", e.Proto},
+		} {
+			if p.value == "" {
+				continue
+			}
+			b.WriteString(sep)
+			b.WriteString(p.name)
+			b.WriteByte('=')
+			writeForwardedValue(&b, p.value)
+			sep = ";"
+		}
+	}
+	return b.String()
+}
+
+// writeForwardedValue writes v to b as a token, or as a quoted string
+// if v isn't a token.
+func writeForwardedValue(b *strings.Builder, v string) {
+	if strings.IndexFunc(v, isNotToken) < 0 {
+		b.WriteString(v)
+		return
+	}
+	b.WriteByte('"')
+	for i := 0; i < len(v); i++ {
+		c := v[i]
+		if c < ' ' && c != '\t' || c == 0x7f {
+			continue // drop bytes that can't appear in a header
+		}
+		if c == '"' || c == '\\' {
+			b.WriteByte('\\')
+		}
+		b.WriteByte(c)
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,71 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"reflect"
+	"testing"
+)
+
+func TestParseForwarded(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want []ForwardedElement
+	}{
+		{"", nil},
+		{`for="_gazonk"`, []ForwardedElement{{For: "_gazonk"}}},
+		{`For="[2001:db8:cafe::17]:4711"`, []ForwardedElement{{For: "[2001:db8:cafe::17]:4711"}}},
+		{"for=192.0.2.60;proto=http;by=203.0.113.43", []ForwardedElement{{For: "192.0.2.60", By: "203.0.113.43", Proto: "http"}}},
+		{"for=192.0.2.43, for=198.51.100.17", []ForwardedElement{{For: "192.0.2.43"}, {For: "198.51.100.17"}}},
+		{`for=unknown;host="example.com:8080", ,for="10.0.0.1:_p"`, []ForwardedElement{{For: "unknown", Host: "example.com:8080"}, {For: "10.0.0.1:_p"}}},
+		{`for=_x; secret="a,b;c=\"d\""; proto=https`, []ForwardedElement{{For: "_x", Proto: "https"}}},
+		{`host="a\"b"`, []ForwardedElement{{Host: `a"b`}}},
+	} {
+		got, err := ParseForwarded(tt.in)
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseForwarded(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"for",
+		"for=",
+		`for="192.0.2.1`,
+		"for=192.0.2.1 by=192.0.2.2",
+		"for=192.0.2.1;for=192.0.2.2",
+		"for=2001:db8::1",
+		`for="[2001:db8::1"`,
+		`for="[192.0.2.1]"`,
+		"for=example.com",
+		"for=_",
+		`for="192.0.2.1:123456"`,
+		`for="192.0.2.1:x"`,
+		`by="_a b"`,
+		"=x",
+		"host=\"a\x00b\"",
+	} {
+		if got, err := ParseForwarded(in); err == nil {
+			t.Errorf("ParseForwarded(%q) = %+v; want error", in, got)
+		}
+	}
+}
+
+func TestFormatForwarded(t *testing.T) {
+	elems := []ForwardedElement{
+		{For: "[2001:db8:cafe::17]:4711", Proto: "https"},
+		{For: "192.0.2.60", By: "_proxy", Host: `a"b`},
+		{},
+	}
+	const want = `for="[2001:db8:cafe::17]:4711";proto=https, for=192.0.2.60;by=_proxy;host="a\"b"`
+	got := FormatForwarded(elems)
+	if got != want {
+		t.Fatalf("FormatForwarded = %s; want %s", got, want)
+	}
+	back, err := ParseForwarded(got)
+	if err != nil || !reflect.DeepEqual(back, elems[:2]) {
+		t.Errorf("ParseForwarded(FormatForwarded(elems)) = %+v, %v; want %+v", back, err, elems[:2])
+	}
+}
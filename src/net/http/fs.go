@@ -7,12 +7,17 @@
 package http
 
 import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
 	"io/fs"
 	"mime"
 	"mime/multipart"
+	"net/http/internal/ascii"
 	"net/textproto"
 	"net/url"
 	"os"
@@ -188,6 +193,11 @@ func dirList(w ResponseWriter, r *Request, f File) {
 // If the caller has set w's ETag header formatted per RFC 7232, section 2.3,
 // ServeContent uses it to handle requests using If-Match, If-None-Match, or If-Range.
 //
+// ServeContent sets the Accept-Ranges header to "bytes", advertising
+// support for Range requests, unless the caller has set it to "none",
+// as SetAcceptRanges(w, false) does, in which case ServeContent ignores
+// Range headers and always replies with the whole content.
+//
 // Note that *os.File implements the io.ReadSeeker interface.
 func ServeContent(w ResponseWriter, req *Request, name string, modtime time.Time, content io.ReadSeeker) {
 	sizeFunc := func() (int64, error) {
@@ -204,6 +214,37 @@ func ServeContent(w ResponseWriter, req *Request, name string, modtime time.Time
 	serveContent(w, req, name, modtime, sizeFunc, content)
 }
 
+// ServeContentWithETag is like ServeContent, but first sets the ETag
+// header of the response to a strong entity tag derived from a SHA-256
+// hash of the content, replacing any ETag the caller has set. The
+// If-Match, If-None-Match, and If-Range headers of the request are
+// then checked against it as ServeContent does, so a client whose
+// If-None-Match holds the tag gets 304 Not Modified, with the ETag,
+// and Range requests are served from the same content.
+//
+// The content is hashed by reading all of it, from its start, and is
+// then seeked back to its start to be served, so that it is never
+// held in memory. If seeking or reading the content fails,
+// ServeContentWithETag returns the error without writing a response,
+// leaving the reply to the caller. Since the content is read in whole
+// for every request, callers serving large content repeatedly should
+// compute its ETag once and pass it to ServeContent instead.
+func ServeContentWithETag(w ResponseWriter, req *Request, name string, modtime time.Time, content io.ReadSeeker) error {
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	h := sha256.New()
+	if _, err := io.Copy(h, content); err != nil {
+		return err
+	}
+	if _, err := content.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	w.Header().Set("Etag", `"`+base64.RawURLEncoding.EncodeToString(h.Sum(nil))+`"`)
+	ServeContent(w, req, name, modtime, content)
+	return nil
+}
+
 // errSeeker is returned by ServeContent's sizeFunc when the content
 // doesn't seek properly. The underlying Seeker's error text isn't
 // included in the sizeFunc reply so it's not sent over HTTP to end
@@ -258,7 +299,20 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 	// handle Content-Range header.
 	sendSize := size
 	var sendContent io.Reader = content
+	if unit, _, ok := r.RangeUnit(); ok && unit != "bytes" {
+		// Ranges in other units are for handlers to implement.
+		rangeReq = ""
+	}
+	acceptRanges := w.Header().get("Accept-Ranges") != "none"
+	if !acceptRanges {
+		rangeReq = ""
+	}
 	if size >= 0 {
+		if acceptRanges {
+			// Also on 416 responses, as RFC 7233, section 4.4
+			// suggests, so the client knows what it can ask for.
+			SetAcceptRanges(w, true)
+		}
 		ranges, err := parseRange(rangeReq, size)
 		if err != nil {
 			if err == errNoOverlap {
@@ -325,7 +379,6 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 			}()
 		}
 
-		w.Header().Set("Accept-Ranges", "bytes")
 		if w.Header().Get("Content-Encoding") == "" {
 			w.Header().Set("Content-Length", strconv.FormatInt(sendSize, 10))
 		}
@@ -338,6 +391,20 @@ func serveContent(w ResponseWriter, r *Request, name string, modtime time.Time,
 	}
 }
 
+// SetAcceptRanges sets the Accept-Ranges header of w, which tells
+// clients such as download managers and media players whether they can
+// request parts of the response with a Range header: to "bytes" if
+// supported is true, and to "none" otherwise. It must be called before
+// the response header is written. ServeContent, ServeFile, and
+// FileServer advertise their support for ranges themselves.
+func SetAcceptRanges(w ResponseWriter, supported bool) {
+	v := "none"
+	if supported {
+		v = "bytes"
+	}
+	w.Header().Set("Accept-Ranges", v)
+}
+
 // scanETag determines if a syntactically valid ETag is present at s. If so,
 // the ETag and remaining text after consuming ETag is returned. Otherwise,
 // it returns "", "".
@@ -582,8 +649,9 @@ func checkPreconditions(w ResponseWriter, r *Request, modtime time.Time) (done b
 	return false, rangeHeader
 }
 
-// name is '/'-separated, not filepath.Separator.
-func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirect bool) {
+// serveFile serves the file or directory of h.root named name, which
+// is '/'-separated, not filepath.Separator.
+func (h *fileHandler) serveFile(w ResponseWriter, r *Request, name string, redirect bool) {
 	const indexPage = "/index.html"
 
 	// redirect .../index.html to .../
@@ -594,10 +662,10 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 		return
 	}
 
-	f, err := fs.Open(name)
+	f, err := h.root.Open(name)
 	if err != nil {
 		msg, code := toHTTPError(err)
-		Error(w, msg, code)
+		serveFileError(w, h.root, h.errorPages, msg, code)
 		return
 	}
 	defer f.Close()
@@ -605,7 +673,7 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 	d, err := f.Stat()
 	if err != nil {
 		msg, code := toHTTPError(err)
-		Error(w, msg, code)
+		serveFileError(w, h.root, h.errorPages, msg, code)
 		return
 	}
 
@@ -636,7 +704,7 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 
 		// use contents of index.html for directory, if present
 		index := strings.TrimSuffix(name, "/") + indexPage
-		ff, err := fs.Open(index)
+		ff, err := h.root.Open(index)
 		if err == nil {
 			defer ff.Close()
 			dd, err := ff.Stat()
@@ -650,12 +718,23 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 
 	// Still a directory? (we didn't find an index.html file)
 	if d.IsDir() {
+		if h.noDirList {
+			serveFileError(w, h.root, h.errorPages, "404 page not found", StatusNotFound)
+			return
+		}
 		if checkIfModifiedSince(r, d.ModTime()) == condFalse {
 			writeNotModified(w)
 			return
 		}
 		setLastModified(w, d.ModTime())
-		dirList(w, r, f)
+		switch {
+		case h.dirList != nil:
+			funcDirList(w, r, f, h.dirList)
+		case h.dirTemplate != nil:
+			dirTemplateList(w, r, f, h.dirTemplate)
+		default:
+			dirList(w, r, f)
+		}
 		return
 	}
 
@@ -664,6 +743,28 @@ func serveFile(w ResponseWriter, r *Request, fs FileSystem, name string, redirec
 	serveContent(w, r, d.Name(), d.ModTime(), sizeFunc, f)
 }
 
+// serveFileError replies to the request with the file from fs named
+// by errorPages[code], with status code. If there is no such file, it
+// replies with msg, as Error does.
+func serveFileError(w ResponseWriter, fs FileSystem, errorPages map[int]string, msg string, code int) {
+	if name, ok := errorPages[code]; ok {
+		if f, err := fs.Open(path.Clean("/" + name)); err == nil {
+			defer f.Close()
+			if d, err := f.Stat(); err == nil && !d.IsDir() {
+				h := w.Header()
+				h.Del("Content-Length")
+				if ctype := mime.TypeByExtension(filepath.Ext(name)); ctype != "" {
+					h.Set("Content-Type", ctype)
+				}
+				w.WriteHeader(code)
+				io.Copy(w, f)
+				return
+			}
+		}
+	}
+	Error(w, msg, code)
+}
+
 // toHTTPError returns a non-specific HTTP error message and status code
 // for a given non-nil error value. It's important that toHTTPError does not
 // actually return err.Error(), since msg and httpStatus are returned to users,
@@ -722,7 +823,7 @@ func ServeFile(w ResponseWriter, r *Request, name string) {
 		return
 	}
 	dir, file := filepath.Split(name)
-	serveFile(w, r, Dir(dir), file, false)
+	(&fileHandler{root: Dir(dir)}).serveFile(w, r, file, false)
 }
 
 func containsDotDot(v string) bool {
@@ -740,7 +841,11 @@ func containsDotDot(v string) bool {
 func isSlashRune(r rune) bool { return r == '/' || r == '\\' }
 
 type fileHandler struct {
-	root FileSystem
+	root        FileSystem
+	errorPages  map[int]string // status code to file name in root
+	dirTemplate interface{ Execute(io.Writer, any) error }
+	dirList     func(w ResponseWriter, r *Request, entries []fs.DirEntry)
+	noDirList   bool
 }
 
 type ioFS struct {
@@ -821,6 +926,175 @@ func FS(fsys fs.FS) FileSystem {
 	return ioFS{fsys}
 }
 
+// MultiFS returns a file system that layers the provided file systems
+// in order. Opening a name returns the file from the first file system
+// that contains it. If that file is a directory, its entries are the
+// union of the entries of the same directory in each of the file systems;
+// when several contain an entry with the same name, the first one wins.
+//
+// MultiFS can be used with FS to serve local overrides on top of a
+// set of defaults:
+//
+//	http.Handle("/", http.FileServer(http.FS(http.MultiFS(overrides, defaults))))
+func MultiFS(filesystems ...fs.FS) fs.FS {
+	return multiFS(append([]fs.FS(nil), filesystems...))
+}
+
+type multiFS []fs.FS
+
+func (m multiFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	for i, fsys := range m {
+		f, err := fsys.Open(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		fi, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		if !fi.IsDir() {
+			return f, nil
+		}
+		return &multiDir{File: f, name: name, lower: m[i+1:]}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+// A multiDir is a directory opened from a multiFS. Its File is the
+// directory found in the first file system containing name; lower holds
+// the file systems after that one, whose entries are merged underneath.
+type multiDir struct {
+	fs.File
+	name  string
+	lower []fs.FS
+
+	entries []fs.DirEntry // merged entries; nil until first ReadDir
+	off     int           // index of the next entry returned by ReadDir
+}
+
+func (d *multiDir) ReadDir(count int) ([]fs.DirEntry, error) {
+	if d.entries == nil {
+		entries, err := d.merge()
+		if err != nil {
+			return nil, err
+		}
+		d.entries = entries
+	}
+	rest := d.entries[d.off:]
+	if count <= 0 {
+		d.off = len(d.entries)
+		return rest, nil
+	}
+	if len(rest) == 0 {
+		return nil, io.EOF
+	}
+	if count > len(rest) {
+		count = len(rest)
+	}
+	d.off += count
+	return rest[:count:count], nil
+}
+
+// merge reads the entries of d's directory from each of its layers,
+// keeping the first entry seen for each name.
+func (d *multiDir) merge() ([]fs.DirEntry, error) {
+	rd, ok := d.File.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: d.name, Err: errMissingReadDir}
+	}
+	entries, err := rd.ReadDir(-1)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool, len(entries))
+	for _, e := range entries {
+		seen[e.Name()] = true
+	}
+	for _, fsys := range d.lower {
+		list, err := readLowerDir(fsys, d.name)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			if !seen[e.Name()] {
+				seen[e.Name()] = true
+				entries = append(entries, e)
+			}
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+// readLowerDir returns the entries of the directory name in fsys.
+// It returns no entries and no error if fsys has no such directory.
+func readLowerDir(fsys fs.FS, name string) ([]fs.DirEntry, error) {
+	f, err := fsys.Open(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.IsDir() {
+		return nil, nil
+	}
+	rd, ok := f.(fs.ReadDirFile)
+	if !ok {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: errMissingReadDir}
+	}
+	return rd.ReadDir(-1)
+}
+
+// sriAlgs maps the names of the hash algorithms supported for
+// Subresource Integrity metadata to their implementations.
+var sriAlgs = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+// ComputeSRI returns the Subresource Integrity metadata for the
+// named file in fsys, such as "sha384-<base64 digest>", for use in
+// the integrity attribute of HTML script and link elements.
+// The algorithm alg is one of "sha256", "sha384", or "sha512";
+// if empty, "sha384" is used.
+//
+// ComputeSRI reads the whole file on each call. Callers that need
+// hashes repeatedly, such as when rendering templates, should cache
+// them, keyed by the file's modification time if it may change.
+func ComputeSRI(fsys fs.FS, path string, alg string) (string, error) {
+	if alg == "" {
+		alg = "sha384"
+	}
+	newHash := sriAlgs[alg]
+	if newHash == nil {
+		return "", errors.New("http: unsupported SRI hash algorithm " + strconv.Quote(alg))
+	}
+	f, err := fsys.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return alg + "-" + base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
 // FileServer returns a handler that serves HTTP requests
 // with the contents of the file system rooted at root.
 //
@@ -838,7 +1112,200 @@ func FS(fsys fs.FS) FileSystem {
 //	http.Handle("/", http.FileServer(http.FS(fsys)))
 //
 func FileServer(root FileSystem) Handler {
-	return &fileHandler{root}
+	return FileServerWithOptions(root, nil)
+}
+
+// FileServerWithErrorPages is like FileServer, but replies to errors
+// with the files in root named by errorPages instead of the default
+// plain text messages. For example, the map
+//
+//	map[int]string{http.StatusNotFound: "/404.html"}
+//
+// serves /404.html as the body of 404 Not Found responses. The Content-Type
+// of an error page is determined by its file extension. If the file for
+// a status code can't be opened, the default message is used.
+func FileServerWithErrorPages(root FileSystem, errorPages map[int]string) Handler {
+	return FileServerWithOptions(root, &FileServerOptions{ErrorPages: errorPages})
+}
+
+// FileServerWithTemplate is like FileServer, but renders directory
+// listings by executing tmpl, usually an *html/template.Template, with
+// a *DirListing describing the directory. The template is executed
+// with the Content-Type of the response set to "text/html; charset=utf-8".
+//
+// The hrefs of a DirListing are relative to the directory's URL and
+// have their special characters escaped, so that the template may
+// use them as they are, as in
+//
+//	{{range .Entries}}<a href="{{.Href}}">{{.Name}}</a>{{end}}
+//
+// even when the file server is served under a prefix with StripPrefix.
+// If tmpl fails before writing anything, the file server replies with
+// 500 Internal Server Error.
+func FileServerWithTemplate(root FileSystem, tmpl interface{ Execute(io.Writer, any) error }) Handler {
+	return FileServerWithOptions(root, &FileServerOptions{DirTemplate: tmpl})
+}
+
+// FileServerOptions configures a file server returned by
+// FileServerWithOptions.
+type FileServerOptions struct {
+	// ErrorPages, if not nil, names the files in root served as the
+	// bodies of error responses, by status code, as described for
+	// FileServerWithErrorPages.
+	ErrorPages map[int]string
+
+	// DirTemplate, if not nil, renders the listings of directories
+	// without an index.html file, as described for
+	// FileServerWithTemplate.
+	DirTemplate interface{ Execute(io.Writer, any) error }
+
+	// DirList, if not nil, writes the listings of directories
+	// without an index.html file, in place of the default HTML
+	// listing. It is passed the entries of the directory, sorted by
+	// name, and is called with the Last-Modified header of the
+	// response set to the modification time of the directory. It
+	// must set the Content-Type of the response itself. DirList
+	// takes precedence over DirTemplate.
+	DirList func(w ResponseWriter, r *Request, entries []fs.DirEntry)
+
+	// DisableDirListing makes the file server reply to requests for
+	// directories without an index.html file with 404 Not Found,
+	// so that the names of their files are not revealed. DirTemplate
+	// and DirList are then unused.
+	DisableDirListing bool
+}
+
+// FileServerWithOptions is like FileServer, but configured by opts.
+// A nil opts is the same as a zero FileServerOptions, and gives a file
+// server that behaves like FileServer. Changes to opts after the call
+// have no effect on the file server.
+func FileServerWithOptions(root FileSystem, opts *FileServerOptions) Handler {
+	h := &fileHandler{root: root}
+	if opts != nil {
+		if opts.ErrorPages != nil {
+			h.errorPages = make(map[int]string, len(opts.ErrorPages))
+			for code, name := range opts.ErrorPages {
+				h.errorPages[code] = name
+			}
+		}
+		h.dirTemplate = opts.DirTemplate
+		h.dirList = opts.DirList
+		h.noDirList = opts.DisableDirListing
+	}
+	return h
+}
+
+// funcDirList lists the directory f by calling list with its entries,
+// sorted by name.
+func funcDirList(w ResponseWriter, r *Request, f File, list func(ResponseWriter, *Request, []fs.DirEntry)) {
+	var entries []fs.DirEntry
+	var err error
+	if d, ok := f.(fs.ReadDirFile); ok {
+		entries, err = d.ReadDir(-1)
+	} else {
+		var infos []fs.FileInfo
+		infos, err = f.Readdir(-1)
+		for _, fi := range infos {
+			entries = append(entries, fs.FileInfoToDirEntry(fi))
+		}
+	}
+	if err != nil {
+		logf(r, "http: error reading directory: %v", err)
+		Error(w, "Error reading directory", StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	list(w, r, entries)
+}
+
+// A DirListing describes a directory listed by a file server with a
+// directory template, returned by FileServerWithTemplate or
+// FileServerWithOptions.
+type DirListing struct {
+	// Path is the URL path of the directory, ending in a slash.
+	Path string
+
+	// Breadcrumbs lists the directories leading to the directory,
+	// starting with the root, "/", and ending with the directory.
+	Breadcrumbs []DirCrumb
+
+	// Entries lists the directory's entries, sorted by name.
+	Entries []DirEntry
+}
+
+// A DirCrumb is a directory on the path to a directory listed by a
+// file server with a directory template.
+type DirCrumb struct {
+	Name string // "/" for the root
+	Href string // relative, such as "../"
+}
+
+// A DirEntry is an entry of a directory listed by a file server with
+// a directory template.
+type DirEntry struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+	IsDir   bool
+	Href    string // relative, escaped, and ending in a slash for directories
+}
+
+func dirTemplateList(w ResponseWriter, r *Request, f File, tmpl interface{ Execute(io.Writer, any) error }) {
+	var entries []DirEntry
+	var err error
+	if d, ok := f.(fs.ReadDirFile); ok {
+		var list []fs.DirEntry
+		list, err = d.ReadDir(-1)
+		for _, de := range list {
+			e := DirEntry{Name: de.Name(), IsDir: de.IsDir()}
+			if fi, err := de.Info(); err == nil {
+				e.Size, e.ModTime = fi.Size(), fi.ModTime()
+			}
+			entries = append(entries, e)
+		}
+	} else {
+		var list []fs.FileInfo
+		list, err = f.Readdir(-1)
+		for _, fi := range list {
+			entries = append(entries, DirEntry{Name: fi.Name(), IsDir: fi.IsDir(), Size: fi.Size(), ModTime: fi.ModTime()})
+		}
+	}
+	if err != nil {
+		logf(r, "http: error reading directory: %v", err)
+		Error(w, "Error reading directory", StatusInternalServerError)
+		return
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	for i := range entries {
+		e := &entries[i]
+		name := e.Name
+		if e.IsDir {
+			name += "/"
+		}
+		// As in dirList, escape '?' and '#', and also ':', which
+		// url.URL.String prefixes with "./".
+		e.Href = (&url.URL{Path: name}).String()
+	}
+
+	l := &DirListing{Path: r.URL.Path, Entries: entries}
+	elems := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if elems[0] == "" {
+		elems = nil
+	}
+	l.Breadcrumbs = append(l.Breadcrumbs, DirCrumb{Name: "/", Href: "./" + strings.Repeat("../", len(elems))})
+	for i, elem := range elems {
+		l.Breadcrumbs = append(l.Breadcrumbs, DirCrumb{Name: elem, Href: "./" + strings.Repeat("../", len(elems)-1-i)})
+	}
+
+	var n countingWriter
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := tmpl.Execute(io.MultiWriter(w, &n), l); err != nil {
+		logf(r, "http: error executing directory template: %v", err)
+		if n == 0 {
+			w.Header().Del("Last-Modified")
+			Error(w, "500 Internal Server Error", StatusInternalServerError)
+		}
+	}
 }
 
 func (f *fileHandler) ServeHTTP(w ResponseWriter, r *Request) {
@@ -847,7 +1314,7 @@ func (f *fileHandler) ServeHTTP(w ResponseWriter, r *Request) {
 		upath = "/" + upath
 		r.URL.Path = upath
 	}
-	serveFile(w, r, f.root, path.Clean(upath), true)
+	f.serveFile(w, r, path.Clean(upath), true)
 }
 
 // httpRange specifies the byte range to be sent to the client.
@@ -873,7 +1340,7 @@ func parseRange(s string, size int64) ([]httpRange, error) {
 		return nil, nil // header not present
 	}
 	const b = "bytes="
-	if !strings.HasPrefix(s, b) {
+	if len(s) < len(b) || !ascii.EqualFold(s[:len(b)], b) {
 		return nil, errors.New("invalid range")
 	}
 	var ranges []httpRange
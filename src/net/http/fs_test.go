@@ -9,8 +9,10 @@ import (
 	"bytes"
 	"errors"
 	"fmt"
+	"html/template"
 	"io"
 	"io/fs"
+	"log"
 	"mime"
 	"mime/multipart"
 	"net"
@@ -26,6 +28,7 @@ import (
 	"runtime"
 	"strings"
 	"testing"
+	"testing/fstest"
 	"time"
 )
 
@@ -53,6 +56,8 @@ var ServeFileRangeTests = []struct {
 	{r: "bytes=0-1,5-", code: StatusPartialContent, ranges: []wantRange{{0, 2}, {5, testFileLen}}},
 	{r: "bytes=5-1000", code: StatusPartialContent, ranges: []wantRange{{5, testFileLen}}},
 	{r: "bytes=0-,1-,2-,3-,4-", code: StatusOK}, // ignore wasteful range request
+	{r: "Bytes=0-4", code: StatusPartialContent, ranges: []wantRange{{0, 5}}},
+	{r: "items=0-4", code: StatusOK}, // ignore units other than bytes
 	{r: "bytes=0-9", code: StatusPartialContent, ranges: []wantRange{{0, testFileLen - 1}}},
 	{r: "bytes=0-10", code: StatusPartialContent, ranges: []wantRange{{0, testFileLen}}},
 	{r: "bytes=0-11", code: StatusPartialContent, ranges: []wantRange{{0, testFileLen}}},
@@ -623,6 +628,236 @@ func TestServeIndexHtmlFS(t *testing.T) {
 	}
 }
 
+func TestMultiFS(t *testing.T) {
+	top := fstest.MapFS{
+		"index.html":   {Data: []byte("top index")},
+		"css/site.css": {Data: []byte("top css")},
+		"only/top.txt": {Data: []byte("top only")},
+	}
+	base := fstest.MapFS{
+		"index.html":    {Data: []byte("base index")},
+		"css/site.css":  {Data: []byte("base css")},
+		"css/print.css": {Data: []byte("base print")},
+		"img/logo.png":  {Data: []byte("logo")},
+		"only/base.txt": {Data: []byte("base only")},
+		"robots.txt":    {Data: []byte("base robots")},
+	}
+	fsys := MultiFS(top, base)
+	if err := fstest.TestFS(fsys, "index.html", "css/site.css", "css/print.css", "img/logo.png", "only/top.txt", "only/base.txt", "robots.txt"); err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewServer(FileServer(FS(fsys)))
+	defer ts.Close()
+	for _, tt := range []struct {
+		path     string
+		wantCode int
+		wantBody string
+	}{
+		{"/index.html", 200, "top index"},
+		{"/css/site.css", 200, "top css"},
+		{"/css/print.css", 200, "base print"},
+		{"/robots.txt", 200, "base robots"},
+		{"/img/logo.png", 200, "logo"},
+		{"/missing.txt", 404, "404 page not found\n"},
+		{"/css/", 200, "<pre>\n<a href=\"print.css\">print.css</a>\n<a href=\"site.css\">site.css</a>\n</pre>\n"},
+		{"/only/", 200, "<pre>\n<a href=\"base.txt\">base.txt</a>\n<a href=\"top.txt\">top.txt</a>\n</pre>\n"},
+	} {
+		res, err := ts.Client().Get(ts.URL + tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("GET %s: StatusCode = %d; want %d", tt.path, res.StatusCode, tt.wantCode)
+		}
+		if string(b) != tt.wantBody {
+			t.Errorf("GET %s: body = %q; want %q", tt.path, b, tt.wantBody)
+		}
+	}
+}
+
+func TestFileServerWithErrorPages(t *testing.T) {
+	fsys := fstest.MapFS{
+		"index.html": {Data: []byte("index")},
+		"404.html":   {Data: []byte("<h1>Not here</h1>")},
+	}
+	for _, tt := range []struct {
+		errorPages map[int]string
+		wantBody   string
+		wantType   string
+	}{
+		{map[int]string{404: "/404.html"}, "<h1>Not here</h1>", "text/html; charset=utf-8"},
+		{map[int]string{404: "404.html"}, "<h1>Not here</h1>", "text/html; charset=utf-8"},
+		{map[int]string{404: "/missing.html"}, "404 page not found\n", "text/plain; charset=utf-8"},
+		{map[int]string{403: "/404.html"}, "404 page not found\n", "text/plain; charset=utf-8"},
+		{nil, "404 page not found\n", "text/plain; charset=utf-8"},
+	} {
+		ts := httptest.NewServer(FileServerWithErrorPages(FS(fsys), tt.errorPages))
+		res, err := ts.Client().Get(ts.URL + "/nope.txt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != StatusNotFound || string(b) != tt.wantBody || res.Header.Get("Content-Type") != tt.wantType {
+			t.Errorf("errorPages %v: got %d %q with Content-Type %q; want 404 %q with %q",
+				tt.errorPages, res.StatusCode, b, res.Header.Get("Content-Type"), tt.wantBody, tt.wantType)
+		}
+	}
+}
+
+func TestFileServerWithTemplate(t *testing.T) {
+	mtime := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"a/b/x?y#z.txt": {Data: []byte("hello"), ModTime: mtime},
+		"a/b/c:d":       {Data: []byte("hi")},
+		"a/b/<sub>/f":   {Data: []byte("")},
+	}
+	tmpl := template.Must(template.New("").Parse(
+		`{{.Path}}|{{range .Breadcrumbs}}<a href="{{.Href}}">{{.Name}}</a>{{end}}|` +
+			`{{range .Entries}}<a href="{{.Href}}">{{.Name}}</a> {{.Size}} {{.IsDir}} {{.ModTime.Year}};{{end}}`))
+	ts := httptest.NewServer(StripPrefix("/files", FileServerWithTemplate(FS(fsys), tmpl)))
+	defer ts.Close()
+
+	res, err := ts.Client().Get(ts.URL + "/files/a/b/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `/a/b/|<a href="./../../">/</a><a href="./../">a</a><a href="./">b</a>|` +
+		`<a href="%3Csub%3E/">&lt;sub&gt;</a> 0 true 1;` +
+		`<a href="./c:d">c:d</a> 2 false 1;` +
+		`<a href="x%3Fy%23z.txt">x?y#z.txt</a> 5 false 2022;`
+	if got := string(b); got != want {
+		t.Errorf("listing:\n got %s\nwant %s", got, want)
+	}
+	if ct := res.Header.Get("Content-Type"); ct != "text/html; charset=utf-8" {
+		t.Errorf("Content-Type = %q; want text/html", ct)
+	}
+
+	bad := template.Must(template.New("").Parse(`{{.Missing}}`))
+	ts2 := httptest.NewUnstartedServer(FileServerWithTemplate(FS(fsys), bad))
+	ts2.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts2.Start()
+	defer ts2.Close()
+	res, err = ts2.Client().Get(ts2.URL + "/a/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusInternalServerError {
+		t.Errorf("failing template: status = %d; want 500", res.StatusCode)
+	}
+}
+
+func TestFileServerWithOptions(t *testing.T) {
+	fsys := fstest.MapFS{
+		"list/b.txt":         {Data: []byte("b")},
+		"list/a/f":           {Data: []byte("")},
+		"list/c.txt":         {Data: []byte("c")},
+		"indexed/index.html": {Data: []byte("index")},
+		"indexed/file.txt":   {Data: []byte("file")},
+	}
+	get := func(h Handler, path string) (int, string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest("GET", path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	h := FileServerWithOptions(FS(fsys), &FileServerOptions{
+		DirList: func(w ResponseWriter, r *Request, entries []fs.DirEntry) {
+			w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+			for _, e := range entries {
+				fmt.Fprintf(w, "%s %v;", e.Name(), e.IsDir())
+			}
+		},
+	})
+	if code, body := get(h, "/list/"); code != StatusOK || body != "a true;b.txt false;c.txt false;" {
+		t.Errorf("custom listing: got %d %q", code, body)
+	}
+	if code, body := get(h, "/indexed/"); code != StatusOK || body != "index" {
+		t.Errorf("custom listing with index: got %d %q; want 200 \"index\"", code, body)
+	}
+
+	h = FileServerWithOptions(FS(fsys), &FileServerOptions{DisableDirListing: true})
+	for _, tt := range []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/list/", StatusNotFound, "404 page not found\n"},
+		{"/", StatusNotFound, "404 page not found\n"},
+		{"/list/b.txt", StatusOK, "b"},
+		{"/indexed/", StatusOK, "index"},
+		{"/indexed/file.txt", StatusOK, "file"},
+	} {
+		if code, body := get(h, tt.path); code != tt.code || body != tt.body {
+			t.Errorf("listing disabled: GET %s = %d %q; want %d %q", tt.path, code, body, tt.code, tt.body)
+		}
+	}
+
+	fsys["404.html"] = &fstest.MapFile{Data: []byte("gone")}
+	pages := map[int]string{StatusNotFound: "/404.html"}
+	h = FileServerWithOptions(FS(fsys), &FileServerOptions{ErrorPages: pages, DisableDirListing: true})
+	pages[StatusNotFound] = "/list/b.txt"
+	if code, body := get(h, "/list/"); code != StatusNotFound || body != "gone" {
+		t.Errorf("error pages, listing disabled: got %d %q; want 404 \"gone\"", code, body)
+	}
+	h = FileServerWithOptions(FS(fsys), &FileServerOptions{
+		ErrorPages:  map[int]string{StatusNotFound: "/404.html"},
+		DirTemplate: template.Must(template.New("").Parse(`{{range .Entries}}{{.Name}};{{end}}`)),
+	})
+	if code, body := get(h, "/list/"); code != StatusOK || body != "a;b.txt;c.txt;" {
+		t.Errorf("error pages and template: GET /list/ = %d %q", code, body)
+	}
+	if code, body := get(h, "/nope"); code != StatusNotFound || body != "gone" {
+		t.Errorf("error pages and template: GET /nope = %d %q; want 404 \"gone\"", code, body)
+	}
+
+	if code, body := get(FileServerWithOptions(FS(fsys), nil), "/list/"); code != StatusOK || !strings.Contains(body, `<a href="b.txt">b.txt</a>`) {
+		t.Errorf("nil options: got %d %q; want default listing", code, body)
+	}
+}
+
+func TestComputeSRI(t *testing.T) {
+	fsys := fstest.MapFS{
+		"js/hello.js": {Data: []byte("alert('Hello, world.');")},
+	}
+	for _, tt := range []struct {
+		alg, want string
+	}{
+		// From the Subresource Integrity specification's examples.
+		{"", "sha384-H8BRh8j48O9oYatfu5AZzq6A9RINhZO5H16dQZngK7T62em8MUt1FLm52t+eX6xO"},
+		{"sha384", "sha384-H8BRh8j48O9oYatfu5AZzq6A9RINhZO5H16dQZngK7T62em8MUt1FLm52t+eX6xO"},
+		{"sha256", "sha256-qznLcsROx4GACP2dm0UCKCzCG+HiZ1guq6ZZDob/Tng="},
+	} {
+		got, err := ComputeSRI(fsys, "js/hello.js", tt.alg)
+		if err != nil || got != tt.want {
+			t.Errorf("ComputeSRI(%q) = %q, %v; want %q, nil", tt.alg, got, err, tt.want)
+		}
+	}
+	if _, err := ComputeSRI(fsys, "js/hello.js", "md5"); err == nil {
+		t.Errorf("ComputeSRI with md5 succeeded; want error")
+	}
+	if _, err := ComputeSRI(fsys, "js/missing.js", ""); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("ComputeSRI of missing file = %v; want fs.ErrNotExist", err)
+	}
+}
+
 func TestFileServerZeroByte(t *testing.T) {
 	defer afterTest(t)
 	ts := httptest.NewServer(FileServer(Dir(".")))
@@ -1117,6 +1352,99 @@ type issue12991File struct{ File }
 func (issue12991File) Stat() (fs.FileInfo, error) { return nil, fs.ErrPermission }
 func (issue12991File) Close() error               { return nil }
 
+func TestServeContentAcceptRanges(t *testing.T) {
+	defer afterTest(t)
+	const content = "0123456789"
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.FormValue("ranges") == "no" {
+			SetAcceptRanges(w, false)
+		}
+		ServeContent(w, r, "f.txt", time.Time{}, strings.NewReader(content))
+	}))
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		query, rangeHeader string
+		wantStatus         int
+		wantAcceptRanges   string
+		wantBody           string
+	}{
+		{"", "", 200, "bytes", content},
+		{"", "bytes=2-4", 206, "bytes", "234"},
+		{"", "bytes=20-", 416, "bytes", ""},
+		{"?ranges=no", "", 200, "none", content},
+		{"?ranges=no", "bytes=2-4", 200, "none", content},
+	} {
+		req, _ := NewRequest("GET", ts.URL+tt.query, nil)
+		if tt.rangeHeader != "" {
+			req.Header.Set("Range", tt.rangeHeader)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.wantStatus {
+			t.Errorf("%q, Range %q: status = %d; want %d", tt.query, tt.rangeHeader, res.StatusCode, tt.wantStatus)
+		}
+		if got := res.Header.Get("Accept-Ranges"); got != tt.wantAcceptRanges {
+			t.Errorf("%q, Range %q: Accept-Ranges = %q; want %q", tt.query, tt.rangeHeader, got, tt.wantAcceptRanges)
+		}
+		if tt.wantBody != "" && string(body) != tt.wantBody {
+			t.Errorf("%q, Range %q: body = %q; want %q", tt.query, tt.rangeHeader, body, tt.wantBody)
+		}
+	}
+}
+
+type noSeeker struct{ io.Reader }
+
+func (noSeeker) Seek(int64, int) (int64, error) { return 0, errors.New("can't seek") }
+
+func TestServeContentWithETag(t *testing.T) {
+	serve := func(content io.ReadSeeker, header ...string) (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest("GET", "/", nil)
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		err := ServeContentWithETag(rec, req, "f.txt", time.Time{}, content)
+		return rec, err
+	}
+
+	content := strings.NewReader("hello, world")
+	content.Seek(5, io.SeekStart) // served from the start regardless
+	rec, err := serve(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	etag := rec.Header().Get("ETag")
+	if rec.Code != StatusOK || rec.Body.String() != "hello, world" || len(etag) < 2 || etag[0] != '"' || etag[len(etag)-1] != '"' {
+		t.Fatalf("GET: got %d %q with ETag %q; want 200 with whole content and a strong ETag", rec.Code, rec.Body.String(), etag)
+	}
+	if rec, _ := serve(strings.NewReader("hello, World")); rec.Header().Get("ETag") == etag {
+		t.Errorf("different content got the same ETag %q", etag)
+	}
+
+	rec, err = serve(strings.NewReader("hello, world"), "If-None-Match", etag)
+	if err != nil || rec.Code != StatusNotModified || rec.Header().Get("ETag") != etag {
+		t.Errorf("If-None-Match: got %d with ETag %q, %v; want 304 with %q", rec.Code, rec.Header().Get("ETag"), err, etag)
+	}
+	rec, err = serve(strings.NewReader("hello, world"), "If-Match", `"other"`)
+	if err != nil || rec.Code != StatusPreconditionFailed {
+		t.Errorf("If-Match: got %d, %v; want 412", rec.Code, err)
+	}
+	rec, err = serve(strings.NewReader("hello, world"), "Range", "bytes=7-11", "If-Range", etag)
+	if err != nil || rec.Code != StatusPartialContent || rec.Body.String() != "world" {
+		t.Errorf("Range: got %d %q, %v; want 206 \"world\"", rec.Code, rec.Body.String(), err)
+	}
+
+	rec, err = serve(noSeeker{strings.NewReader("hello")})
+	if err == nil || rec.Body.Len() != 0 || len(rec.Header()) != 0 {
+		t.Errorf("unseekable content: got error %v, body %q, header %v; want error and no response", err, rec.Body.String(), rec.Header())
+	}
+}
+
 func TestServeContentErrorMessages(t *testing.T) {
 	defer afterTest(t)
 	fs := fakeFS{
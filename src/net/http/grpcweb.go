@@ -0,0 +1,62 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"errors"
+	"net/http/internal/ascii"
+	"sort"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// grpcWebTrailerFlag marks a gRPC-Web frame as carrying trailers
+// rather than a message.
+const grpcWebTrailerFlag = 0x80
+
+// WriteGRPCWebTrailers writes trailers to w as a gRPC-Web trailer frame:
+// a flag byte of 0x80, the 4-byte big-endian length of the payload, and
+// the trailers in HTTP/1 header format with lowercase field names.
+//
+// gRPC-Web carries trailers in the response body, after the
+// length-prefixed message frames, so the caller must write all message
+// frames before calling WriteGRPCWebTrailers, and must not write to w
+// afterwards.
+func WriteGRPCWebTrailers(w ResponseWriter, trailers Header) error {
+	keys := make([]string, 0, len(trailers))
+	for k := range trailers {
+		if !httpguts.ValidHeaderFieldName(k) {
+			return errors.New("http: invalid gRPC-Web trailer name " + k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var payload strings.Builder
+	for _, k := range keys {
+		name, _ := ascii.ToLower(k)
+		for _, v := range trailers[k] {
+			if !httpguts.ValidHeaderFieldValue(v) {
+				return errors.New("http: invalid gRPC-Web trailer value for " + k)
+			}
+			payload.WriteString(name)
+			payload.WriteString(": ")
+			payload.WriteString(v)
+			payload.WriteString("\r\n")
+		}
+	}
+
+	n := payload.Len()
+	frame := make([]byte, 5, 5+n)
+	frame[0] = grpcWebTrailerFlag
+	frame[1] = byte(n >> 24)
+	frame[2] = byte(n >> 16)
+	frame[3] = byte(n >> 8)
+	frame[4] = byte(n)
+	frame = append(frame, payload.String()...)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,39 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWriteGRPCWebTrailers(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rec.WriteString("\x00\x00\x00\x00\x02hi") // a message frame
+	err := WriteGRPCWebTrailers(rec, Header{
+		"Grpc-Status":  {"0"},
+		"Grpc-Message": {"OK"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const payload = "grpc-message: OK\r\ngrpc-status: 0\r\n"
+	want := "\x00\x00\x00\x00\x02hi" + "\x80\x00\x00\x00\x22" + payload
+	if got := rec.Body.String(); got != want {
+		t.Errorf("body = %q; want %q", got, want)
+	}
+
+	rec = httptest.NewRecorder()
+	if err := WriteGRPCWebTrailers(rec, Header{"Bad Name": {"x"}}); err == nil {
+		t.Error("invalid trailer name: got nil error")
+	}
+	if err := WriteGRPCWebTrailers(rec, Header{"Grpc-Message": {"a\r\nb"}}); err == nil {
+		t.Error("invalid trailer value: got nil error")
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("body after errors = %q; want empty", rec.Body.String())
+	}
+}
@@ -58,6 +58,16 @@ func (h Header) Values(key string) []string {
 	return textproto.MIMEHeader(h).Values(key)
 }
 
+// Cookies parses and returns the cookies of the Set-Cookie values of
+// h, in order, skipping values that don't parse. On the server, these
+// are the cookies a handler has queued with SetCookie, or by adding
+// Set-Cookie values to its ResponseWriter's Header. The server sends
+// each value as a separate Set-Cookie field, over HTTP/1 and HTTP/2,
+// never joining them with commas, which may appear in cookies.
+func (h Header) Cookies() []*Cookie {
+	return readSetCookies(h)
+}
+
 // get is like Get, but key must already be in CanonicalHeaderKey form.
 func (h Header) get(key string) string {
 	if v := h[key]; len(v) > 0 {
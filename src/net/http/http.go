@@ -7,7 +7,9 @@
 package http
 
 import (
+	"crypto/tls"
 	"io"
+	"net"
 	"strconv"
 	"strings"
 	"time"
@@ -62,6 +64,29 @@ func isNotToken(r rune) bool {
 	return !httpguts.IsTokenRune(r)
 }
 
+// setTCPBuffers sets the socket send and receive buffer sizes of c,
+// or of the connection underlying it if c is a *tls.Conn, when it is
+// a *net.TCPConn. Sizes that are not positive are left unchanged.
+// Errors are ignored, as the sizes are only hints.
+func setTCPBuffers(c net.Conn, send, recv int) {
+	if send <= 0 && recv <= 0 {
+		return
+	}
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	tc, ok := c.(*net.TCPConn)
+	if !ok {
+		return
+	}
+	if send > 0 {
+		tc.SetWriteBuffer(send)
+	}
+	if recv > 0 {
+		tc.SetReadBuffer(recv)
+	}
+}
+
 // stringContainsCTLByte reports whether s contains any ASCII control character.
 func stringContainsCTLByte(s string) bool {
 	for i := 0; i < len(s); i++ {
@@ -7,6 +7,7 @@
 package httputil
 
 import (
+	"bytes"
 	"context"
 	"fmt"
 	"io"
@@ -14,9 +15,11 @@ import (
 	"mime"
 	"net"
 	"net/http"
+	"net/http/internal"
 	"net/http/internal/ascii"
 	"net/textproto"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -92,6 +95,19 @@ type ReverseProxy struct {
 	// If nil, the default is to log the provided error and return
 	// a 502 Status Bad Gateway response.
 	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+
+	// BufferThreshold, if positive, makes the proxy read the body
+	// of each response whose ContentLength is known and at most
+	// BufferThreshold bytes completely before sending anything to
+	// the client. The response is then sent with an accurate
+	// Content-Length, and if the backend fails partway through the
+	// body, the proxy tries the request once more when it has no
+	// body and an idempotent method, or else calls ErrorHandler,
+	// rather than sending a truncated response. If the backend
+	// sends more than BufferThreshold bytes after all, the proxy
+	// stops buffering and streams the rest of the response.
+	// Other responses are streamed as they arrive.
+	BufferThreshold int
 }
 
 // A BufferPool is an interface for getting and returning temporary
@@ -168,22 +184,9 @@ func copyHeader(dst, src http.Header) {
 	}
 }
 
-// Hop-by-hop headers. These are removed when sent to the backend.
-// As of RFC 7230, hop-by-hop headers are required to appear in the
-// Connection header field. These are the headers defined by the
-// obsoleted RFC 2616 (section 13.5.1) and are used for backward
-// compatibility.
-var hopHeaders = []string{
-	"Connection",
-	"Proxy-Connection", // non-standard but still sent by libcurl and rejected by e.g. google
-	"Keep-Alive",
-	"Proxy-Authenticate",
-	"Proxy-Authorization",
-	"Te",      // canonicalized version of "TE"
-	"Trailer", // not Trailers per URL above; https://www.rfc-editor.org/errata_search.php?eid=4522
-	"Transfer-Encoding",
-	"Upgrade",
-}
+// hopHeaders are the hop-by-hop headers removed when forwarding
+// requests to the backend and responses from it.
+var hopHeaders = internal.HopHeaders
 
 func (p *ReverseProxy) defaultErrorHandler(rw http.ResponseWriter, req *http.Request, err error) {
 	p.logf("http: proxy error: %v", err)
@@ -297,6 +300,15 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 
 	res, err := transport.RoundTrip(outreq)
+	if err == nil && p.shouldBuffer(outreq, res) {
+		err = p.bufferResponse(res)
+		if err != nil && canRetry(outreq) {
+			res, err = transport.RoundTrip(outreq)
+			if err == nil && p.shouldBuffer(outreq, res) {
+				err = p.bufferResponse(res)
+			}
+		}
+	}
 	if err != nil {
 		p.getErrorHandler()(rw, outreq, err)
 		return
@@ -332,6 +344,11 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 			trailerKeys = append(trailerKeys, k)
 		}
 		rw.Header().Add("Trailer", strings.Join(trailerKeys, ", "))
+
+		// An HTTP/2 backend may send a Content-Length along with
+		// trailers. Drop it so that an HTTP/1 client gets a chunked
+		// response, which is the only kind that can carry trailers.
+		rw.Header().Del("Content-Length")
 	}
 
 	rw.WriteHeader(res.StatusCode)
@@ -372,6 +389,56 @@ func (p *ReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
 	}
 }
 
+// shouldBuffer reports whether the body of res, a response to req,
+// should be read completely before it is sent, per BufferThreshold.
+func (p *ReverseProxy) shouldBuffer(req *http.Request, res *http.Response) bool {
+	if p.BufferThreshold <= 0 || req.Method == "HEAD" {
+		return false
+	}
+	switch code := res.StatusCode; {
+	case code < 200, code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return res.ContentLength >= 0 && res.ContentLength <= int64(p.BufferThreshold)
+}
+
+// bufferResponse reads up to BufferThreshold bytes of the body of res
+// into memory and replaces res.Body with them. If the body turns out
+// to be longer, the new body continues with the rest of the original
+// one. If reading fails, res.Body is closed and the error returned.
+func (p *ReverseProxy) bufferResponse(res *http.Response) error {
+	buf, err := io.ReadAll(io.LimitReader(res.Body, int64(p.BufferThreshold)+1))
+	if err != nil {
+		res.Body.Close()
+		return err
+	}
+	if len(buf) > p.BufferThreshold {
+		res.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(buf), res.Body), res.Body}
+		return nil
+	}
+	res.Body.Close() // populates res.Trailer
+	res.Body = io.NopCloser(bytes.NewReader(buf))
+	res.ContentLength = int64(len(buf))
+	res.Header.Set("Content-Length", strconv.Itoa(len(buf)))
+	return nil
+}
+
+// canRetry reports whether req may be sent again after its response
+// failed, because it has no body to resend and an idempotent method.
+func canRetry(req *http.Request) bool {
+	if req.Body != nil || req.Context().Err() != nil {
+		return false
+	}
+	switch req.Method {
+	case "GET", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return false
+}
+
 var inOurTests bool // whether we're in our own tests
 
 // shouldPanicOnCopyError reports whether the reverse proxy should
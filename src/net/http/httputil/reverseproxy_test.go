@@ -25,6 +25,7 @@ import (
 	"strings"
 	"sync"
 	"testing"
+	"testing/iotest"
 	"time"
 )
 
@@ -1537,3 +1538,120 @@ func TestJoinURLPath(t *testing.T) {
 		}
 	}
 }
+
+func TestReverseProxyTrailers(t *testing.T) {
+	for _, backendH2 := range []bool{false, true} {
+		for _, frontendH2 := range []bool{false, true} {
+			for _, body := range []string{"", "body"} {
+				name := fmt.Sprintf("backendH2=%v/frontendH2=%v/body=%q", backendH2, frontendH2, body)
+				t.Run(name, func(t *testing.T) {
+					testReverseProxyTrailers(t, backendH2, frontendH2, body)
+				})
+			}
+		}
+	}
+}
+
+func newTestServer(h http.Handler, h2 bool) *httptest.Server {
+	ts := httptest.NewUnstartedServer(h)
+	if h2 {
+		ts.EnableHTTP2 = true
+		ts.StartTLS()
+	} else {
+		ts.Start()
+	}
+	return ts
+}
+
+func testReverseProxyTrailers(t *testing.T, backendH2, frontendH2 bool, body string) {
+	backend := newTestServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Trailer", "X-Announced")
+		io.WriteString(w, body)
+		w.Header().Set("X-Announced", "announced")
+		w.Header().Set(http.TrailerPrefix+"X-Unannounced", "unannounced")
+	}), backendH2)
+	defer backend.Close()
+	backendURL, err := url.Parse(backend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyHandler := NewSingleHostReverseProxy(backendURL)
+	proxyHandler.Transport = backend.Client().Transport
+	proxyHandler.ErrorLog = log.New(io.Discard, "", 0) // quiet for tests
+	frontend := newTestServer(proxyHandler, frontendH2)
+	defer frontend.Close()
+
+	res, err := frontend.Client().Get(frontend.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(got) != body {
+		t.Fatalf("body = %q, %v; want %q", got, err, body)
+	}
+	want := http.Header{
+		"X-Announced":   {"announced"},
+		"X-Unannounced": {"unannounced"},
+	}
+	if !reflect.DeepEqual(res.Trailer, want) {
+		t.Errorf("Trailer = %v; want %v", res.Trailer, want)
+	}
+}
+
+func TestReverseProxyBufferThreshold(t *testing.T) {
+	const body = "hello world"
+	tests := []struct {
+		name       string
+		method     string
+		failFirst  bool  // the first response's body breaks partway
+		backendLen int64 // the ContentLength reported by the backend
+		wantCode   int
+		wantBody   string
+		wantLen    int64
+		wantTries  int
+	}{
+		{"buffered", "GET", false, int64(len(body)), 200, body, int64(len(body)), 1},
+		{"retried", "GET", true, int64(len(body)), 200, body, int64(len(body)), 2},
+		{"not retried", "POST", true, int64(len(body)), 502, "", 0, 1},
+		{"too long", "GET", false, 3, 200, body, int64(len(body)), 1},
+		{"unknown length", "GET", false, -1, 200, body, -1, 1},
+	}
+	for _, tt := range tests {
+		tries := 0
+		proxyHandler := &ReverseProxy{
+			Director: func(*http.Request) {},
+			Transport: RoundTripperFunc(func(req *http.Request) (*http.Response, error) {
+				tries++
+				var r io.Reader = strings.NewReader(body)
+				if tt.failFirst && tries == 1 {
+					r = io.MultiReader(strings.NewReader(body[:5]), iotest.ErrReader(errors.New("backend hiccup")))
+				}
+				return &http.Response{
+					StatusCode:    200,
+					Header:        http.Header{},
+					ContentLength: tt.backendLen,
+					Body:          io.NopCloser(r),
+				}, nil
+			}),
+			ErrorLog:        log.New(io.Discard, "", 0), // quiet for tests
+			BufferThreshold: 100,
+		}
+		frontend := httptest.NewServer(proxyHandler)
+		req, _ := http.NewRequest(tt.method, frontend.URL, nil)
+		res, err := frontend.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		frontend.Close()
+		if res.StatusCode != tt.wantCode || res.ContentLength != tt.wantLen || (tt.wantCode == 200 && (err != nil || string(got) != tt.wantBody)) {
+			t.Errorf("%s: got status %d, ContentLength %d, body %q, %v; want status %d, ContentLength %d, body %q",
+				tt.name, res.StatusCode, res.ContentLength, got, err, tt.wantCode, tt.wantLen, tt.wantBody)
+		}
+		if tries != tt.wantTries {
+			t.Errorf("%s: backend tried %d times; want %d", tt.name, tries, tt.wantTries)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Reverse proxy that splits traffic between weighted backends.
+
+package httputil
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/internal"
+	"net/url"
+	"sync"
+)
+
+// A WeightedBackend is one of the backends of a WeightedReverseProxy.
+type WeightedBackend struct {
+	// URL is the scheme, host, and base path of the backend,
+	// interpreted as by NewSingleHostReverseProxy.
+	URL *url.URL
+
+	// Weight is the backend's share of requests, relative to
+	// the weights of the other healthy backends. A backend
+	// with a weight of zero or less is never selected.
+	Weight int
+
+	// Name identifies the backend in the value of the sticky
+	// selection cookie. If empty, URL.String() is used.
+	Name string
+
+	// Healthy optionally reports whether the backend can
+	// currently serve requests. It is called for each request,
+	// and unhealthy backends are skipped. If nil, the backend is
+	// always considered healthy.
+	Healthy func() bool
+}
+
+// A WeightedReverseProxy is an HTTP Handler that sends each request
+// to one of several backends, chosen at random in proportion to
+// their weights, such as to route a small share of traffic to a
+// canary deployment.
+type WeightedReverseProxy struct {
+	// Proxy forwards each request to the backend selected for it.
+	// NewWeightedReverseProxy sets its Director, which must not be
+	// changed. Its other fields, such as Transport and
+	// ErrorHandler, may be set as for any ReverseProxy. If no
+	// backend is healthy, Proxy's ErrorHandler is called.
+	//
+	// Proxy may also serve requests itself, in which case its
+	// Director selects the backend, but the StickyCookie is not
+	// set in responses. If no backend is healthy, the Director
+	// leaves the request without a destination, so that it fails
+	// in the Transport and Proxy's ErrorHandler is called.
+	Proxy *ReverseProxy
+
+	// StickyCookie optionally names a cookie used to send a
+	// client's requests to the same backend. When a request has
+	// no such cookie, or it names a backend that is no longer
+	// healthy, the proxy selects a backend as usual and sets the
+	// cookie in the response to that backend's Name.
+	StickyCookie string
+
+	// StickyHeader optionally names a request header, such as a
+	// user ID, whose value selects the backend instead of a random
+	// choice. Requests with the same value go to the same backend
+	// for as long as the set of healthy backends does not change.
+	// A valid StickyCookie takes precedence.
+	StickyHeader string
+
+	// Rand optionally specifies the source of random backend
+	// choices, such as a seeded source in tests. It is only used
+	// with a lock held. If nil, the top-level functions of the
+	// math/rand package are used.
+	Rand *rand.Rand
+
+	mu       sync.Mutex // guards Rand
+	backends []weightedBackend
+}
+
+type weightedBackend struct {
+	WeightedBackend
+	director func(*http.Request)
+}
+
+// weightedBackendKey is the context key for the *weightedBackend
+// selected for a request.
+type weightedBackendKey struct{}
+
+var errNoHealthyBackend = errors.New("httputil: no healthy backend")
+
+// NewWeightedReverseProxy returns a new WeightedReverseProxy that
+// routes requests to backends. Each backend's URL must be non-nil.
+func NewWeightedReverseProxy(backends []WeightedBackend) *WeightedReverseProxy {
+	p := &WeightedReverseProxy{
+		backends: make([]weightedBackend, len(backends)),
+	}
+	for i, b := range backends {
+		if b.Name == "" {
+			b.Name = b.URL.String()
+		}
+		p.backends[i] = weightedBackend{
+			WeightedBackend: b,
+			director:        NewSingleHostReverseProxy(b.URL).Director,
+		}
+	}
+	p.Proxy = &ReverseProxy{Director: p.direct}
+	return p
+}
+
+// direct is the Director of p.Proxy. It sends req to the backend
+// selected by ServeHTTP, or selects one if Proxy is serving req
+// without ServeHTTP.
+func (p *WeightedReverseProxy) direct(req *http.Request) {
+	b, _ := req.Context().Value(weightedBackendKey{}).(*weightedBackend)
+	if b == nil {
+		b, _ = p.selectBackend(req)
+	}
+	if b == nil {
+		req.URL.Scheme = ""
+		req.URL.Host = ""
+		return
+	}
+	b.director(req)
+}
+
+func (p *WeightedReverseProxy) ServeHTTP(rw http.ResponseWriter, req *http.Request) {
+	b, sticky := p.selectBackend(req)
+	if b == nil {
+		p.Proxy.getErrorHandler()(rw, req, errNoHealthyBackend)
+		return
+	}
+	if p.StickyCookie != "" && !sticky {
+		http.SetCookie(rw, &http.Cookie{
+			Name:     p.StickyCookie,
+			Value:    b.Name,
+			Path:     "/",
+			HttpOnly: true,
+		})
+	}
+	ctx := context.WithValue(req.Context(), weightedBackendKey{}, b)
+	p.Proxy.ServeHTTP(rw, req.WithContext(ctx))
+}
+
+// selectBackend returns the backend for req, or nil if no backend
+// is healthy. It reports whether the backend was the one named by
+// req's sticky cookie.
+func (p *WeightedReverseProxy) selectBackend(req *http.Request) (b *weightedBackend, sticky bool) {
+	var healthy []*weightedBackend
+	total := 0
+	for i := range p.backends {
+		b := &p.backends[i]
+		if b.Weight > 0 && (b.Healthy == nil || b.Healthy()) {
+			healthy = append(healthy, b)
+			total += b.Weight
+		}
+	}
+	if len(healthy) == 0 {
+		return nil, false
+	}
+	if p.StickyCookie != "" {
+		if c, err := req.Cookie(p.StickyCookie); err == nil {
+			for _, b := range healthy {
+				if b.Name == c.Value {
+					return b, true
+				}
+			}
+		}
+	}
+	var n int
+	if v := req.Header.Get(p.StickyHeader); p.StickyHeader != "" && v != "" {
+		n = int(internal.HashString(v) % uint64(total))
+	} else {
+		n = p.intn(total)
+	}
+	for _, b := range healthy {
+		if n < b.Weight {
+			return b, false
+		}
+		n -= b.Weight
+	}
+	panic("unreachable")
+}
+
+func (p *WeightedReverseProxy) intn(n int) int {
+	if p.Rand == nil {
+		return rand.Intn(n)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.Rand.Intn(n)
+}
@@ -0,0 +1,140 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package httputil
+
+import (
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+// newWeightedBackends starts n backends that reply with their index
+// and returns them as WeightedBackends with the given weights.
+func newWeightedBackends(t *testing.T, weights ...int) []WeightedBackend {
+	var backends []WeightedBackend
+	for i, w := range weights {
+		i := i
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, strconv.Itoa(i))
+		}))
+		t.Cleanup(ts.Close)
+		u, err := url.Parse(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		backends = append(backends, WeightedBackend{URL: u, Weight: w, Name: "b" + strconv.Itoa(i)})
+	}
+	return backends
+}
+
+// getBackend makes a request through h and returns the index of the
+// backend that served it, or -1 if the proxy failed.
+func getBackend(t *testing.T, h http.Handler, req *http.Request) (int, *httptest.ResponseRecorder) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		return -1, rec
+	}
+	i, err := strconv.Atoi(rec.Body.String())
+	if err != nil {
+		t.Fatalf("unexpected body %q", rec.Body.String())
+	}
+	return i, rec
+}
+
+func TestWeightedReverseProxy(t *testing.T) {
+	backends := newWeightedBackends(t, 3, 1, 0, 2)
+	backends[3].Healthy = func() bool { return false }
+	p := NewWeightedReverseProxy(backends)
+	p.Rand = rand.New(rand.NewSource(1))
+
+	counts := make([]int, len(backends))
+	const n = 400
+	for i := 0; i < n; i++ {
+		b, rec := getBackend(t, p, httptest.NewRequest("GET", "/", nil))
+		if b < 0 {
+			t.Fatalf("request %d: status %d", i, rec.Code)
+		}
+		counts[b]++
+	}
+	if counts[2] != 0 || counts[3] != 0 {
+		t.Errorf("zero-weight or unhealthy backend selected: counts = %v", counts)
+	}
+	// Backend 0 should get about three quarters of the requests.
+	if counts[0] < n/2 || counts[0] > n*7/8 {
+		t.Errorf("counts = %v; want about %d for backend 0", counts, n*3/4)
+	}
+}
+
+func TestWeightedReverseProxyNoHealthyBackend(t *testing.T) {
+	backends := newWeightedBackends(t, 1)
+	backends[0].Healthy = func() bool { return false }
+	p := NewWeightedReverseProxy(backends)
+	p.Proxy.ErrorLog = log.New(io.Discard, "", 0) // quiet for tests
+	if b, rec := getBackend(t, p, httptest.NewRequest("GET", "/", nil)); b >= 0 || rec.Code != http.StatusBadGateway {
+		t.Errorf("got backend %d, status %d; want status %d", b, rec.Code, http.StatusBadGateway)
+	}
+}
+
+func TestWeightedReverseProxyDirect(t *testing.T) {
+	backends := newWeightedBackends(t, 1)
+	p := NewWeightedReverseProxy(backends)
+	p.Proxy.ErrorLog = log.New(io.Discard, "", 0) // quiet for tests
+	if b, rec := getBackend(t, p.Proxy, httptest.NewRequest("GET", "/", nil)); b != 0 {
+		t.Errorf("Proxy.ServeHTTP: got backend %d, status %d; want backend 0", b, rec.Code)
+	}
+	p.backends[0].Healthy = func() bool { return false }
+	if b, rec := getBackend(t, p.Proxy, httptest.NewRequest("GET", "/", nil)); b >= 0 || rec.Code != http.StatusBadGateway {
+		t.Errorf("Proxy.ServeHTTP with no healthy backend: got backend %d, status %d; want status %d", b, rec.Code, http.StatusBadGateway)
+	}
+}
+
+func TestWeightedReverseProxyStickyCookie(t *testing.T) {
+	backends := newWeightedBackends(t, 1, 1)
+	healthy := true
+	backends[1].Healthy = func() bool { return healthy }
+	p := NewWeightedReverseProxy(backends)
+	p.StickyCookie = "backend"
+
+	for i := 0; i < 10; i++ {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.AddCookie(&http.Cookie{Name: "backend", Value: "b1"})
+		if b, rec := getBackend(t, p, req); b != 1 || rec.Header().Get("Set-Cookie") != "" {
+			t.Fatalf("with cookie for b1: got backend %d, Set-Cookie %q; want 1 and no cookie", b, rec.Header().Get("Set-Cookie"))
+		}
+	}
+
+	healthy = false
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(&http.Cookie{Name: "backend", Value: "b1"})
+	b, rec := getBackend(t, p, req)
+	if want := "backend=b0; Path=/; HttpOnly"; b != 0 || rec.Header().Get("Set-Cookie") != want {
+		t.Errorf("with cookie for unhealthy b1: got backend %d, Set-Cookie %q; want 0 and %q", b, rec.Header().Get("Set-Cookie"), want)
+	}
+}
+
+func TestWeightedReverseProxyStickyHeader(t *testing.T) {
+	p := NewWeightedReverseProxy(newWeightedBackends(t, 1, 1, 1, 1))
+	p.StickyHeader = "X-User"
+
+	for _, user := range []string{"alice", "bob", "carol"} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("X-User", user)
+		want, _ := getBackend(t, p, req)
+		for i := 0; i < 10; i++ {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("X-User", user)
+			if b, _ := getBackend(t, p, req); b != want {
+				t.Fatalf("user %q: got backend %d, previously %d", user, b, want)
+			}
+		}
+	}
+}
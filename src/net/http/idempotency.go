@@ -0,0 +1,263 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Deduplication of requests by idempotency key.
+
+package http
+
+import (
+	"bytes"
+	"sync"
+	"time"
+)
+
+// An IdempotentResponse is a response stored by a handler returned by
+// NewIdempotencyHandler, to be sent again for requests repeating its
+// idempotency key.
+type IdempotentResponse struct {
+	Method     string // the method of the request it answered
+	Path       string // the URL.Path of the request it answered
+	StatusCode int
+	Header     Header
+	Body       []byte
+}
+
+// An IdempotencyStore stores the responses of a handler returned by
+// NewIdempotencyHandler by their idempotency keys. It must be safe for
+// concurrent use by multiple goroutines.
+type IdempotencyStore interface {
+	// Get returns the response stored for key, if there is one.
+	// The caller must not modify it.
+	Get(key string) (resp *IdempotentResponse, ok bool)
+
+	// Put stores resp for key, replacing any stored response.
+	Put(key string, resp *IdempotentResponse)
+}
+
+// MemoryIdempotencyStore is an IdempotencyStore that keeps responses
+// in memory for a fixed time after they are stored. The zero value is
+// ready to use. A MemoryIdempotencyStore must not be copied after first
+// use.
+type MemoryIdempotencyStore struct {
+	// TTL is how long a response is kept. If zero, 24 hours is
+	// used.
+	TTL time.Duration
+
+	mu        sync.Mutex
+	entries   map[string]idempotencyEntry
+	lastSweep time.Time
+}
+
+type idempotencyEntry struct {
+	resp    *IdempotentResponse
+	expires time.Time
+}
+
+// Get implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Get(key string) (*IdempotentResponse, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e, ok := s.entries[key]
+	if !ok || !time.Now().Before(e.expires) {
+		return nil, false
+	}
+	return e.resp, true
+}
+
+// Put implements IdempotencyStore.
+func (s *MemoryIdempotencyStore) Put(key string, resp *IdempotentResponse) {
+	ttl := s.TTL
+	if ttl == 0 {
+		ttl = 24 * time.Hour
+	}
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.entries == nil {
+		s.entries = make(map[string]idempotencyEntry)
+	}
+	s.sweep(now, ttl)
+	s.entries[key] = idempotencyEntry{resp, now.Add(ttl)}
+}
+
+// sweep drops the expired entries once each TTL, so that the store
+// holds no key for more than twice its TTL. s.mu must be held.
+func (s *MemoryIdempotencyStore) sweep(now time.Time, ttl time.Duration) {
+	if now.Sub(s.lastSweep) < ttl {
+		return
+	}
+	s.lastSweep = now
+	for k, e := range s.entries {
+		if !now.Before(e.expires) {
+			delete(s.entries, k)
+		}
+	}
+}
+
+// maxIdempotentBodyBytes is the size of the largest response body
+// stored by a handler returned by NewIdempotencyHandler.
+const maxIdempotentBodyBytes = 1 << 20
+
+// NewIdempotencyHandler returns a handler that runs next at most once
+// for each idempotency key, so that clients can safely retry requests
+// that are not idempotent, such as a POST making a payment. The key is
+// the value of the request header named by headerName, or of the
+// Idempotency-Key header if headerName is empty; requests without one
+// are passed to next unchanged.
+//
+// The response to the first request with a key, its status code,
+// header, and body, is stored in store. A later request with the key
+// gets the stored response, with an Idempotent-Replayed header set to
+// "true", without running next, as long as store keeps the response.
+// Requests with the same key are served one at a time, so a retry sent
+// while the first request is still running waits for its response.
+// A request repeating the key of a request with another method or URL
+// path gets a 422 Unprocessable Entity error. Keys are compared
+// exactly, and are shared by all clients, so clients should choose them
+// at random.
+//
+// Responses with a 5xx status code are not stored, so that a request
+// can be retried after a server error, and neither are responses with
+// a body of more than 1 MB. The ResponseWriter passed to next has an
+// Unwrap method returning the original, so that ResponseController can
+// be used to reach methods other than Flush.
+func NewIdempotencyHandler(next Handler, store IdempotencyStore, headerName string) Handler {
+	if headerName == "" {
+		headerName = "Idempotency-Key"
+	}
+	return &idempotencyHandler{
+		next:   next,
+		store:  store,
+		header: headerName,
+		locks:  make(map[string]*idempotencyLock),
+	}
+}
+
+type idempotencyHandler struct {
+	next   Handler
+	store  IdempotencyStore
+	header string
+
+	mu    sync.Mutex
+	locks map[string]*idempotencyLock // keys of requests being served
+}
+
+// An idempotencyLock serializes the requests with one key. Its channel
+// holds a value while a request holds the lock.
+type idempotencyLock struct {
+	ch   chan struct{}
+	refs int // requests holding or waiting for the lock; guarded by idempotencyHandler.mu
+}
+
+func (h *idempotencyHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	key := r.Header.Get(h.header)
+	if key == "" {
+		h.next.ServeHTTP(w, r)
+		return
+	}
+
+	h.mu.Lock()
+	l := h.locks[key]
+	if l == nil {
+		l = &idempotencyLock{ch: make(chan struct{}, 1)}
+		h.locks[key] = l
+	}
+	l.refs++
+	h.mu.Unlock()
+	defer func() {
+		h.mu.Lock()
+		if l.refs--; l.refs == 0 {
+			delete(h.locks, key)
+		}
+		h.mu.Unlock()
+	}()
+	select {
+	case l.ch <- struct{}{}:
+		defer func() { <-l.ch }()
+	case <-r.Context().Done():
+		return // the client is gone
+	}
+
+	if resp, ok := h.store.Get(key); ok {
+		if resp.Method != r.Method || resp.Path != r.URL.Path {
+			Error(w, "422 idempotency key reused for a different request", StatusUnprocessableEntity)
+			return
+		}
+		for k, vv := range resp.Header {
+			w.Header()[k] = append([]string(nil), vv...)
+		}
+		w.Header().Set("Idempotent-Replayed", "true")
+		w.WriteHeader(resp.StatusCode)
+		w.Write(resp.Body)
+		return
+	}
+
+	iw := &idempotencyWriter{rw: w}
+	h.next.ServeHTTP(iw, r)
+	if iw.status == 0 {
+		iw.setStatus(StatusOK)
+	}
+	if iw.tooLarge || iw.status >= 500 {
+		return
+	}
+	h.store.Put(key, &IdempotentResponse{
+		Method:     r.Method,
+		Path:       r.URL.Path,
+		StatusCode: iw.status,
+		Header:     iw.header,
+		Body:       iw.body.Bytes(),
+	})
+}
+
+// idempotencyWriter is the ResponseWriter passed to handlers by
+// NewIdempotencyHandler, recording the response as it is written.
+type idempotencyWriter struct {
+	rw       ResponseWriter
+	status   int
+	header   Header // snapshot at WriteHeader
+	body     bytes.Buffer
+	tooLarge bool
+}
+
+func (w *idempotencyWriter) Header() Header { return w.rw.Header() }
+
+// setStatus records the status and header of the response.
+func (w *idempotencyWriter) setStatus(code int) {
+	w.status = code
+	w.header = w.rw.Header().Clone()
+}
+
+func (w *idempotencyWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.setStatus(code)
+	}
+	w.rw.WriteHeader(code)
+}
+
+func (w *idempotencyWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.setStatus(StatusOK)
+	}
+	n, err := w.rw.Write(p)
+	if !w.tooLarge {
+		if w.body.Len()+n > maxIdempotentBodyBytes {
+			w.tooLarge = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(p[:n])
+		}
+	}
+	return n, err
+}
+
+func (w *idempotencyWriter) Flush() {
+	if w.status == 0 {
+		w.setStatus(StatusOK)
+	}
+	if f, ok := w.rw.(Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *idempotencyWriter) Unwrap() ResponseWriter { return w.rw }
@@ -0,0 +1,132 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"fmt"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIdempotencyHandler(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	var mu sync.Mutex
+	runs := 0
+	release := make(chan struct{})
+	h := NewIdempotencyHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		runs++
+		n := runs
+		mu.Unlock()
+		if r.URL.Path == "/slow" {
+			<-release
+		}
+		if r.URL.Path == "/fail" {
+			w.WriteHeader(StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("X-Run", fmt.Sprint(n))
+		w.WriteHeader(StatusCreated)
+		fmt.Fprintf(w, "payment %d", n)
+	}), &MemoryIdempotencyStore{}, "")
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	post := func(path, key string) (int, string, Header) {
+		t.Helper()
+		req, _ := NewRequest("POST", ts.URL+path, strings.NewReader("amount=1"))
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		res, err := ts.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, _ := io.ReadAll(res.Body)
+		return res.StatusCode, string(b), res.Header
+	}
+	runCount := func() int {
+		mu.Lock()
+		defer mu.Unlock()
+		return runs
+	}
+
+	code, body, hdr := post("/pay", "k1")
+	if code != StatusCreated || body != "payment 1" || hdr.Get("Idempotent-Replayed") != "" {
+		t.Errorf("first request = %d %q, replayed %q; want 201 \"payment 1\", not replayed", code, body, hdr.Get("Idempotent-Replayed"))
+	}
+	code, body, hdr = post("/pay", "k1")
+	if code != StatusCreated || body != "payment 1" || hdr.Get("X-Run") != "1" || hdr.Get("Idempotent-Replayed") != "true" {
+		t.Errorf("repeated request = %d %q, X-Run %q, replayed %q; want stored response", code, body, hdr.Get("X-Run"), hdr.Get("Idempotent-Replayed"))
+	}
+	if n := runCount(); n != 1 {
+		t.Errorf("handler ran %d times for one key; want 1", n)
+	}
+	if code, _, _ := post("/refund", "k1"); code != StatusUnprocessableEntity {
+		t.Errorf("key reused for another path: status = %d; want 422", code)
+	}
+	post("/pay", "")
+	post("/pay", "")
+	if n := runCount(); n != 3 {
+		t.Errorf("handler ran %d times; want 3 after two requests without a key", n)
+	}
+	post("/fail", "k2")
+	post("/fail", "k2")
+	if n := runCount(); n != 5 {
+		t.Errorf("handler ran %d times; want 5 after retrying a 503", n)
+	}
+
+	// Concurrent requests with one key run the handler once.
+	var wg sync.WaitGroup
+	bodies := make([]string, 3)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, bodies[i], _ = post("/slow", "k3")
+		}(i)
+	}
+	if !waitCondition(10*time.Second, time.Millisecond, func() bool { return runCount() == 6 }) {
+		t.Fatal("handler not called for concurrent requests")
+	}
+	close(release)
+	wg.Wait()
+	for i, b := range bodies {
+		if b != "payment 6" {
+			t.Errorf("concurrent request %d: body %q; want \"payment 6\"", i, b)
+		}
+	}
+	if n := runCount(); n != 6 {
+		t.Errorf("handler ran %d times; want 6 after concurrent requests with one key", n)
+	}
+}
+
+func TestMemoryIdempotencyStore(t *testing.T) {
+	s := &MemoryIdempotencyStore{TTL: 100 * time.Millisecond}
+	resp := &IdempotentResponse{Method: "POST", Path: "/", StatusCode: 200}
+	s.Put("k", resp)
+	if got, ok := s.Get("k"); !ok || got != resp {
+		t.Errorf("Get = %v; want stored response", got)
+	}
+	time.Sleep(200 * time.Millisecond)
+	if got, ok := s.Get("k"); ok {
+		t.Errorf("Get after TTL = %v, true; want expired", got)
+	}
+	if _, ok := s.Get("unknown"); ok {
+		t.Errorf("Get of unknown key = true")
+	}
+	// The expired entry is reclaimed by the next Put after a TTL.
+	s.Put("k2", resp)
+	if n := s.ExportLen(); n != 1 {
+		t.Errorf("%d entries kept; want 1", n)
+	}
+}
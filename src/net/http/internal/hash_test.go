@@ -0,0 +1,20 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"hash/fnv"
+	"testing"
+)
+
+func TestHashString(t *testing.T) {
+	for _, s := range []string{"", "a", "foobar", "req-1234"} {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		if got, want := HashString(s), h.Sum64(); got != want {
+			t.Errorf("HashString(%q) = %#x; want %#x", s, got, want)
+		}
+	}
+}
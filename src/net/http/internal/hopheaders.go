@@ -0,0 +1,23 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+// HopHeaders lists the hop-by-hop headers that proxies remove from
+// the requests and responses they forward, besides those named by a
+// message's Connection header. RFC 7230 requires hop-by-hop headers
+// to be listed in Connection; these are the ones defined by the
+// obsoleted RFC 2616 (section 13.5.1), kept for backward
+// compatibility.
+var HopHeaders = []string{
+	"Connection",
+	"Proxy-Connection", // non-standard but still sent by libcurl and rejected by e.g. google
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",      // canonicalized version of "TE"
+	"Trailer", // not Trailers, as listed by RFC 2616; https://www.rfc-editor.org/errata_search.php?eid=4522
+	"Transfer-Encoding",
+	"Upgrade",
+}
@@ -0,0 +1,121 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Decoding of JSON request and response bodies.
+
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+var (
+	// ErrJSONElementTooLarge is returned by DecodeJSONArray when an
+	// element of the array is longer than the given limit.
+	ErrJSONElementTooLarge = errors.New("http: JSON array element too large")
+
+	errNotJSONArray = errors.New("http: request body is not a JSON array")
+)
+
+// NewJSONDecoder returns a JSON decoder reading r's body, which fails
+// with ErrBodyTooLarge once it has read more than maxBytes bytes.
+// The decoder's Token method can be used to process a large body
+// piece by piece.
+func NewJSONDecoder(r *Request, maxBytes int64) *json.Decoder {
+	return json.NewDecoder(&jsonLimitReader{r: r.Body, max: maxBytes, elemStart: -1})
+}
+
+// DecodeJSONArray reads r's body, which must hold a JSON array, and
+// calls each with every element of the array as it arrives, without
+// holding more than about one element in memory at a time. The
+// message passed to each may be retained.
+//
+// DecodeJSONArray returns ErrJSONElementTooLarge as soon as an element
+// turns out to be longer than maxElemBytes, and ErrBodyTooLarge after
+// reading more than maxBytes bytes of the body. If each returns an
+// error, DecodeJSONArray stops and returns it. Otherwise, it returns
+// an error if the body is not a valid JSON array. Elements passed to
+// each before an error was found stay processed.
+func DecodeJSONArray(r *Request, maxElemBytes, maxBytes int64, each func(json.RawMessage) error) error {
+	lr := &jsonLimitReader{r: r.Body, max: maxBytes, maxElem: maxElemBytes, elemStart: -1}
+	dec := json.NewDecoder(lr)
+	tok, err := dec.Token()
+	if err == io.EOF {
+		return errNotJSONArray
+	}
+	if err != nil {
+		return err
+	}
+	if tok != json.Delim('[') {
+		return errNotJSONArray
+	}
+	for dec.More() {
+		lr.elemStart = dec.InputOffset()
+		var elem json.RawMessage
+		if err := dec.Decode(&elem); err != nil {
+			return err
+		}
+		lr.elemStart = -1
+		if int64(len(elem)) > maxElemBytes {
+			return ErrJSONElementTooLarge
+		}
+		if err := each(elem); err != nil {
+			return err
+		}
+	}
+	_, err = dec.Token() // the closing ']'
+	return err
+}
+
+// DecodeJSON decodes the JSON value in r's body into v, as by
+// json.Unmarshal, reading the body only up to the end of the value.
+// It first checks that the response has a JSON media type,
+// "application/json" or one ending in "+json", such as
+// "application/problem+json", and returns a *ContentTypeError
+// otherwise. The caller must still close r.Body.
+func (r *Response) DecodeJSON(v any) error {
+	err := r.expectContentType("application/json", func(mt string) bool {
+		return mt == "application/json" || strings.HasSuffix(mt, "+json")
+	})
+	if err != nil {
+		return err
+	}
+	return json.NewDecoder(r.Body).Decode(v)
+}
+
+// jsonLimitReader is the body reader of the json.Decoder used by
+// NewJSONDecoder and DecodeJSONArray.
+type jsonLimitReader struct {
+	r   io.Reader
+	n   int64 // bytes read so far
+	max int64
+
+	// elemStart is the offset at which the array element being
+	// decoded begins, or -1. While it is set, a Read after the
+	// decoder has buffered more than maxElem bytes since elemStart
+	// fails, since the element is then incomplete and too long.
+	elemStart int64
+	maxElem   int64
+}
+
+func (lr *jsonLimitReader) Read(p []byte) (int, error) {
+	if lr.elemStart >= 0 && lr.n-lr.elemStart > lr.maxElem {
+		return 0, ErrJSONElementTooLarge
+	}
+	if lr.n > lr.max {
+		return 0, ErrBodyTooLarge
+	}
+	if rem := lr.max - lr.n + 1; int64(len(p)) > rem {
+		p = p[:rem] // one extra byte to notice an overlong body
+	}
+	n, err := lr.r.Read(p)
+	lr.n += int64(n)
+	if lr.n > lr.max {
+		return n - 1, ErrBodyTooLarge
+	}
+	return n, err
+}
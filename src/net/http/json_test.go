@@ -0,0 +1,94 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONArray(t *testing.T) {
+	errStop := errors.New("stop")
+	tests := []struct {
+		name     string
+		body     string
+		maxElem  int64
+		maxTotal int64
+		stopAt   int // each returns errStop on this element, if positive
+		want     []string
+		wantErr  error // nil for no error, io.ErrUnexpectedEOF for any
+	}{
+		{"ok", ` [1, {"a": [2]}, "x"] `, 10, 100, 0, []string{`1`, `{"a": [2]}`, `"x"`}, nil},
+		{"empty", `[]`, 10, 100, 0, nil, nil},
+		{"stop", `[1, 2, 3]`, 10, 100, 2, []string{`1`, `2`}, errStop},
+		{"element too large", `[1, "` + strings.Repeat("x", 5000) + `", 3]`, 10, 1 << 20, 0, []string{`1`}, ErrJSONElementTooLarge},
+		{"short element too large", `[1, "0123456789", 3]`, 10, 100, 0, []string{`1`}, ErrJSONElementTooLarge},
+		{"body too large", `[1, 2, 3, 4, 5, 6]`, 10, 10, 0, []string{`1`, `2`, `3`}, ErrBodyTooLarge},
+		{"not array", `{"a": 1}`, 10, 100, 0, nil, io.ErrUnexpectedEOF},
+		{"no body", ``, 10, 100, 0, nil, io.ErrUnexpectedEOF},
+		{"truncated", `[1, 2`, 10, 100, 0, []string{`1`, `2`}, io.ErrUnexpectedEOF},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+		var got []string
+		err := DecodeJSONArray(req, tt.maxElem, tt.maxTotal, func(m json.RawMessage) error {
+			got = append(got, string(m))
+			if len(got) == tt.stopAt {
+				return errStop
+			}
+			return nil
+		})
+		if tt.wantErr == io.ErrUnexpectedEOF {
+			if err == nil {
+				t.Errorf("%s: DecodeJSONArray succeeded; want error", tt.name)
+			}
+		} else if err != tt.wantErr {
+			t.Errorf("%s: DecodeJSONArray error = %v; want %v", tt.name, err, tt.wantErr)
+		}
+		if strings.Join(got, "|") != strings.Join(tt.want, "|") {
+			t.Errorf("%s: elements = %q; want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNewJSONDecoder(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", strings.NewReader(`{"a": 1}`))
+	var v map[string]int
+	if err := NewJSONDecoder(req, 8).Decode(&v); err != nil || v["a"] != 1 {
+		t.Errorf("Decode = %v, %v; want map[a:1], nil", v, err)
+	}
+	req = httptest.NewRequest("POST", "/", strings.NewReader(`{"a": 1}`))
+	if err := NewJSONDecoder(req, 7).Decode(&v); err != ErrBodyTooLarge {
+		t.Errorf("Decode with short limit: err = %v; want ErrBodyTooLarge", err)
+	}
+}
+
+func TestResponseDecodeJSON(t *testing.T) {
+	newResponse := func(ct, body string) *Response {
+		return &Response{
+			StatusCode: 200,
+			Header:     Header{"Content-Type": {ct}},
+			Body:       io.NopCloser(strings.NewReader(body)),
+		}
+	}
+	for _, ct := range []string{"application/json", "application/json; charset=utf-8", "application/problem+json"} {
+		var v struct{ A int }
+		if err := newResponse(ct, `{"a": 1}`).DecodeJSON(&v); err != nil || v.A != 1 {
+			t.Errorf("DecodeJSON with Content-Type %q = %v, %+v", ct, err, v)
+		}
+	}
+
+	var v any
+	err := newResponse("text/html", "<html>oops</html>").DecodeJSON(&v)
+	var cte *ContentTypeError
+	if !errors.As(err, &cte) || string(cte.Snippet) != "<html>oops</html>" {
+		t.Errorf("DecodeJSON of text/html = %v; want *ContentTypeError with body", err)
+	}
+}
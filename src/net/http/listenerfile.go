@@ -0,0 +1,125 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Passing listening sockets to a new process.
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ListenerFile returns a duplicate of the file descriptor of the
+// listener srv is serving on, for passing to a new process, such as an
+// upgraded binary, which recreates the listener with InheritedListener
+// or net.FileListener. Together with Shutdown, this lets a server be
+// restarted without refusing connections: the new process accepts
+// connections on the listener while the old one finishes serving its
+// own.
+//
+// The returned file is independent of the listener and remains valid
+// after srv stops accepting; the caller must close it. For a Unix
+// domain socket, ListenerFile also arranges for the socket file not to
+// be removed when srv closes the listener, since the new process keeps
+// using it.
+//
+// ListenerFile returns an error unless srv is serving on exactly one
+// listener, and that listener has a File method, as *net.TCPListener
+// and *net.UnixListener do. Listeners wrapping another, such as those
+// used by ServeTLS and ListenAndServeTLS, have no file to return; such
+// servers should create their listener with net.Listen and pass its
+// file instead.
+func (srv *Server) ListenerFile() (*os.File, error) {
+	srv.mu.Lock()
+	var ln net.Listener
+	n := 0
+	for l := range srv.listeners {
+		ln = *l
+		n++
+	}
+	srv.mu.Unlock()
+	switch n {
+	case 0:
+		return nil, errors.New("http: server is not serving on a listener")
+	case 1:
+	default:
+		return nil, errors.New("http: server is serving on more than one listener")
+	}
+	if oc, ok := ln.(*onceCloseListener); ok {
+		ln = oc.Listener
+	}
+	fl, ok := ln.(interface{ File() (*os.File, error) })
+	if !ok {
+		return nil, fmt.Errorf("http: listener of type %T has no file", ln)
+	}
+	f, err := fl.File()
+	if err != nil {
+		return nil, err
+	}
+	// *net.UnixListener has no SetUnlinkOnClose on Plan 9.
+	if ul, ok := ln.(interface{ SetUnlinkOnClose(bool) }); ok {
+		ul.SetUnlinkOnClose(false)
+	}
+	return f, nil
+}
+
+// listenFDsStart is the first file descriptor passed by the
+// LISTEN_FDS protocol, after standard input, output, and error.
+const listenFDsStart = 3
+
+// InheritedListener returns the listener named name that was passed to
+// the current process by its parent, such as a previous version of the
+// program passing the file returned by Server.ListenerFile.
+//
+// Listeners are passed using the socket activation protocol of
+// systemd: the parent passes the files as descriptors 3 and up, as
+// exec.Cmd.ExtraFiles does, sets the environment variable LISTEN_FDS
+// to the number of files, and sets LISTEN_FDNAMES to their names,
+// separated by colons. Without LISTEN_FDNAMES, every file is named
+// "unknown". If LISTEN_PID is set, the files are only used if it is the
+// current process ID; a parent passing files to a new process it
+// starts should remove it from the environment.
+//
+// InheritedListener takes ownership of the descriptor, so it must be
+// called at most once for each name. It returns an error if no
+// listener was passed under the name, or if its descriptor is not a
+// listening socket.
+func InheritedListener(name string) (net.Listener, error) {
+	if pid := os.Getenv("LISTEN_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, fmt.Errorf("http: no inherited listener named %q", name)
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return nil, fmt.Errorf("http: no inherited listener named %q", name)
+	}
+	var names []string
+	if s := os.Getenv("LISTEN_FDNAMES"); s != "" {
+		names = strings.Split(s, ":")
+	}
+	for i := 0; i < n; i++ {
+		fdName := "unknown"
+		if i < len(names) {
+			fdName = names[i]
+		}
+		if fdName != name {
+			continue
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		if f == nil {
+			break
+		}
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("http: inherited listener %q: %v", name, err)
+		}
+		return ln, nil
+	}
+	return nil, fmt.Errorf("http: no inherited listener named %q", name)
+}
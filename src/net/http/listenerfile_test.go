@@ -0,0 +1,120 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"internal/testenv"
+	"io"
+	"net"
+	. "net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestServerListenerFile(t *testing.T) {
+	switch runtime.GOOS {
+	case "js", "plan9", "windows":
+		t.Skipf("listener files not supported on %s", runtime.GOOS)
+	}
+	testenv.MustHaveExec(t)
+	defer afterTest(t)
+
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "parent")
+	})}
+	if _, err := srv.ListenerFile(); err == nil {
+		t.Errorf("ListenerFile before Serve = nil error; want error")
+	}
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + ln.Addr().String()
+	go srv.Serve(ln)
+	get := func() string {
+		t.Helper()
+		res, err := Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		b, err := io.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(b)
+	}
+	if got := get(); got != "parent" {
+		t.Fatalf("got %q from parent; want parent", got)
+	}
+
+	f, err := srv.ListenerFile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd := exec.Command(os.Args[0], "-test.run=TestInheritedListenerChild")
+	cmd.Env = append(os.Environ(), "GO_WANT_HELPER_PROCESS=1", "LISTEN_FDS=1", "LISTEN_FDNAMES=http")
+	cmd.ExtraFiles = []*os.File{f}
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if got := get(); got != "child" {
+		t.Errorf("got %q after restart; want child", got)
+	}
+	if err := cmd.Wait(); err != nil {
+		t.Errorf("child: %v", err)
+	}
+}
+
+// TestInheritedListenerChild isn't a real test. It's used as a helper
+// process for TestServerListenerFile.
+func TestInheritedListenerChild(*testing.T) {
+	if os.Getenv("GO_WANT_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+	if _, err := InheritedListener("other"); err == nil {
+		panic("InheritedListener(other) = nil error")
+	}
+	ln, err := InheritedListener("http")
+	if err != nil {
+		panic(err)
+	}
+	done := make(chan struct{})
+	srv := &Server{Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Connection", "close")
+		io.WriteString(w, "child")
+		close(done)
+	})}
+	go srv.Serve(ln)
+	<-done
+	srv.Shutdown(context.Background())
+}
+
+func TestInheritedListenerMissing(t *testing.T) {
+	t.Setenv("LISTEN_FDS", "")
+	if _, err := InheritedListener("http"); err == nil {
+		t.Errorf("InheritedListener without LISTEN_FDS = nil error; want error")
+	}
+	t.Setenv("LISTEN_FDS", "1")
+	t.Setenv("LISTEN_PID", "1")
+	if os.Getpid() != 1 {
+		if _, err := InheritedListener("unknown"); err == nil {
+			t.Errorf("InheritedListener with LISTEN_PID of another process = nil error; want error")
+		}
+	}
+}
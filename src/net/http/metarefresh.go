@@ -0,0 +1,202 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// HTML meta refresh redirects, followed by Clients with
+// FollowMetaRefresh set.
+
+package http
+
+import (
+	"bytes"
+	"io"
+	"net/http/internal/ascii"
+	"net/textproto"
+	"strings"
+)
+
+const (
+	// maxMetaRefreshBodySize is the size of the largest HTML
+	// response body that is searched for a meta refresh.
+	maxMetaRefreshBodySize = 32 << 10
+
+	// maxMetaRefreshDelay is the longest refresh delay, in seconds,
+	// that is followed.
+	maxMetaRefreshDelay = 5
+)
+
+// metaRefreshURL returns the target of the meta refresh in the HTML
+// body of resp, or "" if there is none to follow.
+//
+// metaRefreshURL reads at most maxMetaRefreshBodySize+1 bytes of the
+// body. If it returns "", the bytes read are put back in front of
+// the remaining body.
+func metaRefreshURL(resp *Response) string {
+	if resp.StatusCode != StatusOK || resp.ContentLength > maxMetaRefreshBodySize {
+		return ""
+	}
+	mt, _, _ := strings.Cut(resp.Header.Get("Content-Type"), ";")
+	if !ascii.EqualFold(textproto.TrimString(mt), "text/html") {
+		return ""
+	}
+	buf, err := io.ReadAll(io.LimitReader(resp.Body, maxMetaRefreshBodySize+1))
+	if err == nil && len(buf) <= maxMetaRefreshBodySize {
+		if u := findMetaRefresh(buf); u != "" {
+			return u
+		}
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{
+		io.MultiReader(bytes.NewReader(buf), resp.Body),
+		resp.Body,
+	}
+	return ""
+}
+
+// findMetaRefresh returns the URL of the first
+// <meta http-equiv="refresh"> element in doc whose delay is at most
+// maxMetaRefreshDelay seconds, or "" if there is none.
+func findMetaRefresh(doc []byte) string {
+	for {
+		i := indexFold(doc, "<meta")
+		if i < 0 {
+			return ""
+		}
+		doc = doc[i+len("<meta"):]
+		if len(doc) == 0 || !isHTMLSpace(doc[0]) {
+			continue
+		}
+		var attrs map[string]string
+		attrs, doc = parseHTMLAttrs(doc)
+		if !ascii.EqualFold(attrs["http-equiv"], "refresh") {
+			continue
+		}
+		if delay, u, ok := parseRefresh(attrs["content"]); ok && delay <= maxMetaRefreshDelay && u != "" {
+			return u
+		}
+	}
+}
+
+// parseHTMLAttrs parses the attributes of the tag at the start of b,
+// up to the closing '>'. Attribute names are lowercased. It returns
+// the attributes and the rest of b.
+func parseHTMLAttrs(b []byte) (attrs map[string]string, rest []byte) {
+	attrs = make(map[string]string)
+	for {
+		for len(b) > 0 && (isHTMLSpace(b[0]) || b[0] == '/') {
+			b = b[1:]
+		}
+		if len(b) == 0 || b[0] == '>' {
+			return attrs, b
+		}
+		n := 0
+		for n < len(b) && !isHTMLSpace(b[n]) && b[n] != '=' && b[n] != '>' && b[n] != '/' {
+			n++
+		}
+		name, _ := ascii.ToLower(string(b[:n]))
+		b = b[n:]
+		for len(b) > 0 && isHTMLSpace(b[0]) {
+			b = b[1:]
+		}
+		var val string
+		if len(b) > 0 && b[0] == '=' {
+			b = b[1:]
+			for len(b) > 0 && isHTMLSpace(b[0]) {
+				b = b[1:]
+			}
+			if len(b) > 0 && (b[0] == '"' || b[0] == '\'') {
+				end := bytes.IndexByte(b[1:], b[0])
+				if end < 0 {
+					return attrs, nil
+				}
+				val, b = string(b[1:1+end]), b[2+end:]
+			} else {
+				n := 0
+				for n < len(b) && !isHTMLSpace(b[n]) && b[n] != '>' {
+					n++
+				}
+				val, b = string(b[:n]), b[n:]
+			}
+		}
+		if _, dup := attrs[name]; !dup && name != "" {
+			attrs[name] = val
+		}
+	}
+}
+
+// parseRefresh parses the content attribute of a meta refresh,
+// such as "0; url=/next", into its delay in whole seconds and URL.
+// The URL is "" if the refresh reloads the current page.
+func parseRefresh(content string) (delay int, u string, ok bool) {
+	s := trimHTMLSpace(content)
+	n := 0
+	for n < len(s) && '0' <= s[n] && s[n] <= '9' {
+		if delay < 1<<20 {
+			delay = delay*10 + int(s[n]-'0')
+		}
+		n++
+	}
+	if n == 0 && (len(s) == 0 || s[0] != '.') {
+		return 0, "", false
+	}
+	// Skip any fractional part.
+	for n < len(s) && ('0' <= s[n] && s[n] <= '9' || s[n] == '.') {
+		n++
+	}
+	s = s[n:]
+	if s = trimHTMLSpace(s); s == "" {
+		return delay, "", true
+	}
+	if s[0] != ';' && s[0] != ',' {
+		return 0, "", false
+	}
+	s = trimHTMLSpace(s[1:])
+	if len(s) >= 3 && ascii.EqualFold(s[:3], "url") {
+		if t := trimHTMLSpace(s[3:]); len(t) > 0 && t[0] == '=' {
+			s = trimHTMLSpace(t[1:])
+		}
+	}
+	if len(s) > 0 && (s[0] == '"' || s[0] == '\'') {
+		if end := strings.IndexByte(s[1:], s[0]); end >= 0 {
+			s = s[1 : 1+end]
+		} else {
+			s = s[1:]
+		}
+	}
+	return delay, trimHTMLSpace(s), true
+}
+
+// indexFold is like bytes.Index, but matches the lowercase ASCII
+// string substr case-insensitively.
+func indexFold(b []byte, substr string) int {
+next:
+	for i := 0; i+len(substr) <= len(b); i++ {
+		for j := 0; j < len(substr); j++ {
+			c := b[i+j]
+			if 'A' <= c && c <= 'Z' {
+				c += 'a' - 'A'
+			}
+			if c != substr[j] {
+				continue next
+			}
+		}
+		return i
+	}
+	return -1
+}
+
+func isHTMLSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\f' || c == '\r'
+}
+
+func trimHTMLSpace(s string) string {
+	for len(s) > 0 && isHTMLSpace(s[0]) {
+		s = s[1:]
+	}
+	for len(s) > 0 && isHTMLSpace(s[len(s)-1]) {
+		s = s[:len(s)-1]
+	}
+	return s
+}
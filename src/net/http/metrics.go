@@ -0,0 +1,94 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Response metrics.
+
+package http
+
+// A MetricsResponseWriter is a ResponseWriter that records the status
+// code and size of the response written through it, for access logging
+// and similar middleware. It is returned by NewMetricsResponseWriter.
+type MetricsResponseWriter interface {
+	ResponseWriter
+
+	// Status returns the status code of the response: the code
+	// given to WriteHeader, ignoring informational 1xx codes other
+	// than 101 Switching Protocols, or 200 OK if WriteHeader was
+	// not called.
+	Status() int
+
+	// Written returns the number of bytes of the response body
+	// written so far.
+	Written() int64
+}
+
+// NewMetricsResponseWriter returns a MetricsResponseWriter that writes
+// the response to w. A handler is given the returned writer in place
+// of w, and middleware inspects it once the handler returns.
+//
+// The returned writer implements Flusher, flushing w if it supports
+// flushing, and it implements Hijacker and Pusher if w does. It also
+// has an Unwrap method returning w, for use by ResponseController.
+func NewMetricsResponseWriter(w ResponseWriter) MetricsResponseWriter {
+	mw := &metricsWriter{rw: w}
+	hj, isHijacker := w.(Hijacker)
+	p, isPusher := w.(Pusher)
+	switch {
+	case isHijacker && isPusher:
+		return &struct {
+			*metricsWriter
+			Hijacker
+			Pusher
+		}{mw, hj, p}
+	case isHijacker:
+		return &struct {
+			*metricsWriter
+			Hijacker
+		}{mw, hj}
+	case isPusher:
+		return &struct {
+			*metricsWriter
+			Pusher
+		}{mw, p}
+	}
+	return mw
+}
+
+type metricsWriter struct {
+	rw      ResponseWriter
+	code    int // zero until WriteHeader is called with a final status
+	written int64
+}
+
+func (mw *metricsWriter) Header() Header { return mw.rw.Header() }
+
+func (mw *metricsWriter) WriteHeader(code int) {
+	if mw.code == 0 && (code < 100 || code > 199 || code == StatusSwitchingProtocols) {
+		mw.code = code
+	}
+	mw.rw.WriteHeader(code)
+}
+
+func (mw *metricsWriter) Write(p []byte) (int, error) {
+	n, err := mw.rw.Write(p)
+	mw.written += int64(n)
+	return n, err
+}
+
+func (mw *metricsWriter) Flush() {
+	if f, ok := mw.rw.(Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (mw *metricsWriter) Unwrap() ResponseWriter { return mw.rw }
+
+func (mw *metricsWriter) Status() int {
+	if mw.code == 0 {
+		return StatusOK
+	}
+	return mw.code
+}
+
+func (mw *metricsWriter) Written() int64 { return mw.written }
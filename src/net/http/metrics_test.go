@@ -0,0 +1,88 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestMetricsResponseWriter(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		h           func(w ResponseWriter)
+		wantStatus  int
+		wantWritten int64
+	}{
+		{"empty", func(w ResponseWriter) {}, 200, 0},
+		{"write", func(w ResponseWriter) {
+			io.WriteString(w, "hello, ")
+			w.(Flusher).Flush()
+			io.WriteString(w, "world")
+		}, 200, 12},
+		{"status", func(w ResponseWriter) {
+			w.WriteHeader(StatusNotFound)
+			w.WriteHeader(StatusInternalServerError)
+			io.WriteString(w, "not found")
+		}, 404, 9},
+		{"informational", func(w ResponseWriter) {
+			w.WriteHeader(StatusEarlyHints)
+			w.WriteHeader(StatusCreated)
+		}, 201, 0},
+	} {
+		rec := httptest.NewRecorder()
+		mw := NewMetricsResponseWriter(rec)
+		tt.h(mw)
+		if got := mw.Status(); got != tt.wantStatus {
+			t.Errorf("%s: Status() = %d; want %d", tt.name, got, tt.wantStatus)
+		}
+		if got := mw.Written(); got != tt.wantWritten {
+			t.Errorf("%s: Written() = %d; want %d", tt.name, got, tt.wantWritten)
+		}
+		if got := int64(rec.Body.Len()); got != tt.wantWritten {
+			t.Errorf("%s: recorded %d bytes; want %d", tt.name, got, tt.wantWritten)
+		}
+	}
+
+	// A ResponseRecorder is neither a Hijacker nor a Pusher.
+	mw := NewMetricsResponseWriter(httptest.NewRecorder())
+	if _, ok := mw.(Hijacker); ok {
+		t.Errorf("wrapped ResponseRecorder implements Hijacker")
+	}
+	if _, ok := mw.(Pusher); ok {
+		t.Errorf("wrapped ResponseRecorder implements Pusher")
+	}
+}
+
+func TestMetricsResponseWriterHijack(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mw := NewMetricsResponseWriter(w)
+		if _, err := NewResponseController(mw).NegotiatedProtocol(); err != nil {
+			t.Errorf("NegotiatedProtocol through Unwrap: %v", err)
+		}
+		c, buf, err := mw.(Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer c.Close()
+		buf.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 2\r\nConnection: close\r\n\r\nhi")
+		buf.Flush()
+	}))
+	defer ts.Close()
+	res, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(res.Body)
+	if err != nil || string(b) != "hi" {
+		t.Errorf("hijacked response body = %q, %v; want hi", b, err)
+	}
+}
@@ -7,7 +7,10 @@
 package http
 
 import (
+	"bufio"
+	"crypto/tls"
 	"errors"
+	"net"
 	"sync"
 	"time"
 )
@@ -40,6 +43,12 @@ type http2noDialClientConnPool struct {
 	http2clientConnPool http2clientConnPool
 }
 
+func (http2noDialClientConnPool) MarkDead(*http2ClientConn) { panic(noHTTP2) }
+
+type http2ClientConn struct {
+	tconn net.Conn
+}
+
 type http2clientConnPool struct {
 	mu    *sync.Mutex
 	conns map[string][]struct{}
@@ -62,6 +71,54 @@ func http2NewPriorityWriteScheduler(any) http2WriteScheduler { panic(noHTTP2) }
 
 func http2ConfigureServer(s *Server, conf *http2Server) error { panic(noHTTP2) }
 
+type http2responseWriter struct {
+	rws *http2responseWriterState
+}
+
+func (*http2responseWriter) Header() Header            { panic(noHTTP2) }
+func (*http2responseWriter) Write([]byte) (int, error) { panic(noHTTP2) }
+func (*http2responseWriter) WriteHeader(int)           { panic(noHTTP2) }
+
+type http2responseWriterState struct {
+	conn        *http2serverConn
+	stream      *http2stream
+	bw          *bufio.Writer
+	status      int
+	wroteHeader bool
+}
+
+type http2serverConn struct {
+	tlsState    *tls.ConnectionState
+	pushEnabled bool
+	doneServing chan struct{}
+}
+
+func (*http2serverConn) startGracefulShutdown()   { panic(noHTTP2) }
+func (*http2serverConn) sendServeMsg(interface{}) { panic(noHTTP2) }
+
+func (*http2serverConn) writeHeaders(*http2stream, *http2writeResHeaders) error { panic(noHTTP2) }
+
+type http2writeResHeaders struct {
+	streamID    uint32
+	httpResCode int
+	h           Header
+}
+
+type http2stream struct {
+	sc *http2serverConn
+	id uint32
+	cw http2closeWaiter
+}
+
+type http2closeWaiter chan struct{}
+
+var (
+	http2errClientDisconnected = errors.New("client disconnected")
+	http2errStreamClosed       = errors.New("http2: stream closed")
+)
+
+func (*http2stream) isPushed() bool { panic(noHTTP2) }
+
 var http2ErrNoCachedConn = http2noCachedConnError{}
 
 type http2noCachedConnError struct{}
@@ -0,0 +1,97 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http/internal"
+	"net/textproto"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// removeHopByHopHeaders removes the hop-by-hop headers from h,
+// including those listed in its Connection header.
+func removeHopByHopHeaders(h Header) {
+	for _, f := range h["Connection"] {
+		for _, sf := range strings.Split(f, ",") {
+			if sf = textproto.TrimString(sf); sf != "" {
+				h.Del(sf)
+			}
+		}
+	}
+	for _, k := range internal.HopHeaders {
+		h.Del(k)
+	}
+}
+
+// ProxyRequest forwards the server request inbound to target using
+// client, and returns the response for the handler to copy back to
+// its own client. If client is nil, DefaultClient is used.
+//
+// The outbound request uses ctx, the method of inbound, and a copy of
+// its header from which hop-by-hop headers, such as Connection and
+// those it lists, have been removed. The request body is streamed
+// from inbound.Body as it is sent, without being buffered. The client
+// IP address from inbound.RemoteAddr is appended to the
+// X-Forwarded-For header. The Host header is taken from target.
+//
+// Hop-by-hop headers are also removed from the response. As with
+// Client.Do, the caller must close the response body, and redirects
+// are followed according to client's CheckRedirect policy; proxies
+// usually want a Client whose CheckRedirect returns ErrUseLastResponse
+// so that redirects are passed back to their own clients instead.
+//
+// ProxyRequest does not support protocol upgrades, such as WebSocket
+// connections; requests for them fail with an error.
+func ProxyRequest(ctx context.Context, client *Client, inbound *Request, target *url.URL) (*Response, error) {
+	if client == nil {
+		client = DefaultClient
+	}
+	if httpguts.HeaderValuesContainsToken(inbound.Header["Connection"], "upgrade") {
+		return nil, errors.New("http: ProxyRequest does not support protocol upgrades")
+	}
+
+	outreq := inbound.Clone(ctx)
+	outreq.URL = cloneURL(target)
+	outreq.Host = ""
+	outreq.RequestURI = ""
+	outreq.Close = false
+	if inbound.ContentLength == 0 {
+		outreq.Body = nil // for Transport retries, as in httputil.ReverseProxy
+	}
+	if outreq.Header == nil {
+		outreq.Header = make(Header)
+	}
+	removeHopByHopHeaders(outreq.Header)
+
+	// Tell the backend that trailers are supported if the
+	// inbound client did, as that is what the TE header says.
+	if httpguts.HeaderValuesContainsToken(inbound.Header["Te"], "trailers") {
+		outreq.Header.Set("Te", "trailers")
+	}
+	if clientIP, _, err := net.SplitHostPort(inbound.RemoteAddr); err == nil {
+		if prior := outreq.Header["X-Forwarded-For"]; len(prior) > 0 {
+			clientIP = strings.Join(prior, ", ") + ", " + clientIP
+		}
+		outreq.Header.Set("X-Forwarded-For", clientIP)
+	}
+	if _, ok := outreq.Header["User-Agent"]; !ok {
+		// Don't send the Client's default User-Agent on the
+		// inbound client's behalf.
+		outreq.Header.Set("User-Agent", "")
+	}
+
+	res, err := client.Do(outreq)
+	if err != nil {
+		return nil, err
+	}
+	removeHopByHopHeaders(res.Header)
+	return res, nil
+}
@@ -0,0 +1,96 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestProxyRequest(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	backend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		for _, k := range []string{"X-Hop", "Connection", "Keep-Alive", "User-Agent"} {
+			if v, ok := r.Header[k]; ok {
+				t.Errorf("backend got hop-by-hop or default header %s: %q", k, v)
+			}
+		}
+		if got, want := r.Header.Get("X-Forwarded-For"), "192.0.2.1, 127.0.0.1"; got != want {
+			t.Errorf("X-Forwarded-For = %q; want %q", got, want)
+		}
+		if r.Header.Get("X-End-To-End") != "yes" {
+			t.Errorf("end-to-end header not forwarded")
+		}
+		if r.Host != r.Context().Value(LocalAddrContextKey).(net.Addr).String() {
+			t.Errorf("backend got Host %q", r.Host)
+		}
+		w.Header().Set("Connection", "X-Backend-Hop")
+		w.Header().Set("X-Backend-Hop", "1")
+		w.Header().Set("X-Path", r.URL.RequestURI())
+		io.Copy(w, r.Body)
+	}))
+	defer backend.Close()
+
+	frontend := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		target, _ := url.Parse(backend.URL)
+		target.Path = "/backend" + r.URL.Path
+		target.RawQuery = r.URL.RawQuery
+		res, err := ProxyRequest(r.Context(), backend.Client(), r, target)
+		if err != nil {
+			Error(w, err.Error(), StatusBadGateway)
+			return
+		}
+		defer res.Body.Close()
+		for k, vv := range res.Header {
+			w.Header()[k] = vv
+		}
+		w.WriteHeader(res.StatusCode)
+		io.Copy(w, res.Body)
+	}))
+	defer frontend.Close()
+
+	req, _ := NewRequest("POST", frontend.URL+"/p?q=1", strings.NewReader("request body"))
+	req.Header.Set("Connection", "X-Hop")
+	req.Header.Set("X-Hop", "1")
+	req.Header.Set("X-Forwarded-For", "192.0.2.1")
+	req.Header.Set("X-End-To-End", "yes")
+	req.Header.Set("User-Agent", "")
+	res, err := frontend.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != StatusOK || string(body) != "request body" {
+		t.Fatalf("got %v %q; want 200 OK %q", res.Status, body, "request body")
+	}
+	if got := res.Header.Get("X-Path"); got != "/backend/p?q=1" {
+		t.Errorf("backend got path %q; want %q", got, "/backend/p?q=1")
+	}
+	if _, ok := res.Header["X-Backend-Hop"]; ok {
+		t.Errorf("hop-by-hop response header was not removed")
+	}
+
+	req, _ = NewRequest("GET", frontend.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	res, err = frontend.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusBadGateway {
+		t.Errorf("upgrade request: got %v; want 502", res.Status)
+	}
+}
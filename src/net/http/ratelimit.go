@@ -0,0 +1,180 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Rate limiting of requests by client.
+
+package http
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A Limiter decides whether requests are allowed, by key, for a
+// handler returned by LimitHandler. It must be safe for concurrent use
+// by multiple goroutines.
+//
+// A Limiter may also implement these methods, which LimitHandler then
+// uses:
+//
+//	Key(r *Request) string               // the key of r
+//	RetryAfter(key string) time.Duration // the wait until key is allowed again
+type Limiter interface {
+	// Allow reports whether a request with key is allowed now,
+	// counting it against the limit if so.
+	Allow(key string) bool
+}
+
+// LimitHandler returns a handler that serves the requests limiter
+// allows with h, and replies to the others with 429 Too Many
+// Requests. Requests are keyed by the limiter's Key method, if it has
+// one, or else by the IP address of the client in r.RemoteAddr. If the
+// limiter has a RetryAfter method, denied requests get a Retry-After
+// header giving the whole number of seconds, at least one, until it
+// will allow the key again.
+func LimitHandler(h Handler, limiter Limiter) Handler {
+	keyer, _ := limiter.(interface{ Key(*Request) string })
+	waiter, _ := limiter.(interface{ RetryAfter(string) time.Duration })
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		var key string
+		if keyer != nil {
+			key = keyer.Key(r)
+		} else {
+			key = remoteIP(r)
+		}
+		if limiter.Allow(key) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		if waiter != nil {
+			secs := int64(math.Ceil(waiter.RetryAfter(key).Seconds()))
+			if secs < 1 {
+				secs = 1
+			}
+			w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+		}
+		Error(w, "429 too many requests", StatusTooManyRequests)
+	})
+}
+
+// remoteIP returns the IP address of r.RemoteAddr, without the port,
+// which differs among the connections of a client.
+func remoteIP(r *Request) string {
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// A TokenBucketLimiter is a Limiter keeping a token bucket for each
+// key. A bucket holds up to burst tokens and gains rate tokens a
+// second, and each request allowed takes a token from the bucket of
+// its key, so that a client may make burst requests at once, and rate
+// requests a second on average. The buckets of keys that have not been
+// seen for long enough to refill are reclaimed, so memory use is
+// bounded by the number of recently active keys.
+//
+// A TokenBucketLimiter must be created with NewTokenBucketLimiter, and
+// is safe for concurrent use by multiple goroutines.
+type TokenBucketLimiter struct {
+	// KeyFunc, if not nil, returns the key of a request, such as
+	// its API token. If nil, requests are keyed by the IP address
+	// of the client, from Request.RemoteAddr. It must not be
+	// changed after the limiter is first used.
+	KeyFunc func(r *Request) string
+
+	rate  float64 // tokens per second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time // when tokens was computed
+}
+
+// NewTokenBucketLimiter returns a TokenBucketLimiter allowing rate
+// requests a second for each key, in bursts of up to burst requests.
+// It panics if rate is not positive or burst is less than one.
+func NewTokenBucketLimiter(rate float64, burst int) *TokenBucketLimiter {
+	if !(rate > 0) || burst < 1 {
+		panic("http: NewTokenBucketLimiter called with non-positive rate or burst")
+	}
+	return &TokenBucketLimiter{
+		rate:    rate,
+		burst:   float64(burst),
+		buckets: make(map[string]*tokenBucket),
+	}
+}
+
+// Key returns the key of r, for LimitHandler.
+func (l *TokenBucketLimiter) Key(r *Request) string {
+	if l.KeyFunc != nil {
+		return l.KeyFunc(r)
+	}
+	return remoteIP(r)
+}
+
+// refill returns the bucket of key, updated to now. l.mu must be held.
+func (l *TokenBucketLimiter) refill(key string, now time.Time) *tokenBucket {
+	b := l.buckets[key]
+	if b == nil {
+		return &tokenBucket{tokens: l.burst, last: now}
+	}
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(l.burst, b.tokens+elapsed*l.rate)
+		b.last = now
+	}
+	return b
+}
+
+// Allow implements Limiter.
+func (l *TokenBucketLimiter) Allow(key string) bool {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.sweep(now)
+	b := l.refill(key, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	l.buckets[key] = b
+	return true
+}
+
+// RetryAfter returns how long a request with key must wait before
+// Allow will allow it, or zero if it would be allowed now.
+func (l *TokenBucketLimiter) RetryAfter(key string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.refill(key, now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
+
+// sweep drops the buckets that have refilled, which are the same as
+// no bucket, once each time a bucket takes to refill from empty.
+// l.mu must be held.
+func (l *TokenBucketLimiter) sweep(now time.Time) {
+	fill := time.Duration(l.burst / l.rate * float64(time.Second))
+	if now.Sub(l.lastSweep) < fill {
+		return
+	}
+	l.lastSweep = now
+	for key := range l.buckets {
+		if l.refill(key, now).tokens >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+}
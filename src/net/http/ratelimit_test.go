@@ -0,0 +1,109 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestLimitHandler(t *testing.T) {
+	l := NewTokenBucketLimiter(0.5, 2) // a token every 2s
+	h := LimitHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}), l)
+	get := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	// Different ports of a client share its bucket.
+	for i, addr := range []string{"192.0.2.1:1000", "192.0.2.1:1001"} {
+		if rec := get(addr); rec.Code != StatusOK {
+			t.Fatalf("request %d within burst: status %d; want 200", i, rec.Code)
+		}
+	}
+	rec := get("192.0.2.1:1002")
+	if rec.Code != StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Errorf("request over burst: status %d, Retry-After %q; want 429, 2", rec.Code, rec.Header().Get("Retry-After"))
+	}
+	if rec := get("192.0.2.2:1000"); rec.Code != StatusOK {
+		t.Errorf("other client: status %d; want 200", rec.Code)
+	}
+}
+
+func TestTokenBucketLimiterKeyFunc(t *testing.T) {
+	l := NewTokenBucketLimiter(1, 1)
+	l.KeyFunc = func(r *Request) string { return r.Header.Get("Authorization") }
+	h := LimitHandler(HandlerFunc(func(w ResponseWriter, r *Request) {}), l)
+	for _, tt := range []struct {
+		token string
+		want  int
+	}{
+		{"a", StatusOK},
+		{"b", StatusOK},
+		{"a", StatusTooManyRequests},
+	} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Authorization", tt.token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.want {
+			t.Errorf("token %q: status %d; want %d", tt.token, rec.Code, tt.want)
+		}
+	}
+}
+
+func TestTokenBucketLimiterRefill(t *testing.T) {
+	l := NewTokenBucketLimiter(100, 1) // refills in 10ms
+	if !l.Allow("a") || l.Allow("a") {
+		t.Fatal("burst of 1 not enforced")
+	}
+	if d := l.RetryAfter("a"); d <= 0 || d > 10*time.Millisecond {
+		t.Errorf("RetryAfter = %v; want up to 10ms", d)
+	}
+	l.Allow("b")
+	l.Allow("c")
+	time.Sleep(20 * time.Millisecond)
+	if !l.Allow("a") {
+		t.Error("bucket did not refill")
+	}
+	// The refilled buckets of b and c were reclaimed.
+	if n := l.ExportLen(); n != 1 {
+		t.Errorf("%d buckets kept; want 1", n)
+	}
+}
+
+func TestTokenBucketLimiterConcurrent(t *testing.T) {
+	l := NewTokenBucketLimiter(1e-3, 50)
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		allowed int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				if l.Allow("k") {
+					mu.Lock()
+					allowed++
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if allowed != 50 {
+		t.Errorf("%d requests allowed; want 50", allowed)
+	}
+}
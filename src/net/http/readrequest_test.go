@@ -7,6 +7,7 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/url"
@@ -472,3 +473,30 @@ func TestReadRequest_Bad(t *testing.T) {
 		}
 	}
 }
+
+func TestReadRequestBytes(t *testing.T) {
+	const msg = "POST / HTTP/1.1\r\n" +
+		"Host: foo.com\r\n" +
+		"Content-Length: 5\r\n" +
+		"\r\n" +
+		"hello"
+	req, err := ReadRequestBytes([]byte(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := io.ReadAll(req.Body); string(b) != "hello" {
+		t.Errorf("body = %q; want hello", b)
+	}
+
+	// A pipelined second request is trailing data.
+	const get = "GET / HTTP/1.1\r\nHost: foo.com\r\n\r\n"
+	_, err = ReadRequestBytes([]byte(get + get))
+	var te *TrailingDataError
+	if !errors.As(err, &te) || te.Offset != len(get) || te.Len != len(get) {
+		t.Errorf("ReadRequestBytes with trailing data: err = %v; want TrailingDataError at %d", err, len(get))
+	}
+	req, err = ReadRequestBytes([]byte(get))
+	if err != nil || req.Body != NoBody {
+		t.Errorf("ReadRequestBytes without body = %v, %v; want NoBody", req.Body, err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Recovering from handler panics.
+
+package http
+
+import (
+	"bufio"
+	"net"
+	"runtime"
+)
+
+// RecoverHandler returns a handler that runs h and recovers from its
+// panics, so that the client gets an error response rather than a
+// dropped connection, which stays open for further requests. The
+// panic is logged, with its stack trace, to the ErrorLog of the Server
+// serving the request, as the Server logs the panics it recovers.
+//
+// If h has not written the response header when it panics, the header
+// fields it set, such as Content-Length or Set-Cookie, are deleted, and
+// onPanic is called with the value passed to panic to write the
+// response; if onPanic is nil, the client gets a 500 Internal Server
+// Error. If the header has been written, or the connection hijacked,
+// the response can't be replaced, so
+// RecoverHandler aborts it by panicking with ErrAbortHandler, which
+// closes the connection without logging again. A panic with
+// ErrAbortHandler itself is not recovered, so that it keeps its
+// meaning.
+//
+// The ResponseWriter passed to h implements Flusher and Hijacker, whose
+// Hijack method fails with ErrNotSupported if the original isn't a
+// Hijacker. It also has an Unwrap method returning the original, so
+// that ResponseController can be used to reach other methods.
+func RecoverHandler(h Handler, onPanic func(w ResponseWriter, r *Request, v any)) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		rw := &recoverWriter{rw: w}
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == ErrAbortHandler {
+				panic(v)
+			}
+			const size = 64 << 10
+			buf := make([]byte, size)
+			buf = buf[:runtime.Stack(buf, false)]
+			logf(r, "http: panic serving %v: %v\n%s", r.RemoteAddr, v, buf)
+			if rw.wroteHeader || hijackedWriter(w) {
+				panic(ErrAbortHandler)
+			}
+			h := w.Header()
+			for k := range h {
+				delete(h, k)
+			}
+			if onPanic != nil {
+				onPanic(w, r, v)
+			} else {
+				Error(w, StatusText(StatusInternalServerError), StatusInternalServerError)
+			}
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// recoverWriter is the ResponseWriter passed to handlers by
+// RecoverHandler, recording whether the header has been written or
+// the connection hijacked.
+type recoverWriter struct {
+	rw          ResponseWriter
+	wroteHeader bool
+}
+
+func (w *recoverWriter) Header() Header { return w.rw.Header() }
+
+func (w *recoverWriter) WriteHeader(code int) {
+	if code >= 200 || code == StatusSwitchingProtocols {
+		w.wroteHeader = true
+	}
+	w.rw.WriteHeader(code)
+}
+
+func (w *recoverWriter) Write(p []byte) (int, error) {
+	w.wroteHeader = true
+	return w.rw.Write(p)
+}
+
+func (w *recoverWriter) Flush() {
+	w.wroteHeader = true
+	if f, ok := w.rw.(Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *recoverWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := w.rw.(Hijacker)
+	if !ok {
+		return nil, nil, ErrNotSupported
+	}
+	c, brw, err := hj.Hijack()
+	if err == nil {
+		w.wroteHeader = true
+	}
+	return c, brw, err
+}
+
+func (w *recoverWriter) Unwrap() ResponseWriter { return w.rw }
+
+// hijackedWriter reports whether rw, or the writer it wraps, is a
+// Server's response whose connection has been hijacked, as it is when
+// a handler reaches Hijack through Unwrap rather than a recoverWriter.
+func hijackedWriter(rw ResponseWriter) bool {
+	for {
+		switch t := rw.(type) {
+		case *response:
+			return t.conn.hijacked()
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return false
+		}
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	. "net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRecoverHandler(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	logc := make(chanWriter, 10)
+	mux := NewServeMux()
+	mux.HandleFunc("/before", func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Partial", "1")
+		w.Header().Set("Content-Length", "1000")
+		w.Header().Set("Set-Cookie", "session=1")
+		panic("boom")
+	})
+	mux.HandleFunc("/after", func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "partial")
+		w.(Flusher).Flush()
+		panic("late boom")
+	})
+	hijack := func(hj Hijacker) {
+		c, _, err := hj.Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		io.WriteString(c, "HTTP/1.1 204 No Content\r\nConnection: close\r\n\r\n")
+		c.Close()
+		panic("hijack boom")
+	}
+	mux.HandleFunc("/hijack", func(w ResponseWriter, r *Request) {
+		hijack(w.(Hijacker))
+	})
+	mux.HandleFunc("/hijack-unwrap", func(w ResponseWriter, r *Request) {
+		hijack(w.(interface{ Unwrap() ResponseWriter }).Unwrap().(Hijacker))
+	})
+	mux.HandleFunc("/abort", func(w ResponseWriter, r *Request) {
+		panic(ErrAbortHandler)
+	})
+	mux.HandleFunc("/ok", func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	})
+	ts := httptest.NewUnstartedServer(RecoverHandler(mux, nil))
+	ts.Config.ErrorLog = log.New(logc, "", 0)
+	ts.Start()
+	defer ts.Close()
+
+	// The connection survives a panic before the response is written.
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	for _, path := range []string{"/before", "/ok"} {
+		fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: foo\r\n\r\n", path)
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		switch path {
+		case "/before":
+			if res.StatusCode != StatusInternalServerError {
+				t.Errorf("GET /before: status %d; want 500", res.StatusCode)
+			}
+			for _, k := range []string{"X-Partial", "Set-Cookie"} {
+				if v := res.Header.Get(k); v != "" {
+					t.Errorf("GET /before: %s = %q; want the handler's header deleted", k, v)
+				}
+			}
+			if want := "Internal Server Error\n"; string(body) != want {
+				t.Errorf("GET /before: body %q; want %q", body, want)
+			}
+		case "/ok":
+			if string(body) != "ok" {
+				t.Errorf("GET /ok on the same connection: body %q; want ok", body)
+			}
+		}
+	}
+	if msg := <-logc; !strings.Contains(msg, "http: panic serving") || !strings.Contains(msg, "boom") {
+		t.Errorf("logged %q; want panic message", msg)
+	}
+
+	// A panic after the header is written aborts the response,
+	// and ErrAbortHandler is passed on unlogged.
+	for _, path := range []string{"/after", "/abort"} {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err == nil {
+			_, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		if err == nil {
+			t.Errorf("GET %s: got complete response; want error", path)
+		}
+	}
+	if msg := <-logc; !strings.Contains(msg, "late boom") {
+		t.Errorf("logged %q; want late panic message", msg)
+	}
+
+	// A panic after the connection is hijacked, through the wrapper
+	// or the original, writes nothing more.
+	for _, path := range []string{"/hijack", "/hijack-unwrap"} {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		res.Body.Close()
+		if res.StatusCode != StatusNoContent {
+			t.Errorf("GET %s: status %d; want the hijacker's 204", path, res.StatusCode)
+		}
+		if msg := <-logc; !strings.Contains(msg, "hijack boom") {
+			t.Errorf("GET %s: logged %q; want panic message", path, msg)
+		}
+	}
+	select {
+	case msg := <-logc:
+		t.Errorf("unexpected log after aborted responses: %q", msg)
+	default:
+	}
+}
+
+func TestRecoverHandlerOnPanic(t *testing.T) {
+	h := RecoverHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		panic("no db")
+	}), func(w ResponseWriter, r *Request, v any) {
+		w.WriteHeader(StatusServiceUnavailable)
+		fmt.Fprintf(w, "unavailable: %v", v)
+	})
+	rec := httptest.NewRecorder()
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != StatusServiceUnavailable || rec.Body.String() != "unavailable: no db" {
+		t.Errorf("got %d %q; want 503 \"unavailable: no db\"", rec.Code, rec.Body.String())
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"bytes"
 	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"errors"
 	"fmt"
@@ -26,6 +27,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/net/idna"
 )
@@ -297,6 +299,8 @@ type Request struct {
 	// The HTTP server in this package sets the field for
 	// TLS-enabled connections before invoking a handler;
 	// otherwise it leaves the field nil.
+	// Its DidResume field reports whether the client resumed
+	// a previous TLS session rather than performing a full handshake.
 	// This field is ignored by the HTTP client.
 	TLS *tls.ConnectionState
 
@@ -321,6 +325,20 @@ type Request struct {
 	// It is unexported to prevent people from using Context wrong
 	// and mutating the contexts held by callers of the same request.
 	ctx context.Context
+
+	// pathValues holds the values of the path wildcards matched by
+	// ServeMux, and those set by SetPathValue, keyed by name.
+	pathValues map[string]string
+
+	// mountPrefix is the part of the path removed by the handlers
+	// registered with ServeMux.Mount that the request went through,
+	// so that a mounted ServeMux redirects to paths below it.
+	mountPrefix string
+
+	// serverPush, if non-nil, reports whether the client of an
+	// incoming HTTP/2 request accepts pushes. It is a func so that
+	// clients don't link in the HTTP/2 server.
+	serverPush func() bool
 }
 
 // Context returns the request's context. To change the context, use
@@ -391,9 +409,75 @@ func (r *Request) Clone(ctx context.Context) *Request {
 	r2.Form = cloneURLValues(r.Form)
 	r2.PostForm = cloneURLValues(r.PostForm)
 	r2.MultipartForm = cloneMultipartForm(r.MultipartForm)
+	if r.pathValues != nil {
+		r2.pathValues = make(map[string]string, len(r.pathValues))
+		for k, v := range r.pathValues {
+			r2.pathValues[k] = v
+		}
+	}
 	return r2
 }
 
+// ErrBodyTooLarge is returned by Request.WithBufferedBody,
+// NewJSONDecoder, and DecodeJSONArray when the request body is
+// longer than the given limit.
+var ErrBodyTooLarge = errors.New("http: request body too large")
+
+// WithBufferedBody reads r's body into memory and returns a shallow
+// copy of r whose Body can be read more than once: after it returns
+// io.EOF or is closed, the next Read starts again at the beginning.
+// This lets middleware read the body, such as to validate or log it,
+// and still pass the request on to a handler that reads it again.
+// The copy's GetBody returns new readers of the same contents, and
+// its ContentLength is the length of the body.
+//
+// If the body is longer than maxSize bytes, WithBufferedBody returns
+// ErrBodyTooLarge without buffering more than maxSize+1 bytes. Part
+// of r's body has then been read. Other errors are those from reading
+// the body.
+func (r *Request) WithBufferedBody(maxSize int64) (*Request, error) {
+	r2 := new(Request)
+	*r2 = *r
+	if r.Body == nil || r.Body == NoBody {
+		r2.GetBody = func() (io.ReadCloser, error) { return NoBody, nil }
+		return r2, nil
+	}
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxSize {
+		return nil, ErrBodyTooLarge
+	}
+	r2.Body = &replayBody{data: data}
+	r2.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+	r2.ContentLength = int64(len(data))
+	return r2, nil
+}
+
+// replayBody is the Body of a request returned by WithBufferedBody.
+type replayBody struct {
+	data []byte
+	off  int
+}
+
+func (b *replayBody) Read(p []byte) (int, error) {
+	if b.off == len(b.data) {
+		b.off = 0
+		return 0, io.EOF
+	}
+	n := copy(p, b.data[b.off:])
+	b.off += n
+	return n, nil
+}
+
+func (b *replayBody) Close() error {
+	b.off = 0
+	return nil
+}
+
 // ProtoAtLeast reports whether the HTTP protocol used
 // in the request is at least major.minor.
 func (r *Request) ProtoAtLeast(major, minor int) bool {
@@ -401,6 +485,27 @@ func (r *Request) ProtoAtLeast(major, minor int) bool {
 		r.ProtoMajor == major && r.ProtoMinor >= minor
 }
 
+// TLSInfo reports, for a request received over TLS, the application
+// protocol negotiated with ALPN for its connection, such as "h2" or
+// "http/1.1", and whether the connection resumed an earlier TLS
+// session. It returns ok false, with the other results zero, if r.TLS
+// is nil, as it is for requests not received over TLS. Other details
+// of the connection, such as its cipher suite, are in r.TLS.
+//
+// The protocol is empty when none was negotiated: when the client or
+// the server did not use ALPN, or when a client offering only
+// "http/1.1" connected to a server offering only "h2", which the TLS
+// server accepts without negotiating a protocol for compatibility.
+// HTTP/1 connections that negotiated "http/1.1" report it, as do
+// those of servers started with ServeTLS and ListenAndServeTLS, which
+// always offer it.
+func (r *Request) TLSInfo() (proto string, resumed bool, ok bool) {
+	if r.TLS == nil {
+		return "", false, false
+	}
+	return r.TLS.NegotiatedProtocol, r.TLS.DidResume, true
+}
+
 // UserAgent returns the client's User-Agent, if sent in the request.
 func (r *Request) UserAgent() string {
 	return r.Header.Get("User-Agent")
@@ -452,6 +557,76 @@ func (r *Request) Referer() string {
 	return r.Header.Get("Referer")
 }
 
+// RangeUnit returns the range unit and range set of the request's
+// Range header, as described in RFC 7233, section 3.1. For example,
+// a Range header of "items=0-9" has unit "items" and spec "0-9".
+// Range units are case-insensitive, so unit is returned in lowercase.
+// If the header is absent or malformed, ok is false.
+//
+// ServeContent and FileServer only support the "bytes" unit and
+// ignore Range headers with other units, serving the full content.
+// Handlers may use RangeUnit to implement units of their own.
+func (r *Request) RangeUnit() (unit, spec string, ok bool) {
+	unit, spec, ok = strings.Cut(r.Header.Get("Range"), "=")
+	if !ok || unit == "" || strings.IndexFunc(unit, isNotToken) != -1 {
+		return "", "", false
+	}
+	unit, _ = ascii.ToLower(unit)
+	return unit, textproto.TrimString(spec), true
+}
+
+// ContentTypeParams parses the request's Content-Type header with
+// mime.ParseMediaType, returning the media type in lowercase and its
+// parameters, such as "charset".
+func (r *Request) ContentTypeParams() (mediaType string, params map[string]string, err error) {
+	return mime.ParseMediaType(r.Header.Get("Content-Type"))
+}
+
+// HasContentType reports whether the request's Content-Type header
+// has the given media type, such as "application/json", ignoring
+// case and any parameters. It reports false if the header is missing
+// or malformed.
+func (r *Request) HasContentType(mediaType string) bool {
+	mt, _, err := r.ContentTypeParams()
+	return err == nil && ascii.EqualFold(mt, mediaType)
+}
+
+// IsHTTP2 reports whether the request uses HTTP/2 or a later major
+// version of the protocol. Once HTTP/3 is supported, IsHTTP2 will
+// report true for HTTP/3 requests too, so that handlers checking for
+// features like trailers sent after a streamed body keep working.
+func (r *Request) IsHTTP2() bool {
+	return r.ProtoMajor >= 2
+}
+
+// SupportsServerPush reports whether the server can push responses
+// to the client along with the response to r: that is, whether r
+// is an incoming HTTP/2 request initiated by the client on a
+// connection whose client hasn't disabled push with its
+// SETTINGS_ENABLE_PUSH setting. When it reports true, the
+// ResponseWriter for r implements Pusher, but the client may still
+// disable push before a call to Push.
+func (r *Request) SupportsServerPush() bool {
+	return r.serverPush != nil && r.serverPush()
+}
+
+// PathValue returns the value of the named path wildcard in the
+// ServeMux pattern that matched the request, or the value set for
+// name by SetPathValue. It returns the empty string if there is no
+// such wildcard or value.
+func (r *Request) PathValue(name string) string {
+	return r.pathValues[name]
+}
+
+// SetPathValue sets the value returned by PathValue for name,
+// such as for a handler dispatched to without a ServeMux.
+func (r *Request) SetPathValue(name, value string) {
+	if r.pathValues == nil {
+		r.pathValues = make(map[string]string)
+	}
+	r.pathValues[name] = value
+}
+
 // multipartByReader is a sentinel value.
 // Its presence in Request.MultipartForm indicates that parsing of the request
 // body has been handed off to a MultipartReader instead of ParseMultipartForm.
@@ -464,6 +639,9 @@ var multipartByReader = &multipart.Form{
 // multipart/form-data or a multipart/mixed POST request, else returns nil and an error.
 // Use this function instead of ParseMultipartForm to
 // process the request body as a stream.
+// For requests received by a Server, the reader's MaxParts and
+// MaxPartHeaderBytes are set from the Server's MaxMultipartParts and
+// MaxPartHeaderBytes.
 func (r *Request) MultipartReader() (*multipart.Reader, error) {
 	if r.MultipartForm == multipartByReader {
 		return nil, errors.New("http: MultipartReader called twice")
@@ -488,7 +666,12 @@ func (r *Request) multipartReader(allowMixed bool) (*multipart.Reader, error) {
 	if !ok {
 		return nil, ErrMissingBoundary
 	}
-	return multipart.NewReader(r.Body, boundary), nil
+	mr := multipart.NewReader(r.Body, boundary)
+	if srv, ok := r.Context().Value(ServerContextKey).(*Server); ok {
+		mr.MaxParts = srv.MaxMultipartParts
+		mr.MaxPartHeaderBytes = srv.MaxPartHeaderBytes
+	}
+	return mr, nil
 }
 
 // isH2Upgrade reports whether r represents the http2 "client preface"
@@ -934,6 +1117,24 @@ func NewRequestWithContext(ctx context.Context, method, url string, body io.Read
 	return req, nil
 }
 
+// NewRequestTimeout is like NewRequestWithContext but uses a context
+// that is canceled once the timeout elapses. The timeout covers the
+// entire lifetime of the request, including reading the response body.
+//
+// The caller must call the returned cancel function once it is done
+// with the request and its response, to release the resources
+// associated with the context. If NewRequestTimeout returns an error,
+// the returned cancel function is nil.
+func NewRequestTimeout(method, url string, body io.Reader, timeout time.Duration) (*Request, context.CancelFunc, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	req, err := NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return req, cancel, nil
+}
+
 // BasicAuth returns the username and password provided in the request's
 // Authorization header, if the request uses HTTP Basic Authentication.
 // See RFC 2617, Section 2.
@@ -978,6 +1179,36 @@ func (r *Request) SetBasicAuth(username, password string) {
 	r.Header.Set("Authorization", "Basic "+basicAuth(username, password))
 }
 
+// ClientCertificate returns the leaf certificate the client presented
+// during the TLS handshake, if any. The full chain remains available
+// in r.TLS.PeerCertificates.
+//
+// For server requests, the certificate has only been verified if the
+// Server's TLSConfig.ClientAuth requires it.
+func (r *Request) ClientCertificate() (*x509.Certificate, bool) {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return nil, false
+	}
+	return r.TLS.PeerCertificates[0], true
+}
+
+// ClientSPIFFEID returns the SPIFFE ID, such as
+// "spiffe://example.org/service", in the client certificate returned
+// by ClientCertificate. It reports false unless the certificate has
+// exactly one URI subject alternative name and that name is a SPIFFE
+// ID, as required of an X.509 SPIFFE Verifiable Identity Document.
+func (r *Request) ClientSPIFFEID() (string, bool) {
+	cert, ok := r.ClientCertificate()
+	if !ok || len(cert.URIs) != 1 {
+		return "", false
+	}
+	u := cert.URIs[0]
+	if u.Scheme != "spiffe" || u.Host == "" || u.User != nil || u.RawQuery != "" || u.Fragment != "" {
+		return "", false
+	}
+	return u.String(), true
+}
+
 // parseRequestLine parses "GET /foo HTTP/1.1" into its three parts.
 func parseRequestLine(line string) (method, requestURI, proto string, ok bool) {
 	method, rest, ok1 := strings.Cut(line, " ")
@@ -1011,7 +1242,7 @@ func putTextprotoReader(r *textproto.Reader) {
 // requests and handle them via the Handler interface. ReadRequest
 // only supports HTTP/1.x requests. For HTTP/2, use golang.org/x/net/http2.
 func ReadRequest(b *bufio.Reader) (*Request, error) {
-	req, err := readRequest(b)
+	req, err := readRequest(b, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -1020,7 +1251,28 @@ func ReadRequest(b *bufio.Reader) (*Request, error) {
 	return req, err
 }
 
-func readRequest(b *bufio.Reader) (req *Request, err error) {
+// ReadRequestBytes parses data as a complete HTTP/1.x request, as
+// ReadRequest does, and reads its body into memory. If data continues
+// past the end of the request, ReadRequestBytes returns the request
+// along with a *TrailingDataError.
+// ReadRequestBytes is mainly intended for tests of request parsing.
+func ReadRequestBytes(data []byte) (*Request, error) {
+	r := bytes.NewReader(data)
+	br := bufio.NewReader(r)
+	req, err := ReadRequest(br)
+	if err != nil {
+		return nil, err
+	}
+	if req.Body, err = readBodyBytes(req.Body); err != nil {
+		return nil, err
+	}
+	if n := br.Buffered() + r.Len(); n > 0 {
+		return req, &TrailingDataError{Offset: len(data) - n, Len: n}
+	}
+	return req, nil
+}
+
+func readRequest(b *bufio.Reader, allowCodings []string) (req *Request, err error) {
 	tp := newTextprotoReader(b)
 	req = new(Request)
 
@@ -1098,7 +1350,7 @@ func readRequest(b *bufio.Reader) (req *Request, err error) {
 
 	req.Close = shouldClose(req.ProtoMajor, req.ProtoMinor, req.Header, false)
 
-	err = readTransfer(req, b)
+	err = readTransfer(req, b, allowCodings)
 	if err != nil {
 		return nil, err
 	}
@@ -1296,6 +1548,10 @@ func (r *Request) ParseForm() error {
 // If ParseForm returns an error, ParseMultipartForm returns it but also
 // continues parsing the request body.
 // After one call to ParseMultipartForm, subsequent calls have no effect.
+//
+// For requests received by a Server, the number of temporary files is
+// limited by the Server's MaxMultipartTempFiles, and the parts by its
+// MaxMultipartParts and MaxPartHeaderBytes.
 func (r *Request) ParseMultipartForm(maxMemory int64) error {
 	if r.MultipartForm == multipartByReader {
 		return errors.New("http: multipart handled by MultipartReader")
@@ -1314,6 +1570,9 @@ func (r *Request) ParseMultipartForm(maxMemory int64) error {
 	if err != nil {
 		return err
 	}
+	if srv, ok := r.Context().Value(ServerContextKey).(*Server); ok {
+		mr.MaxTempFiles = srv.MaxMultipartTempFiles
+	}
 
 	f, err := mr.ReadForm(maxMemory)
 	if err != nil {
@@ -1391,6 +1650,19 @@ func (r *Request) expectsContinue() bool {
 	return hasToken(r.Header.get("Expect"), "100-continue")
 }
 
+// hasOtherExpectation reports whether the Expect header of r holds an
+// expectation other than 100-continue.
+func (r *Request) hasOtherExpectation() bool {
+	for _, v := range r.Header["Expect"] {
+		for _, e := range strings.Split(v, ",") {
+			if e = textproto.TrimString(e); e != "" && !ascii.EqualFold(e, "100-continue") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
 func (r *Request) wantsHttp10KeepAlive() bool {
 	if r.ProtoMajor != 1 || r.ProtoMinor != 0 {
 		return false
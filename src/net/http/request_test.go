@@ -9,6 +9,8 @@ import (
 	"bytes"
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -16,12 +18,14 @@ import (
 	"mime/multipart"
 	. "net/http"
 	"net/http/httptest"
+	"net/textproto"
 	"net/url"
 	"os"
 	"reflect"
 	"regexp"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestQuery(t *testing.T) {
@@ -285,6 +289,105 @@ Content-Type: text/plain
 	}
 }
 
+func TestParseMultipartFormMaxTempFiles(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if err := r.ParseMultipartForm(0); err != nil {
+			Error(w, err.Error(), StatusBadRequest)
+			return
+		}
+		r.MultipartForm.RemoveAll()
+	}))
+	ts.Config.MaxMultipartTempFiles = 2
+	ts.Start()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		files    int
+		wantCode int
+	}{
+		{2, StatusOK},
+		{3, StatusBadRequest},
+	} {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for i := 0; i < tt.files; i++ {
+			fw, err := mw.CreateFormFile(fmt.Sprint("file", i), "f.txt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(fw, "file contents")
+		}
+		mw.Close()
+		res, err := ts.Client().Post(ts.URL, mw.FormDataContentType(), &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.wantCode {
+			t.Errorf("%d files: status = %d (%q); want %d", tt.files, res.StatusCode, body, tt.wantCode)
+		}
+		if tt.wantCode == StatusBadRequest && string(body) != multipart.ErrTooManyTempFiles.Error()+"\n" {
+			t.Errorf("%d files: body = %q; want %q", tt.files, body, multipart.ErrTooManyTempFiles)
+		}
+	}
+}
+
+func TestParseMultipartFormMaxParts(t *testing.T) {
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			Error(w, err.Error(), StatusBadRequest)
+			return
+		}
+		r.MultipartForm.RemoveAll()
+	}))
+	ts.Config.MaxMultipartParts = 2
+	ts.Config.MaxPartHeaderBytes = 200
+	ts.Start()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		parts   int
+		pad     int
+		wantErr error
+	}{
+		{2, 0, nil},
+		{3, 0, multipart.ErrTooManyParts},
+		{1, 200, multipart.ErrPartHeaderTooLarge},
+	} {
+		var buf bytes.Buffer
+		mw := multipart.NewWriter(&buf)
+		for i := 0; i < tt.parts; i++ {
+			h := textproto.MIMEHeader{}
+			h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="field%d"`, i))
+			if tt.pad > 0 {
+				h.Set("X-Pad", strings.Repeat("x", tt.pad))
+			}
+			pw, err := mw.CreatePart(h)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.WriteString(pw, "value")
+		}
+		mw.Close()
+		res, err := ts.Client().Post(ts.URL, mw.FormDataContentType(), &buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		wantCode, wantBody := StatusOK, ""
+		if tt.wantErr != nil {
+			wantCode, wantBody = StatusBadRequest, tt.wantErr.Error()+"\n"
+		}
+		if res.StatusCode != wantCode || string(body) != wantBody {
+			t.Errorf("%d parts, %d bytes of padding: got %d %q; want %d %q", tt.parts, tt.pad, res.StatusCode, body, wantCode, wantBody)
+		}
+	}
+}
+
 // Issue #40430: Test that if maxMemory for ParseMultipartForm when combined with
 // the payload size and the internal leeway buffer size of 10MiB overflows, that we
 // correctly return an error.
@@ -577,6 +680,101 @@ func TestNewRequestHost(t *testing.T) {
 	}
 }
 
+func TestRequestRangeUnit(t *testing.T) {
+	tests := []struct {
+		header     string
+		unit, spec string
+		ok         bool
+	}{
+		{"bytes=0-499", "bytes", "0-499", true},
+		{"Items=0-9, 20-", "items", "0-9, 20-", true},
+		{"rows= 5-", "rows", "5-", true},
+		{"", "", "", false},
+		{"0-499", "", "", false},
+		{"=0-4", "", "", false},
+		{"my unit=0-4", "", "", false},
+	}
+	for _, tt := range tests {
+		req := &Request{Header: Header{}}
+		if tt.header != "" {
+			req.Header.Set("Range", tt.header)
+		}
+		unit, spec, ok := req.RangeUnit()
+		if unit != tt.unit || spec != tt.spec || ok != tt.ok {
+			t.Errorf("Range %q: RangeUnit() = %q, %q, %v; want %q, %q, %v", tt.header, unit, spec, ok, tt.unit, tt.spec, tt.ok)
+		}
+	}
+}
+
+func TestRequestHasContentType(t *testing.T) {
+	tests := []struct {
+		header string
+		media  string
+		want   bool
+	}{
+		{"application/json", "application/json", true},
+		{"application/json; charset=utf-8", "application/json", true},
+		{"Application/JSON ; Charset=UTF-8", "application/json", true},
+		{"application/json", "Application/Json", true},
+		{"application/json-patch+json", "application/json", false},
+		{"text/plain", "application/json", false},
+		{"", "application/json", false},
+		{"application/json; =", "application/json", false},
+	}
+	for _, tt := range tests {
+		req := &Request{Header: Header{}}
+		if tt.header != "" {
+			req.Header.Set("Content-Type", tt.header)
+		}
+		if got := req.HasContentType(tt.media); got != tt.want {
+			t.Errorf("Content-Type %q: HasContentType(%q) = %v; want %v", tt.header, tt.media, got, tt.want)
+		}
+	}
+
+	req := &Request{Header: Header{"Content-Type": {"Text/HTML; Charset=\"utf-8\""}}}
+	mt, params, err := req.ContentTypeParams()
+	if err != nil || mt != "text/html" || !reflect.DeepEqual(params, map[string]string{"charset": "utf-8"}) {
+		t.Errorf("ContentTypeParams() = %q, %v, %v; want \"text/html\", map[charset:utf-8], nil", mt, params, err)
+	}
+}
+
+func TestRequestWithBufferedBody(t *testing.T) {
+	const body = "hello, world"
+	req := httptest.NewRequest("POST", "/", io.NopCloser(strings.NewReader(body)))
+	req.ContentLength = -1
+	req2, err := req.WithBufferedBody(int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if req2.ContentLength != int64(len(body)) {
+		t.Errorf("ContentLength = %d; want %d", req2.ContentLength, len(body))
+	}
+	for i := 0; i < 2; i++ {
+		got, err := io.ReadAll(req2.Body)
+		if err != nil || string(got) != body {
+			t.Errorf("read %d of Body = %q, %v; want %q", i+1, got, err, body)
+		}
+	}
+	var buf [5]byte
+	io.ReadFull(req2.Body, buf[:])
+	req2.Body.Close()
+	if got, _ := io.ReadAll(req2.Body); string(got) != body {
+		t.Errorf("Body after partial read and Close = %q; want %q", got, body)
+	}
+	rc, err := req2.GetBody()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := io.ReadAll(rc); string(got) != body {
+		t.Errorf("GetBody returned body %q; want %q", got, body)
+	}
+
+	req = httptest.NewRequest("POST", "/", strings.NewReader(body))
+	if _, err := req.WithBufferedBody(int64(len(body) - 1)); err != ErrBodyTooLarge {
+		t.Errorf("WithBufferedBody with short limit: err = %v; want ErrBodyTooLarge", err)
+	}
+}
+
 func TestRequestInvalidMethod(t *testing.T) {
 	_, err := NewRequest("bad method", "http://foo.com/", nil)
 	if err == nil {
@@ -634,6 +832,26 @@ func TestNewRequestContentLength(t *testing.T) {
 	}
 }
 
+func TestNewRequestTimeout(t *testing.T) {
+	req, cancel, err := NewRequestTimeout("GET", "http://localhost/", nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadline, ok := req.Context().Deadline()
+	if !ok || time.Until(deadline) > time.Hour {
+		t.Errorf("request context deadline = %v, %v; want within an hour", deadline, ok)
+	}
+	cancel()
+	if err := req.Context().Err(); err != context.Canceled {
+		t.Errorf("after cancel, context error = %v; want %v", err, context.Canceled)
+	}
+
+	req, cancel, err = NewRequestTimeout("bad method", "http://localhost/", nil, time.Hour)
+	if err == nil || req != nil || cancel != nil {
+		t.Errorf("NewRequestTimeout with bad method = %v, %v, %v; want nil, nil, error", req, cancel, err)
+	}
+}
+
 var parseHTTPVersionTests = []struct {
 	vers         string
 	major, minor int
@@ -732,6 +950,50 @@ var parseBasicAuthTests = []struct {
 	{`Digest username="Aladdin"`, "", "", false},
 }
 
+func TestClientCertificate(t *testing.T) {
+	mustParseURL := func(s string) *url.URL {
+		u, err := url.Parse(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return u
+	}
+	tests := []struct {
+		name   string
+		tls    *tls.ConnectionState
+		wantID string // empty if ClientSPIFFEID should report false
+	}{
+		{"no TLS", nil, ""},
+		{"no certificate", &tls.ConnectionState{}, ""},
+		{"no URIs", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}, ""},
+		{"SPIFFE ID", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{
+			URIs: []*url.URL{mustParseURL("spiffe://example.org/service")},
+		}, {}}}, "spiffe://example.org/service"},
+		{"other scheme", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{
+			URIs: []*url.URL{mustParseURL("https://example.org/service")},
+		}}}, ""},
+		{"two URIs", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{
+			URIs: []*url.URL{mustParseURL("spiffe://example.org/a"), mustParseURL("spiffe://example.org/b")},
+		}}}, ""},
+		{"query", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{
+			URIs: []*url.URL{mustParseURL("spiffe://example.org/a?b=c")},
+		}}}, ""},
+	}
+	for _, tt := range tests {
+		r := &Request{TLS: tt.tls}
+		cert, ok := r.ClientCertificate()
+		if hasCert := tt.tls != nil && len(tt.tls.PeerCertificates) > 0; ok != hasCert {
+			t.Errorf("%s: ClientCertificate ok = %v; want %v", tt.name, ok, hasCert)
+		} else if ok && cert != tt.tls.PeerCertificates[0] {
+			t.Errorf("%s: ClientCertificate didn't return the leaf certificate", tt.name)
+		}
+		id, ok := r.ClientSPIFFEID()
+		if id != tt.wantID || ok != (tt.wantID != "") {
+			t.Errorf("%s: ClientSPIFFEID = %q, %v; want %q, %v", tt.name, id, ok, tt.wantID, tt.wantID != "")
+		}
+	}
+}
+
 func TestParseBasicAuth(t *testing.T) {
 	for _, tt := range parseBasicAuthTests {
 		r, _ := NewRequest("GET", "http://example.com/", nil)
@@ -13,10 +13,14 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime"
+	"mime/multipart"
+	"net/http/internal/ascii"
 	"net/textproto"
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"golang.org/x/net/http/httpguts"
 )
@@ -116,6 +120,9 @@ type Response struct {
 
 	// TLS contains information about the TLS connection on which the
 	// response was received. It is nil for unencrypted responses.
+	// Its DidResume field reports whether the connection resumed
+	// a previous TLS session, as configured by the Transport's
+	// TLSClientConfig.ClientSessionCache.
 	// The pointer is shared between responses and should not be
 	// modified.
 	TLS *tls.ConnectionState
@@ -145,6 +152,126 @@ func (r *Response) Location() (*url.URL, error) {
 	return url.Parse(lv)
 }
 
+// MultipartRanges returns a MIME multipart reader over the parts of a
+// 206 Partial Content response to a request for several ranges, whose
+// body has type multipart/byteranges (RFC 7233, Appendix A). Each part
+// has Content-Range and Content-Type headers giving the range it holds.
+// If the response isn't multipart/byteranges, such as a 206 response
+// for a single range, MultipartRanges returns ErrNotMultipart.
+//
+// The parts are read from r.Body, which the caller must still close.
+func (r *Response) MultipartRanges() (*multipart.Reader, error) {
+	if r.StatusCode != StatusPartialContent {
+		return nil, ErrNotMultipart
+	}
+	d, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || d != "multipart/byteranges" {
+		return nil, ErrNotMultipart
+	}
+	boundary, ok := params["boundary"]
+	if !ok {
+		return nil, ErrMissingBoundary
+	}
+	return multipart.NewReader(r.Body, boundary), nil
+}
+
+// Sunset returns the time given by the response's "Sunset" header
+// (RFC 8594), after which the resource is expected to become
+// unavailable, and reports whether the header holds a valid HTTP-date.
+func (r *Response) Sunset() (time.Time, bool) {
+	t, err := ParseTime(r.Header.Get("Sunset"))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// A ContentTypeError is returned by Response.ExpectContentType and
+// Response.DecodeJSON when the response does not have the expected
+// media type, such as when a server answers a request to a JSON API
+// with an HTML error page.
+type ContentTypeError struct {
+	Want        string // the expected media type
+	ContentType string // the response's Content-Type header, or ""
+	StatusCode  int    // the response's status code
+	Snippet     []byte // the start of the response body
+}
+
+func (e *ContentTypeError) Error() string {
+	ct := e.ContentType
+	if ct == "" {
+		ct = "no Content-Type"
+	} else {
+		ct = "Content-Type " + strconv.Quote(ct)
+	}
+	return fmt.Sprintf("http: %d response with %s, want %s; body begins %q", e.StatusCode, ct, e.Want, e.Snippet)
+}
+
+// contentTypeSnippetLen is the most bytes of a body a ContentTypeError
+// holds.
+const contentTypeSnippetLen = 128
+
+// ExpectContentType returns a *ContentTypeError if the media type of
+// the response's Content-Type header, ignoring its parameters and
+// case, is not mediaType, as in "application/json". A caller checks
+// a response's type this way before decoding its body, so that an
+// unexpected response causes a clear error rather than a confusing
+// one from the decoder.
+//
+// The error holds the start of the body, read from r.Body. r.Body is
+// replaced so that it still reads the whole body, and the caller must
+// still close it.
+func (r *Response) ExpectContentType(mediaType string) error {
+	return r.expectContentType(mediaType, func(mt string) bool {
+		return ascii.EqualFold(mt, mediaType)
+	})
+}
+
+func (r *Response) expectContentType(want string, match func(mediaType string) bool) error {
+	ct := r.Header.Get("Content-Type")
+	if mt, _, err := mime.ParseMediaType(ct); err == nil && match(mt) {
+		return nil
+	}
+	e := &ContentTypeError{Want: want, ContentType: ct, StatusCode: r.StatusCode}
+	if r.Body != nil && r.Body != NoBody {
+		buf := make([]byte, contentTypeSnippetLen)
+		n, _ := io.ReadFull(r.Body, buf)
+		e.Snippet = buf[:n]
+		r.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(e.Snippet), r.Body), r.Body}
+	}
+	return e
+}
+
+// MaxDrainBodyBytes is the most bytes of a response body that
+// DrainBody reads before giving up on draining it.
+const MaxDrainBodyBytes = 256 << 10
+
+// DrainBody reads and discards the rest of resp.Body, then closes it.
+// Reading a response body to EOF before closing it lets the
+// Transport reuse the connection for later requests.
+//
+// To bound the time and memory a misbehaving server can cost,
+// DrainBody reads at most MaxDrainBodyBytes. If the body is longer,
+// it is closed without being drained, and its connection is not
+// reused. DrainBody returns the first error from reading or closing
+// the body, other than io.EOF.
+func DrainBody(resp *Response) error {
+	if resp.Body == nil || resp.Body == NoBody {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, resp.Body, MaxDrainBodyBytes+1)
+	if err == io.EOF {
+		err = nil
+	}
+	if cerr := resp.Body.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
 // ReadResponse reads and returns an HTTP response from r.
 // The req parameter optionally specifies the Request that corresponds
 // to this Response. If nil, a GET request is assumed.
@@ -196,7 +323,7 @@ func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
 
 	fixPragmaCacheControl(resp.Header)
 
-	err = readTransfer(resp, r)
+	err = readTransfer(resp, r, nil)
 	if err != nil {
 		return nil, err
 	}
@@ -204,6 +331,58 @@ func ReadResponse(r *bufio.Reader, req *Request) (*Response, error) {
 	return resp, nil
 }
 
+// ReadResponseBytes parses data as a complete HTTP/1.x response,
+// as ReadResponse does, and reads its body into memory. If data
+// continues past the end of the response, ReadResponseBytes returns
+// the response along with a *TrailingDataError.
+// The req parameter is as for ReadResponse. ReadResponseBytes is
+// mainly intended for tests of response parsing.
+func ReadResponseBytes(data []byte, req *Request) (*Response, error) {
+	r := bytes.NewReader(data)
+	br := bufio.NewReader(r)
+	resp, err := ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.Body, err = readBodyBytes(resp.Body); err != nil {
+		return nil, err
+	}
+	if n := br.Buffered() + r.Len(); n > 0 {
+		return resp, &TrailingDataError{Offset: len(data) - n, Len: n}
+	}
+	return resp, nil
+}
+
+// A TrailingDataError is returned by ReadResponseBytes and
+// ReadRequestBytes when their input continues past the end of the
+// message.
+type TrailingDataError struct {
+	Offset int // length of the message, where the trailing data starts
+	Len    int // number of trailing bytes
+}
+
+func (e *TrailingDataError) Error() string {
+	return fmt.Sprintf("http: %d bytes of trailing data after message of %d bytes", e.Len, e.Offset)
+}
+
+// readBodyBytes reads and closes body, returning a replacement
+// holding its contents. Reading it to the end also fills the message's
+// Trailer, if any.
+func readBodyBytes(body io.ReadCloser) (io.ReadCloser, error) {
+	if body == nil || body == NoBody {
+		return body, nil
+	}
+	b, err := io.ReadAll(body)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return NoBody, nil
+	}
+	return io.NopCloser(bytes.NewReader(b)), nil
+}
+
 // RFC 7234, section 5.4: Should treat
 //	Pragma: no-cache
 // like
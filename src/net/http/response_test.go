@@ -9,10 +9,13 @@ import (
 	"bytes"
 	"compress/gzip"
 	"crypto/rand"
+	"errors"
 	"fmt"
 	"go/token"
 	"io"
+	"mime/multipart"
 	"net/http/internal"
+	"net/textproto"
 	"net/url"
 	"reflect"
 	"regexp"
@@ -1010,3 +1013,126 @@ func TestResponseWritesOnlySingleConnectionClose(t *testing.T) {
 		t.Errorf("Found %d %q header", count, connectionCloseHeader)
 	}
 }
+
+func TestResponseMultipartRanges(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for _, cr := range []string{"bytes 0-4/20", "bytes 10-14/20"} {
+		pw, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":  {"text/plain"},
+			"Content-Range": {cr},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.WriteString(pw, cr[6:])
+	}
+	mw.Close()
+
+	res := &Response{
+		StatusCode: StatusPartialContent,
+		Header:     Header{"Content-Type": {"multipart/byteranges; boundary=" + mw.Boundary()}},
+		Body:       io.NopCloser(&buf),
+	}
+	mr, err := res.MultipartRanges()
+	if err != nil {
+		t.Fatalf("MultipartRanges = %v", err)
+	}
+	for _, want := range []string{"bytes 0-4/20", "bytes 10-14/20"} {
+		part, err := mr.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(part)
+		if got := part.Header.Get("Content-Range"); got != want || string(body) != want[6:] {
+			t.Errorf("part Content-Range = %q, body %q; want %q, %q", got, body, want, want[6:])
+		}
+	}
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("after last part, NextPart = %v; want io.EOF", err)
+	}
+
+	for _, tt := range []struct {
+		code  int
+		ctype string
+		want  error
+	}{
+		{StatusOK, "multipart/byteranges; boundary=x", ErrNotMultipart},
+		{StatusPartialContent, "text/plain", ErrNotMultipart},
+		{StatusPartialContent, "", ErrNotMultipart},
+		{StatusPartialContent, "multipart/byteranges", ErrMissingBoundary},
+	} {
+		res := &Response{StatusCode: tt.code, Header: Header{"Content-Type": {tt.ctype}}, Body: NoBody}
+		if _, err := res.MultipartRanges(); err != tt.want {
+			t.Errorf("status %d, Content-Type %q: MultipartRanges = %v; want %v", tt.code, tt.ctype, err, tt.want)
+		}
+	}
+}
+
+func TestReadResponseBytes(t *testing.T) {
+	const msg = "HTTP/1.1 200 OK\r\n" +
+		"Transfer-Encoding: chunked\r\n" +
+		"\r\n" +
+		"5\r\nhello\r\n0\r\nX-Trailer: t\r\n\r\n"
+	res, err := ReadResponseBytes([]byte(msg), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := res.Trailer.Get("X-Trailer"); got != "t" {
+		t.Errorf("Trailer X-Trailer = %q; want t", got)
+	}
+	if b, _ := io.ReadAll(res.Body); string(b) != "hello" {
+		t.Errorf("body = %q; want hello", b)
+	}
+
+	res, err = ReadResponseBytes([]byte(msg+"junk"), nil)
+	var te *TrailingDataError
+	if !errors.As(err, &te) || te.Offset != len(msg) || te.Len != 4 {
+		t.Fatalf("ReadResponseBytes with trailing data: err = %v; want TrailingDataError at %d", err, len(msg))
+	}
+	if res == nil || res.StatusCode != 200 {
+		t.Errorf("ReadResponseBytes with trailing data returned response %v; want the parsed response", res)
+	}
+
+	short := "HTTP/1.1 200 OK\r\nContent-Length: 10\r\n\r\nhello"
+	if _, err := ReadResponseBytes([]byte(short), nil); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadResponseBytes with short body: err = %v; want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestResponseExpectContentType(t *testing.T) {
+	newResponse := func(ct, body string) *Response {
+		r := &Response{StatusCode: 502, Header: Header{}, Body: io.NopCloser(strings.NewReader(body))}
+		if ct != "" {
+			r.Header.Set("Content-Type", ct)
+		}
+		return r
+	}
+	for _, ct := range []string{"application/json", "Application/JSON; charset=utf-8"} {
+		if err := newResponse(ct, "{}").ExpectContentType("application/json"); err != nil {
+			t.Errorf("Content-Type %q: %v", ct, err)
+		}
+	}
+
+	page := "<html><body>" + strings.Repeat("Bad Gateway ", 20) + "</body></html>"
+	res := newResponse("text/html", page)
+	err := res.ExpectContentType("application/json")
+	var cte *ContentTypeError
+	if !errors.As(err, &cte) {
+		t.Fatalf("ExpectContentType of text/html = %v; want *ContentTypeError", err)
+	}
+	if cte.ContentType != "text/html" || cte.Want != "application/json" || cte.StatusCode != 502 || string(cte.Snippet) != page[:128] {
+		t.Errorf("error = %+v", cte)
+	}
+	if want := `http: 502 response with Content-Type "text/html", want application/json; body begins "<html><body>Bad Gateway`; !strings.HasPrefix(err.Error(), want) {
+		t.Errorf("Error() = %q; want prefix %q", err, want)
+	}
+	if b, err := io.ReadAll(res.Body); err != nil || string(b) != page {
+		t.Errorf("body after ExpectContentType = %q, %v; want whole body", b, err)
+	}
+
+	res = newResponse("", "")
+	if err := res.ExpectContentType("application/json"); err == nil || !strings.Contains(err.Error(), "no Content-Type") {
+		t.Errorf("ExpectContentType without Content-Type = %v", err)
+	}
+}
@@ -0,0 +1,341 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// A ResponseController is used by an HTTP handler to control the response.
+//
+// A ResponseController may not be used after the Handler.ServeHTTP method has returned.
+type ResponseController struct {
+	rw ResponseWriter
+}
+
+// NewResponseController creates a ResponseController for a request.
+//
+// The ResponseWriter should be the original value passed to the Handler.ServeHTTP method,
+// or have an Unwrap method returning the original ResponseWriter.
+//
+// If the ResponseWriter implements any of the following methods, the ResponseController
+// will call them as appropriate:
+//
+//	Flush()
+//	FlushError() error // alternative Flush returning an error
+//	SetWriteCoalescing(enabled bool, maxDelay time.Duration) error
+//	AbortRequestBody() error
+//	SetPreferredTransferEncoding(chunked, identity bool) error
+//	NegotiatedProtocol() (string, error)
+//	EarlyHints(header Header) error
+//	SetReadDeadline(deadline time.Time) error
+//	SetWriteDeadline(deadline time.Time) error
+//	WriteError() error
+//
+// If the ResponseWriter does not support a method, ResponseController returns
+// an error matching ErrNotSupported.
+func NewResponseController(rw ResponseWriter) *ResponseController {
+	return &ResponseController{rw}
+}
+
+type rwUnwrapper interface {
+	Unwrap() ResponseWriter
+}
+
+// errHandlerDone is returned by ResponseController methods that reach
+// an HTTP/2 response after its handler has returned.
+var errHandlerDone = errors.New("http: ResponseController used after ServeHTTP finished")
+
+// Flush flushes buffered data to the client. It returns the error of
+// writing to the client, if the ResponseWriter reports it.
+func (c *ResponseController) Flush() error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ FlushError() error }:
+			return t.FlushError()
+		case Flusher:
+			t.Flush()
+			return nil
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// canFlush reports whether Flush would find a method to flush the
+// response with.
+func (c *ResponseController) canFlush() bool {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ FlushError() error }, Flusher:
+			return true
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return false
+		}
+	}
+}
+
+// SetWriteCoalescing enables or disables write coalescing for the response.
+//
+// While write coalescing is enabled, data written to the response is
+// buffered and sent no later than maxDelay after it was written, together
+// with anything written in the meantime, or sooner if the buffers fill.
+// A handler that would otherwise call Flush after each of many small
+// writes to keep latency low can instead enable write coalescing, which
+// sends fewer, larger packets. Explicit calls to Flush still flush
+// immediately, and all buffered data is flushed when the handler returns.
+//
+// When enabled, maxDelay must be positive.
+// Write coalescing is not supported for HTTP/2 responses.
+func (c *ResponseController) SetWriteCoalescing(enabled bool, maxDelay time.Duration) error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface {
+			SetWriteCoalescing(bool, time.Duration) error
+		}:
+			return t.SetWriteCoalescing(enabled, maxDelay)
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// AbortRequestBody tells the server that the handler will not read the
+// rest of the request body, such as when it rejects a large or malformed
+// upload early. The request body is closed without being drained, so
+// later reads from it fail, and the server closes the connection after
+// writing the response instead of reading the rest of the body to reuse
+// the connection.
+//
+// AbortRequestBody should be called before the response header is
+// written, so that the response can tell the client the connection
+// will be closed. It is not supported for HTTP/2 requests, where an
+// unread request body does not affect other requests on the connection.
+func (c *ResponseController) AbortRequestBody() error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ AbortRequestBody() error }:
+			return t.AbortRequestBody()
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// SetPreferredTransferEncoding sets how the body of an HTTP/1 response
+// of unknown length is delimited. A response has an unknown length
+// when the handler neither sets a Content-Length header nor writes
+// the whole body before the first flush of the response buffer.
+// The framing of such a response is:
+//
+//	client     chunked  identity  framing
+//	HTTP/1.1   true     any       chunked, the default
+//	HTTP/1.1   false    true      close-delimited
+//	HTTP/1.0   any      any       close-delimited
+//
+// A close-delimited body ends when the server closes the connection,
+// which it then does after the response. HTTP/1.0 clients don't
+// support chunking, so their responses of unknown length are always
+// close-delimited. Responses of known length are sent with their
+// Content-Length regardless. At least one of chunked and identity
+// must be true.
+//
+// SetPreferredTransferEncoding must be called before the response
+// header is sent. It is not supported for HTTP/2 responses, whose
+// framing does not depend on the response length.
+func (c *ResponseController) SetPreferredTransferEncoding(chunked, identity bool) error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface {
+			SetPreferredTransferEncoding(chunked, identity bool) error
+		}:
+			return t.SetPreferredTransferEncoding(chunked, identity)
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// NegotiatedProtocol returns the application protocol negotiated with
+// TLS ALPN for the connection the request arrived on, such as "h2" or
+// "http/1.1", or "" if the connection does not use TLS or no protocol
+// was negotiated. It is the same as Request.TLS.NegotiatedProtocol.
+//
+// The protocol is negotiated once per connection, not per request,
+// so it is the same for all requests on a connection.
+func (c *ResponseController) NegotiatedProtocol() (string, error) {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ NegotiatedProtocol() (string, error) }:
+			return t.NegotiatedProtocol()
+		case *http2responseWriter:
+			if t.rws == nil {
+				return "", errHandlerDone
+			}
+			if ts := t.rws.conn.tlsState; ts != nil {
+				return ts.NegotiatedProtocol, nil
+			}
+			return "", nil
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return "", errNotSupported()
+		}
+	}
+}
+
+// EarlyHints sends an informational 103 Early Hints response with the
+// given header, typically Link headers naming resources the client
+// may preload while the handler computes the final response. It may
+// be called more than once before the final response header is
+// written, and it does nothing once the response header has been
+// written. The header is sent as is; the response's own Header is
+// not consulted.
+//
+// EarlyHints is not supported for HTTP/1.0 requests, which have no
+// informational responses.
+func (c *ResponseController) EarlyHints(header Header) error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ EarlyHints(Header) error }:
+			return t.EarlyHints(header)
+		case *http2responseWriter:
+			rws := t.rws
+			if rws == nil {
+				return errHandlerDone
+			}
+			if rws.wroteHeader {
+				return nil
+			}
+			return rws.conn.writeHeaders(rws.stream, &http2writeResHeaders{
+				streamID:    rws.stream.id,
+				httpResCode: StatusEarlyHints,
+				h:           header,
+			})
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// SetReadDeadline sets the deadline for reading the entire request,
+// including the body. Reads from the request body after the deadline
+// has been exceeded will return an error. A zero value means no
+// deadline. The deadline replaces the one set by the Server's
+// ReadTimeout for the rest of the request, and stops
+// RequestBodyTimeoutResets from moving it.
+//
+// Setting the read deadline after it has been exceeded will not extend
+// it. SetReadDeadline is not supported for HTTP/2 requests.
+func (c *ResponseController) SetReadDeadline(deadline time.Time) error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ SetReadDeadline(time.Time) error }:
+			return t.SetReadDeadline(deadline)
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// SetWriteDeadline sets the deadline for writing the response. Writes
+// to the response body after the deadline has been exceeded will not
+// block, but may succeed if the data has been buffered. A zero value
+// means no deadline. The deadline replaces the one set by the Server's
+// WriteTimeout for the rest of the response.
+//
+// Setting the write deadline after it has been exceeded will not
+// extend it. SetWriteDeadline is not supported for HTTP/2 requests.
+func (c *ResponseController) SetWriteDeadline(deadline time.Time) error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ SetWriteDeadline(time.Time) error }:
+			return t.SetWriteDeadline(deadline)
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// WriteError returns the error that made an earlier write of the
+// response to the client fail, or nil if no write has failed. Once a
+// write has failed, such as because the client disconnected, the rest
+// of the response can't be sent either, so a handler doing expensive
+// work to produce it can check WriteError and stop early, without
+// checking the error of every Write. The request's context is also
+// canceled when a write fails.
+//
+// Since the response is buffered, a Write may succeed before the
+// failure is noticed; WriteError reports it once the buffered data
+// has been written, after the buffer fills or on Flush. For HTTP/1,
+// Write itself returns the error once WriteError does. For HTTP/2,
+// WriteError also reports the reset of the request's stream by the
+// client and the closing of the connection, as errors reading
+// "http2: stream closed" and "client disconnected", while a Write
+// that only fills the buffer may still succeed.
+func (c *ResponseController) WriteError() error {
+	rw := c.rw
+	for {
+		switch t := rw.(type) {
+		case interface{ WriteError() error }:
+			return t.WriteError()
+		case *http2responseWriter:
+			rws := t.rws
+			if rws == nil {
+				return errHandlerDone
+			}
+			// A bufio.Writer keeps the error of a failed write
+			// of its buffer, and returns it from any Write.
+			if _, err := rws.bw.Write(nil); err != nil {
+				return err
+			}
+			select {
+			case <-rws.stream.cw:
+				return http2errStreamClosed
+			case <-rws.conn.doneServing:
+				return http2errClientDisconnected
+			default:
+				return nil
+			}
+		case rwUnwrapper:
+			rw = t.Unwrap()
+		default:
+			return errNotSupported()
+		}
+	}
+}
+
+// errNotSupported returns an error that Is ErrNotSupported,
+// but is not == to it.
+func errNotSupported() error {
+	return fmt.Errorf("%w", ErrNotSupported)
+}
@@ -0,0 +1,454 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	. "net/http"
+	"net/http/httptrace"
+	"net/textproto"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResponseControllerFlush_h1(t *testing.T) { testResponseControllerFlush(t, h1Mode) }
+func TestResponseControllerFlush_h2(t *testing.T) { testResponseControllerFlush(t, h2Mode) }
+func testResponseControllerFlush(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	continuec := make(chan struct{})
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(w)
+		w.Write([]byte("one"))
+		if err := ctl.Flush(); err != nil {
+			t.Errorf("ctl.Flush() = %v, want nil", err)
+			return
+		}
+		<-continuec
+		w.Write([]byte("two"))
+	}))
+	defer cst.close()
+
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected connection error: %v", err)
+	}
+	defer res.Body.Close()
+
+	buf := make([]byte, 16)
+	n, err := res.Body.Read(buf)
+	close(continuec)
+	if err != nil || string(buf[:n]) != "one" {
+		t.Fatalf("Read = %q, %v, want %q, nil", string(buf[:n]), err, "one")
+	}
+
+	got, err := io.ReadAll(res.Body)
+	if err != nil || string(got) != "two" {
+		t.Fatalf("Read = %q, %v, want %q, nil", string(got), err, "two")
+	}
+}
+
+// wrapResponseWriter is a ResponseWriter wrapper with an Unwrap method.
+type wrapResponseWriter struct {
+	ResponseWriter
+}
+
+func (w wrapResponseWriter) Unwrap() ResponseWriter { return w.ResponseWriter }
+
+func TestResponseControllerWriteCoalescing(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	continuec := make(chan struct{})
+	cst := newClientServerTest(t, h1Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(wrapResponseWriter{w})
+		if err := ctl.SetWriteCoalescing(true, 0); err == nil {
+			t.Errorf("SetWriteCoalescing(true, 0) succeeded; want error")
+		}
+		if err := ctl.SetWriteCoalescing(true, 10*time.Millisecond); err != nil {
+			t.Errorf("SetWriteCoalescing = %v, want nil", err)
+			return
+		}
+		// Without a Flush, these only reach the client
+		// once the coalescing delay has passed.
+		for _, s := range []string{"a", "b", "c"} {
+			io.WriteString(w, s)
+		}
+		<-continuec
+		if err := ctl.SetWriteCoalescing(false, 0); err != nil {
+			t.Errorf("SetWriteCoalescing(false, 0) = %v, want nil", err)
+		}
+		io.WriteString(w, "def")
+	}))
+	defer cst.close()
+
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatalf("unexpected connection error: %v", err)
+	}
+	defer res.Body.Close()
+
+	buf := make([]byte, 3)
+	_, err = io.ReadFull(res.Body, buf)
+	close(continuec)
+	if err != nil || string(buf) != "abc" {
+		t.Fatalf("Read = %q, %v, want %q, nil", buf, err, "abc")
+	}
+	got, err := io.ReadAll(res.Body)
+	if err != nil || string(got) != "def" {
+		t.Fatalf("Read = %q, %v, want %q, nil", got, err, "def")
+	}
+}
+
+func TestResponseControllerWriteCoalescingNotSupported(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(w)
+		if err := ctl.SetWriteCoalescing(true, time.Millisecond); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("SetWriteCoalescing over HTTP/2 = %v, want ErrNotSupported", err)
+		}
+	}))
+	defer cst.close()
+
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
+
+func TestResponseControllerAbortRequestBody(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h1Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(w)
+		buf := make([]byte, 5)
+		if _, err := io.ReadFull(r.Body, buf); err != nil {
+			t.Errorf("reading first chunk: %v", err)
+		}
+		if err := ctl.AbortRequestBody(); err != nil {
+			t.Errorf("AbortRequestBody = %v, want nil", err)
+		}
+		if _, err := r.Body.Read(buf); err == nil {
+			t.Errorf("Read after AbortRequestBody succeeded; want error")
+		}
+		w.WriteHeader(StatusBadRequest)
+	}))
+	defer cst.close()
+
+	conn, err := net.Dial("tcp", cst.ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	// Send the first chunk of a body that never ends. Without
+	// AbortRequestBody the server would wait for more of it.
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: foo\r\nTransfer-Encoding: chunked\r\n\r\n5\r\nhello\r\n")
+	res, err := ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.StatusCode != StatusBadRequest || !res.Close {
+		t.Errorf("got status %d, Close = %v; want 400 with connection close", res.StatusCode, res.Close)
+	}
+	if _, err := io.ReadAll(res.Body); err != nil {
+		t.Errorf("reading response body: %v", err)
+	}
+}
+
+func TestResponseControllerAbortRequestBodyNotSupported(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if err := NewResponseController(w).AbortRequestBody(); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("AbortRequestBody over HTTP/2 = %v, want ErrNotSupported", err)
+		}
+	}))
+	defer cst.close()
+
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
+
+func TestResponseControllerSetPreferredTransferEncoding(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h1Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(w)
+		if err := ctl.SetPreferredTransferEncoding(false, false); err == nil {
+			t.Errorf("SetPreferredTransferEncoding(false, false) = nil; want error")
+		}
+		chunked, identity := r.FormValue("chunked") == "1", r.FormValue("identity") == "1"
+		if err := ctl.SetPreferredTransferEncoding(chunked, identity); err != nil {
+			t.Errorf("SetPreferredTransferEncoding(%v, %v) = %v", chunked, identity, err)
+		}
+		io.WriteString(w, "a")
+		ctl.Flush()
+		if err := ctl.SetPreferredTransferEncoding(true, true); err == nil {
+			t.Errorf("SetPreferredTransferEncoding after Flush = nil; want error")
+		}
+		io.WriteString(w, "b")
+	}))
+	defer cst.close()
+
+	tests := []struct {
+		proto             string
+		chunked, identity string
+		wantChunked       bool
+	}{
+		{"HTTP/1.1", "1", "1", true},
+		{"HTTP/1.1", "1", "0", true},
+		{"HTTP/1.1", "0", "1", false},
+		{"HTTP/1.0", "1", "1", false},
+		{"HTTP/1.0", "1", "0", false},
+		{"HTTP/1.0", "0", "1", false},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", cst.ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		fmt.Fprintf(conn, "GET /?chunked=%s&identity=%s %s\r\nHost: foo\r\n\r\n", tt.chunked, tt.identity, tt.proto)
+		res, err := ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		conn.Close()
+		gotChunked := len(res.TransferEncoding) > 0
+		if err != nil || string(body) != "ab" || gotChunked != tt.wantChunked || res.Close == tt.wantChunked {
+			t.Errorf("%s chunked=%s identity=%s: got body %q, %v, chunked %v, Close %v; want chunked %v",
+				tt.proto, tt.chunked, tt.identity, body, err, gotChunked, res.Close, tt.wantChunked)
+		}
+	}
+}
+
+func TestResponseControllerNegotiatedProtocol_h1(t *testing.T) {
+	testResponseControllerNegotiatedProtocol(t, h1Mode, "")
+}
+func TestResponseControllerNegotiatedProtocol_h2(t *testing.T) {
+	testResponseControllerNegotiatedProtocol(t, h2Mode, "h2")
+}
+func testResponseControllerNegotiatedProtocol(t *testing.T, h2 bool, want string) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		got, err := NewResponseController(wrapResponseWriter{w}).NegotiatedProtocol()
+		if err != nil || got != want {
+			t.Errorf("NegotiatedProtocol() = %q, %v; want %q, nil", got, err, want)
+		}
+	}))
+	defer cst.close()
+
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
+
+func TestResponseControllerEarlyHints_h1(t *testing.T) { testResponseControllerEarlyHints(t, h1Mode) }
+func TestResponseControllerEarlyHints_h2(t *testing.T) { testResponseControllerEarlyHints(t, h2Mode) }
+func testResponseControllerEarlyHints(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(wrapResponseWriter{w})
+		for _, link := range []string{"</style.css>; rel=preload; as=style", "</script.js>; rel=preload; as=script"} {
+			if err := ctl.EarlyHints(Header{"Link": {link}}); err != nil {
+				t.Errorf("EarlyHints = %v", err)
+			}
+		}
+		w.Header().Set("X-Final", "1")
+		w.WriteHeader(StatusOK)
+		if err := ctl.EarlyHints(Header{"Link": {"</late.js>; rel=preload"}}); err != nil {
+			t.Errorf("EarlyHints after WriteHeader = %v; want nil", err)
+		}
+		io.WriteString(w, "page")
+	}))
+	defer cst.close()
+
+	var hints []string
+	req, _ := NewRequest("GET", cst.ts.URL, nil)
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		Got1xxResponse: func(code int, header textproto.MIMEHeader) error {
+			hints = append(hints, fmt.Sprintf("%d %s", code, header.Get("Link")))
+			return nil
+		},
+	}))
+	res, err := cst.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(body) != "page" || res.Header.Get("X-Final") != "1" || res.Header.Get("Link") != "" {
+		t.Errorf("got body %q, %v, header %v; want final page", body, err, res.Header)
+	}
+	want := []string{
+		"103 </style.css>; rel=preload; as=style",
+		"103 </script.js>; rel=preload; as=script",
+	}
+	if !reflect.DeepEqual(hints, want) {
+		t.Errorf("informational responses = %q; want %q", hints, want)
+	}
+}
+
+func TestResponseControllerEarlyHintsHTTP10(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h1Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		err := NewResponseController(w).EarlyHints(Header{"Link": {"</a.css>; rel=preload"}})
+		if !errors.Is(err, ErrNotSupported) {
+			t.Errorf("EarlyHints for HTTP/1.0 request = %v; want ErrNotSupported", err)
+		}
+		io.WriteString(w, "page")
+	}))
+	defer cst.close()
+
+	conn, err := net.Dial("tcp", cst.ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	io.WriteString(conn, "GET / HTTP/1.0\r\nHost: foo\r\n\r\n")
+	res, err := ReadResponse(bufio.NewReader(conn), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(res.Body)
+	if err != nil || res.StatusCode != StatusOK || string(body) != "page" {
+		t.Errorf("got %d %q, %v; want 200 page", res.StatusCode, body, err)
+	}
+}
+
+func TestResponseControllerSetDeadlines(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	errc := make(chan error, 2)
+	cst := newClientServerTest(t, h1Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(wrapResponseWriter{w})
+		if err := ctl.SetReadDeadline(time.Now().Add(-time.Second)); err != nil {
+			t.Errorf("SetReadDeadline = %v", err)
+		}
+		_, err := io.ReadAll(r.Body)
+		errc <- err
+		if err := ctl.SetWriteDeadline(time.Now().Add(-time.Second)); err != nil {
+			t.Errorf("SetWriteDeadline = %v", err)
+		}
+		_, err = w.Write(make([]byte, 1<<20))
+		errc <- err
+	}))
+	defer cst.close()
+
+	// The Transport doesn't give up on the request until it has
+	// finished sending the body, so send it from another goroutine.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go func() {
+		res, err := cst.c.Post(cst.ts.URL, "text/plain", pr)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	if err := <-errc; err == nil {
+		t.Errorf("reading body after read deadline: nil error")
+	}
+	if err := <-errc; err == nil {
+		t.Errorf("writing after write deadline: nil error")
+	}
+}
+
+func TestResponseControllerSetDeadlinesNotSupported(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		ctl := NewResponseController(w)
+		if err := ctl.SetReadDeadline(time.Now()); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("SetReadDeadline over HTTP/2 = %v, want ErrNotSupported", err)
+		}
+		if err := ctl.SetWriteDeadline(time.Now()); !errors.Is(err, ErrNotSupported) {
+			t.Errorf("SetWriteDeadline over HTTP/2 = %v, want ErrNotSupported", err)
+		}
+	}))
+	defer cst.close()
+
+	res, err := cst.c.Get(cst.ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+}
+
+func TestResponseControllerWriteError_h1(t *testing.T) { testResponseControllerWriteError(t, h1Mode) }
+func TestResponseControllerWriteError_h2(t *testing.T) { testResponseControllerWriteError(t, h2Mode) }
+func testResponseControllerWriteError(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	errc := make(chan error, 1)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		errc <- func() error {
+			rc := NewResponseController(w)
+			io.WriteString(w, "hello")
+			if err := rc.Flush(); err != nil {
+				return fmt.Errorf("Flush = %v", err)
+			}
+			if err := rc.WriteError(); err != nil {
+				return fmt.Errorf("WriteError before client went away = %v", err)
+			}
+			<-r.Context().Done()
+			buf := make([]byte, 16<<10)
+			deadline := time.Now().Add(10 * time.Second)
+			for rc.WriteError() == nil {
+				if time.Now().After(deadline) {
+					return errors.New("WriteError = nil after disconnect")
+				}
+				w.Write(buf)
+				rc.Flush()
+				time.Sleep(time.Millisecond)
+			}
+			if h2 {
+				return nil
+			}
+			werr := rc.WriteError()
+			if _, err := w.Write([]byte("x")); err != werr {
+				return fmt.Errorf("Write after failed write = %v; want %v", err, werr)
+			}
+			if err := rc.Flush(); err != werr {
+				return fmt.Errorf("Flush after failed write = %v; want %v", err, werr)
+			}
+			return nil
+		}()
+	}))
+	defer cst.close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	req, _ := NewRequestWithContext(ctx, "GET", cst.ts.URL, nil)
+	res, err := cst.c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 5)
+	if _, err := io.ReadFull(res.Body, b); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	res.Body.Close()
+	if err := <-errc; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Client retry policies.
+
+package http
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// A RetryPolicy decides whether a Client retries a request.
+//
+// ShouldRetry is called after each attempt to send req, numbered from
+// 1, with the response received or the error that prevented one. If
+// it reports true, the Client closes the response's body, waits for
+// delay, and sends the request again. ShouldRetry must not read or
+// close resp.Body, though it may inspect the response's header.
+//
+// A RetryPolicy must be safe for concurrent use by multiple
+// goroutines. The Client only retries requests whose body, if any,
+// it can send again, but it is up to the policy not to retry requests
+// that aren't idempotent, such as most POST requests.
+type RetryPolicy interface {
+	ShouldRetry(attempt int, req *Request, resp *Response, err error) (delay time.Duration, retry bool)
+}
+
+// IdempotentRetryPolicy is a RetryPolicy that retries idempotent
+// requests after a connection error or a 502 Bad Gateway, 503 Service
+// Unavailable, or 504 Gateway Timeout response, waiting longer before
+// each attempt. Requests are idempotent if their method is GET, HEAD,
+// OPTIONS, TRACE, PUT, or DELETE, or if they have an Idempotency-Key
+// or X-Idempotency-Key header.
+//
+// If a response has a Retry-After header, its delay is used instead,
+// unless it is longer than MaxDelay, in which case the request is not
+// retried.
+type IdempotentRetryPolicy struct {
+	// MaxAttempts is the maximum number of attempts to send a
+	// request, including the first. If zero, 3 is used.
+	MaxAttempts int
+
+	// MinDelay is the delay before the second attempt, which
+	// doubles for each further attempt. If zero, 100ms is used.
+	MinDelay time.Duration
+
+	// MaxDelay caps the delay between attempts. If zero, 10s is used.
+	MaxDelay time.Duration
+}
+
+// ShouldRetry implements RetryPolicy.
+func (p *IdempotentRetryPolicy) ShouldRetry(attempt int, req *Request, resp *Response, err error) (time.Duration, bool) {
+	maxAttempts, delay, maxDelay := p.MaxAttempts, p.MinDelay, p.MaxDelay
+	if maxAttempts == 0 {
+		maxAttempts = 3
+	}
+	if delay == 0 {
+		delay = 100 * time.Millisecond
+	}
+	if maxDelay == 0 {
+		maxDelay = 10 * time.Second
+	}
+	if attempt >= maxAttempts || !isIdempotent(req) {
+		return 0, false
+	}
+	if err != nil {
+		if !isConnError(err) {
+			return 0, false
+		}
+	} else {
+		switch resp.StatusCode {
+		case StatusBadGateway, StatusServiceUnavailable, StatusGatewayTimeout:
+		default:
+			return 0, false
+		}
+		if d, ok := retryAfter(resp.Header.Get("Retry-After")); ok {
+			return d, d <= maxDelay
+		}
+	}
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	if delay > maxDelay {
+		delay = maxDelay
+	}
+	return delay, true
+}
+
+// A RetryBudget limits the retries of a Client to a fraction of its
+// requests, as a token bucket: each request sent, other than a retry,
+// adds Ratio tokens to the bucket, up to MaxTokens, and each retry
+// takes one token. A request is not retried unless the bucket has a
+// whole token. The bucket starts full, so that a new Client can retry
+// its first requests.
+//
+// A RetryBudget is safe for concurrent use by multiple goroutines.
+// It must not be copied after first use.
+type RetryBudget struct {
+	// Ratio is the number of retries allowed for each request, on
+	// average. If zero, 0.1 is used, allowing one retry for every
+	// ten requests.
+	Ratio float64
+
+	// MaxTokens is the largest number of retries the budget can
+	// save up while requests succeed, and so the largest burst of
+	// retries it allows. If zero, 10 is used.
+	MaxTokens int
+
+	mu     sync.Mutex
+	init   bool
+	tokens float64
+}
+
+// fill adds n tokens to b, up to its maximum. b.mu must be held.
+func (b *RetryBudget) fill(n float64) {
+	max := float64(b.MaxTokens)
+	if max <= 0 {
+		max = 10
+	}
+	if !b.init {
+		b.init = true
+		b.tokens = max
+	}
+	b.tokens += n
+	if b.tokens > max {
+		b.tokens = max
+	}
+}
+
+// deposit records that a request other than a retry is being sent.
+// A nil budget does nothing.
+func (b *RetryBudget) deposit() {
+	if b == nil {
+		return
+	}
+	ratio := b.Ratio
+	if ratio == 0 {
+		ratio = 0.1
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(ratio)
+}
+
+// withdraw reports whether b allows a retry, and if so takes a token
+// for it. A nil budget allows every retry.
+func (b *RetryBudget) withdraw() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.fill(0)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// isIdempotent reports whether resending req has the same effect as
+// sending it once.
+func isIdempotent(req *Request) bool {
+	switch valueOrDefault(req.Method, "GET") {
+	case "GET", "HEAD", "OPTIONS", "TRACE", "PUT", "DELETE":
+		return true
+	}
+	return req.Header.has("Idempotency-Key") || req.Header.has("X-Idempotency-Key")
+}
+
+// isConnError reports whether err, returned by a RoundTripper, is a
+// failure of the connection that a new attempt might not hit.
+func isConnError(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) && dnsErr.IsNotFound {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// retryAfter parses the value of a Retry-After header, a number of
+// seconds or an HTTP date.
+func retryAfter(v string) (time.Duration, bool) {
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.ParseInt(v, 10, 64); err == nil {
+		if secs < 0 || secs > int64(1<<63-1)/int64(time.Second) {
+			return 0, false
+		}
+		return time.Duration(secs) * time.Second, true
+	}
+	t, err := ParseTime(v)
+	if err != nil {
+		return 0, false
+	}
+	d := time.Until(t)
+	if d < 0 {
+		d = 0
+	}
+	return d, true
+}
@@ -0,0 +1,204 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	. "net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+type retryAlways struct{}
+
+func (retryAlways) ShouldRetry(attempt int, req *Request, resp *Response, err error) (time.Duration, bool) {
+	return time.Millisecond, attempt < 3
+}
+
+func TestClientRetry(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	var mu sync.Mutex
+	hits := make(map[string]int)
+	var bodies []string
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		hits[r.URL.Path]++
+		n := hits[r.URL.Path]
+		b, _ := io.ReadAll(r.Body)
+		if len(b) > 0 {
+			bodies = append(bodies, string(b))
+		}
+		mu.Unlock()
+		switch {
+		case r.URL.Path == "/conn":
+			if n == 1 {
+				c, _, _ := w.(Hijacker).Hijack()
+				c.Close()
+			}
+		case r.URL.Path == "/after":
+			w.Header().Set("Retry-After", r.FormValue("after"))
+			w.WriteHeader(StatusServiceUnavailable)
+		case n < 3 || r.URL.Path == "/down":
+			w.WriteHeader(StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+	c := ts.Client()
+	c.Retry = &IdempotentRetryPolicy{MinDelay: time.Millisecond}
+
+	do := func(method, uri string, body io.Reader) int {
+		t.Helper()
+		path, _, _ := strings.Cut(uri, "?")
+		mu.Lock()
+		hits[path] = 0
+		mu.Unlock()
+		req, _ := NewRequest(method, ts.URL+uri, body)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatalf("%s %s: %v", method, path, err)
+		}
+		res.Body.Close()
+		mu.Lock()
+		defer mu.Unlock()
+		return hits[path]
+	}
+
+	if n := do("GET", "/flaky", nil); n != 3 {
+		t.Errorf("GET /flaky: %d attempts; want 3", n)
+	}
+	if n := do("GET", "/down", nil); n != 3 {
+		t.Errorf("GET /down: %d attempts; want MaxAttempts of 3", n)
+	}
+	if n := do("GET", "/conn", nil); n != 2 {
+		t.Errorf("GET /conn: %d attempts; want 2 after connection error", n)
+	}
+	if n := do("POST", "/flaky", strings.NewReader("x")); n != 1 {
+		t.Errorf("POST /flaky: %d attempts; want 1 for non-idempotent request", n)
+	}
+	if n := do("GET", "/after?after=0", nil); n != 3 {
+		t.Errorf("GET with Retry-After 0: %d attempts; want 3", n)
+	}
+	if n := do("GET", "/after?after=3600", nil); n != 1 {
+		t.Errorf("GET with Retry-After 3600: %d attempts; want 1", n)
+	}
+
+	c.Retry = retryAlways{}
+	bodies = nil
+	if n := do("POST", "/flaky", strings.NewReader("body")); n != 3 {
+		t.Errorf("POST /flaky with retryAlways policy: %d attempts; want 3", n)
+	}
+	if want := []string{"body", "body", "body"}; strings.Join(bodies, ",") != strings.Join(want, ",") {
+		t.Errorf("bodies received = %q; want %q", bodies, want)
+	}
+	if n := do("POST", "/flaky", io.MultiReader(strings.NewReader("body"))); n != 1 {
+		t.Errorf("POST /flaky without GetBody: %d attempts; want 1", n)
+	}
+}
+
+type retryAfterHour struct{}
+
+func (retryAfterHour) ShouldRetry(int, *Request, *Response, error) (time.Duration, bool) {
+	return time.Hour, true
+}
+
+func TestClientRetryContext(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.WriteHeader(StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	c := ts.Client()
+	c.Retry = retryAfterHour{}
+
+	// A delay past the context's deadline returns the last response.
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	req, _ := NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.StatusCode != StatusServiceUnavailable {
+		t.Errorf("status = %d; want 503", res.StatusCode)
+	}
+
+	// Canceling the context ends the wait.
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	req, _ = NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	if _, err := c.Do(req); !errors.Is(err, context.Canceled) {
+		t.Errorf("Do with canceled context = %v; want context.Canceled", err)
+	}
+}
+
+type retryFive struct{}
+
+func (retryFive) ShouldRetry(attempt int, req *Request, resp *Response, err error) (time.Duration, bool) {
+	return 0, attempt < 5
+}
+
+func TestClientRetryBudget(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	var mu sync.Mutex
+	hits := 0
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		mu.Lock()
+		hits++
+		mu.Unlock()
+		w.WriteHeader(StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+	c := ts.Client()
+	c.Retry = retryFive{}
+	c.RetryBudget = &RetryBudget{Ratio: 0.5, MaxTokens: 1}
+
+	// The budget starts with one token, and each request adds half
+	// of one.
+	for i, want := range []int{2, 1, 2, 1, 2} {
+		mu.Lock()
+		hits = 0
+		mu.Unlock()
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		if res.StatusCode != StatusServiceUnavailable {
+			t.Errorf("request %d: status = %d; want 503", i, res.StatusCode)
+		}
+		mu.Lock()
+		got := hits
+		mu.Unlock()
+		if got != want {
+			t.Errorf("request %d: %d attempts; want %d", i, got, want)
+		}
+	}
+
+	// The budget is safe to share between clients making
+	// concurrent requests.
+	c2 := &Client{Transport: c.Transport, Retry: retryFive{}, RetryBudget: c.RetryBudget}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(c *Client) {
+			defer wg.Done()
+			if res, err := c.Get(ts.URL); err == nil {
+				res.Body.Close()
+			} else {
+				t.Error(err)
+			}
+		}([]*Client{c, c2}[i%2])
+	}
+	wg.Wait()
+}
@@ -27,6 +27,7 @@ import (
 	"net/http/httputil"
 	"net/http/internal"
 	"net/http/internal/testcert"
+	"net/netip"
 	"net/url"
 	"os"
 	"os/exec"
@@ -387,6 +388,224 @@ func TestServeMuxHandler(t *testing.T) {
 	}
 }
 
+func TestServeMuxHostPatterns(t *testing.T) {
+	setParallel(t)
+	mux := NewServeMux()
+	mux.Handle("/path", serve(200))
+	mux.Handle("Example.COM/path", serve(201))
+	mux.Handle("example.org/dir/", serve(202))
+	mux.Handle("[::1]/", serve(203))
+	mux.Handle("2001:DB8::1/", serve(204))
+	for _, tt := range []struct {
+		host    string
+		path    string
+		code    int
+		pattern string
+	}{
+		{"example.com", "/path", 201, "example.com/path"},
+		{"example.com:8080", "/path", 201, "example.com/path"},
+		{"EXAMPLE.com", "/path", 201, "example.com/path"},
+		{"example.com.", "/path", 201, "example.com/path"},
+		{"example.com", "/other", 404, ""},
+		{"unknown.org", "/path", 200, "/path"},
+		{"example.org:1", "/dir", 301, "/dir/"},
+		{"example.org", "/path", 200, "/path"},
+		{"[::1]:8080", "/path", 203, "[::1]/"},
+		{"[::1]", "/path", 203, "[::1]/"},
+		{"[0:0::1]:8080", "/path", 203, "[::1]/"},
+		{"[2001:db8::1]:443", "/path", 204, "[2001:db8::1]/"},
+	} {
+		r := &Request{Method: "GET", Host: tt.host, URL: &url.URL{Path: tt.path}}
+		h, pattern := mux.Handler(r)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, r)
+		if pattern != tt.pattern || rr.Code != tt.code {
+			t.Errorf("%s %s = %d, %q, want %d, %q", tt.host, tt.path, rr.Code, pattern, tt.code, tt.pattern)
+		}
+	}
+}
+
+func TestServeMuxMount(t *testing.T) {
+	api := NewServeMux()
+	api.HandleFunc("/users/", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "users %s tenant=%s", r.URL.EscapedPath(), r.PathValue("tenant"))
+	})
+	api.HandleFunc("/info", func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "info %s", r.URL.Path)
+	})
+	mux := NewServeMux()
+	mux.Mount("/t/{tenant}/api/", api)
+	mux.Mount("/", HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "root %s", r.URL.Path)
+	}))
+
+	for _, tt := range []struct {
+		path         string
+		wantCode     int
+		wantBody     string
+		wantLocation string
+	}{
+		{"/t/acme/api/users/1", 200, "users /users/1 tenant=acme", ""},
+		{"/t/acme/api/users/a%2Fb", 200, "users /users/a%2Fb tenant=acme", ""},
+		{"/t/acme/api/info", 200, "info /info", ""},
+		{"/t/acme/api", 301, "", "/t/acme/api/"},
+		{"/t/acme/api/users", 301, "", "/t/acme/api/users/"},
+		{"/t/acme/api/", 404, "", ""},
+		{"/other", 200, "root /other", ""},
+	} {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("GET %s: status = %d; want %d", tt.path, rec.Code, tt.wantCode)
+		}
+		if tt.wantBody != "" && rec.Body.String() != tt.wantBody {
+			t.Errorf("GET %s: body = %q; want %q", tt.path, rec.Body.String(), tt.wantBody)
+		}
+		if got := rec.Header().Get("Location"); got != tt.wantLocation {
+			t.Errorf("GET %s: Location = %q; want %q", tt.path, got, tt.wantLocation)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Mount with {name...} wildcard did not panic")
+		}
+	}()
+	mux.Mount("/files/{path...}", api)
+}
+
+func TestServeMuxUse(t *testing.T) {
+	var order []string
+	tag := func(name string) Middleware {
+		return func(h Handler) Handler {
+			return HandlerFunc(func(w ResponseWriter, r *Request) {
+				order = append(order, name)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	mux := NewServeMux()
+	mux.HandleFunc("/a", func(w ResponseWriter, r *Request) {
+		order = append(order, "handler")
+	})
+	mux.Use(tag("first"), tag("second"))
+	mux.HandleFunc("/b", func(w ResponseWriter, r *Request) {
+		order = append(order, "handler b")
+	})
+	mux.Use(Chain(tag("third"), tag("fourth")))
+
+	for _, tt := range []struct {
+		path string
+		want []string
+	}{
+		{"/a", []string{"first", "second", "third", "fourth", "handler"}},
+		{"/b", []string{"first", "second", "third", "fourth", "handler b"}},
+		{"/missing", []string{"first", "second", "third", "fourth"}},
+	} {
+		order = nil
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", tt.path, nil))
+		if !reflect.DeepEqual(order, tt.want) {
+			t.Errorf("GET %s: order = %q; want %q", tt.path, order, tt.want)
+		}
+	}
+
+	order = nil
+	Chain()(HandlerFunc(func(w ResponseWriter, r *Request) {
+		order = append(order, "handler")
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/", nil))
+	if want := []string{"handler"}; !reflect.DeepEqual(order, want) {
+		t.Errorf("empty Chain: order = %q; want %q", order, want)
+	}
+}
+
+func TestServeMuxUseWrapsOnce(t *testing.T) {
+	wraps := 0
+	counting := func(h Handler) Handler {
+		wraps++
+		return h
+	}
+	mux := NewServeMux()
+	mux.HandleFunc("/a", func(w ResponseWriter, r *Request) {})
+	mux.Use(counting)
+	serve := func(path string) {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+	for i := 0; i < 3; i++ {
+		serve("/a")
+	}
+	if wraps != 1 {
+		t.Errorf("after 3 requests to /a: %d wraps; want 1", wraps)
+	}
+	mux.Use(Chain())
+	serve("/a")
+	serve("/a")
+	if wraps != 2 {
+		t.Errorf("after Use and 2 more requests: %d wraps; want 2", wraps)
+	}
+	serve("/missing")
+	serve("/missing")
+	if wraps != 4 {
+		t.Errorf("after 2 requests to /missing: %d wraps; want 4", wraps)
+	}
+}
+
+func TestServeMuxHandleWithTimeouts(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	readErr := make(chan error, 1)
+	mux := NewServeMux()
+	mux.HandleWithTimeouts("/upload", HandlerFunc(func(w ResponseWriter, r *Request) {
+		_, err := io.ReadAll(r.Body)
+		readErr <- err
+	}), 50*time.Millisecond, 0)
+	mux.HandleWithTimeouts("/quick", HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "quick")
+	}), 0, 100*time.Millisecond)
+	mux.HandleFunc("/slow", func(w ResponseWriter, r *Request) {
+		time.Sleep(200 * time.Millisecond)
+		io.WriteString(w, "slow")
+	})
+	ts := httptest.NewUnstartedServer(mux)
+	ts.Config.ReadTimeout = time.Minute
+	ts.Start()
+	defer ts.Close()
+	c := ts.Client()
+
+	// The route's read timeout replaces the server's.
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	go io.WriteString(pw, "partial")
+	go func() {
+		res, err := c.Post(ts.URL+"/upload", "text/plain", pr)
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	select {
+	case err := <-readErr:
+		if ne, ok := err.(net.Error); !ok || !ne.Timeout() {
+			t.Errorf("reading upload body: %v; want timeout", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("route read timeout did not expire")
+	}
+
+	// The route's write deadline doesn't outlive its response
+	// on a connection reused for another route.
+	for _, path := range []string{"/quick", "/slow"} {
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		b, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil || string(b) != path[1:] {
+			t.Errorf("GET %s = %q, %v", path, b, err)
+		}
+	}
+}
+
 // Issue 24297
 func TestServeMuxHandleFuncWithNilHandler(t *testing.T) {
 	setParallel(t)
@@ -455,6 +674,225 @@ func TestServeMuxHandlerRedirects(t *testing.T) {
 	}
 }
 
+func TestServeMuxCounters(t *testing.T) {
+	setParallel(t)
+	mux := NewServeMux()
+	mux.HandleFunc("/", func(w ResponseWriter, r *Request) {})
+	mux.HandleFunc("/a/", func(w ResponseWriter, r *Request) {})
+	mux.HandleFunc("example.com/b", func(w ResponseWriter, r *Request) {})
+	serve := func(host, path string) {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Host = host
+		mux.ServeHTTP(httptest.NewRecorder(), r)
+	}
+	serve("foo.com", "/x")
+	if c := mux.Counts(); c != nil {
+		t.Fatalf("Counts before EnableCounters = %v; want nil", c)
+	}
+
+	mux.EnableCounters()
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serve("foo.com", "/a/1")
+		}()
+	}
+	wg.Wait()
+	serve("foo.com", "/x")
+	serve("foo.com", "/a")     // redirected to /a/, not counted
+	serve("foo.com", "/a//b")  // redirected to /a/b, not counted
+	serve("example.com", "/b") // host-specific pattern
+	want := map[string]uint64{"/": 1, "/a/": 10, "example.com/b": 1}
+	if got := mux.Counts(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Counts = %v; want %v", got, want)
+	}
+}
+
+func TestServeMuxMethods(t *testing.T) {
+	setParallel(t)
+	mux := NewServeMux()
+	handle := func(pattern string) {
+		mux.HandleFunc(pattern, func(w ResponseWriter, r *Request) {})
+	}
+	handle("GET /items/")
+	handle("POST /items/")
+	handle("DELETE /items/special")
+	handle("/items/more/")
+	handle("PUT  /things")
+	handle("DELETE /things")
+	handle("GET /users/{id}")
+	handle("/any")
+	tests := []struct {
+		method, path   string
+		code           int
+		pattern, allow string
+	}{
+		{"GET", "/items/1", 200, "GET /items/", ""},
+		{"POST", "/items/1", 200, "POST /items/", ""},
+		{"DELETE", "/items/special", 200, "DELETE /items/special", ""},
+		{"GET", "/items/more/x", 200, "/items/more/", ""},
+		{"PUT", "/things", 200, "PUT /things", ""},
+		{"PATCH", "/any", 200, "/any", ""},
+		{"PUT", "/items/1", 405, "", "GET, POST"},
+		{"PUT", "/items/special", 405, "", "DELETE, GET, POST"},
+		{"GET", "/things", 405, "", "DELETE, PUT"},
+		{"POST", "/users/1", 405, "", "GET"},
+		{"GET", "/missing", 404, "", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.path, nil)
+		if _, pattern := mux.Handler(req); pattern != tt.pattern {
+			t.Errorf("%s %s: pattern = %q; want %q", tt.method, tt.path, pattern, tt.pattern)
+		}
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, req)
+		if rw.Code != tt.code {
+			t.Errorf("%s %s: status = %d; want %d", tt.method, tt.path, rw.Code, tt.code)
+		}
+		if got := rw.Header().Get("Allow"); got != tt.allow {
+			t.Errorf("%s %s: Allow = %q; want %q", tt.method, tt.path, got, tt.allow)
+		}
+	}
+}
+
+func TestServeMuxPathValues(t *testing.T) {
+	setParallel(t)
+	mux := NewServeMux()
+	for _, pattern := range []string{
+		"/users/{id}/posts/{postID}",
+		"/users/{id}",
+		"/users/me",
+		"/users/{id}/{rest...}",
+		"/users/me/{rest...}",
+		"/files/{path...}",
+		"/static/",
+		"GET /items/{id}",
+	} {
+		pattern := pattern
+		mux.HandleFunc(pattern, func(w ResponseWriter, r *Request) {
+			fmt.Fprintf(w, "%s id=%s postID=%s rest=%s path=%s", pattern,
+				r.PathValue("id"), r.PathValue("postID"), r.PathValue("rest"), r.PathValue("path"))
+		})
+	}
+	tests := []struct {
+		method, path string
+		code         int
+		body         string
+	}{
+		{"GET", "/users/42/posts/7", 200, "/users/{id}/posts/{postID} id=42 postID=7 rest= path="},
+		{"GET", "/users/42", 200, "/users/{id} id=42 postID= rest= path="},
+		{"GET", "/users/me", 200, "/users/me id= postID= rest= path="},
+		{"GET", "/users/42/a/b", 200, "/users/{id}/{rest...} id=42 postID= rest=a/b path="},
+		{"GET", "/users/me/posts/7", 200, "/users/me/{rest...} id= postID= rest=posts/7 path="},
+		{"GET", "/files/a/b/c.txt", 200, "/files/{path...} id= postID= rest= path=a/b/c.txt"},
+		{"GET", "/files/", 200, "/files/{path...} id= postID= rest= path="},
+		{"GET", "/static/x", 200, "/static/ id= postID= rest= path="},
+		{"GET", "/items/3", 200, "GET /items/{id} id=3 postID= rest= path="},
+		{"POST", "/items/3", 405, ""},
+		{"GET", "/users/{id}", 200, "/users/{id} id={id} postID= rest= path="},
+		{"GET", "/nope", 404, ""},
+	}
+	for _, tt := range tests {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, httptest.NewRequest(tt.method, tt.path, nil))
+		if rw.Code != tt.code {
+			t.Errorf("%s %s: status = %d; want %d", tt.method, tt.path, rw.Code, tt.code)
+		} else if tt.code == 200 && rw.Body.String() != tt.body {
+			t.Errorf("%s %s: got %q; want %q", tt.method, tt.path, rw.Body.String(), tt.body)
+		}
+	}
+
+	for _, pattern := range []string{"/a/{x}/{x}", "/a/{x...}/b", "/a/{}", "/a/b{x}", "{host}/a"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Handle(%q) didn't panic", pattern)
+				}
+			}()
+			NewServeMux().HandleFunc(pattern, func(ResponseWriter, *Request) {})
+		}()
+	}
+
+	// Patterns matching the same requests as a registered one panic.
+	for _, pattern := range []string{"/users/{postID}", "/users/{x}/posts/{y}", "/files/{rest...}", "/static/{x...}", "GET /items/{x}"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Handle(%q) after registering a pattern of the same shape didn't panic", pattern)
+				}
+			}()
+			mux.HandleFunc(pattern, func(ResponseWriter, *Request) {})
+		}()
+	}
+	// Patterns of other shapes, or with another method, don't.
+	for _, pattern := range []string{"/users/{id}/posts/{postID}/", "POST /items/{id}", "/items/{id}", "/users/{id}/posts/"} {
+		mux.HandleFunc(pattern, func(ResponseWriter, *Request) {})
+	}
+}
+
+// Patterns with braces, which were literal before wildcards, are
+// wildcards or invalid now, unless GODEBUG=httpmuxgo121=1.
+func TestServeMuxLiteralBraces(t *testing.T) {
+	get := func(mux *ServeMux, path string) int {
+		rw := httptest.NewRecorder()
+		mux.ServeHTTP(rw, httptest.NewRequest("GET", path, nil))
+		return rw.Code
+	}
+	h := func(ResponseWriter, *Request) {}
+
+	mux := NewServeMux()
+	mux.HandleFunc("/{id}", h)
+	if code := get(mux, "/42"); code != 200 {
+		t.Errorf("GET /42 with pattern /{id}: status = %d; want 200", code)
+	}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("Handle(%q) didn't panic", "/a{b}")
+			}
+		}()
+		NewServeMux().HandleFunc("/a{b}", h)
+	}()
+
+	t.Setenv("GODEBUG", "httpmuxgo121=1")
+	mux = NewServeMux()
+	mux.HandleFunc("/{id}", h)
+	mux.HandleFunc("/a{b}", h)
+	mux.HandleFunc("/{x}/", h)
+	for _, tt := range []struct {
+		path string
+		code int
+	}{
+		{"/{id}", 200},
+		{"/42", 404},
+		{"/a{b}", 200},
+		{"/{x}/y", 200},
+		{"/z/y", 404},
+	} {
+		if code := get(mux, tt.path); code != tt.code {
+			t.Errorf("with httpmuxgo121=1: GET %s: status = %d; want %d", tt.path, code, tt.code)
+		}
+	}
+}
+
+func TestRequestSetPathValue(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	if v := r.PathValue("id"); v != "" {
+		t.Errorf("PathValue before SetPathValue = %q; want empty", v)
+	}
+	r.SetPathValue("id", "1")
+	r2 := r.Clone(context.Background())
+	r2.SetPathValue("id", "2")
+	if v := r.PathValue("id"); v != "1" {
+		t.Errorf("PathValue = %q after setting it on a clone; want 1", v)
+	}
+	if v := r2.PathValue("id"); v != "2" {
+		t.Errorf("clone's PathValue = %q; want 2", v)
+	}
+}
+
 // Tests for https://golang.org/issue/900
 func TestMuxRedirectLeadingSlashes(t *testing.T) {
 	setParallel(t)
@@ -1422,52 +1860,1037 @@ func TestTLSHandshakeTimeout(t *testing.T) {
 	}
 }
 
-func TestTLSServer(t *testing.T) {
+func TestServerConnStats(t *testing.T) {
 	setParallel(t)
 	defer afterTest(t)
-	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
-		if r.TLS != nil {
-			w.Header().Set("X-TLS-Set", "true")
-			if r.TLS.HandshakeComplete {
-				w.Header().Set("X-TLS-HandshakeComplete", "true")
-			}
+	states := make(chan ConnState, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/hijack" {
+			c, _, _ := w.(Hijacker).Hijack()
+			c.Close()
 		}
 	}))
-	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.Config.ConnState = func(c net.Conn, st ConnState) { states <- st }
+	ts.Start()
 	defer ts.Close()
+	waitState := func(want ConnState) {
+		t.Helper()
+		for st := range states {
+			if st == want {
+				return
+			}
+		}
+	}
 
-	// Connect an idle TCP connection to this server before we run
-	// our real tests. This idle connection used to block forever
-	// in the TLS handshake, preventing future connections from
-	// being accepted. It may prevent future accidental blocking
-	// in newConn.
-	idleConn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	// One connection is kept idle after a request, one never sends
+	// one, one is hijacked, and one is closed.
+	c := ts.Client()
+	res, err := c.Get(ts.URL)
 	if err != nil {
-		t.Fatalf("Dial: %v", err)
+		t.Fatal(err)
 	}
-	defer idleConn.Close()
+	res.Body.Close()
+	waitState(StateIdle)
 
-	if !strings.HasPrefix(ts.URL, "https://") {
-		t.Errorf("expected test TLS server to start with https://, got %q", ts.URL)
-		return
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
 	}
-	client := ts.Client()
-	res, err := client.Get(ts.URL)
+	defer conn.Close()
+	waitState(StateNew)
+
+	for _, path := range []string{"/hijack", "/close"} {
+		tr := &Transport{DisableKeepAlives: true}
+		defer tr.CloseIdleConnections()
+		if res, err := (&Client{Transport: tr}).Get(ts.URL + path); err == nil {
+			res.Body.Close()
+		}
+	}
+	waitState(StateHijacked)
+	waitState(StateClosed)
+
+	want := ConnStats{New: 1, Idle: 1, Accepted: 4, Closed: 1, Hijacked: 1}
+	if got := ts.Config.ConnStats(); got != want {
+		t.Errorf("ConnStats() = %+v; want %+v", got, want)
+	}
+}
+
+func TestServerMaxConcurrentHandshakes(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	newConn := make(chan net.Conn, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.MaxConcurrentHandshakes = 1
+	ts.Config.ErrorLog = quietLog
+	ts.Config.ConnState = func(c net.Conn, state ConnState) {
+		if state == StateNew {
+			newConn <- c
+		}
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	// A connection that never sends a ClientHello holds the only slot.
+	hold, err := net.Dial("tcp", ts.Listener.Addr().String())
 	if err != nil {
-		t.Error(err)
-		return
+		t.Fatal(err)
 	}
-	if res == nil {
-		t.Errorf("got nil Response")
-		return
+	defer hold.Close()
+	<-newConn
+
+	c := ts.Client()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := NewRequestWithContext(ctx, "GET", ts.URL, nil)
+	if res, err := c.Do(req); err == nil {
+		res.Body.Close()
+		t.Fatal("request succeeded while the handshake slot was held")
 	}
-	defer res.Body.Close()
-	if res.Header.Get("X-TLS-Set") != "true" {
-		t.Errorf("expected X-TLS-Set response header")
-		return
+
+	hold.Close()
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("Get after freeing the handshake slot: %v", err)
+	}
+	res.Body.Close()
+}
+
+func TestServerAcceptQueueLimit(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	newConn := make(chan net.Conn, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.AcceptQueueLimit = 1
+	ts.Config.ConnState = func(c net.Conn, state ConnState) {
+		if state == StateNew {
+			newConn <- c
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	addr := ts.Listener.Addr().String()
+
+	dial := func() net.Conn {
+		t.Helper()
+		c, err := net.Dial("tcp", addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	notAccepted := func() {
+		t.Helper()
+		select {
+		case <-newConn:
+			t.Fatal("connection accepted while the queue was full")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	// A connection that sends nothing fills the queue, so a second
+	// one waits in the listener's backlog.
+	first := dial()
+	defer first.Close()
+	<-newConn
+	second := dial()
+	defer second.Close()
+	notAccepted()
+
+	// Once the first connection sends a request, the second is
+	// accepted, and fills the queue in turn.
+	io.WriteString(first, "GET / HTTP/1.1\r\nHost: foo\r\n\r\n")
+	if _, err := ReadResponse(bufio.NewReader(first), nil); err != nil {
+		t.Fatal(err)
+	}
+	<-newConn
+	third := dial()
+	defer third.Close()
+	notAccepted()
+
+	// Closing the second connection makes room for the third.
+	second.Close()
+	<-newConn
+}
+
+func TestServerMaxConcurrentHandshakesShutdown(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	errc := make(chanWriter, 10)
+	newConn := make(chan net.Conn, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.MaxConcurrentHandshakes = 1
+	ts.Config.ErrorLog = log.New(errc, "", 0)
+	ts.Config.ConnState = func(c net.Conn, state ConnState) {
+		if state == StateNew {
+			newConn <- c
+		}
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	var conns []net.Conn
+	for i := 0; i < 2; i++ {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		<-newConn
+	}
+
+	// Shutting down the server drops the queued connection while
+	// the first one still holds the slot.
+	shutdownDone := make(chan error, 1)
+	go func() { shutdownDone <- ts.Config.Shutdown(context.Background()) }()
+	timeout := time.After(5 * time.Second)
+	for dropped := false; !dropped; {
+		select {
+		case v := <-errc:
+			dropped = strings.Contains(v, ErrServerClosed.Error())
+		case <-timeout:
+			t.Fatal("timeout waiting for the queued handshake to be dropped")
+		}
+	}
+
+	conns[0].Close()
+	select {
+	case err := <-shutdownDone:
+		if err != nil {
+			t.Errorf("Shutdown: %v", err)
+		}
+	case <-timeout:
+		t.Fatal("timeout waiting for Shutdown")
+	}
+}
+
+func TestRequestTLSInfo(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	type info struct {
+		proto       string
+		resumed, ok bool
+	}
+	infoc := make(chan info, 1)
+	h := HandlerFunc(func(w ResponseWriter, r *Request) {
+		proto, resumed, ok := r.TLSInfo()
+		infoc <- info{proto, resumed, ok}
+	})
+	for _, tt := range []struct {
+		name         string
+		serverProtos []string
+		clientProtos []string
+		want         string
+	}{
+		{"http/1.1", []string{"h2", "http/1.1"}, []string{"http/1.1"}, "http/1.1"},
+		{"no client ALPN", []string{"h2", "http/1.1"}, nil, ""},
+		{"h2-only server", []string{"h2"}, []string{"http/1.1"}, ""},
+	} {
+		ts := httptest.NewUnstartedServer(h)
+		ts.TLS = &tls.Config{NextProtos: tt.serverProtos}
+		ts.StartTLS()
+		tr := ts.Client().Transport.(*Transport)
+		tr.TLSClientConfig.NextProtos = tt.clientProtos
+		tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+		tr.DisableKeepAlives = true
+		for i := 0; i < 2; i++ {
+			res, err := ts.Client().Get(ts.URL)
+			if err != nil {
+				t.Fatalf("%s: %v", tt.name, err)
+			}
+			res.Body.Close()
+			// The second connection resumes the session of the first.
+			if got, want := <-infoc, (info{tt.want, i == 1, true}); got != want {
+				t.Errorf("%s: connection %d: TLSInfo = %+v; want %+v", tt.name, i, got, want)
+			}
+		}
+		ts.Close()
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	if proto, resumed, ok := req.TLSInfo(); proto != "" || resumed || ok {
+		t.Errorf("without TLS: TLSInfo = %q, %v, %v; want empty", proto, resumed, ok)
+	}
+}
+
+func TestTLSServer(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewTLSServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.TLS != nil {
+			w.Header().Set("X-TLS-Set", "true")
+			if r.TLS.HandshakeComplete {
+				w.Header().Set("X-TLS-HandshakeComplete", "true")
+			}
+		}
+	}))
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	defer ts.Close()
+
+	// Connect an idle TCP connection to this server before we run
+	// our real tests. This idle connection used to block forever
+	// in the TLS handshake, preventing future connections from
+	// being accepted. It may prevent future accidental blocking
+	// in newConn.
+	idleConn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatalf("Dial: %v", err)
+	}
+	defer idleConn.Close()
+
+	if !strings.HasPrefix(ts.URL, "https://") {
+		t.Errorf("expected test TLS server to start with https://, got %q", ts.URL)
+		return
+	}
+	client := ts.Client()
+	res, err := client.Get(ts.URL)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	if res == nil {
+		t.Errorf("got nil Response")
+		return
+	}
+	defer res.Body.Close()
+	if res.Header.Get("X-TLS-Set") != "true" {
+		t.Errorf("expected X-TLS-Set response header")
+		return
+	}
+	if res.Header.Get("X-TLS-HandshakeComplete") != "true" {
+		t.Errorf("expected X-TLS-HandshakeComplete header")
+	}
+}
+
+func TestServerTLSHandshakeDone(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		fmt.Fprintf(w, "%v", r.TLS.DidResume)
+	}))
+	resumed := make(chan bool, 2)
+	ts.Config.TLSHandshakeDone = func(c net.Conn, cs tls.ConnectionState) {
+		resumed <- cs.DidResume
+	}
+	ts.StartTLS()
+	defer ts.Close()
+
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	tr.DisableKeepAlives = true
+	tr.TLSClientConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+	for i, want := range []bool{false, true} {
+		res, err := c.Get(ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := <-resumed; got != want {
+			t.Errorf("request %d: TLSHandshakeDone DidResume = %v; want %v", i, got, want)
+		}
+		if got := string(body); got != fmt.Sprint(want) {
+			t.Errorf("request %d: Request.TLS.DidResume = %v; want %v", i, got, want)
+		}
+		if got := res.TLS.DidResume; got != want {
+			t.Errorf("request %d: Response.TLS.DidResume = %v; want %v", i, got, want)
+		}
+	}
+}
+
+func TestServerConfigureConn(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	errc := make(chanWriter, 10)
+	var reject int32 // accessed atomically
+	ts.Config.ConfigureConn = func(c net.Conn) error {
+		tc, ok := c.(*net.TCPConn)
+		if !ok {
+			t.Errorf("ConfigureConn got %T; want *net.TCPConn", c)
+		} else if err := tc.SetNoDelay(false); err != nil {
+			t.Errorf("SetNoDelay: %v", err)
+		}
+		if atomic.LoadInt32(&reject) != 0 {
+			return errors.New("rejected")
+		}
+		return nil
+	}
+	ts.Config.ErrorLog = log.New(errc, "", 0)
+	ts.Start()
+	defer ts.Close()
+
+	c := ts.Client()
+	c.Transport.(*Transport).DisableKeepAlives = true
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	atomic.StoreInt32(&reject, 1)
+	if res, err := c.Get(ts.URL); err == nil {
+		res.Body.Close()
+		t.Fatal("Get succeeded on a connection rejected by ConfigureConn")
+	}
+	select {
+	case v := <-errc:
+		if !strings.Contains(v, "ConfigureConn error") || !strings.Contains(v, "rejected") {
+			t.Errorf("unexpected error log %q", v)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout waiting for logged error")
+	}
+}
+
+func TestServerStatusCodeRewriter(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		code, _ := strconv.Atoi(r.FormValue("code"))
+		w.Header().Set("Content-Length", "4")
+		w.WriteHeader(code)
+		io.WriteString(w, "body")
+	}))
+	ts.Config.StatusCodeRewriter = func(code int) int {
+		switch code {
+		case StatusTeapot:
+			return StatusBadRequest
+		case StatusGone:
+			return StatusNoContent
+		case StatusConflict:
+			return StatusEarlyHints
+		}
+		return code
+	}
+	ts.Config.ErrorLog = log.New(io.Discard, "", 0)
+	ts.Start()
+	defer ts.Close()
+
+	// All the requests are sent on one connection, which would be
+	// desynchronized if a rewritten response were framed for the
+	// handler's code.
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	br := bufio.NewReader(c)
+	for _, tt := range []struct {
+		code, want int
+		body       string
+	}{
+		{StatusTeapot, StatusBadRequest, "body"},
+		{StatusGone, StatusNoContent, ""},
+		{StatusOK, StatusOK, "body"},
+		{StatusConflict, StatusConflict, "body"}, // 1xx rewrites are ignored
+		{StatusNotFound, StatusNotFound, "body"},
+	} {
+		fmt.Fprintf(c, "GET /?code=%d HTTP/1.1\r\nHost: foo\r\n\r\n", tt.code)
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("handler code %d: %v", tt.code, err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.StatusCode != tt.want || string(body) != tt.body {
+			t.Errorf("handler code %d: got %d with body %q; want %d with body %q", tt.code, res.StatusCode, body, tt.want, tt.body)
+		}
+		if tt.want == StatusNoContent && res.Header.Get("Content-Length") != "" {
+			t.Errorf("handler code %d: 204 response has Content-Length %q", tt.code, res.Header.Get("Content-Length"))
+		}
+	}
+}
+
+func TestServerOnFirstByte(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	const delay = 50 * time.Millisecond
+	type call struct {
+		path string
+		ttfb time.Duration
+	}
+	calls := make(chan call, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		time.Sleep(delay)
+		if r.URL.Path == "/write" {
+			io.WriteString(w, "a")
+			if len(calls) != 1 {
+				t.Errorf("OnFirstByte not called by first Write")
+			}
+			w.WriteHeader(StatusTeapot) // superfluous
+			io.WriteString(w, "b")
+		}
+	}))
+	ts.Config.OnFirstByte = func(r *Request, ttfb time.Duration) {
+		calls <- call{r.URL.Path, ttfb}
+	}
+	ts.Config.ErrorLog = quietLog
+	ts.Start()
+	defer ts.Close()
+
+	for _, path := range []string{"/write", "/empty"} {
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		select {
+		case c := <-calls:
+			if c.path != path || c.ttfb < delay {
+				t.Errorf("%s: OnFirstByte called for %s with %v; want at least %v", path, c.path, c.ttfb, delay)
+			}
+		default:
+			t.Fatalf("%s: OnFirstByte not called", path)
+		}
+		if len(calls) != 0 {
+			t.Errorf("%s: OnFirstByte called more than once", path)
+		}
+	}
+}
+
+func TestServerMaxResponseBodyBytes(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	type result struct {
+		written int
+		err     error
+		ctxErr  error
+	}
+	results := make(chan result, 10)
+	tooLarge := make(chan string, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/stream" {
+			AllowLargeResponseBody(r.Context())
+		}
+		n, _ := strconv.Atoi(r.FormValue("n"))
+		chunk := 100
+		if c := r.FormValue("chunk"); c != "" {
+			chunk, _ = strconv.Atoi(c)
+		}
+		var res result
+		for res.written < n {
+			if _, res.err = io.WriteString(w, strings.Repeat("x", chunk)); res.err != nil {
+				break
+			}
+			res.written += chunk
+		}
+		res.ctxErr = r.Context().Err()
+		results <- res
+	}))
+	ts.Config.MaxResponseBodyBytes = 5000
+	ts.Config.OnResponseBodyTooLarge = func(r *Request) { tooLarge <- r.URL.Path }
+	ts.Start()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		path    string
+		n       int
+		chunk   int
+		wantErr bool
+	}{
+		{"/small", 5000, 100, false},
+		{"/big", 8000, 100, true},
+		{"/first", 6000, 6000, true},
+		{"/stream", 8000, 100, false},
+	} {
+		res, err := ts.Client().Get(ts.URL + tt.path + "?n=" + strconv.Itoa(tt.n) + "&chunk=" + strconv.Itoa(tt.chunk))
+		var body []byte
+		code := 0
+		if err == nil {
+			code = res.StatusCode
+			body, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		r := <-results
+		if tt.wantErr {
+			// Data accepted before the limit is sent, in a truncated
+			// response; a response with nothing sent yet becomes an
+			// error.
+			accepted := 5000 / tt.chunk * tt.chunk
+			if accepted == 0 {
+				if err != nil || code != StatusInternalServerError {
+					t.Errorf("%s: client got status %d, %v; want 500", tt.path, code, err)
+				}
+			} else if err == nil || len(body) != accepted {
+				t.Errorf("%s: client read %d bytes, %v; want truncated response of %d bytes", tt.path, len(body), err, accepted)
+			}
+			if r.written != accepted || r.err != ErrResponseBodyTooLarge || r.ctxErr == nil {
+				t.Errorf("%s: handler wrote %d bytes, got %v, context error %v; want %d bytes, ErrResponseBodyTooLarge, canceled context", tt.path, r.written, r.err, r.ctxErr, accepted)
+			}
+			if p := <-tooLarge; p != tt.path {
+				t.Errorf("%s: OnResponseBodyTooLarge called for %s", tt.path, p)
+			}
+			continue
+		}
+		if err != nil || len(body) != tt.n || r.err != nil {
+			t.Errorf("%s: client got %d bytes, %v; handler error %v; want %d bytes", tt.path, len(body), err, r.err, tt.n)
+		}
+	}
+	if len(tooLarge) != 0 {
+		t.Errorf("OnResponseBodyTooLarge called for %s", <-tooLarge)
+	}
+}
+
+// ReadFrom must not send bodies with sendfile past MaxResponseBodyBytes.
+func TestServerMaxResponseBodyBytesReadFrom(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	const size = 100000
+	tooLarge := make(chan bool, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/length" {
+			w.Header().Set("Content-Length", strconv.Itoa(size))
+		}
+		// Hide the WriterTo method of strings.Reader, so that
+		// io.Copy uses the ResponseWriter's ReadFrom.
+		io.Copy(w, struct{ io.Reader }{strings.NewReader(strings.Repeat("x", size))})
+	}))
+	ts.Config.MaxResponseBodyBytes = 1000
+	ts.Config.OnResponseBodyTooLarge = func(r *Request) { tooLarge <- true }
+	ts.Start()
+	defer ts.Close()
+
+	for _, tt := range []struct {
+		path, proto string
+	}{
+		{"/length", "HTTP/1.1"},
+		{"/length", "HTTP/1.0"},
+		{"/", "HTTP/1.1"},
+		{"/", "HTTP/1.0"},
+	} {
+		c, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The connection is closed after an aborted response.
+		c.SetDeadline(time.Now().Add(10 * time.Second))
+		fmt.Fprintf(c, "GET %s %s\r\nHost: foo\r\n\r\n", tt.path, tt.proto)
+		got, _ := io.ReadAll(c)
+		c.Close()
+		// Nothing was sent before the first large write, so the
+		// response is replaced by an error.
+		if !bytes.Contains(got, []byte(" 500 ")) || len(got) > 1000 {
+			t.Errorf("%s %s: got %d bytes, %.20q...; want a 500 response", tt.proto, tt.path, len(got), got)
+		}
+		select {
+		case <-tooLarge:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s %s: OnResponseBodyTooLarge not called", tt.proto, tt.path)
+		}
+	}
+}
+
+func TestServerOnBodyWithBodylessStatus(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	type call struct {
+		path string
+		code int
+	}
+	calls := make(chan call, 10)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		code, _ := strconv.Atoi(r.URL.Path[1:])
+		w.Header().Set("Content-Length", "4")
+		w.WriteHeader(code)
+		for i := 0; i < 2; i++ {
+			if n, err := io.WriteString(w, "body"); n != 0 || err != ErrBodyNotAllowed {
+				t.Errorf("%d: Write = %d, %v; want 0, ErrBodyNotAllowed", code, n, err)
+			}
+		}
+	}))
+	ts.Config.OnBodyWithBodylessStatus = func(r *Request, code int) {
+		calls <- call{r.URL.Path, code}
+	}
+	ts.Start()
+	defer ts.Close()
+
+	for _, code := range []int{StatusNoContent, StatusNotModified} {
+		path := "/" + strconv.Itoa(code)
+		res, err := ts.Client().Get(ts.URL + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(res.Body)
+		res.Body.Close()
+		if res.StatusCode != code || len(body) != 0 {
+			t.Errorf("%s: got status %d with body %q; want %d with no body", path, res.StatusCode, body, code)
+		}
+		if cl, ok := res.Header["Content-Length"]; ok {
+			t.Errorf("%s: got Content-Length %q; want none", path, cl)
+		}
+		select {
+		case c := <-calls:
+			if c.path != path || c.code != code {
+				t.Errorf("%s: OnBodyWithBodylessStatus called for %s with %d", path, c.path, c.code)
+			}
+		default:
+			t.Fatalf("%s: OnBodyWithBodylessStatus not called", path)
+		}
+		if len(calls) != 0 {
+			t.Errorf("%s: OnBodyWithBodylessStatus called more than once", path)
+		}
+	}
+}
+
+func TestServerMaxBufferedResponseBytes(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	aWrote := make(chan bool)
+	unblockA := make(chan bool)
+	bWrote := make(chan bool, 1)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/a":
+			io.WriteString(w, "aaaaaaaa")
+			aWrote <- true
+			<-unblockA
+		case "/b":
+			io.WriteString(w, "bbbbb")
+			bWrote <- true
+		case "/big":
+			io.WriteString(w, strings.Repeat("x", 100))
+		}
+	}))
+	ts.Config.MaxBufferedResponseBytes = 10
+	ts.Start()
+	defer ts.Close()
+	c := ts.Client()
+
+	get := func(path string, errc chan<- error) {
+		res, err := c.Get(ts.URL + path)
+		if err == nil {
+			_, err = io.ReadAll(res.Body)
+			res.Body.Close()
+		}
+		errc <- err
+	}
+	errc := make(chan error, 2)
+	go get("/a", errc)
+	<-aWrote
+	go get("/b", errc)
+	select {
+	case <-bWrote:
+		t.Fatal("handler b's write didn't wait for handler a's buffered data")
+	case <-time.After(100 * time.Millisecond):
+	}
+	close(unblockA)
+	<-bWrote
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A write larger than the limit goes through on its own.
+	go get("/big", errc)
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestServerMaxHeaderValueBytes(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	ts.Config.MaxHeaderValueBytes = 100
+	ts.Start()
+	defer ts.Close()
+
+	long := strings.Repeat("x", 200)
+	tests := []struct {
+		req  string
+		code int
+	}{
+		{"GET / HTTP/1.1\r\nHost: foo\r\nX-Short: " + long[:80] + "\r\n\r\n", 200},
+		{"GET / HTTP/1.1\r\nHost: foo\r\nX-Long: " + long + "\r\n\r\n", 431},
+		{"GET / HTTP/1.1\r\nHost: foo\r\nX-Long: " + strings.Repeat(long, 1<<10), 431}, // never ends
+		{"GET /" + long + " HTTP/1.1\r\nHost: foo\r\n\r\n", 431},
+		{"POST / HTTP/1.1\r\nHost: foo\r\nContent-Length: 200\r\n\r\n" + long, 200},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		go io.WriteString(conn, tt.req)
+		res, err := ReadResponse(bufio.NewReader(conn), nil)
+		conn.Close()
+		if err != nil {
+			t.Errorf("request %.40q...: %v", tt.req, err)
+			continue
+		}
+		if res.StatusCode != tt.code {
+			t.Errorf("request %.40q...: status %d; want %d", tt.req, res.StatusCode, tt.code)
+		}
+	}
+
+	// The header of a pipelined request is buffered while the
+	// previous request is read, and must be checked as well.
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	go io.WriteString(conn, "POST / HTTP/1.1\r\nHost: foo\r\nContent-Length: 4\r\n\r\nbody"+
+		"GET / HTTP/1.1\r\nHost: foo\r\n\r\n"+
+		"GET / HTTP/1.1\r\nHost: foo\r\nX-Long: "+long+"\r\n\r\n")
+	br := bufio.NewReader(conn)
+	for i, want := range []int{200, 200, 431} {
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("pipelined request %d: %v", i, err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != want {
+			t.Errorf("pipelined request %d: status %d; want %d", i, res.StatusCode, want)
+		}
+	}
+}
+
+func TestServerStrictBodyTermination(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.Copy(io.Discard, r.Body)
+	}))
+	ts.Config.StrictBodyTermination = true
+	ts.Start()
+	defer ts.Close()
+
+	const closeReq = "GET / HTTP/1.1\r\nHost: foo\r\nConnection: close\r\n\r\n"
+	tests := []struct {
+		after     string // sent immediately after the first request's body
+		later     string // sent after the first response
+		responses int    // before the server closes the connection
+	}{
+		{closeReq, "", 2},
+		{"\x00\x01garbage", "", 1},
+		{"GET /\x00", "", 1},
+		{"hello world\r\n", "", 1},
+		{" GET / HTTP/1.1\r\n", "", 1},
+
+		// The CRLF that old clients send after a POST body is
+		// tolerated, even split from the request that follows.
+		{"\r\n", closeReq, 2},
+		{"\n", closeReq, 2},
+		{"\r", "\n" + closeReq, 2},
+		{"\r\n" + closeReq, "", 2},
+	}
+	for _, tt := range tests {
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.SetDeadline(time.Now().Add(10 * time.Second))
+		io.WriteString(conn, "POST / HTTP/1.1\r\nHost: foo\r\nContent-Length: 5\r\n\r\nhello"+tt.after)
+		br := bufio.NewReader(conn)
+		n := 0
+		for {
+			if _, err := br.Peek(1); err == io.EOF {
+				break
+			}
+			res, err := ReadResponse(br, nil)
+			if err != nil {
+				t.Fatalf("after body %q: reading response %d: %v", tt.after, n+1, err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			if res.StatusCode != StatusOK {
+				t.Errorf("after body %q: response %d has status %q", tt.after, n+1, res.Status)
+			}
+			n++
+			if n == 1 && tt.later != "" {
+				io.WriteString(conn, tt.later)
+			}
+		}
+		conn.Close()
+		if n != tt.responses {
+			t.Errorf("after body %q: got %d responses; want %d", tt.after, n, tt.responses)
+		}
+	}
+}
+
+func TestServerRequestBodyTimeout(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	const timeout = 200 * time.Millisecond
+	tests := []struct {
+		name    string
+		resets  bool
+		drip    bool // send the body a byte at a time, for longer than timeout
+		wantErr bool
+	}{
+		{"stall", false, false, true},
+		{"stall resets", true, false, true},
+		{"drip", false, true, true},
+		{"drip resets", true, true, false},
+	}
+	for _, tt := range tests {
+		errc := make(chan error, 1)
+		ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+			time.Sleep(timeout / 4) // slow handler, not counted
+			_, err := io.ReadAll(r.Body)
+			errc <- err
+		}))
+		ts.Config.RequestBodyTimeout = timeout
+		ts.Config.RequestBodyTimeoutResets = tt.resets
+		ts.Start()
+
+		conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		const body = "0123456789"
+		io.WriteString(conn, "POST / HTTP/1.1\r\nHost: foo\r\nContent-Length: 10\r\n\r\n")
+		if tt.drip {
+			go func() {
+				for i := 0; i < len(body); i++ {
+					time.Sleep(timeout / 5)
+					if _, err := io.WriteString(conn, body[i:i+1]); err != nil {
+						return
+					}
+				}
+			}()
+		} else {
+			io.WriteString(conn, body[:5])
+		}
+		select {
+		case err := <-errc:
+			if gotErr := err != nil; gotErr != tt.wantErr {
+				t.Errorf("%s: reading body: err = %v; want error: %v", tt.name, err, tt.wantErr)
+			}
+		case <-time.After(10 * time.Second):
+			t.Errorf("%s: timeout waiting for handler to read body", tt.name)
+		}
+		conn.Close()
+		ts.Close()
+	}
+}
+
+func TestServerPerRequestReadTimeout(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	const timeout = 200 * time.Millisecond
+	errc := make(chan error, 3)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		_, err := io.ReadAll(r.Body)
+		errc <- err
+	}))
+	ts.Config.PerRequestReadTimeout = timeout
+	ts.Start()
+	defer ts.Close()
+
+	conn, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	br := bufio.NewReader(conn)
+	const req = "POST / HTTP/1.1\r\nHost: foo\r\nContent-Length: 10\r\n\r\n"
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			// Idling for longer than the timeout between
+			// requests is allowed, and each request gets the
+			// whole timeout.
+			time.Sleep(timeout * 3 / 2)
+		}
+		io.WriteString(conn, req+"01234")
+		time.Sleep(timeout / 2)
+		io.WriteString(conn, "56789")
+		if err := <-errc; err != nil {
+			t.Fatalf("request %d: reading body: %v", i, err)
+		}
+		res, err := ReadResponse(br, nil)
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		res.Body.Close()
+	}
+
+	// A stalled body is cut off once the timeout has passed.
+	io.WriteString(conn, req+"01234")
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Errorf("stalled request: reading body succeeded; want error")
+		}
+	case <-time.After(10 * time.Second):
+		t.Errorf("timeout waiting for handler to read stalled body")
+	}
+}
+
+// Tests that a write waiting for MaxBufferedResponseBytes returns
+// when the client goes away or the server shuts down.
+func TestServerMaxBufferedResponseBytesWaitEnds(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	aWrote := make(chan bool)
+	unblockA := make(chan bool)
+	bStarted := make(chan bool, 1)
+	bErr := make(chan error, 1)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		switch r.URL.Path {
+		case "/a":
+			io.WriteString(w, "aaaaaaaa")
+			aWrote <- true
+			<-unblockA
+		case "/b":
+			bStarted <- true
+			_, err := io.WriteString(w, "bbbbb")
+			bErr <- err
+		}
+	}))
+	ts.Config.MaxBufferedResponseBytes = 10
+	ts.Start()
+	defer ts.Close()
+	defer close(unblockA)
+
+	go func() {
+		res, err := ts.Client().Get(ts.URL + "/a")
+		if err == nil {
+			res.Body.Close()
+		}
+	}()
+	<-aWrote
+
+	// The client of b gives up.
+	c, err := net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(c, "GET /b HTTP/1.1\r\nHost: foo\r\n\r\n")
+	<-bStarted
+	c.Close()
+	if err := <-bErr; err == nil {
+		t.Error("write waiting after the client went away succeeded")
+	}
+
+	// The server shuts down.
+	c, err = net.Dial("tcp", ts.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
 	}
-	if res.Header.Get("X-TLS-HandshakeComplete") != "true" {
-		t.Errorf("expected X-TLS-HandshakeComplete header")
+	defer c.Close()
+	io.WriteString(c, "GET /b HTTP/1.1\r\nHost: foo\r\n\r\n")
+	<-bStarted
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	go ts.Config.Shutdown(ctx)
+	if err := <-bErr; err != ErrServerClosed {
+		t.Errorf("write waiting during Shutdown: err = %v; want ErrServerClosed", err)
 	}
 }
 
@@ -1710,6 +3133,7 @@ var serverExpectTests = []serverExpectTest{
 
 	// Non-standard expectations are failures
 	expectTest(0, "a-pony", false, "417 Expectation Failed"),
+	expectTest(0, "100-continue, a-pony", false, "417 Expectation Failed"),
 
 	// Expect-100 requested but no body (is apparently okay: Issue 7625)
 	expectTest(0, "100-continue", true, "200 OK"),
@@ -2960,6 +4384,154 @@ func TestStripPrefixNotModifyRequest(t *testing.T) {
 	}
 }
 
+func TestFormatByExtension(t *testing.T) {
+	handler := func(name string) Handler {
+		return HandlerFunc(func(w ResponseWriter, r *Request) {
+			format, _ := r.Context().Value(FormatContextKey).(string)
+			fmt.Fprintf(w, "%s %s %s %s", name, format, r.URL.Path, r.URL.RawPath)
+		})
+	}
+	h := FormatByExtension(map[string]Handler{
+		"json": handler("J"),
+		"xml":  handler("X"),
+	}, "json")
+
+	tests := []struct {
+		path string
+		want string // If empty we want a 404.
+	}{
+		{"/users/42.json", "J json /users/42 "},
+		{"/users/42.xml", "X xml /users/42 "},
+		{"/users/42", "J json /users/42 "},
+		{"/users/42.csv", "J json /users/42.csv "},
+		{"/users.xml/42", "J json /users.xml/42 "},
+		{"/users/.xml", "J json /users/.xml "},
+		{"/a%2Fb.xml", "X xml /a/b /a%2Fb"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", tt.path, nil)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Body.String(); got != tt.want {
+			t.Errorf("%s: got %q; want %q", tt.path, got, tt.want)
+		}
+		if got := req.URL.EscapedPath(); got != tt.path {
+			t.Errorf("%s: FormatByExtension modified the provided Request path to %q", tt.path, got)
+		}
+	}
+
+	h = FormatByExtension(map[string]Handler{"xml": handler("X")}, "json")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/users/42", nil))
+	if rec.Code != StatusNotFound {
+		t.Errorf("missing default format: got %d; want 404", rec.Code)
+	}
+}
+
+func TestRestrictToNetworks(t *testing.T) {
+	h := RestrictToNetworks(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}), []netip.Prefix{
+		netip.MustParsePrefix("127.0.0.0/8"),
+		netip.MustParsePrefix("10.1.0.0/16"),
+		netip.MustParsePrefix("::1/128"),
+		netip.MustParsePrefix("fe80::/10"),
+		netip.MustParsePrefix("::ffff:192.168.0.0/112"),
+	})
+	tests := []struct {
+		remoteAddr string
+		allowed    bool
+	}{
+		{"127.0.0.1:1234", true},
+		{"10.1.2.3:80", true},
+		{"10.2.0.1:80", false},
+		{"[::1]:1234", true},
+		{"[::ffff:127.0.0.1]:1234", true},
+		{"[fe80::1%eth0]:1234", true},
+		{"[2001:db8::1]:1234", false},
+		{"192.0.2.1:1234", false},
+		{"192.168.3.4:1234", true},
+		{"[::ffff:192.168.3.4]:1234", true},
+		{"192.169.0.1:1234", false},
+		{"127.0.0.1", false}, // no port
+		{"", false},
+		{"@", false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.RemoteAddr = tt.remoteAddr
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if got := rec.Code == StatusOK; got != tt.allowed {
+			t.Errorf("RemoteAddr %q: got status %d; want allowed = %v", tt.remoteAddr, rec.Code, tt.allowed)
+		}
+		if !tt.allowed && rec.Code != StatusForbidden {
+			t.Errorf("RemoteAddr %q: got status %d; want 403", tt.remoteAddr, rec.Code)
+		}
+	}
+}
+
+func TestPatchHandler(t *testing.T) {
+	h := PatchHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}), []string{"application/json-patch+json", "application/merge-patch+json"})
+	const wantAcceptPatch = "application/json-patch+json, application/merge-patch+json"
+	tests := []struct {
+		method      string
+		contentType string
+		wantCode    int
+	}{
+		{"PATCH", "application/json-patch+json", StatusOK},
+		{"PATCH", "Application/Merge-Patch+JSON; charset=utf-8", StatusOK},
+		{"PATCH", "application/json", StatusUnsupportedMediaType},
+		{"PATCH", "", StatusUnsupportedMediaType},
+		{"PATCH", "application/json-patch+json; =", StatusUnsupportedMediaType},
+		{"OPTIONS", "", StatusOK},
+		{"POST", "application/json", StatusOK},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, "/", strings.NewReader("[]"))
+		if tt.contentType != "" {
+			req.Header.Set("Content-Type", tt.contentType)
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		if rec.Code != tt.wantCode {
+			t.Errorf("%s with Content-Type %q: got status %d; want %d", tt.method, tt.contentType, rec.Code, tt.wantCode)
+		}
+		if got := rec.Header().Get("Accept-Patch"); got != wantAcceptPatch {
+			t.Errorf("%s with Content-Type %q: Accept-Patch = %q; want %q", tt.method, tt.contentType, got, wantAcceptPatch)
+		}
+	}
+}
+
+func TestDeprecationHandler(t *testing.T) {
+	sunset := time.Date(2030, time.June, 30, 23, 59, 59, 0, time.FixedZone("x", 3600))
+	h := DeprecationHandler(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Add("Link", "</next>; rel=\"next\"")
+		io.WriteString(w, "ok")
+	}), sunset, "https://example.com/deprecation")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+	res := rec.Result()
+	want := Header{
+		"Content-Type": {"text/plain; charset=utf-8"},
+		"Deprecation":  {"true"},
+		"Sunset":       {"Sun, 30 Jun 2030 22:59:59 GMT"},
+		"Link":         {"<https://example.com/deprecation>; rel=\"sunset\"", "</next>; rel=\"next\""},
+	}
+	if !reflect.DeepEqual(res.Header, want) {
+		t.Errorf("header = %v; want %v", res.Header, want)
+	}
+	if got, ok := res.Sunset(); !ok || !got.Equal(sunset) {
+		t.Errorf("Sunset() = %v, %v; want %v, true", got, ok, sunset)
+	}
+	res.Header.Set("Sunset", "soon")
+	if got, ok := res.Sunset(); ok {
+		t.Errorf("Sunset() with invalid header = %v, true; want false", got)
+	}
+}
+
 func TestRequestLimit_h1(t *testing.T) { testRequestLimit(t, h1Mode) }
 func TestRequestLimit_h2(t *testing.T) { testRequestLimit(t, h2Mode) }
 func testRequestLimit(t *testing.T, h2 bool) {
@@ -4960,6 +6532,43 @@ func testServerContext_LocalAddrContextKey(t *testing.T, h2 bool) {
 	}
 }
 
+func TestServerContext_ListenerAddrContextKey(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	fromConnContext := make(chan any, 2)
+	srv := &Server{
+		Handler: HandlerFunc(func(w ResponseWriter, r *Request) {
+			fmt.Fprint(w, r.Context().Value(ListenerAddrContextKey))
+		}),
+		ConnContext: func(ctx context.Context, c net.Conn) context.Context {
+			fromConnContext <- ctx.Value(ListenerAddrContextKey)
+			return ctx
+		},
+	}
+	defer srv.Close()
+	for i := 0; i < 2; i++ {
+		ln := newLocalListener(t)
+		go srv.Serve(ln)
+		addr := ln.Addr().String()
+
+		res, err := Get("http://" + addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != addr {
+			t.Errorf("listener %d: handler got listener addr %q; want %q", i, body, addr)
+		}
+		if got := <-fromConnContext; fmt.Sprint(got) != addr {
+			t.Errorf("listener %d: ConnContext got listener addr %v; want %v", i, got, addr)
+		}
+	}
+}
+
 // https://golang.org/issue/15960
 func TestHandlerSetTransferEncodingChunked(t *testing.T) {
 	setParallel(t)
@@ -5651,6 +7260,96 @@ func testServerShutdown(t *testing.T, h2 bool) {
 	}
 }
 
+func TestServerShutdownWithTimeout_h1(t *testing.T) { testServerShutdownWithTimeout(t, h1Mode) }
+func TestServerShutdownWithTimeout_h2(t *testing.T) { testServerShutdownWithTimeout(t, h2Mode) }
+
+func testServerShutdownWithTimeout(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	started := make(chan bool, 3)
+	unblock := make(chan bool)
+	gotOnShutdown := make(chan bool, 1)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/hijack" {
+			c, _, err := w.(Hijacker).Hijack()
+			if err != nil {
+				t.Errorf("Hijack: %v", err)
+				started <- true
+				return
+			}
+			defer c.Close()
+		}
+		started <- true
+		<-unblock
+	}), func(ts *httptest.Server) {
+		ts.Config.RegisterOnShutdown(func() {
+			time.Sleep(10 * time.Millisecond)
+			gotOnShutdown <- true
+		})
+		ts.Config.ErrorLog = quietLog
+	})
+	defer cst.close()
+	defer close(unblock)
+
+	// Two requests are in progress, on two HTTP/1 connections or one
+	// HTTP/2 connection. A hijacked HTTP/1 connection isn't counted.
+	const requests = 2
+	errc := make(chan error, requests)
+	for i := 0; i < requests; i++ {
+		go func() {
+			res, err := cst.c.Get(cst.ts.URL)
+			if err == nil {
+				_, err = io.ReadAll(res.Body)
+				res.Body.Close()
+			}
+			errc <- err
+		}()
+		<-started
+	}
+	if !h2 {
+		go cst.c.Get(cst.ts.URL + "/hijack")
+		<-started
+	}
+
+	forced, err := cst.ts.Config.ShutdownWithTimeout(50 * time.Millisecond)
+	if forced != requests || err != nil {
+		t.Errorf("ShutdownWithTimeout = %d, %v; want %d forcibly terminated requests", forced, err, requests)
+	}
+	select {
+	case <-gotOnShutdown:
+	default:
+		t.Errorf("onShutdown callback not done before connections were closed")
+	}
+	for i := 0; i < requests; i++ {
+		if err := <-errc; err == nil {
+			t.Errorf("request in progress succeeded; want error after forced close")
+		}
+	}
+}
+
+func TestServerShutdownWithTimeoutWaitsForOnShutdown(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	done := make(chan bool, 1)
+	ts.Config.RegisterOnShutdown(func() {
+		time.Sleep(50 * time.Millisecond)
+		done <- true
+	})
+	ts.Start()
+	defer ts.Close()
+
+	// With no connections, only the callback holds up the shutdown.
+	if forced, err := ts.Config.ShutdownWithTimeout(10 * time.Second); forced != 0 || err != nil {
+		t.Errorf("ShutdownWithTimeout = %d, %v; want 0, nil", forced, err)
+	}
+	select {
+	case <-done:
+	default:
+		t.Errorf("ShutdownWithTimeout returned before the onShutdown callback")
+	}
+}
+
 func TestServerShutdownStateNew(t *testing.T) {
 	if testing.Short() {
 		t.Skip("test takes 5-6 seconds; skipping in short mode")
@@ -5728,6 +7427,251 @@ func TestServerCloseDeadlock(t *testing.T) {
 	s.Close()
 }
 
+func TestServerActiveConns_h1(t *testing.T) { testServerActiveConns(t, h1Mode) }
+func TestServerActiveConns_h2(t *testing.T) { testServerActiveConns(t, h2Mode) }
+func testServerActiveConns(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	defer cst.close()
+	srv := cst.ts.Config
+	for i := 0; i < 3; i++ {
+		res, err := cst.c.Get(cst.ts.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+	}
+	conns := srv.ActiveConns()
+	if len(conns) != 1 {
+		t.Fatalf("ActiveConns = %+v; want 1 connection", conns)
+	}
+	wantProto := "http/1.1"
+	if h2 {
+		wantProto = "h2"
+	}
+	if c := conns[0]; c.Protocol != wantProto || c.Requests != 3 || !strings.HasPrefix(c.RemoteAddr, "127.0.0.1:") {
+		t.Errorf("ActiveConns()[0] = %+v; want 3 %s requests from 127.0.0.1", c, wantProto)
+	}
+
+	if n := srv.CloseConnsByAddr(netip.MustParseAddr("192.0.2.1")); n != 0 {
+		t.Errorf("CloseConnsByAddr(192.0.2.1) = %d; want 0", n)
+	}
+	if n := srv.CloseConnsByAddr(netip.MustParseAddr("::ffff:127.0.0.1")); n != 1 {
+		t.Errorf("CloseConnsByAddr(::ffff:127.0.0.1) = %d; want 1", n)
+	}
+	if conns := srv.ActiveConns(); len(conns) != 0 {
+		t.Errorf("after CloseConnsByAddr, ActiveConns = %+v; want none", conns)
+	}
+	// The client notices the closed connection and dials a new one.
+	if !waitCondition(2*time.Second, 10*time.Millisecond, func() bool {
+		res, err := cst.c.Get(cst.ts.URL)
+		if err != nil {
+			return false
+		}
+		res.Body.Close()
+		return true
+	}) {
+		t.Fatal("requests fail after CloseConnsByAddr")
+	}
+}
+
+// Tests that ActiveConns doesn't deadlock with a concurrent Hijack,
+// which takes the locks it needs in the opposite order.
+func TestServerActiveConnsHijack(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		c, _, err := w.(Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		c.Close()
+	}))
+	defer ts.Close()
+	done := make(chan struct{})
+	defer close(done)
+	for i := 0; i < 2; i++ {
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				default:
+					ts.Config.ActiveConns()
+				}
+			}
+		}()
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				c, err := net.Dial("tcp", ts.Listener.Addr().String())
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				io.WriteString(c, "GET / HTTP/1.1\r\nHost: foo\r\n\r\n")
+				io.Copy(io.Discard, c)
+				c.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestServerCloseOnStatus_h1(t *testing.T) { testServerCloseOnStatus(t, h1Mode) }
+func TestServerCloseOnStatus_h2(t *testing.T) { testServerCloseOnStatus(t, h2Mode) }
+func testServerCloseOnStatus(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		code, _ := strconv.Atoi(r.FormValue("code"))
+		w.WriteHeader(code)
+	}), func(ts *httptest.Server) {
+		ts.Config.CloseOnStatus = []int{StatusInternalServerError, StatusBadGateway}
+	})
+	defer cst.close()
+	srv := cst.ts.Config
+	get := func(code int) {
+		t.Helper()
+		res, err := cst.c.Get(cst.ts.URL + "/?code=" + strconv.Itoa(code))
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != code {
+			t.Fatalf("status = %d; want %d", res.StatusCode, code)
+		}
+		if wantClose := code == StatusBadGateway; !h2 && res.Close != wantClose {
+			t.Errorf("status %d: Response.Close = %v", code, res.Close)
+		}
+	}
+	get(StatusOK)
+	get(StatusNotFound)
+	if n := len(srv.ActiveConns()); n != 1 {
+		t.Fatalf("after successful requests, %d active connections; want 1", n)
+	}
+	get(StatusBadGateway)
+	if !waitCondition(5*time.Second, 10*time.Millisecond, func() bool { return len(srv.ActiveConns()) == 0 }) {
+		t.Errorf("connection not closed after status %d", StatusBadGateway)
+	}
+	get(StatusOK)
+}
+
+func TestServerAllowedHosts_h1(t *testing.T) { testServerAllowedHosts(t, h1Mode) }
+func TestServerAllowedHosts_h2(t *testing.T) { testServerAllowedHosts(t, h2Mode) }
+func testServerAllowedHosts(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.WriteString(w, "ok")
+	}), func(ts *httptest.Server) {
+		ts.Config.AllowedHosts = []string{"Example.com", "*.example.org", "127.0.0.1", "[::1]", "2001:db8::1"}
+	})
+	defer cst.close()
+	for _, tt := range []struct {
+		host string
+		want int
+	}{
+		{"example.com", StatusOK},
+		{"EXAMPLE.COM.", StatusOK},
+		{"example.com:8080", StatusOK},
+		{"www.example.org", StatusOK},
+		{"a.b.example.org:443", StatusOK},
+		{"example.org", StatusMisdirectedRequest},
+		{"badexample.org", StatusMisdirectedRequest},
+		{"example.com.evil.net", StatusMisdirectedRequest},
+		{"127.0.0.1:1234", StatusOK},
+		{"[::1]:80", StatusOK},
+		{"[2001:db8:0::1]", StatusOK},
+		{"[::2]", StatusMisdirectedRequest},
+		{"evil.net", StatusMisdirectedRequest},
+	} {
+		req, _ := NewRequest("GET", cst.ts.URL, nil)
+		req.Host = tt.host
+		res, err := cst.c.Do(req)
+		if err != nil {
+			t.Fatalf("Host %q: %v", tt.host, err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Errorf("Host %q: status = %d; want %d", tt.host, res.StatusCode, tt.want)
+		}
+	}
+}
+
+func TestServerHandleExpect_h1(t *testing.T) { testServerHandleExpect(t, h1Mode) }
+func TestServerHandleExpect_h2(t *testing.T) { testServerHandleExpect(t, h2Mode) }
+func testServerHandleExpect(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	var (
+		mu   sync.Mutex
+		seen []string
+	)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.Copy(io.Discard, r.Body)
+		io.WriteString(w, "ok")
+	}), func(ts *httptest.Server) {
+		ts.Config.HandleExpect = func(r *Request) (bool, int) {
+			e := r.Header.Get("Expect")
+			mu.Lock()
+			seen = append(seen, e)
+			mu.Unlock()
+			switch e = strings.ToLower(e); {
+			case strings.Contains(e, "x-ok"):
+				return true, 0
+			case strings.Contains(e, "x-precondition"):
+				return false, StatusPreconditionFailed
+			}
+			return false, StatusOK // not an error status
+		}
+	})
+	defer cst.close()
+	for _, tt := range []struct {
+		expect string
+		body   string
+		want   int
+	}{
+		{"", "", StatusOK},
+		{"x-ok", "", StatusOK},
+		{"X-OK, 100-continue", "body", StatusOK},
+		{"x-precondition", "", StatusPreconditionFailed},
+		{"a-pony", "", StatusExpectationFailed},
+	} {
+		var body io.Reader
+		if tt.body != "" {
+			body = strings.NewReader(tt.body)
+		}
+		req, _ := NewRequest("POST", cst.ts.URL, body)
+		if tt.expect != "" {
+			req.Header.Set("Expect", tt.expect)
+		}
+		res, err := cst.c.Do(req)
+		if err != nil {
+			t.Fatalf("Expect %q: %v", tt.expect, err)
+		}
+		io.Copy(io.Discard, res.Body)
+		res.Body.Close()
+		if res.StatusCode != tt.want {
+			t.Errorf("Expect %q: status = %d; want %d", tt.expect, res.StatusCode, tt.want)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []string{"x-ok", "X-OK, 100-continue", "x-precondition", "a-pony"}; !reflect.DeepEqual(seen, want) {
+		t.Errorf("HandleExpect saw %q; want %q", seen, want)
+	}
+}
+
 // Issue 17717: tests that Server.SetKeepAlivesEnabled is respected by
 // both HTTP/1 and HTTP/2.
 func TestServerKeepAlivesEnabled_h1(t *testing.T) { testServerKeepAlivesEnabled(t, h1Mode) }
@@ -6273,6 +8217,59 @@ func TestUnsupportedTransferEncodingsReturn501(t *testing.T) {
 	}
 }
 
+func TestServerAllowTransferEncodings(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		b, err := io.ReadAll(r.Body)
+		fmt.Fprintf(w, "%v %q %v", r.TransferEncoding, b, err)
+	}))
+	ts.Config.AllowTransferEncodings = []string{"gzip"}
+	ts.Config.MaxDecodedBodyBytes = 100
+	ts.Config.ErrorLog = quietLog
+	ts.Start()
+	defer ts.Close()
+
+	gzipped := func(s string) string {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		io.WriteString(zw, s)
+		zw.Close()
+		return fmt.Sprintf("%x\r\n%s\r\n0\r\n\r\n", buf.Len(), buf.Bytes())
+	}
+	for _, tt := range []struct {
+		te, body string
+		want     string
+	}{
+		{"gzip, chunked", gzipped("hello"), `200 OK [chunked] "hello" <nil>`},
+		{"X-Gzip,chunked", gzipped("hello"), `200 OK [chunked] "hello" <nil>`},
+		{"gzip, chunked", gzipped(strings.Repeat("a", 101)), "http: request body too large"},
+		{"gzip, chunked", "5\r\nhello\r\n0\r\n\r\n", `200 OK [chunked] "" unexpected EOF`},
+		{"chunked", "5\r\nhello\r\n0\r\n\r\n", `200 OK [chunked] "hello" <nil>`},
+		{"chunked, gzip", gzipped("hello"), "501 Not Implemented"},
+		{"gzip", gzipped("hello"), "501 Not Implemented"},
+		{"deflate, chunked", gzipped("hello"), "501 Not Implemented"},
+		{"gzip, , chunked", gzipped("hello"), "501 Not Implemented"},
+	} {
+		req := "POST / HTTP/1.1\r\nHost: foo\r\nConnection: close\r\n" +
+			"Transfer-Encoding: " + tt.te + "\r\n\r\n" + tt.body
+		got, err := fetchWireResponse(ts.Listener.Addr().String(), []byte(req))
+		if err != nil {
+			t.Errorf("Transfer-Encoding %q: %v", tt.te, err)
+			continue
+		}
+		res, err := ReadResponse(bufio.NewReader(bytes.NewReader(got)), nil)
+		if err != nil {
+			t.Errorf("Transfer-Encoding %q: %v", tt.te, err)
+			continue
+		}
+		b, _ := io.ReadAll(res.Body)
+		if s := res.Status + " " + string(b); !strings.Contains(s, tt.want) {
+			t.Errorf("Transfer-Encoding %q: got %q; want %q", tt.te, s, tt.want)
+		}
+	}
+}
+
 func TestContentEncodingNoSniffing_h1(t *testing.T) {
 	testContentEncodingNoSniffing(t, h1Mode)
 }
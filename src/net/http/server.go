@@ -9,6 +9,7 @@ package http
 import (
 	"bufio"
 	"bytes"
+	"compress/gzip"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -17,7 +18,10 @@ import (
 	"io"
 	"log"
 	"math/rand"
+	"mime"
 	"net"
+	"net/http/internal/ascii"
+	"net/netip"
 	"net/textproto"
 	"net/url"
 	urlpkg "net/url"
@@ -53,6 +57,11 @@ var (
 	// declared.
 	ErrContentLength = errors.New("http: wrote more than the declared Content-Length")
 
+	// ErrResponseBodyTooLarge is returned by ResponseWriter.Write
+	// calls once the response body would exceed the Server's
+	// MaxResponseBodyBytes.
+	ErrResponseBodyTooLarge = errors.New("http: response body too large")
+
 	// Deprecated: ErrWriteAfterFlush is no longer returned by
 	// anything in the net/http package. Callers should not
 	// compare errors against this variable.
@@ -136,6 +145,15 @@ type ResponseWriter interface {
 	// writing the response. However, such behavior may not be supported
 	// by all HTTP/2 clients. Handlers should read before writing if
 	// possible to maximize compatibility.
+	//
+	// If an HTTP/1.x handler writes a response without reading the
+	// whole request body, the server reads and discards a limited
+	// amount of the rest of the body so that the connection can be
+	// reused. If the body doesn't end within that limit, the server
+	// closes the connection after the response. A handler that knows
+	// it won't read the rest of the body, for instance after
+	// rejecting the request, can skip that read with
+	// ResponseController.AbortRequestBody.
 	Write([]byte) (int, error)
 
 	// WriteHeader sends an HTTP response header with the provided
@@ -242,10 +260,30 @@ var (
 	// address the connection arrived on.
 	// The associated value will be of type net.Addr.
 	LocalAddrContextKey = &contextKey{"local-addr"}
+
+	// ListenerAddrContextKey is a context key. It can be used in
+	// ConnContext functions and HTTP handlers with Context.Value to
+	// access the address of the listener the connection was accepted
+	// on, which tells apart connections from different listeners
+	// served by the same Server.
+	// The associated value will be of type net.Addr.
+	ListenerAddrContextKey = &contextKey{"listener-addr"}
+
+	// FormatContextKey is a context key. It can be used in HTTP
+	// handlers invoked by a FormatByExtension handler with
+	// Context.Value to access the selected format.
+	// The associated value will be of type string.
+	FormatContextKey = &contextKey{"format"}
 )
 
 // A conn represents the server side of an HTTP connection.
 type conn struct {
+	// bufferedResponse is the number of bytes written by handlers
+	// that count against Server.MaxBufferedResponseBytes and have
+	// not yet been written to rwc. It is accessed atomically, and
+	// is first for 64-bit alignment on 32-bit platforms.
+	bufferedResponse int64
+
 	// server is the server on which the connection arrived.
 	// Immutable; never nil.
 	server *Server
@@ -292,13 +330,27 @@ type conn struct {
 
 	curState struct{ atomic uint64 } // packed (unixtime<<8|uint8(ConnState))
 
-	// mu guards hijackedv
+	// requests is the number of requests read from the connection.
+	// It is accessed atomically.
+	requests int64
+
+	// acceptSem, if non-nil, is the Server's AcceptQueueLimit
+	// semaphore, which holds a value for the connection while it is
+	// in StateNew.
+	acceptSem chan struct{}
+
+	// mu guards hijackedv and nextProto
 	mu sync.Mutex
 
 	// hijackedv is whether this connection has been hijacked
 	// by a Handler with the Hijacker interface.
 	// It is guarded by mu.
 	hijackedv bool
+
+	// nextProto is the ALPN protocol of the TLSNextProto function
+	// that took over the connection, if any.
+	// It is guarded by mu.
+	nextProto string
 }
 
 func (c *conn) hijacked() bool {
@@ -313,6 +365,7 @@ func (c *conn) hijackLocked() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
 		return nil, nil, ErrHijacked
 	}
 	c.r.abortPendingRead()
+	c.r.setBodyTimeout(0, time.Time{})
 
 	c.hijackedv = true
 	rwc = c.rwc
@@ -472,6 +525,36 @@ type response struct {
 
 	handlerDone atomicBool // set true when the handler exits
 
+	// handlerStart is when the handler was called, if
+	// Server.OnFirstByte is set.
+	handlerStart time.Time
+
+	// noChunking is whether SetPreferredTransferEncoding ruled out
+	// chunking, making a response of unknown length close-delimited.
+	noChunking bool
+
+	// reportedBodyless is whether Server.OnBodyWithBodylessStatus
+	// has been called for the response.
+	reportedBodyless bool
+
+	// bodyLimitHit is whether the body exceeded the Server's
+	// MaxResponseBodyBytes, aborting the response. bodyLimitOff is
+	// set by AllowLargeResponseBody; accessed atomically.
+	bodyLimitHit bool
+	bodyLimitOff int32
+
+	// writeCoalesceDelay is the maximum delay set by
+	// SetWriteCoalescing, or zero if write coalescing is disabled.
+	// It is only accessed by the handler goroutine.
+	writeCoalesceDelay time.Duration
+
+	// writeCoalesceMu guards w.w and the conn's bufw, and the fields
+	// below, while write coalescing is enabled, since coalesced data
+	// is flushed by writeCoalesceTimer on its own goroutine.
+	writeCoalesceMu      sync.Mutex
+	writeCoalesceTimer   *time.Timer
+	writeCoalescePending bool // writeCoalesceTimer will flush
+
 	// Buffers for Date, Content-Length, and status code
 	dateBuf   [len(TimeFormat)]byte
 	clenBuf   [10]byte
@@ -573,7 +656,9 @@ func (w *response) ReadFrom(src io.Reader) (n int64, err error) {
 	// own ReadFrom method). If not, just fall back to the normal
 	// copy method.
 	rf, ok := w.conn.rwc.(io.ReaderFrom)
-	if !ok {
+	if !ok || w.bodyLimited() {
+		// A body limited by MaxResponseBodyBytes is written by
+		// w.write, which enforces the limit.
 		return io.CopyBuffer(writerOnly{w}, src, buf)
 	}
 
@@ -589,8 +674,9 @@ func (w *response) ReadFrom(src io.Reader) (n int64, err error) {
 		}
 	}
 
-	w.w.Flush()  // get rid of any previous writes
-	w.cw.flush() // make sure Header is written; flush data to rwc
+	// Get rid of any previous writes, make sure Header is written,
+	// and flush data to rwc.
+	w.Flush()
 
 	// Now that cw has been flushed, its chunking field is guaranteed initialized.
 	if !w.cw.chunking && w.bodyAllowed() {
@@ -643,6 +729,21 @@ type connReader struct {
 	inRead  bool
 	aborted bool  // set true before conn.rwc deadline is set to past
 	remain  int64 // bytes remaining
+
+	// bodyTimeout, if non-zero, is the Server.RequestBodyTimeout
+	// to restart after each read making progress, up to bodyMaxDeadline
+	// (if non-zero).
+	bodyTimeout     time.Duration
+	bodyMaxDeadline time.Time
+
+	// maxLine, if positive, is the Server.MaxHeaderValueBytes limit
+	// on the request header lines being read. lineLen is the length
+	// of the current line, sawLine is whether a non-empty line was
+	// read, and lineTooLong is whether a line exceeded maxLine.
+	maxLine     int
+	lineLen     int
+	sawLine     bool
+	lineTooLong bool
 }
 
 func (cr *connReader) lock() {
@@ -664,6 +765,7 @@ func (cr *connReader) startBackgroundRead() {
 		return
 	}
 	cr.inRead = true
+	cr.bodyTimeout = 0
 	cr.conn.rwc.SetReadDeadline(time.Time{})
 	go cr.backgroundRead()
 }
@@ -722,6 +824,55 @@ func (cr *connReader) abortPendingRead() {
 	cr.conn.rwc.SetReadDeadline(time.Time{})
 }
 
+// setBodyTimeout makes reads restart the connection's read deadline
+// to d from now, but no later than maxDeadline if non-zero. A zero d
+// stops it.
+func (cr *connReader) setBodyTimeout(d time.Duration, maxDeadline time.Time) {
+	cr.lock()
+	defer cr.unlock()
+	cr.bodyTimeout = d
+	cr.bodyMaxDeadline = maxDeadline
+}
+
+// setMaxHeaderLine starts limiting the length of each request header
+// line read to n bytes, if positive, until the end of the header.
+func (cr *connReader) setMaxHeaderLine(n int) {
+	cr.maxLine = n
+	cr.lineLen = 0
+	cr.sawLine = false
+	cr.lineTooLong = false
+}
+
+// countHeaderLines tracks the length of the request header lines in
+// p, the next bytes of the header, whether read from the connection
+// or already buffered. When a line is longer than maxLine, it sets
+// lineTooLong and makes reads stop as if the read limit were hit.
+func (cr *connReader) countHeaderLines(p []byte) {
+	for _, b := range p {
+		if cr.maxLine <= 0 {
+			return
+		}
+		switch b {
+		case '\n':
+			if cr.lineLen == 0 && cr.sawLine {
+				cr.maxLine = 0 // end of header
+				return
+			}
+			cr.sawLine = cr.sawLine || cr.lineLen > 0
+			cr.lineLen = 0
+		case '\r':
+		default:
+			cr.lineLen++
+			if cr.lineLen > cr.maxLine {
+				cr.lineTooLong = true
+				cr.remain = 0
+				cr.maxLine = 0
+				return
+			}
+		}
+	}
+}
+
 func (cr *connReader) setReadLimit(remain int64) { cr.remain = remain }
 func (cr *connReader) setInfiniteReadLimit()     { cr.remain = maxInt64 }
 func (cr *connReader) hitReadLimit() bool        { return cr.remain <= 0 }
@@ -772,6 +923,7 @@ func (cr *connReader) Read(p []byte) (n int, err error) {
 	if cr.hasByte {
 		p[0] = cr.byteBuf[0]
 		cr.hasByte = false
+		cr.countHeaderLines(p[:1])
 		cr.unlock()
 		return 1, nil
 	}
@@ -785,6 +937,14 @@ func (cr *connReader) Read(p []byte) (n int, err error) {
 		cr.handleReadError(err)
 	}
 	cr.remain -= int64(n)
+	cr.countHeaderLines(p[:n])
+	if n > 0 && cr.bodyTimeout > 0 {
+		dl := time.Now().Add(cr.bodyTimeout)
+		if !cr.bodyMaxDeadline.IsZero() && cr.bodyMaxDeadline.Before(dl) {
+			dl = cr.bodyMaxDeadline
+		}
+		cr.conn.rwc.SetReadDeadline(dl)
+	}
 	cr.unlock()
 
 	cr.cond.Broadcast()
@@ -887,6 +1047,44 @@ func (srv *Server) tlsHandshakeTimeout() time.Duration {
 	return ret
 }
 
+// acquireHandshake waits for one of the MaxConcurrentHandshakes slots,
+// giving up at the handshake deadline dl, if non-zero, when ctx is
+// done, or when the server shuts down. The returned release func frees
+// the slot.
+func (srv *Server) acquireHandshake(ctx context.Context, dl time.Time) (release func(), err error) {
+	if srv.MaxConcurrentHandshakes <= 0 {
+		return func() {}, nil
+	}
+	srv.mu.Lock()
+	if srv.handshakeSem == nil {
+		srv.handshakeSem = make(chan struct{}, srv.MaxConcurrentHandshakes)
+	}
+	sem := srv.handshakeSem
+	srv.mu.Unlock()
+	release = func() { <-sem }
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	default:
+	}
+	var timeout <-chan time.Time
+	if !dl.IsZero() {
+		t := time.NewTimer(time.Until(dl))
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case sem <- struct{}{}:
+		return release, nil
+	case <-timeout:
+		return nil, errors.New("timed out waiting for a handshake slot")
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-srv.getDoneChan():
+		return nil, ErrServerClosed
+	}
+}
+
 // wrapper around io.ReadCloser which on first read, sends an
 // HTTP/1.1 100 Continue header
 type expectContinueReader struct {
@@ -923,6 +1121,47 @@ func (ecr *expectContinueReader) Close() error {
 	return ecr.readCloser.Close()
 }
 
+func (srv *Server) maxDecodedBodyBytes() int64 {
+	if srv.MaxDecodedBodyBytes > 0 {
+		return srv.MaxDecodedBodyBytes
+	}
+	return 10 << 20
+}
+
+// transferDecoder decodes a request body sent with the transfer codings
+// allowed by Server.AllowTransferEncodings. The decoders are created on
+// the first Read, so that reading their headers doesn't block before
+// the Handler asks for the body.
+type transferDecoder struct {
+	src     io.ReadCloser
+	codings []string // all "gzip"
+	r       io.Reader
+	err     error
+}
+
+func (d *transferDecoder) Read(p []byte) (int, error) {
+	if d.r == nil && d.err == nil {
+		d.r = d.src
+		for range d.codings {
+			zr, err := gzip.NewReader(d.r)
+			if err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				d.err = err
+				break
+			}
+			d.r = zr
+		}
+	}
+	if d.err != nil {
+		return 0, d.err
+	}
+	return d.r.Read(p)
+}
+
+func (d *transferDecoder) Close() error { return d.src.Close() }
+
 // TimeFormat is the time format to use when generating times in HTTP
 // headers. It is like time.RFC1123 but hard-codes GMT as the time
 // zone. The time being formatted must be in UTC for Format to
@@ -972,26 +1211,48 @@ func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
 	if d := c.server.ReadTimeout; d > 0 {
 		wholeReqDeadline = t0.Add(d)
 	}
+	if d := c.server.PerRequestReadTimeout; d > 0 {
+		if dl := t0.Add(d); wholeReqDeadline.IsZero() || dl.Before(wholeReqDeadline) {
+			wholeReqDeadline = dl
+		}
+		if hdrDeadline.IsZero() || wholeReqDeadline.Before(hdrDeadline) {
+			hdrDeadline = wholeReqDeadline
+		}
+	}
 	c.rwc.SetReadDeadline(hdrDeadline)
 	if d := c.server.WriteTimeout; d > 0 {
 		defer func() {
 			c.rwc.SetWriteDeadline(time.Now().Add(d))
 		}()
+	} else {
+		// Clear any deadline a handler set for the previous response.
+		c.rwc.SetWriteDeadline(time.Time{})
 	}
 
 	c.r.setReadLimit(c.server.initialReadLimitSize())
+	c.r.setMaxHeaderLine(c.server.MaxHeaderValueBytes)
+	if c.server.MaxHeaderValueBytes > 0 {
+		// The start of the header may already be buffered, read
+		// along with a previous request, so count lines from there.
+		buffered, _ := c.bufr.Peek(c.bufr.Buffered())
+		c.r.countHeaderLines(buffered)
+	}
 	if c.lastMethod == "POST" {
 		// RFC 7230 section 3 tolerance for old buggy clients.
 		peek, _ := c.bufr.Peek(4) // ReadRequest will get err below
 		c.bufr.Discard(numLeadingCRorLF(peek))
 	}
-	req, err := readRequest(c.bufr)
+	req, err := readRequest(c.bufr, c.server.AllowTransferEncodings)
 	if err != nil {
 		if c.r.hitReadLimit() {
 			return nil, errTooLarge
 		}
 		return nil, err
 	}
+	if c.r.lineTooLong {
+		return nil, errTooLarge
+	}
+	c.r.setMaxHeaderLine(0)
 
 	if !http1ServerSupportsRequest(req) {
 		return nil, statusError{StatusHTTPVersionNotSupported, "unsupported protocol version"}
@@ -1032,6 +1293,14 @@ func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
 	if !hdrDeadline.Equal(wholeReqDeadline) {
 		c.rwc.SetReadDeadline(wholeReqDeadline)
 	}
+	if d := c.server.RequestBodyTimeout; d > 0 && requestBodyRemains(req.Body) {
+		if bodyDeadline := time.Now().Add(d); wholeReqDeadline.IsZero() || bodyDeadline.Before(wholeReqDeadline) {
+			c.rwc.SetReadDeadline(bodyDeadline)
+		}
+		if c.server.RequestBodyTimeoutResets {
+			c.r.setBodyTimeout(d, wholeReqDeadline)
+		}
+	}
 
 	w = &response{
 		conn:          c,
@@ -1053,6 +1322,9 @@ func (c *conn) readRequest(ctx context.Context) (w *response, err error) {
 	}
 	w.cw.res = w
 	w.w = newBufioWriterSize(&w.cw, bufferBeforeChunkingSize)
+	if c.server.MaxResponseBodyBytes > 0 {
+		req.ctx = context.WithValue(req.ctx, responseBodyLimitKey, w)
+	}
 	return w, nil
 }
 
@@ -1143,8 +1415,18 @@ func (w *response) WriteHeader(code int) {
 		return
 	}
 	checkWriteHeaderCode(code)
+	if rewrite := w.conn.server.StatusCodeRewriter; rewrite != nil && code >= 200 {
+		if c := rewrite(code); c >= 200 && c <= 999 {
+			code = c
+		} else {
+			w.conn.server.logf("http: StatusCodeRewriter returned invalid status code %d for %d", c, code)
+		}
+	}
 	w.wroteHeader = true
 	w.status = code
+	if fn := w.conn.server.OnFirstByte; fn != nil && !w.handlerStart.IsZero() {
+		fn(w.req, time.Since(w.handlerStart))
+	}
 
 	if w.calledHeader && w.cw.header == nil {
 		w.cw.header = w.handlerHeader.Clone()
@@ -1313,7 +1595,7 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 		w.closeAfterReply = true
 	}
 
-	if header.get("Connection") == "close" || !keepAlivesEnabled {
+	if header.get("Connection") == "close" || !keepAlivesEnabled || w.conn.server.closesOnStatus(w.status) {
 		w.closeAfterReply = true
 	}
 
@@ -1438,7 +1720,7 @@ func (cw *chunkWriter) writeHeader(p []byte) {
 		// reply is written, and no chunking is to be done. This is the setup
 		// recommended in the Server-Sent Events candidate recommendation 11,
 		// section 8.
-		if hasTE && te == "identity" {
+		if hasTE && te == "identity" || w.noChunking {
 			cw.chunking = false
 			w.closeAfterReply = true
 			delHeader("Transfer-Encoding")
@@ -1604,13 +1886,38 @@ func (w *response) write(lenData int, dataB []byte, dataS string) (n int, err er
 		return 0, nil
 	}
 	if !w.bodyAllowed() {
+		if fn := w.conn.server.OnBodyWithBodylessStatus; fn != nil && !w.reportedBodyless {
+			w.reportedBodyless = true
+			fn(w.req, w.status)
+		}
 		return 0, ErrBodyNotAllowed
 	}
 
+	if w.bodyLimitHit {
+		return 0, ErrResponseBodyTooLarge
+	}
+	if w.bodyLimited() && w.written+int64(lenData) > w.conn.server.MaxResponseBodyBytes {
+		w.hitResponseBodyLimit()
+		return 0, ErrResponseBodyTooLarge
+	}
+
 	w.written += int64(lenData) // ignoring errors, for errorKludge
 	if w.contentLength != -1 && w.written > w.contentLength {
 		return 0, ErrContentLength
 	}
+	if w.conn.server.MaxBufferedResponseBytes > 0 {
+		if err := w.reserveBufferedResponse(int64(lenData)); err != nil {
+			return 0, err
+		}
+	}
+	if w.writeCoalesceDelay > 0 {
+		return w.coalescedWrite(dataB, dataS)
+	}
+	if w.conn.werr != nil {
+		// Don't let the write look successful because it fits
+		// in the buffer after an earlier flush failed.
+		return 0, w.conn.werr
+	}
 	if dataB != nil {
 		return w.w.Write(dataB)
 	} else {
@@ -1618,17 +1925,270 @@ func (w *response) write(lenData int, dataB []byte, dataS string) (n int, err er
 	}
 }
 
+// bodyLimited reports whether the body of w is limited by the Server's
+// MaxResponseBodyBytes.
+func (w *response) bodyLimited() bool {
+	return w.conn.server.MaxResponseBodyBytes > 0 && atomic.LoadInt32(&w.bodyLimitOff) == 0
+}
+
+// hitResponseBodyLimit aborts the response when the handler tries to
+// write more than the Server's MaxResponseBodyBytes, canceling the
+// request's context and reporting the handler.
+func (w *response) hitResponseBodyLimit() {
+	w.bodyLimitHit = true
+	w.closeAfterReply = true
+	w.cancelCtx()
+	if fn := w.conn.server.OnResponseBodyTooLarge; fn != nil {
+		fn(w.req)
+	} else {
+		w.conn.server.logf("http: response to %s %s exceeded MaxResponseBodyBytes", w.req.Method, w.req.URL.Path)
+	}
+}
+
+// replaceWithTooLargeError replaces a response aborted by
+// MaxResponseBodyBytes before anything was sent, which would otherwise
+// go out looking complete, with a 500 Internal Server Error.
+func (w *response) replaceWithTooLargeError() {
+	const body = "Internal Server Error: response body too large\n"
+	w.w.Reset(&w.cw)
+	w.status = StatusInternalServerError
+	w.handlerHeader = Header{
+		"Content-Type":           {"text/plain; charset=utf-8"},
+		"X-Content-Type-Options": {"nosniff"},
+	}
+	w.cw.header = nil
+	w.contentLength = -1
+	w.written = int64(len(body))
+	w.w.WriteString(body)
+}
+
+// responseBodyLimitKey is the context key under which the server stores
+// the *response of a request when MaxResponseBodyBytes is set.
+var responseBodyLimitKey = &contextKey{"response-body-limit"}
+
+// AllowLargeResponseBody exempts from the Server's MaxResponseBodyBytes
+// the response to the request whose context is ctx or an ancestor of
+// ctx. Handlers of endpoints that legitimately stream
+// large responses, or middleware routing to them, call it before
+// writing the body. It has no effect if ctx doesn't belong to an
+// HTTP/1 server request with a MaxResponseBodyBytes limit.
+func AllowLargeResponseBody(ctx context.Context) {
+	if w, ok := ctx.Value(responseBodyLimitKey).(*response); ok {
+		atomic.StoreInt32(&w.bodyLimitOff, 1)
+	}
+}
+
+// reserveBufferedResponse counts n bytes about to be written by the
+// handler against the Server's MaxBufferedResponseBytes, sending the
+// response's own buffered data and waiting for other connections to
+// send theirs while the limit would be exceeded. If the request's
+// context is done or the server shuts down while it waits, it closes
+// the connection, so that the response isn't taken for a complete
+// one, and returns an error.
+func (w *response) reserveBufferedResponse(n int64) error {
+	c := w.conn
+	srv := c.server
+	b := srv.respBuf
+	fits := func(cur int64) bool {
+		return cur == 0 || cur+n <= srv.MaxBufferedResponseBytes
+	}
+	for {
+		if cur := atomic.LoadInt64(&b.n); fits(cur) {
+			if atomic.CompareAndSwapInt64(&b.n, cur, cur+n) {
+				atomic.AddInt64(&c.bufferedResponse, n)
+				return nil
+			}
+			continue
+		}
+		if atomic.LoadInt64(&c.bufferedResponse) > 0 {
+			// Send our own data rather than wait for it.
+			w.Flush()
+			if c.werr != nil {
+				// The connection failed; don't count what it couldn't send.
+				c.releaseBufferedResponse(-1)
+				return c.werr
+			}
+			continue
+		}
+
+		// Register as a waiter before checking again, so that a
+		// release in between wakes us.
+		atomic.AddInt32(&b.waiters, 1)
+		released := b.releasedChan()
+		var err error
+		if !fits(atomic.LoadInt64(&b.n)) {
+			select {
+			case <-released:
+			case <-w.req.Context().Done():
+				err = w.req.Context().Err()
+			case <-srv.getDoneChan():
+				err = ErrServerClosed
+			}
+		}
+		atomic.AddInt32(&b.waiters, -1)
+		if err != nil {
+			c.rwc.Close()
+			return err
+		}
+	}
+}
+
+// releasedChan returns a channel that is closed the next time
+// buffered bytes are released with waiters registered in b.waiters.
+func (b *responseBuffer) releasedChan() <-chan struct{} {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.released == nil {
+		b.released = make(chan struct{})
+	}
+	return b.released
+}
+
+// releaseBufferedResponse stops counting up to n bytes buffered
+// by c against the Server's MaxBufferedResponseBytes, or all of
+// them if n is negative.
+func (c *conn) releaseBufferedResponse(n int64) {
+	for {
+		cur := atomic.LoadInt64(&c.bufferedResponse)
+		m := n
+		if m < 0 || m > cur {
+			m = cur
+		}
+		if m == 0 {
+			return
+		}
+		if atomic.CompareAndSwapInt64(&c.bufferedResponse, cur, cur-m) {
+			n = m
+			break
+		}
+	}
+	b := c.server.respBuf
+	atomic.AddInt64(&b.n, -n)
+	if atomic.LoadInt32(&b.waiters) > 0 {
+		b.mu.Lock()
+		if b.released != nil {
+			close(b.released)
+			b.released = nil
+		}
+		b.mu.Unlock()
+	}
+}
+
+// coalescedWrite writes dataB or dataS while write coalescing is
+// enabled, arranging for the data to be flushed within
+// w.writeCoalesceDelay.
+func (w *response) coalescedWrite(dataB []byte, dataS string) (n int, err error) {
+	w.writeCoalesceMu.Lock()
+	defer w.writeCoalesceMu.Unlock()
+	if w.conn.werr != nil {
+		return 0, w.conn.werr
+	}
+	if dataB != nil {
+		n, err = w.w.Write(dataB)
+	} else {
+		n, err = w.w.WriteString(dataS)
+	}
+	if !w.cw.wroteHeader {
+		// Write the header now, on the handler goroutine, so that
+		// the delayed flush doesn't need to look at it.
+		w.w.Flush()
+	}
+	if !w.writeCoalescePending {
+		w.writeCoalescePending = true
+		if w.writeCoalesceTimer == nil {
+			w.writeCoalesceTimer = time.AfterFunc(w.writeCoalesceDelay, w.coalescedFlush)
+		} else {
+			w.writeCoalesceTimer.Reset(w.writeCoalesceDelay)
+		}
+	}
+	return n, err
+}
+
+// coalescedFlush is called by writeCoalesceTimer to send the data
+// written since write coalescing last flushed.
+func (w *response) coalescedFlush() {
+	w.writeCoalesceMu.Lock()
+	defer w.writeCoalesceMu.Unlock()
+	if !w.writeCoalescePending {
+		return // flushed or stopped in the meantime
+	}
+	w.writeCoalescePending = false
+	w.w.Flush()
+	w.conn.bufw.Flush()
+}
+
+// stopWriteCoalescing disables write coalescing, canceling any
+// pending coalesced flush. The buffered data is left for the
+// caller to flush.
+func (w *response) stopWriteCoalescing() {
+	if w.writeCoalesceDelay == 0 {
+		return
+	}
+	w.writeCoalesceDelay = 0
+	w.writeCoalesceMu.Lock()
+	defer w.writeCoalesceMu.Unlock()
+	w.writeCoalescePending = false
+	if w.writeCoalesceTimer != nil {
+		w.writeCoalesceTimer.Stop()
+	}
+}
+
+// SetWriteCoalescing implements ResponseController.SetWriteCoalescing.
+func (w *response) SetWriteCoalescing(enabled bool, maxDelay time.Duration) error {
+	if w.handlerDone.isSet() {
+		panic("net/http: SetWriteCoalescing called after ServeHTTP finished")
+	}
+	if !enabled {
+		w.stopWriteCoalescing()
+		return nil
+	}
+	if maxDelay <= 0 {
+		return errors.New("http: non-positive write coalescing delay")
+	}
+	if w.conn.hijacked() {
+		return ErrHijacked
+	}
+	w.writeCoalesceDelay = maxDelay
+	return nil
+}
+
+func (w *response) SetPreferredTransferEncoding(chunked, identity bool) error {
+	if w.handlerDone.isSet() {
+		panic("net/http: SetPreferredTransferEncoding called after ServeHTTP finished")
+	}
+	if !chunked && !identity {
+		return errors.New("http: no transfer encoding allowed")
+	}
+	if w.cw.wroteHeader {
+		return errors.New("http: SetPreferredTransferEncoding called after response header was sent")
+	}
+	w.noChunking = !chunked
+	return nil
+}
+
 func (w *response) finishRequest() {
 	w.handlerDone.setTrue()
+	w.stopWriteCoalescing()
 
 	if !w.wroteHeader {
 		w.WriteHeader(StatusOK)
 	}
 
+	if w.bodyLimitHit && !w.cw.wroteHeader {
+		w.replaceWithTooLargeError()
+	}
 	w.w.Flush()
 	putBufioWriter(w.w)
-	w.cw.close()
+	// A response aborted by MaxResponseBodyBytes after its header
+	// was sent is left unterminated, so that the client doesn't take
+	// the truncated body for the whole one. The connection is closed.
+	if !w.bodyLimitHit || !w.cw.wroteHeader {
+		w.cw.close()
+	}
 	w.conn.bufw.Flush()
+	if w.conn.server.MaxBufferedResponseBytes > 0 {
+		w.conn.releaseBufferedResponse(-1)
+	}
 
 	w.conn.r.abortPendingRead()
 
@@ -1669,17 +2229,98 @@ func (w *response) shouldReuseConnection() bool {
 	return true
 }
 
+// AbortRequestBody implements ResponseController.AbortRequestBody.
+func (w *response) AbortRequestBody() error {
+	if w.handlerDone.isSet() {
+		panic("net/http: AbortRequestBody called after ServeHTTP finished")
+	}
+	w.closeAfterReply = true
+	if b, ok := w.reqBody.(*body); ok {
+		b.abort()
+	}
+	return nil
+}
+
+// NegotiatedProtocol implements ResponseController.NegotiatedProtocol.
+func (w *response) NegotiatedProtocol() (string, error) {
+	if w.conn.tlsState == nil {
+		return "", nil
+	}
+	return w.conn.tlsState.NegotiatedProtocol, nil
+}
+
+// SetReadDeadline implements ResponseController.SetReadDeadline.
+func (w *response) SetReadDeadline(deadline time.Time) error {
+	// An explicit deadline replaces the one RequestBodyTimeoutResets
+	// would move forward with each read.
+	w.conn.r.setBodyTimeout(0, time.Time{})
+	return w.conn.rwc.SetReadDeadline(deadline)
+}
+
+// SetWriteDeadline implements ResponseController.SetWriteDeadline.
+func (w *response) SetWriteDeadline(deadline time.Time) error {
+	return w.conn.rwc.SetWriteDeadline(deadline)
+}
+
+// EarlyHints implements ResponseController.EarlyHints.
+func (w *response) EarlyHints(h Header) error {
+	if w.wroteHeader || w.conn.hijacked() {
+		return nil
+	}
+	if !w.req.ProtoAtLeast(1, 1) {
+		return errNotSupported()
+	}
+	// Hold writeContinueMu so the 103 isn't interleaved with a
+	// 100 Continue written by a concurrent read of the request body.
+	w.writeContinueMu.Lock()
+	defer w.writeContinueMu.Unlock()
+	w.conn.bufw.WriteString("HTTP/1.1 103 Early Hints\r\n")
+	h.Write(w.conn.bufw)
+	w.conn.bufw.WriteString("\r\n")
+	return w.conn.bufw.Flush()
+}
+
 func (w *response) closedRequestBodyEarly() bool {
-	body, ok := w.req.Body.(*body)
+	body, ok := w.reqBody.(*body)
 	return ok && body.didEarlyClose()
 }
 
 func (w *response) Flush() {
+	w.FlushError()
+}
+
+// FlushError is like Flush, but returns the error of writing to the
+// connection, if any.
+func (w *response) FlushError() error {
 	if !w.wroteHeader {
 		w.WriteHeader(StatusOK)
 	}
-	w.w.Flush()
+	if w.writeCoalesceDelay > 0 {
+		w.writeCoalesceMu.Lock()
+		defer w.writeCoalesceMu.Unlock()
+		w.writeCoalescePending = false
+	}
+	err := w.w.Flush()
 	w.cw.flush()
+	if err == nil {
+		err = w.conn.werr
+	}
+	return err
+}
+
+// WriteError returns the error of the first failed write of the
+// response to the connection, or ErrHijacked if the connection has
+// been hijacked.
+func (w *response) WriteError() error {
+	if w.conn.hijacked() {
+		return ErrHijacked
+	}
+	if w.writeCoalesceDelay > 0 {
+		// A coalesced flush may be writing.
+		w.writeCoalesceMu.Lock()
+		defer w.writeCoalesceMu.Unlock()
+	}
+	return w.conn.werr
 }
 
 func (c *conn) finalFlush() {
@@ -1692,6 +2333,9 @@ func (c *conn) finalFlush() {
 
 	if c.bufw != nil {
 		c.bufw.Flush()
+		if c.server.MaxBufferedResponseBytes > 0 {
+			c.releaseBufferedResponse(-1)
+		}
 		// Steal the bufio.Writer (~4KB worth of memory) and its associated
 		// writer for a future connection.
 		putBufioWriter(c.bufw)
@@ -1762,7 +2406,18 @@ func (c *conn) setState(nc net.Conn, state ConnState, runHook bool) {
 		panic("internal error")
 	}
 	packedState := uint64(time.Now().Unix()<<8) | uint64(state)
-	atomic.StoreUint64(&c.curState.atomic, packedState)
+	old := atomic.SwapUint64(&c.curState.atomic, packedState)
+	if c.acceptSem != nil && ConnState(old&0xff) == StateNew && state != StateNew {
+		<-c.acceptSem // leave the AcceptQueueLimit queue
+	}
+	if cs := srv.connStats; cs != nil {
+		if state == StateNew {
+			atomic.AddInt64(&cs.accepted, 1)
+		} else {
+			atomic.AddInt64(&cs.states[old&0xff], -1)
+		}
+		atomic.AddInt64(&cs.states[state], 1)
+	}
 	if !runHook {
 		return
 	}
@@ -1771,6 +2426,53 @@ func (c *conn) setState(nc net.Conn, state ConnState, runHook bool) {
 	}
 }
 
+// connCounters holds a Server's connection counts, updated
+// atomically by conn.setState.
+type connCounters struct {
+	states   [StateClosed + 1]int64 // connections in each state, or that ended in it
+	accepted int64
+}
+
+// ConnStats is a snapshot of the connections of a Server, returned by
+// Server.ConnStats.
+type ConnStats struct {
+	// New, Active, and Idle are the numbers of connections in
+	// states StateNew, StateActive, and StateIdle.
+	New, Active, Idle int64
+
+	// Accepted is the number of connections the server has
+	// accepted. Closed is the number it has closed, and Hijacked
+	// the number hijacked from it, which are not counted as closed.
+	Accepted, Closed, Hijacked int64
+}
+
+// ConnStats returns the numbers of the server's connections in each
+// ConnState, as reported to the ConnState hook, and the numbers of
+// connections it has accepted, closed, and had hijacked since the
+// server started serving. The counts are maintained as connections
+// change state, whether or not the ConnState hook is set, and read
+// one at a time, so that a snapshot taken while connections change
+// state may be off by a few transitions.
+//
+// HTTP/2 connections are counted as StateActive from the end of their
+// TLS handshake until they are closed.
+func (srv *Server) ConnStats() ConnStats {
+	srv.mu.Lock()
+	cs := srv.connStats
+	srv.mu.Unlock()
+	if cs == nil {
+		return ConnStats{}
+	}
+	return ConnStats{
+		New:      atomic.LoadInt64(&cs.states[StateNew]),
+		Active:   atomic.LoadInt64(&cs.states[StateActive]),
+		Idle:     atomic.LoadInt64(&cs.states[StateIdle]),
+		Accepted: atomic.LoadInt64(&cs.accepted),
+		Closed:   atomic.LoadInt64(&cs.states[StateClosed]),
+		Hijacked: atomic.LoadInt64(&cs.states[StateHijacked]),
+	}
+}
+
 func (c *conn) getState() (state ConnState, unixSec int64) {
 	packedState := atomic.LoadUint64(&c.curState.atomic)
 	return ConnState(packedState & 0xff), int64(packedState >> 8)
@@ -1827,6 +2529,7 @@ func (c *conn) serve(ctx context.Context) {
 		}
 		if inFlightResponse != nil {
 			inFlightResponse.cancelCtx()
+			inFlightResponse.stopWriteCoalescing()
 		}
 		if !c.hijacked() {
 			if inFlightResponse != nil {
@@ -1840,12 +2543,20 @@ func (c *conn) serve(ctx context.Context) {
 
 	if tlsConn, ok := c.rwc.(*tls.Conn); ok {
 		tlsTO := c.server.tlsHandshakeTimeout()
+		var dl time.Time
 		if tlsTO > 0 {
-			dl := time.Now().Add(tlsTO)
+			dl = time.Now().Add(tlsTO)
 			c.rwc.SetReadDeadline(dl)
 			c.rwc.SetWriteDeadline(dl)
 		}
-		if err := tlsConn.HandshakeContext(ctx); err != nil {
+		release, err := c.server.acquireHandshake(ctx, dl)
+		if err != nil {
+			c.server.logf("http: TLS handshake error from %s: %v", c.rwc.RemoteAddr(), err)
+			return
+		}
+		err = tlsConn.HandshakeContext(ctx)
+		release()
+		if err != nil {
 			// If the handshake failed due to the client not speaking
 			// TLS, assume they're speaking plaintext HTTP and write a
 			// 400 response on the TLS conn's underlying net.Conn.
@@ -1864,9 +2575,15 @@ func (c *conn) serve(ctx context.Context) {
 		}
 		c.tlsState = new(tls.ConnectionState)
 		*c.tlsState = tlsConn.ConnectionState()
+		if fn := c.server.TLSHandshakeDone; fn != nil {
+			fn(c.rwc, *c.tlsState)
+		}
 		if proto := c.tlsState.NegotiatedProtocol; validNextProto(proto) {
 			if fn := c.server.TLSNextProto[proto]; fn != nil {
-				h := initALPNRequest{ctx, tlsConn, serverHandler{c.server}}
+				h := initALPNRequest{ctx, tlsConn, serverHandler{c.server}, c}
+				c.mu.Lock()
+				c.nextProto = proto
+				c.mu.Unlock()
 				// Mark freshly created HTTP/2 as active and prevent any server state hooks
 				// from being run on these connections. This prevents closeIdleConns from
 				// closing such connections. See issue https://golang.org/issue/39776.
@@ -1934,17 +2651,22 @@ func (c *conn) serve(ctx context.Context) {
 			}
 		}
 
+		atomic.AddInt64(&c.requests, 1)
+
 		// Expect 100 Continue support
 		req := w.req
+		if req.hasOtherExpectation() {
+			if ok, code := c.server.allowExpectation(req); !ok {
+				w.sendExpectationFailed(code)
+				return
+			}
+		}
 		if req.expectsContinue() {
 			if req.ProtoAtLeast(1, 1) && req.ContentLength != 0 {
 				// Wrap the Body reader with one that replies on the connection
 				req.Body = &expectContinueReader{readCloser: req.Body, resp: w}
 				w.canWriteContinue.setTrue()
 			}
-		} else if req.Header.get("Expect") != "" {
-			w.sendExpectationFailed()
-			return
 		}
 
 		c.curReq.Store(w)
@@ -1955,6 +2677,13 @@ func (c *conn) serve(ctx context.Context) {
 			w.conn.r.startBackgroundRead()
 		}
 
+		// Decode the transfer codings allowed by AllowTransferEncodings.
+		if n := len(req.TransferEncoding); n > 1 {
+			d := &transferDecoder{src: req.Body, codings: req.TransferEncoding[:n-1]}
+			req.Body = MaxBytesReader(w, d, c.server.maxDecodedBodyBytes())
+			req.TransferEncoding = []string{"chunked"}
+		}
+
 		// HTTP cannot have multiple simultaneous active requests.[*]
 		// Until the server replies to this request, it can't read another,
 		// so we might as well run the handler in this goroutine.
@@ -1963,6 +2692,9 @@ func (c *conn) serve(ctx context.Context) {
 		// But we're not going to implement HTTP pipelining because it
 		// was never deployed in the wild and the answer is HTTP/2.
 		inFlightResponse = w
+		if c.server.OnFirstByte != nil {
+			w.handlerStart = time.Now()
+		}
 		serverHandler{c.server}.ServeHTTP(w, w.req)
 		inFlightResponse = nil
 		w.cancelCtx()
@@ -1970,12 +2702,30 @@ func (c *conn) serve(ctx context.Context) {
 			return
 		}
 		w.finishRequest()
+		c.r.setBodyTimeout(0, time.Time{})
 		if !w.shouldReuseConnection() {
 			if w.requestBodyLimitHit || w.closedRequestBodyEarly() {
 				c.closeWriteAndWait()
 			}
 			return
 		}
+		if c.server.StrictBodyTermination && req.ContentLength > 0 {
+			if n := c.bufr.Buffered(); n > 0 {
+				b, _ := c.bufr.Peek(n)
+				if c.lastMethod == "POST" {
+					// Skip the CR and LF bytes readRequest
+					// discards for old buggy clients.
+					peek := b
+					if len(peek) > 4 {
+						peek = peek[:4]
+					}
+					b = b[numLeadingCRorLF(peek):]
+				}
+				if len(b) > 0 && !maybeRequestLine(b) {
+					return
+				}
+			}
+		}
 		c.setState(c.rwc, StateIdle, runHooks)
 		c.curReq.Store((*response)(nil))
 
@@ -1987,8 +2737,15 @@ func (c *conn) serve(ctx context.Context) {
 			return
 		}
 
-		if d := c.server.idleTimeout(); d != 0 {
-			c.rwc.SetReadDeadline(time.Now().Add(d))
+		if d := c.server.idleTimeout(); d != 0 || c.server.PerRequestReadTimeout > 0 {
+			// Wait for the next request before starting its
+			// PerRequestReadTimeout, with no deadline if there
+			// is no idle timeout.
+			var idleDeadline time.Time
+			if d != 0 {
+				idleDeadline = time.Now().Add(d)
+			}
+			c.rwc.SetReadDeadline(idleDeadline)
 			if _, err := c.bufr.Peek(4); err != nil {
 				return
 			}
@@ -1997,21 +2754,47 @@ func (c *conn) serve(ctx context.Context) {
 	}
 }
 
-func (w *response) sendExpectationFailed() {
-	// TODO(bradfitz): let ServeHTTP handlers handle
-	// requests with non-standard expectation[s]? Seems
-	// theoretical at best, and doesn't fit into the
-	// current ServeHTTP model anyway. We'd need to
-	// make the ResponseWriter an optional
-	// "ExpectReplier" interface or something.
-	//
-	// For now we'll just obey RFC 7231 5.1.1 which says
-	// "A server that receives an Expect field-value other
-	// than 100-continue MAY respond with a 417 (Expectation
-	// Failed) status code to indicate that the unexpected
-	// expectation cannot be met."
+// maybeRequestLine reports whether b could be the start of an HTTP/1
+// request line. b may end before the line does.
+func maybeRequestLine(b []byte) bool {
+	line, _, complete := strings.Cut(string(b), "\n")
+	if complete {
+		method, requestURI, proto, ok := parseRequestLine(strings.TrimSuffix(line, "\r"))
+		if !ok || !validMethod(method) || requestURI == "" {
+			return false
+		}
+		_, _, ok = ParseHTTPVersion(proto)
+		return ok
+	}
+	method, rest, sawSpace := strings.Cut(line, " ")
+	if method == "" && sawSpace || method != "" && !validMethod(method) {
+		return false
+	}
+	return !stringContainsCTLByte(strings.TrimSuffix(rest, "\r"))
+}
+
+// allowExpectation reports whether r, which has an expectation other
+// than 100-continue, is served, and if not, the status of the error
+// response.
+//
+// Unless srv.HandleExpect says otherwise, we obey RFC 9110 10.1.1,
+// which says "A server that receives an Expect field value containing
+// a member other than 100-continue MAY respond with a 417 (Expectation
+// Failed) status code to indicate that the unexpected expectation
+// cannot be met."
+func (srv *Server) allowExpectation(r *Request) (ok bool, code int) {
+	if srv.HandleExpect != nil {
+		ok, code = srv.HandleExpect(r)
+	}
+	if code < 400 || code > 599 {
+		code = StatusExpectationFailed
+	}
+	return ok, code
+}
+
+func (w *response) sendExpectationFailed(code int) {
 	w.Header().Set("Connection", "close")
-	w.WriteHeader(StatusExpectationFailed)
+	w.WriteHeader(code)
 	w.finishRequest()
 }
 
@@ -2021,6 +2804,7 @@ func (w *response) Hijack() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
 	if w.handlerDone.isSet() {
 		panic("net/http: Hijack called after ServeHTTP finished")
 	}
+	w.stopWriteCoalescing()
 	if w.wroteHeader {
 		w.cw.flush()
 	}
@@ -2035,6 +2819,10 @@ func (w *response) Hijack() (rwc net.Conn, buf *bufio.ReadWriter, err error) {
 	if err == nil {
 		putBufioWriter(w.w)
 		w.w = nil
+		if c.server.MaxBufferedResponseBytes > 0 {
+			c.releaseBufferedResponse(-1)
+		}
+		atomic.AddInt32(&c.server.inFlight, -1)
 	}
 	return rwc, buf, err
 }
@@ -2084,6 +2872,25 @@ func (f HandlerFunc) ServeHTTP(w ResponseWriter, r *Request) {
 	f(w, r)
 }
 
+// A Middleware wraps a handler in another, which typically does some
+// work, such as logging, authentication, or recovering from panics,
+// before or after calling the wrapped handler.
+type Middleware func(Handler) Handler
+
+// Chain returns a Middleware applying the middlewares in order, the
+// first listed outermost: Chain(a, b, c)(h) is a(b(c(h))), so a
+// request reaches a first and h last. With no middlewares, Chain
+// returns handlers unchanged.
+func Chain(middlewares ...Middleware) Middleware {
+	middlewares = append([]Middleware(nil), middlewares...)
+	return func(h Handler) Handler {
+		for i := len(middlewares) - 1; i >= 0; i-- {
+			h = middlewares[i](h)
+		}
+		return h
+	}
+}
+
 // Helper handlers
 
 // Error replies to the request with the specified error message and HTTP code.
@@ -2104,6 +2911,18 @@ func NotFound(w ResponseWriter, r *Request) { Error(w, "404 page not found", Sta
 // that replies to each request with a ``404 page not found'' reply.
 func NotFoundHandler() Handler { return HandlerFunc(NotFound) }
 
+// methodNotAllowedHandler returns a request handler that replies to
+// each request with a 405 Method Not Allowed reply listing allow in its
+// Allow header.
+func methodNotAllowedHandler(allow []string) Handler {
+	sort.Strings(allow)
+	methods := strings.Join(allow, ", ")
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Allow", methods)
+		Error(w, StatusText(StatusMethodNotAllowed), StatusMethodNotAllowed)
+	})
+}
+
 // StripPrefix returns a handler that serves HTTP requests by removing the
 // given prefix from the request URL's Path (and RawPath if set) and invoking
 // the handler h. StripPrefix handles a request for a path that doesn't begin
@@ -2131,7 +2950,153 @@ func StripPrefix(prefix string, h Handler) Handler {
 	})
 }
 
-// Redirect replies to the request with a redirect to url,
+// FormatByExtension returns a handler that selects one of handlers by
+// the extension of the last element of the request URL's Path. The
+// keys of handlers are extensions without the leading dot, such as
+// "json" or "xml".
+//
+// If the path ends in a recognized extension, the extension is removed
+// from the request URL's Path (and RawPath if set) before the matching
+// handler is invoked. Otherwise the path is left unchanged and the
+// handler for defaultFormat is used; if there is none, FormatByExtension
+// replies with an HTTP 404 not found error. In either case the handler
+// can retrieve the selected format from the request context with
+// FormatContextKey.
+func FormatByExtension(handlers map[string]Handler, defaultFormat string) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		format, p, rp := defaultFormat, r.URL.Path, r.URL.RawPath
+		if ext, ok := pathExtension(r.URL.Path); ok {
+			if _, ok := handlers[ext]; ok && (r.URL.RawPath == "" || strings.HasSuffix(r.URL.RawPath, "."+ext)) {
+				format = ext
+				p = strings.TrimSuffix(p, "."+ext)
+				rp = strings.TrimSuffix(rp, "."+ext)
+			}
+		}
+		h, ok := handlers[format]
+		if !ok {
+			NotFound(w, r)
+			return
+		}
+		r2 := r.WithContext(context.WithValue(r.Context(), FormatContextKey, format))
+		if p != r.URL.Path {
+			r2.URL = new(url.URL)
+			*r2.URL = *r.URL
+			r2.URL.Path = p
+			r2.URL.RawPath = rp
+		}
+		h.ServeHTTP(w, r2)
+	})
+}
+
+// pathExtension returns the extension of the last element of p,
+// without the leading dot. Elements that consist only of a dot and
+// an extension, such as ".json", have no extension.
+func pathExtension(p string) (ext string, ok bool) {
+	i := strings.LastIndexByte(p, '.')
+	if i <= strings.LastIndexByte(p, '/')+1 {
+		return "", false
+	}
+	return p[i+1:], true
+}
+
+// RestrictToNetworks returns a handler that runs next only for requests
+// from a client IP address within one of the allowed prefixes, and
+// replies to other requests with an HTTP 403 Forbidden error.
+//
+// The client IP address is taken from the request's RemoteAddr, with
+// any IPv6 zone removed. IPv4 clients, including those connecting
+// with IPv4-mapped IPv6 addresses, are matched against IPv4 prefixes
+// and against IPv4-mapped IPv6 prefixes such as "::ffff:10.0.0.0/104",
+// which are treated as the IPv4 prefixes they map.
+// Headers such as X-Forwarded-For are not consulted, so behind a
+// reverse proxy it is the proxy's address that is checked.
+func RestrictToNetworks(next Handler, allowed []netip.Prefix) Handler {
+	allowed = append([]netip.Prefix(nil), allowed...)
+	for i, p := range allowed {
+		if p.Addr().Is4In6() && p.Bits() >= 96 {
+			allowed[i] = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+	}
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		if ap, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+			ip := ap.Addr().WithZone("").Unmap()
+			for _, p := range allowed {
+				if p.Contains(ip) {
+					next.ServeHTTP(w, r)
+					return
+				}
+			}
+		}
+		Error(w, "403 Forbidden", StatusForbidden)
+	})
+}
+
+// PatchHandler returns a handler that advertises the media types
+// accepted for PATCH requests, as described in RFC 5789, and rejects
+// PATCH requests with other content types.
+//
+// The returned handler sets the Accept-Patch response header to the
+// comma-separated acceptedTypes on every response, including those
+// to OPTIONS requests. It replies to a PATCH request whose
+// Content-Type is missing or does not match one of acceptedTypes
+// with an HTTP 415 Unsupported Media Type error, without calling
+// next. Media types are compared case-insensitively, and parameters
+// such as charset are ignored.
+func PatchHandler(next Handler, acceptedTypes []string) Handler {
+	acceptedTypes = append([]string(nil), acceptedTypes...)
+	acceptPatch := strings.Join(acceptedTypes, ", ")
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("Accept-Patch", acceptPatch)
+		if r.Method == MethodPatch && !acceptsPatchType(acceptedTypes, r.Header.Get("Content-Type")) {
+			Error(w, "415 Unsupported Media Type", StatusUnsupportedMediaType)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// acceptsPatchType reports whether the media type of the Content-Type
+// header value ct is one of accepted.
+func acceptsPatchType(accepted []string, ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, a := range accepted {
+		if amt, _, _ := strings.Cut(a, ";"); ascii.EqualFold(textproto.TrimString(amt), mt) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetSunset sets the "Sunset" header of w to t, as described in
+// RFC 8594, announcing that the resource is expected to become
+// unavailable at that time. It must be called before the response
+// header is written.
+func SetSunset(w ResponseWriter, t time.Time) {
+	w.Header().Set("Sunset", t.UTC().Format(TimeFormat))
+}
+
+// DeprecationHandler returns a handler that runs next and marks each
+// of its responses as deprecated, with a "Deprecation: true" header
+// and a "Sunset" header giving the time at which the resource is
+// expected to become unavailable. If link is not empty, a "Link"
+// header with relation type "sunset" refers to it, typically a page
+// describing the deprecation.
+func DeprecationHandler(next Handler, sunset time.Time, link string) Handler {
+	return HandlerFunc(func(w ResponseWriter, r *Request) {
+		h := w.Header()
+		h.Set("Deprecation", "true")
+		SetSunset(w, sunset)
+		if link != "" {
+			h.Add("Link", "<"+link+">; rel=\"sunset\"")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Redirect replies to the request with a redirect to url,
 // which may be a path relative to the request path.
 //
 // The provided code should be in the 3xx range and is usually
@@ -2260,21 +3225,236 @@ func RedirectHandler(url string, code int) Handler {
 // URLs on that host only. Host-specific patterns take precedence over
 // general patterns, so that a handler might register for the two patterns
 // "/codesearch" and "codesearch.google.com/" without also taking over
-// requests for "http://www.google.com/".
+// requests for "http://www.google.com/". A request to a host that no
+// pattern names is matched against the patterns without a host. Host
+// names match case-insensitively and regardless of a trailing dot, and
+// the port of the request's Host header is ignored, so that the pattern
+// "example.com/" matches requests for "http://Example.COM:8080/".
+// An IPv6 address matches in any of its textual forms, with or without
+// brackets in the pattern, so that "[::1]/" and "::1/" both match
+// requests for "http://[0:0::1]:8080/".
+//
+// Patterns may also begin with a method followed by a space, as in
+// "POST /items/" or "GET example.com/", restricting matches to requests
+// with that method. Patterns without a method match requests with any
+// method. The precedence rules above apply among the patterns matching
+// a request's method, and of two patterns differing only in their
+// method, the one with the method takes precedence. If only patterns
+// with other methods match a request, ServeMux replies with
+// 405 Method Not Allowed and an Allow header listing their methods.
+//
+// A path segment of a pattern may be a wildcard of the form "{name}",
+// matching any one non-empty segment of a request path, as in
+// "/users/{id}/posts/{postID}". The last segment may instead be of
+// the form "{name...}", matching the rest of the path, slashes
+// included. Handlers read the matched segments with Request.PathValue.
+// Wildcards may not appear in host names. A pattern without wildcards
+// matching a path exactly takes precedence over patterns with them.
+// Otherwise, of the patterns ending in a slash or containing wildcards
+// that match a path, the most specific wins: comparing their segments
+// in order, a literal segment is more specific than a "{name}"
+// wildcard, which is more specific than a "{name...}" wildcard or a
+// trailing slash. Since no pattern would be more specific, registering
+// patterns that differ only in the names of their wildcards, such as
+// "/a/{x}" and "/a/{y}", or in ending in a "{name...}" wildcard rather
+// than a slash, panics.
+//
+// Before wildcards, braces in patterns were literal, so that "/{id}"
+// matched only the path "/{id}" and "/a{b}" was a valid pattern. Now
+// "/{id}" is a wildcard pattern, and a brace that is not part of a
+// wildcard segment, as in "/a{b}", makes Handle panic. Setting
+// GODEBUG=httpmuxgo121=1 when patterns are registered makes braces
+// literal again.
 //
 // ServeMux also takes care of sanitizing the URL request path and the Host
 // header, stripping the port number and redirecting any request containing . or
 // .. elements or repeated slashes to an equivalent, cleaner URL.
 type ServeMux struct {
-	mu    sync.RWMutex
-	m     map[string]muxEntry
-	es    []muxEntry // slice of entries sorted from longest to shortest.
-	hosts bool       // whether any patterns contain hostnames
+	mu      sync.RWMutex
+	m       map[string]muxEntry
+	es      []muxEntry          // slice of entries sorted from longest to shortest.
+	hosts   bool                // whether any patterns contain hostnames
+	methods bool                // whether any patterns contain methods
+	shapes  map[string]string   // patterns ending in a slash or with wildcards, by muxShape
+	allow   map[string][]string // methods of the other patterns with a method, by path
+	mw      []Middleware        // added by Use; replaced, not modified, by Use
+	mwGen   int                 // incremented by Use
+
+	counting int32 // accessed atomically; non-zero after EnableCounters
 }
 
 type muxEntry struct {
 	h       Handler
-	pattern string
+	pattern string // as registered, with a single space after any method
+	path    string // pattern without the method
+	method  string // or "" for any method
+	st      *muxEntryState
+
+	// segs holds the path's segments, starting with its host name or,
+	// if it has none, "". If wild is set, the path has wildcards and
+	// is matched by matchSegs rather than by comparing it to paths.
+	segs []muxSeg
+	wild bool
+}
+
+// muxEntryState is the state of a muxEntry updated as it serves
+// requests.
+type muxEntryState struct {
+	hits    uint64       // accessed atomically; requests served when counting
+	wrapped atomic.Value // of *muxWrapped
+}
+
+// muxWrapped is the handler of a muxEntry wrapped in the middlewares
+// of its ServeMux, as they were at generation gen.
+type muxWrapped struct {
+	gen int
+	h   Handler
+}
+
+// A muxSeg is a segment of a ServeMux pattern.
+type muxSeg struct {
+	s    string // literal segment, or wildcard name
+	kind int    // segLiteral, segWild, or segRest
+}
+
+const (
+	segLiteral = iota
+	segWild    // "{name}"
+	segRest    // "{name...}", or the "" after a trailing slash
+)
+
+// parseSegs splits path, a pattern without its method, into segments.
+// It panics if the path's wildcards are malformed. With
+// GODEBUG=httpmuxgo121=1, braces are literal, as they were before
+// patterns had wildcards.
+func parseSegs(path string) (segs []muxSeg, wild bool) {
+	literal := godebug.Get("httpmuxgo121") == "1"
+	elems := strings.Split(path, "/")
+	if !literal && strings.ContainsAny(elems[0], "{}") {
+		panic("http: wildcard in host name of pattern " + path)
+	}
+	seen := make(map[string]bool)
+	for i, elem := range elems {
+		switch {
+		case i == 0:
+			segs = append(segs, muxSeg{s: elem})
+		case elem == "" && i == len(elems)-1:
+			segs = append(segs, muxSeg{kind: segRest})
+		case literal:
+			segs = append(segs, muxSeg{s: elem})
+		case len(elem) > 2 && elem[0] == '{' && elem[len(elem)-1] == '}':
+			seg := muxSeg{s: elem[1 : len(elem)-1], kind: segWild}
+			if strings.HasSuffix(seg.s, "...") {
+				if i != len(elems)-1 {
+					panic("http: {" + seg.s + "} not at end of pattern " + path)
+				}
+				seg = muxSeg{s: strings.TrimSuffix(seg.s, "..."), kind: segRest}
+			}
+			if seg.s == "" || strings.ContainsAny(seg.s, "{}") || seen[seg.s] {
+				panic("http: invalid wildcard {" + seg.s + "} in pattern " + path)
+			}
+			seen[seg.s] = true
+			segs = append(segs, seg)
+			wild = true
+		case strings.ContainsAny(elem, "{}"):
+			panic("http: invalid wildcard in pattern " + path)
+		default:
+			segs = append(segs, muxSeg{s: elem})
+		}
+	}
+	return segs, wild
+}
+
+// muxShape returns the shape of a pattern with the given method and
+// segments: the pattern with its wildcards unnamed, so that patterns
+// of equal shape match the same requests.
+func muxShape(method string, segs []muxSeg) string {
+	var b strings.Builder
+	b.WriteString(method)
+	b.WriteByte(' ')
+	for i, seg := range segs {
+		if i > 0 {
+			b.WriteByte('/')
+		}
+		switch seg.kind {
+		case segLiteral:
+			b.WriteString(seg.s)
+		case segWild:
+			b.WriteString("{}")
+		case segRest:
+			b.WriteString("{...}")
+		}
+	}
+	return b.String()
+}
+
+// matchSegs reports whether path, a request path with any host name
+// prepended, matches segs, and returns the values of its wildcards.
+func matchSegs(segs []muxSeg, path string) (values map[string]string, ok bool) {
+	for i, seg := range segs {
+		if i > 0 {
+			if path == "" {
+				return nil, false
+			}
+			path = path[1:] // the '/' before the segment
+		}
+		if seg.kind == segRest {
+			if seg.s != "" {
+				if values == nil {
+					values = make(map[string]string)
+				}
+				values[seg.s] = path
+			}
+			return values, true
+		}
+		elem := path
+		if j := strings.IndexByte(path, '/'); j >= 0 {
+			elem = path[:j]
+		}
+		path = path[len(elem):]
+		switch seg.kind {
+		case segLiteral:
+			if elem != seg.s {
+				return nil, false
+			}
+		case segWild:
+			if elem == "" {
+				return nil, false
+			}
+			if values == nil {
+				values = make(map[string]string)
+			}
+			values[seg.s] = elem
+		}
+	}
+	if path != "" {
+		return nil, false
+	}
+	return values, true
+}
+
+// moreSpecific reports whether mux entry a takes precedence over b
+// for the paths they both match.
+func moreSpecific(a, b muxEntry) bool {
+	for i := 0; i < len(a.segs) && i < len(b.segs); i++ {
+		if a.segs[i].kind != b.segs[i].kind {
+			return a.segs[i].kind < b.segs[i].kind
+		}
+	}
+	if len(a.segs) != len(b.segs) {
+		return len(a.segs) > len(b.segs)
+	}
+	// Patterns with a method go first.
+	return a.method != "" && b.method == ""
+}
+
+// matches reports whether e matches path, and returns the values of
+// its wildcards.
+func (e *muxEntry) matches(path string) (values map[string]string, ok bool) {
+	if e.wild {
+		return matchSegs(e.segs, path)
+	}
+	return nil, e.path == path || e.path[len(e.path)-1] == '/' && strings.HasPrefix(path, e.path)
 }
 
 // NewServeMux allocates and returns a new ServeMux.
@@ -2320,32 +3500,90 @@ func stripHostPort(h string) string {
 	return host
 }
 
+// muxHost returns the host name h of a pattern or a request, without
+// its port, in the form ServeMux matches: in lower case and without a
+// trailing dot, with an IPv6 literal in its canonical form and in
+// brackets, whether or not h has them.
+func muxHost(h string) string {
+	if lower, ok := ascii.ToLower(h); ok {
+		h = lower
+	}
+	h = strings.TrimSuffix(h, ".")
+	if ip, err := netip.ParseAddr(stripBrackets(h)); err == nil && ip.Is6() {
+		return "[" + ip.String() + "]"
+	}
+	return h
+}
+
 // Find a handler on a handler map given a path string.
-// Most-specific (longest) pattern wins.
-func (mux *ServeMux) match(path string) (h Handler, pattern string) {
+// Most-specific pattern wins.
+func (mux *ServeMux) match(method, path string) (h Handler, pattern string, values map[string]string) {
 	// Check for exact match first.
+	if mux.methods {
+		if v, ok := mux.m[method+" "+path]; ok && !v.wild {
+			return v.h, v.pattern, nil
+		}
+	}
 	v, ok := mux.m[path]
-	if ok {
-		return v.h, v.pattern
+	if ok && !v.wild {
+		return v.h, v.pattern, nil
+	}
+
+	// Check for most specific valid match.  mux.es contains all
+	// patterns that end in / or have wildcards, sorted from most
+	// to least specific.
+	for _, e := range mux.es {
+		if e.method != "" && e.method != method {
+			continue
+		}
+		if e.wild {
+			if values, ok := matchSegs(e.segs, path); ok {
+				return e.h, e.pattern, values
+			}
+		} else if strings.HasPrefix(path, e.path) {
+			return e.h, e.pattern, nil
+		}
 	}
+	return nil, "", nil
+}
 
-	// Check for longest valid match.  mux.es contains all patterns
-	// that end in / sorted from longest to shortest.
+// allowedMethods returns the methods of the patterns that match path,
+// excluding those without a method, adding them to allow. The
+// patterns matching path exactly are looked up in mux.allow, so that
+// only those ending in a slash or with wildcards are matched.
+func (mux *ServeMux) allowedMethods(path string, allow []string) []string {
+	if !mux.methods {
+		return allow
+	}
+	add := func(method string) {
+		for _, m := range allow {
+			if m == method {
+				return
+			}
+		}
+		allow = append(allow, method)
+	}
+	for _, m := range mux.allow[path] {
+		add(m)
+	}
 	for _, e := range mux.es {
-		if strings.HasPrefix(path, e.pattern) {
-			return e.h, e.pattern
+		if e.method == "" {
+			continue
+		}
+		if _, ok := e.matches(path); ok {
+			add(e.method)
 		}
 	}
-	return nil, ""
+	return allow
 }
 
 // redirectToPathSlash determines if the given path needs appending "/" to it.
 // This occurs when a handler for path + "/" was already registered, but
 // not for path itself. If the path needs appending to, it creates a new
 // URL, setting the path to u.Path + "/" and returning true to indicate so.
-func (mux *ServeMux) redirectToPathSlash(host, path string, u *url.URL) (*url.URL, bool) {
+func (mux *ServeMux) redirectToPathSlash(method, host, path string, u *url.URL) (*url.URL, bool) {
 	mux.mu.RLock()
-	shouldRedirect := mux.shouldRedirectRLocked(host, path)
+	shouldRedirect := mux.shouldRedirectRLocked(method, host, path)
 	mux.mu.RUnlock()
 	if !shouldRedirect {
 		return u, false
@@ -2357,12 +3595,16 @@ func (mux *ServeMux) redirectToPathSlash(host, path string, u *url.URL) (*url.UR
 
 // shouldRedirectRLocked reports whether the given path and host should be redirected to
 // path+"/". This should happen if a handler is registered for path+"/" but
-// not path -- see comments at ServeMux.
-func (mux *ServeMux) shouldRedirectRLocked(host, path string) bool {
+// not path -- see comments at ServeMux. Only patterns without a method
+// or with the given method are considered.
+func (mux *ServeMux) shouldRedirectRLocked(method, host, path string) bool {
 	p := []string{path, host + path}
+	if mux.methods {
+		p = append(p, method+" "+path, method+" "+host+path)
+	}
 
 	for _, c := range p {
-		if _, exist := mux.m[c]; exist {
+		if e, exist := mux.m[c]; exist && !e.wild {
 			return false
 		}
 	}
@@ -2372,7 +3614,7 @@ func (mux *ServeMux) shouldRedirectRLocked(host, path string) bool {
 		return false
 	}
 	for _, c := range p {
-		if _, exist := mux.m[c+"/"]; exist {
+		if e, exist := mux.m[c+"/"]; exist && !e.wild {
 			return path[n-1] != '/'
 		}
 	}
@@ -2396,55 +3638,74 @@ func (mux *ServeMux) shouldRedirectRLocked(host, path string) bool {
 // If there is no registered handler that applies to the request,
 // Handler returns a ``page not found'' handler and an empty pattern.
 func (mux *ServeMux) Handler(r *Request) (h Handler, pattern string) {
+	h, pattern, _, _ = mux.findHandler(r)
+	return
+}
+
+// findHandler is the implementation of Handler. It also returns the
+// state of the matched pattern's entry if the request is dispatched
+// to that pattern's handler, or nil otherwise, and the values of the
+// pattern's wildcards.
+func (mux *ServeMux) findHandler(r *Request) (h Handler, pattern string, st *muxEntryState, values map[string]string) {
 
 	// CONNECT requests are not canonicalized.
 	if r.Method == "CONNECT" {
 		// If r.URL.Path is /tree and its handler is not registered,
 		// the /tree -> /tree/ redirect applies to CONNECT requests
 		// but the path canonicalization does not.
-		if u, ok := mux.redirectToPathSlash(r.URL.Host, r.URL.Path, r.URL); ok {
-			return RedirectHandler(u.String(), StatusMovedPermanently), u.Path
+		if u, ok := mux.redirectToPathSlash(r.Method, r.URL.Host, r.URL.Path, r.URL); ok {
+			u.Path = r.mountPrefix + u.Path
+			return RedirectHandler(u.String(), StatusMovedPermanently), u.Path, nil, nil
 		}
 
-		return mux.handler(r.Host, r.URL.Path)
+		return mux.handler(r.Method, r.Host, r.URL.Path)
 	}
 
 	// All other requests have any port stripped and path cleaned
 	// before passing to mux.handler.
-	host := stripHostPort(r.Host)
+	host := muxHost(stripHostPort(r.Host))
 	path := cleanPath(r.URL.Path)
 
 	// If the given path is /tree and its handler is not registered,
 	// redirect for /tree/.
-	if u, ok := mux.redirectToPathSlash(host, path, r.URL); ok {
-		return RedirectHandler(u.String(), StatusMovedPermanently), u.Path
+	if u, ok := mux.redirectToPathSlash(r.Method, host, path, r.URL); ok {
+		u.Path = r.mountPrefix + u.Path
+		return RedirectHandler(u.String(), StatusMovedPermanently), u.Path, nil, nil
 	}
 
 	if path != r.URL.Path {
-		_, pattern = mux.handler(host, path)
-		u := &url.URL{Path: path, RawQuery: r.URL.RawQuery}
-		return RedirectHandler(u.String(), StatusMovedPermanently), pattern
+		_, pattern, _, _ = mux.handler(r.Method, host, path)
+		u := &url.URL{Path: r.mountPrefix + path, RawQuery: r.URL.RawQuery}
+		return RedirectHandler(u.String(), StatusMovedPermanently), pattern, nil, nil
 	}
 
-	return mux.handler(host, r.URL.Path)
+	return mux.handler(r.Method, host, r.URL.Path)
 }
 
 // handler is the main implementation of Handler.
 // The path is known to be in canonical form, except for CONNECT methods.
-func (mux *ServeMux) handler(host, path string) (h Handler, pattern string) {
+func (mux *ServeMux) handler(method, host, path string) (h Handler, pattern string, st *muxEntryState, values map[string]string) {
 	mux.mu.RLock()
 	defer mux.mu.RUnlock()
 
 	// Host-specific pattern takes precedence over generic ones
 	if mux.hosts {
-		h, pattern = mux.match(host + path)
+		h, pattern, values = mux.match(method, host+path)
 	}
 	if h == nil {
-		h, pattern = mux.match(path)
+		h, pattern, values = mux.match(method, path)
 	}
 	if h == nil {
-		h, pattern = NotFoundHandler(), ""
+		var allow []string
+		if mux.hosts {
+			allow = mux.allowedMethods(host+path, allow)
+		}
+		if allow = mux.allowedMethods(path, allow); len(allow) > 0 {
+			return methodNotAllowedHandler(allow), "", nil, nil
+		}
+		return NotFoundHandler(), "", nil, nil
 	}
+	st = mux.m[pattern].st
 	return
 }
 
@@ -2458,12 +3719,85 @@ func (mux *ServeMux) ServeHTTP(w ResponseWriter, r *Request) {
 		w.WriteHeader(StatusBadRequest)
 		return
 	}
-	h, _ := mux.Handler(r)
+	h, _, st, values := mux.findHandler(r)
+	if st != nil && atomic.LoadInt32(&mux.counting) != 0 {
+		atomic.AddUint64(&st.hits, 1)
+	}
+	for name, v := range values {
+		r.SetPathValue(name, v)
+	}
+	mux.mu.RLock()
+	mw, gen := mux.mw, mux.mwGen
+	mux.mu.RUnlock()
+	if len(mw) > 0 {
+		if st == nil {
+			// One of the handlers made for this request.
+			h = Chain(mw...)(h)
+		} else if wh, _ := st.wrapped.Load().(*muxWrapped); wh != nil && wh.gen == gen {
+			h = wh.h
+		} else {
+			h = Chain(mw...)(h)
+			st.wrapped.Store(&muxWrapped{gen: gen, h: h})
+		}
+	}
 	h.ServeHTTP(w, r)
 }
 
+// Use adds middlewares applied to the handler of each request that mux
+// serves, after those added by earlier calls, in the order of Chain:
+// the first middleware added runs outermost. The middlewares wrap the
+// handler when a request is dispatched, so they apply to all handlers,
+// whether registered before or after the call to Use, and also to the
+// handlers with which ServeMux answers requests itself, such as
+// redirects and ``page not found'' replies. The handler of a
+// registered pattern is wrapped by the first request dispatched to it
+// after a call to Use, and the wrapped handler is kept for later
+// requests; a ServeMux's own handlers are wrapped for each request.
+// The Handler method returns handlers without the middlewares.
+func (mux *ServeMux) Use(middlewares ...Middleware) {
+	mux.mu.Lock()
+	defer mux.mu.Unlock()
+	for _, m := range middlewares {
+		if m == nil {
+			panic("http: nil middleware")
+		}
+	}
+	mux.mw = append(mux.mw[:len(mux.mw):len(mux.mw)], middlewares...)
+	mux.mwGen++
+}
+
+// EnableCounters makes the ServeMux count the requests it dispatches
+// to the handler of each registered pattern. Requests that the
+// ServeMux answers itself, such as redirects to a canonical path and
+// ``page not found'' replies, are not counted.
+//
+// Counting costs one atomic increment per request and one counter
+// per registered pattern.
+func (mux *ServeMux) EnableCounters() {
+	atomic.StoreInt32(&mux.counting, 1)
+}
+
+// Counts returns the number of requests dispatched to each registered
+// pattern since EnableCounters was called, keyed by pattern.
+// It returns nil if counting is not enabled.
+func (mux *ServeMux) Counts() map[string]uint64 {
+	if atomic.LoadInt32(&mux.counting) == 0 {
+		return nil
+	}
+	mux.mu.RLock()
+	defer mux.mu.RUnlock()
+	counts := make(map[string]uint64, len(mux.m))
+	for pattern, e := range mux.m {
+		counts[pattern] = atomic.LoadUint64(&e.st.hits)
+	}
+	return counts
+}
+
 // Handle registers the handler for the given pattern.
 // If a handler already exists for pattern, Handle panics.
+// The host name of the pattern, if any, is put in the form that
+// ServeMux matches, so that Handler and Counts report the pattern
+// "Example.COM/" as "example.com/".
 func (mux *ServeMux) Handle(pattern string, handler Handler) {
 	mux.mu.Lock()
 	defer mux.mu.Unlock()
@@ -2474,6 +3808,22 @@ func (mux *ServeMux) Handle(pattern string, handler Handler) {
 	if handler == nil {
 		panic("http: nil handler")
 	}
+	path, method := pattern, ""
+	if m, p, ok := strings.Cut(pattern, " "); ok && !strings.Contains(m, "/") {
+		path = strings.TrimLeft(p, " ")
+		if !validMethod(m) || path == "" {
+			panic("http: invalid pattern " + pattern)
+		}
+		method = m
+	}
+	if i := strings.IndexByte(path, '/'); i > 0 {
+		path = muxHost(path[:i]) + path[i:]
+	}
+	if method != "" {
+		pattern = method + " " + path
+	} else {
+		pattern = path
+	}
 	if _, exist := mux.m[pattern]; exist {
 		panic("http: multiple registrations for " + pattern)
 	}
@@ -2481,21 +3831,40 @@ func (mux *ServeMux) Handle(pattern string, handler Handler) {
 	if mux.m == nil {
 		mux.m = make(map[string]muxEntry)
 	}
-	e := muxEntry{h: handler, pattern: pattern}
-	mux.m[pattern] = e
-	if pattern[len(pattern)-1] == '/' {
+	e := muxEntry{h: handler, pattern: pattern, path: path, method: method, st: new(muxEntryState)}
+	e.segs, e.wild = parseSegs(path)
+	if path[len(path)-1] == '/' || e.wild {
+		// Such patterns can differ only in the names of their
+		// wildcards, as "/a/{x}" and "/a/{y}" do.
+		shape := muxShape(method, e.segs)
+		if other, exist := mux.shapes[shape]; exist {
+			panic("http: pattern " + pattern + " matches the same requests as " + other)
+		}
+		if mux.shapes == nil {
+			mux.shapes = make(map[string]string)
+		}
+		mux.shapes[shape] = pattern
 		mux.es = appendSorted(mux.es, e)
+	} else if method != "" {
+		if mux.allow == nil {
+			mux.allow = make(map[string][]string)
+		}
+		mux.allow[path] = append(mux.allow[path], method)
 	}
+	mux.m[pattern] = e
 
-	if pattern[0] != '/' {
+	if path[0] != '/' {
 		mux.hosts = true
 	}
+	if method != "" {
+		mux.methods = true
+	}
 }
 
 func appendSorted(es []muxEntry, e muxEntry) []muxEntry {
 	n := len(es)
 	i := sort.Search(n, func(i int) bool {
-		return len(es[i].pattern) < len(e.pattern)
+		return moreSpecific(e, es[i])
 	})
 	if i == n {
 		return append(es, e)
@@ -2507,6 +3876,131 @@ func appendSorted(es []muxEntry, e muxEntry) []muxEntry {
 	return es
 }
 
+// HandleWithTimeouts registers the handler for the given pattern, like
+// Handle, with timeouts for the requests it serves. If read is
+// positive, the request, including its body, must be read within read
+// of the start of the handler; if write is positive, the response
+// must be written within write of the start of the handler. They
+// replace the Server's ReadTimeout and WriteTimeout only for requests
+// matching pattern; a zero value leaves the Server's timeout in
+// effect.
+//
+// The timeouts are set with ResponseController.SetReadDeadline and
+// SetWriteDeadline, and so only apply to HTTP/1 requests.
+func (mux *ServeMux) HandleWithTimeouts(pattern string, handler Handler, read, write time.Duration) {
+	if handler == nil {
+		panic("http: nil handler")
+	}
+	mux.Handle(pattern, &routeTimeoutHandler{handler, read, write})
+}
+
+type routeTimeoutHandler struct {
+	h           Handler
+	read, write time.Duration
+}
+
+func (h *routeTimeoutHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	rc := NewResponseController(w)
+	now := time.Now()
+	if h.read > 0 {
+		rc.SetReadDeadline(now.Add(h.read))
+	}
+	if h.write > 0 {
+		rc.SetWriteDeadline(now.Add(h.write))
+	}
+	h.h.ServeHTTP(w, r)
+}
+
+// Mount registers the handler for the requests whose paths begin with
+// prefix, as Handle does for the pattern prefix+"/", and removes prefix
+// from the request URL's Path (and RawPath if set) before invoking h.
+// A ServeMux mounted as h thus sees paths relative to its mount point:
+// with h mounted at "/api", h serves a request for "/api/users" as one
+// for "/users". A request for prefix itself is redirected to
+// prefix+"/", which h sees as "/"; for a prefix with wildcards, Mount
+// registers the pattern prefix to do so. The redirects of a mounted
+// ServeMux keep the prefix.
+//
+// A trailing slash of prefix is ignored. Like a pattern, prefix may
+// begin with a method or a host name, and may contain "{name}"
+// wildcards, whose values h can read with Request.PathValue. Mount
+// panics if prefix contains a "{name...}" wildcard.
+func (mux *ServeMux) Mount(prefix string, h Handler) {
+	if h == nil {
+		panic("http: nil handler")
+	}
+	path := prefix
+	if m, p, ok := strings.Cut(prefix, " "); ok && !strings.Contains(m, "/") {
+		path = strings.TrimLeft(p, " ")
+	}
+	if i := strings.IndexByte(path, '/'); i >= 0 {
+		path = path[i:]
+	} else {
+		path = ""
+	}
+	if strings.Contains(path, "...}") {
+		panic("http: invalid mount prefix " + prefix)
+	}
+	path = strings.TrimSuffix(path, "/")
+	prefix = strings.TrimSuffix(prefix, "/")
+	mux.Handle(prefix+"/", &mountHandler{h, strings.Count(path, "/")})
+	if strings.Contains(path, "{") {
+		// ServeMux only redirects to patterns without wildcards.
+		mux.Handle(prefix, HandlerFunc(redirectToMount))
+	}
+}
+
+// redirectToMount redirects a request for the prefix of a mounted
+// handler to the prefix followed by a slash.
+func redirectToMount(w ResponseWriter, r *Request) {
+	u := &url.URL{Path: r.mountPrefix + r.URL.Path + "/", RawQuery: r.URL.RawQuery}
+	Redirect(w, r, u.String(), StatusMovedPermanently)
+}
+
+// A mountHandler removes the first segs segments of the request path
+// before invoking h.
+type mountHandler struct {
+	h    Handler
+	segs int
+}
+
+func (h *mountHandler) ServeHTTP(w ResponseWriter, r *Request) {
+	p, ok := trimSegments(r.URL.Path, h.segs)
+	if !ok {
+		NotFound(w, r)
+		return
+	}
+	r2 := new(Request)
+	*r2 = *r
+	r2.URL = new(url.URL)
+	*r2.URL = *r.URL
+	r2.URL.Path = p
+	r2.URL.RawPath = ""
+	if rp, ok := trimSegments(r.URL.RawPath, h.segs); ok {
+		if up, err := url.PathUnescape(rp); err == nil && up == p {
+			r2.URL.RawPath = rp
+		}
+	}
+	r2.mountPrefix = r.mountPrefix + r.URL.Path[:len(r.URL.Path)-len(p)]
+	h.h.ServeHTTP(w, r2)
+}
+
+// trimSegments removes the first n segments from path, which begins
+// with a slash, and reports whether path had more than n segments.
+func trimSegments(path string, n int) (string, bool) {
+	if path == "" || path[0] != '/' {
+		return "", false
+	}
+	for ; n > 0; n-- {
+		i := strings.IndexByte(path[1:], '/')
+		if i < 0 {
+			return "", false
+		}
+		path = path[i+1:]
+	}
+	return path, true
+}
+
 // HandleFunc registers the handler function for the given pattern.
 func (mux *ServeMux) HandleFunc(pattern string, handler func(ResponseWriter, *Request)) {
 	if handler == nil {
@@ -2605,6 +4099,33 @@ type Server struct {
 	// A zero or negative value means there will be no timeout.
 	WriteTimeout time.Duration
 
+	// RequestBodyTimeout, if positive, is the maximum duration
+	// allowed for reading an HTTP/1 request body, measured from
+	// when the Handler is called until the body has been read to
+	// its end. Unlike ReadTimeout, it does not include the time
+	// spent reading the request headers, so a server can give
+	// handlers time to start while still aborting uploads that
+	// stall. If ReadTimeout ends sooner, ReadTimeout applies.
+	RequestBodyTimeout time.Duration
+
+	// RequestBodyTimeoutResets, if true, restarts RequestBodyTimeout
+	// whenever more of the request body arrives, so that it limits
+	// how long an upload may stall rather than how long it may
+	// take in total.
+	RequestBodyTimeoutResets bool
+
+	// PerRequestReadTimeout, if positive, is the maximum duration
+	// for reading each HTTP/1 request on a connection, including
+	// its body, measured from when the request begins to arrive:
+	// for the first request on a connection, when the connection
+	// is accepted, and for later ones, when the idle wait for them
+	// ends. Unlike ReadTimeout, it is not used in place of an unset
+	// ReadHeaderTimeout or IdleTimeout, so it doesn't limit how
+	// long a keep-alive connection may wait between requests; only
+	// IdleTimeout does. If ReadTimeout, ReadHeaderTimeout, or
+	// RequestBodyTimeout ends sooner, it applies.
+	PerRequestReadTimeout time.Duration
+
 	// IdleTimeout is the maximum amount of time to wait for the
 	// next request when keep-alives are enabled. If IdleTimeout
 	// is zero, the value of ReadTimeout is used. If both are
@@ -2618,6 +4139,147 @@ type Server struct {
 	// If zero, DefaultMaxHeaderBytes is used.
 	MaxHeaderBytes int
 
+	// MaxHeaderValueBytes, if positive, limits the length of each
+	// line of an HTTP/1 request header, such as a single field with
+	// its name and value, and the request line. A request with a
+	// longer line is rejected with 431 Request Header Fields Too
+	// Large as soon as the limit is exceeded, without reading the
+	// rest of the line. This guards against single oversized fields
+	// that stay within MaxHeaderBytes. If zero, only MaxHeaderBytes
+	// applies.
+	MaxHeaderValueBytes int
+
+	// MaxConcurrentHandshakes, if positive, limits the number of
+	// TLS handshakes the server performs at once. Handshakes of
+	// further connections wait for a slot to free up. The wait
+	// counts against the handshake's time limit, the smallest of
+	// any positive ReadHeaderTimeout, ReadTimeout, or WriteTimeout,
+	// and connections still waiting when it expires or when the
+	// server is closed or shut down are dropped. This bounds the
+	// CPU spent on handshakes when many clients connect at once.
+	MaxConcurrentHandshakes int
+
+	// AcceptQueueLimit, if positive, limits the number of accepted
+	// connections that have not yet begun serving a request: those
+	// in StateNew, which includes connections waiting for a
+	// handshake slot under MaxConcurrentHandshakes. Once that many
+	// are waiting, Serve stops accepting connections, leaving further
+	// ones in the listener's backlog, until one of them sends a
+	// request or is closed. This bounds the connections a burst of
+	// clients can pile up inside the server, separately from those
+	// already being served. Since a client that connects and sends
+	// nothing holds its place, servers setting AcceptQueueLimit
+	// should also set ReadHeaderTimeout or ReadTimeout.
+	AcceptQueueLimit int
+
+	// AllowTransferEncodings lists the transfer codings other than
+	// chunked that the server accepts on HTTP/1.1 request bodies,
+	// decoding them before the Handler reads the body. Only "gzip",
+	// which also admits its alias "x-gzip", is supported; other
+	// values are ignored. The codings must be followed by chunked,
+	// as in "Transfer-Encoding: gzip, chunked", and Request.
+	// TransferEncoding then lists only chunked. By default, requests
+	// with any transfer coding other than chunked are rejected.
+	AllowTransferEncodings []string
+
+	// MaxDecodedBodyBytes limits the size of a request body decoded
+	// from the codings allowed by AllowTransferEncodings. Reading
+	// past the limit returns an error and closes the connection after
+	// the response, as for MaxBytesReader. If zero, 10 MB is used.
+	MaxDecodedBodyBytes int64
+
+	// MaxMultipartTempFiles limits the number of temporary files
+	// that Request.ParseMultipartForm may create on disk to hold
+	// the file parts of a single request. Forms needing more cause
+	// ParseMultipartForm to return multipart.ErrTooManyTempFiles.
+	// If zero, there is no limit.
+	MaxMultipartTempFiles int
+
+	// MaxMultipartParts and MaxPartHeaderBytes limit the number of
+	// parts in a multipart request body, and the size of the header
+	// of each part, as read by Request.MultipartReader and
+	// Request.ParseMultipartForm. They set the MaxParts and
+	// MaxPartHeaderBytes of the multipart.Reader, which checks them
+	// as it reads each part, so that a body of very many parts or
+	// of huge part headers is rejected before it has been read
+	// whole. If zero, there is no limit.
+	MaxMultipartParts  int
+	MaxPartHeaderBytes int
+
+	// MaxBufferedResponseBytes, if positive, limits the number of
+	// bytes that HTTP/1 handlers across all connections may have
+	// written without them having been sent to their connections
+	// yet. A handler whose write would exceed the limit first sends
+	// its own buffered data and then blocks until enough data
+	// buffered by other handlers has been sent. This bounds the
+	// memory held for slow clients, at the cost of making writes
+	// slower when the limit is reached. A single write larger than
+	// the limit proceeds once nothing else is buffered. A waiting
+	// write fails, and the connection is closed, if the request's
+	// context is done or the server is shut down. Data stays
+	// counted until it is sent, including while the handler that
+	// wrote it is blocked on something else, so such handlers should
+	// call Flush before blocking.
+	// The accounting is approximate: it includes the data written by
+	// handlers, but not the response headers and framing.
+	// If zero, there is no limit.
+	MaxBufferedResponseBytes int64
+
+	// StrictBodyTermination, if true, makes the server check the
+	// bytes that arrived immediately after an HTTP/1 request body
+	// delimited by Content-Length. Such bytes are only valid as the
+	// start of a pipelined request, so if they cannot begin a valid
+	// request line, the server closes the connection without
+	// reading them, rather than treating them as the next request.
+	// The empty line that some old clients send after a POST body
+	// is skipped first, as it is when reading the next request.
+	// This guards against request smuggling that relies on
+	// ambiguous body boundaries.
+	StrictBodyTermination bool
+
+	// CloseOnStatus lists response status codes after which the
+	// server closes the connection, so that the client reconnects
+	// rather than reusing a connection a failed handler may have
+	// left in an uncertain state. An HTTP/1 response with such a
+	// status is sent with "Connection: close". On HTTP/2, the
+	// server starts a graceful shutdown of the connection once the
+	// handler returns, letting other streams in progress finish.
+	CloseOnStatus []int
+
+	// AllowedHosts optionally lists the hosts the server serves.
+	// A request whose Host is not among them is answered with 421
+	// Misdirected Request before the Handler is called, as
+	// protection against forged Host headers. Each entry is a host
+	// name, such as "example.com", an IP address, with or without
+	// square brackets for IPv6, or a wildcard "*.example.com"
+	// matching any subdomain of example.com but not example.com
+	// itself. Host names are matched case-insensitively, a trailing
+	// dot is ignored, and so is the port of the Host of the
+	// request. If empty, requests for any host are served.
+	AllowedHosts []string
+
+	// HandleExpect optionally decides what to do with a request
+	// whose Expect header holds an expectation other than
+	// 100-continue, which the server itself does not implement. It
+	// is called before the Handler, and must not read the request
+	// body. If it returns proceed, the request is served as if the
+	// other expectations were met, with a 100-continue expectation
+	// still handled by the server; otherwise the client gets an
+	// error response with the returned status, or with 417
+	// Expectation Failed if status is not a 4xx or 5xx code. If
+	// nil, all such requests get 417 Expectation Failed, as RFC
+	// 9110 suggests.
+	HandleExpect func(r *Request) (proceed bool, status int)
+
+	// TCPSendBuffer and TCPRecvBuffer, if positive, set the sizes
+	// of the operating system's send and receive buffers (SO_SNDBUF
+	// and SO_RCVBUF) for accepted TCP connections, before
+	// ConfigureConn is called. The operating system may clamp the
+	// requested sizes. They are ignored for connections that are
+	// not TCP.
+	TCPSendBuffer int
+	TCPRecvBuffer int
+
 	// TLSNextProto optionally specifies a function to take over
 	// ownership of the provided TLS connection when an ALPN
 	// protocol upgrade has occurred. The map key is the protocol
@@ -2634,6 +4296,15 @@ type Server struct {
 	// ConnState type and associated constants for details.
 	ConnState func(net.Conn, ConnState)
 
+	// TLSHandshakeDone optionally specifies a function that is
+	// called after the TLS handshake on a new connection completes
+	// successfully, before the connection is used to serve any
+	// requests. It is called for both HTTP/1.x and HTTP/2
+	// connections. The state's DidResume field reports whether
+	// the client resumed a previous TLS session, which makes this
+	// a convenient place to measure session resumption rates.
+	TLSHandshakeDone func(net.Conn, tls.ConnectionState)
+
 	// ErrorLog specifies an optional logger for errors accepting
 	// connections, unexpected behavior from handlers, and
 	// underlying FileSystem errors.
@@ -2654,9 +4325,75 @@ type Server struct {
 	// value.
 	ConnContext func(ctx context.Context, c net.Conn) context.Context
 
+	// ConfigureConn optionally specifies a function that is called
+	// with each connection returned by the listener's Accept method,
+	// before the connection is served. It can be used to set
+	// socket options, for example by asserting c to *net.TCPConn.
+	// For connections accepted by ServeTLS or ListenAndServeTLS,
+	// c is a *tls.Conn whose NetConn method returns the underlying
+	// connection.
+	//
+	// ConfigureConn is called on the goroutine accepting connections,
+	// so it should not block. If it returns an error, the error is
+	// logged and the connection is closed without being served.
+	ConfigureConn func(c net.Conn) error
+
+	// StatusCodeRewriter optionally specifies a function that maps
+	// the status code a handler passes to WriteHeader to the one
+	// sent, such as to send 400 Bad Request in place of a status used
+	// internally. The response is then framed for the new code: if
+	// it is one that has no body, such as 204 No Content, the
+	// handler's writes fail with ErrBodyNotAllowed. Informational
+	// (1xx) codes are not rewritten, and a returned code outside the
+	// range 200-999 is logged and ignored.
+	//
+	// StatusCodeRewriter applies to HTTP/1 responses only. HTTP/2
+	// responses are not rewritten.
+	StatusCodeRewriter func(code int) int
+
+	// OnFirstByte optionally specifies a function that is called
+	// once for each HTTP/1 request with the time from the start of
+	// its Handler to the Handler's first call to WriteHeader or
+	// Write, its time to first byte. For a Handler that writes
+	// nothing, it is called with the Handler's duration once it
+	// returns. It is not called for hijacked connections, or for
+	// HTTP/2 requests.
+	OnFirstByte func(r *Request, ttfb time.Duration)
+
+	// OnBodyWithBodylessStatus optionally specifies a function that
+	// is called when a Handler writes body data to an HTTP/1
+	// response whose status code does not allow a body: 204 No
+	// Content, 304 Not Modified, or a 1xx code. Such writes are
+	// discarded, returning ErrBodyNotAllowed, and the response is
+	// sent without a Content-Length, whether or not it is set. The
+	// function is called at most once per response, with the
+	// response's status code, and may be used to log the Handler's
+	// mistake. It is not called for HTTP/2 requests.
+	OnBodyWithBodylessStatus func(r *Request, code int)
+
+	// MaxResponseBodyBytes, if positive, limits the size of each
+	// HTTP/1 response body, as a safety net against handlers that
+	// write without end because of a bug. A Write that would exceed
+	// the limit returns ErrResponseBodyTooLarge, cancels the
+	// request's context, and aborts the response. If none of the
+	// response has been sent yet, as when the handler hasn't flushed
+	// and has written no more than a few kilobytes, a 500 Internal
+	// Server Error is sent instead. Otherwise the data written so far is sent,
+	// but the response is not completed, so that clients see an
+	// error, and the connection is closed. Handlers exempt their
+	// responses with AllowLargeResponseBody. If zero, there is no
+	// limit.
+	MaxResponseBodyBytes int64
+
+	// OnResponseBodyTooLarge optionally specifies a function that
+	// is called when a response exceeds MaxResponseBodyBytes. If
+	// nil, the server logs the request's method and path.
+	OnResponseBodyTooLarge func(r *Request)
+
 	inShutdown atomicBool // true when server is in shutdown
 
 	disableKeepAlives int32     // accessed atomically.
+	inFlight          int32     // handlers running, for ShutdownWithTimeout; accessed atomically.
 	nextProtoOnce     sync.Once // guards setupHTTP2_* init
 	nextProtoErr      error     // result of http2.ConfigureServer if used
 
@@ -2665,6 +4402,30 @@ type Server struct {
 	activeConn map[*conn]struct{}
 	doneChan   chan struct{}
 	onShutdown []func()
+
+	handshakeSem chan struct{} // for MaxConcurrentHandshakes; guarded by mu
+	acceptSem    chan struct{} // for AcceptQueueLimit; guarded by mu
+
+	// connStats is allocated by the first call to Serve, before any
+	// connection exists, for ConnStats.
+	connStats *connCounters
+
+	// respBuf is allocated with connStats, and kept apart from the
+	// Server so that its counter is 64-bit aligned on 32-bit platforms.
+	respBuf *responseBuffer
+}
+
+// responseBuffer counts the response bytes buffered by a Server's
+// connections against its MaxBufferedResponseBytes.
+type responseBuffer struct {
+	// n is the number of bytes counted, and waiters the number of
+	// handlers waiting for it to drop; both are accessed atomically.
+	// mu guards released, which is closed, and cleared, when bytes
+	// are released while handlers wait.
+	n        int64
+	waiters  int32
+	mu       sync.Mutex
+	released chan struct{}
 }
 
 func (s *Server) getDoneChan() <-chan struct{} {
@@ -2744,13 +4505,73 @@ const shutdownPollIntervalMax = 500 * time.Millisecond
 // Once Shutdown has been called on a server, it may not be reused;
 // future calls to methods such as Serve will return ErrServerClosed.
 func (srv *Server) Shutdown(ctx context.Context) error {
+	lnerr, err := srv.shutdown(ctx, nil)
+	if err != nil {
+		return err
+	}
+	return lnerr
+}
+
+// ShutdownWithTimeout shuts down the server like Shutdown, waiting up
+// to d for active connections to become idle, and then closes those
+// that remain like Close does. The functions registered with
+// RegisterOnShutdown are started when the shutdown begins, and are
+// given until the end of the same grace period to return before any
+// connection is closed forcibly.
+//
+// ShutdownWithTimeout returns the number of requests whose handlers
+// were still running when their connections were closed, for HTTP/1
+// and HTTP/2 alike, and any error returned from closing the Server's
+// underlying Listener(s). Handlers of hijacked connections, which
+// ShutdownWithTimeout doesn't close, are not counted.
+func (srv *Server) ShutdownWithTimeout(d time.Duration) (forced int, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), d)
+	defer cancel()
+	var hooks sync.WaitGroup
+	lnerr, err := srv.shutdown(ctx, &hooks)
+	hooksDone := make(chan struct{})
+	go func() {
+		hooks.Wait()
+		close(hooksDone)
+	}()
+	select {
+	case <-hooksDone:
+	case <-ctx.Done():
+	}
+	if err == nil {
+		return 0, lnerr
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	forced = int(atomic.LoadInt32(&srv.inFlight))
+	for c := range srv.activeConn {
+		c.rwc.Close()
+		delete(srv.activeConn, c)
+	}
+	return forced, lnerr
+}
+
+// shutdown implements Shutdown, returning separately the error from
+// closing the listeners and the context's error if it expires first.
+// If hooks is not nil, it is done once the functions registered with
+// RegisterOnShutdown have returned.
+func (srv *Server) shutdown(ctx context.Context, hooks *sync.WaitGroup) (lnerr, err error) {
 	srv.inShutdown.setTrue()
 
 	srv.mu.Lock()
-	lnerr := srv.closeListenersLocked()
+	lnerr = srv.closeListenersLocked()
 	srv.closeDoneChanLocked()
 	for _, f := range srv.onShutdown {
-		go f()
+		if hooks == nil {
+			go f()
+			continue
+		}
+		hooks.Add(1)
+		go func(f func()) {
+			defer hooks.Done()
+			f()
+		}(f)
 	}
 	srv.mu.Unlock()
 
@@ -2770,11 +4591,11 @@ func (srv *Server) Shutdown(ctx context.Context) error {
 	defer timer.Stop()
 	for {
 		if srv.closeIdleConns() && srv.numListeners() == 0 {
-			return lnerr
+			return lnerr, nil
 		}
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return lnerr, ctx.Err()
 		case <-timer.C:
 			timer.Reset(nextPollInterval())
 		}
@@ -2792,6 +4613,88 @@ func (srv *Server) RegisterOnShutdown(f func()) {
 	srv.mu.Unlock()
 }
 
+// ConnInfo describes a connection reported by Server.ActiveConns.
+type ConnInfo struct {
+	// RemoteAddr is the client's network address, in the form of
+	// Request.RemoteAddr.
+	RemoteAddr string
+
+	// Protocol is the ALPN protocol ID of the protocol served on
+	// the connection: "h2" for HTTP/2, the key of another
+	// TLSNextProto entry that took it over, or "http/1.1".
+	Protocol string
+
+	// Requests is the number of requests received on the
+	// connection so far.
+	Requests int64
+}
+
+// ActiveConns returns information about each connection the server
+// is tracking: connections in state StateNew, StateActive, or
+// StateIdle, including HTTP/2 connections. Hijacked connections are
+// not included.
+func (srv *Server) ActiveConns() []ConnInfo {
+	// Copy the set, as c.mu must not be taken while holding srv.mu:
+	// Hijack takes srv.mu, in trackConn, while holding c.mu.
+	srv.mu.Lock()
+	active := make([]*conn, 0, len(srv.activeConn))
+	for c := range srv.activeConn {
+		active = append(active, c)
+	}
+	srv.mu.Unlock()
+	conns := make([]ConnInfo, 0, len(active))
+	for _, c := range active {
+		c.mu.Lock()
+		proto := c.nextProto
+		c.mu.Unlock()
+		if proto == "" {
+			proto = "http/1.1"
+		}
+		conns = append(conns, ConnInfo{
+			RemoteAddr: c.rwc.RemoteAddr().String(),
+			Protocol:   proto,
+			Requests:   atomic.LoadInt64(&c.requests),
+		})
+	}
+	return conns
+}
+
+// CloseConnsByAddr immediately closes the connections reported by
+// ActiveConns whose client IP address is addr, and returns the number
+// of connections closed. Requests in progress on them are aborted.
+// IPv4 addresses match their IPv4-mapped IPv6 form.
+//
+// CloseConnsByAddr does not stop the server from accepting new
+// connections from addr; see RestrictToNetworks for that.
+func (srv *Server) CloseConnsByAddr(addr netip.Addr) int {
+	addr = addr.Unmap()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	n := 0
+	for c := range srv.activeConn {
+		if connIP(c.rwc) == addr {
+			c.rwc.Close()
+			delete(srv.activeConn, c)
+			n++
+		}
+	}
+	return n
+}
+
+// connIP returns the unmapped IP address of the remote end of c, or
+// the zero Addr if it has none.
+func connIP(c net.Conn) netip.Addr {
+	switch a := c.RemoteAddr().(type) {
+	case *net.TCPAddr:
+		return a.AddrPort().Addr().Unmap()
+	case nil:
+		return netip.Addr{}
+	default:
+		ap, _ := netip.ParseAddrPort(a.String())
+		return ap.Addr().Unmap()
+	}
+}
+
 func (s *Server) numListeners() int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -2897,9 +4800,25 @@ func (sh serverHandler) ServeHTTP(rw ResponseWriter, req *Request) {
 	if handler == nil {
 		handler = DefaultServeMux
 	}
+	if w, ok := rw.(*http2responseWriter); ok {
+		st := w.rws.stream
+		req.serverPush = func() bool { return http2PushEnabled(st) }
+	}
 	if req.RequestURI == "*" && req.Method == "OPTIONS" {
 		handler = globalOptionsHandler{}
 	}
+	if len(sh.srv.AllowedHosts) > 0 && !sh.srv.hostAllowed(req.Host) {
+		handler = HandlerFunc(misdirectedRequest)
+	}
+	if req.ProtoMajor == 2 && req.hasOtherExpectation() {
+		// HTTP/1 requests are checked by conn.serve, before
+		// their 100-continue expectations are handled.
+		if ok, code := sh.srv.allowExpectation(req); !ok {
+			handler = HandlerFunc(func(w ResponseWriter, r *Request) {
+				w.WriteHeader(code)
+			})
+		}
+	}
 
 	if req.URL != nil && strings.Contains(req.URL.RawQuery, ";") {
 		var allowQuerySemicolonsInUse int32
@@ -2913,7 +4832,87 @@ func (sh serverHandler) ServeHTTP(rw ResponseWriter, req *Request) {
 		}()
 	}
 
+	atomic.AddInt32(&sh.srv.inFlight, 1)
+	defer func() {
+		// Response.Hijack stops counting the handlers of hijacked
+		// connections.
+		if w, ok := rw.(*response); !ok || !w.conn.hijacked() {
+			atomic.AddInt32(&sh.srv.inFlight, -1)
+		}
+	}()
 	handler.ServeHTTP(rw, req)
+
+	if w, ok := rw.(*http2responseWriter); ok && sh.srv.closesOnStatus(w.rws.status) {
+		w.rws.conn.startGracefulShutdown()
+	}
+}
+
+// http2PushEnabled reports whether the server may push responses
+// along with the response to the request on st.
+func http2PushEnabled(st *http2stream) bool {
+	if st.isPushed() {
+		return false
+	}
+	// pushEnabled is owned by the connection's serve goroutine.
+	sc := st.sc
+	enabled := make(chan bool, 1)
+	sc.sendServeMsg(func(int) { enabled <- sc.pushEnabled })
+	select {
+	case v := <-enabled:
+		return v
+	case <-sc.doneServing:
+		return false
+	}
+}
+
+// closesOnStatus reports whether responses with the given status code
+// end their connection, per CloseOnStatus.
+func (srv *Server) closesOnStatus(code int) bool {
+	for _, c := range srv.CloseOnStatus {
+		if c == code {
+			return true
+		}
+	}
+	return false
+}
+
+// hostAllowed reports whether the Host of a request, host, is listed
+// in srv.AllowedHosts.
+func (srv *Server) hostAllowed(host string) bool {
+	host = stripBrackets(muxHost(stripHostPort(host)))
+	if host == "" {
+		return false
+	}
+	ip, ipErr := netip.ParseAddr(host)
+	for _, pat := range srv.AllowedHosts {
+		pat = stripBrackets(muxHost(pat))
+		if rest := strings.TrimPrefix(pat, "*."); rest != pat {
+			if strings.HasSuffix(host, "."+rest) {
+				return true
+			}
+			continue
+		}
+		if pat == host {
+			return true
+		}
+		if ipErr == nil {
+			if pip, err := netip.ParseAddr(pat); err == nil && pip.Unmap() == ip.Unmap() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func stripBrackets(h string) string {
+	if len(h) > 1 && h[0] == '[' && h[len(h)-1] == ']' {
+		return h[1 : len(h)-1]
+	}
+	return h
+}
+
+func misdirectedRequest(w ResponseWriter, r *Request) {
+	Error(w, "421 misdirected request", StatusMisdirectedRequest)
 }
 
 var silenceSemWarnContextKey = &contextKey{"silence-semicolons"}
@@ -3034,10 +5033,32 @@ func (srv *Server) Serve(l net.Listener) error {
 
 	var tempDelay time.Duration // how long to sleep on accept failure
 
+	var acceptSem chan struct{}
+	if srv.AcceptQueueLimit > 0 {
+		srv.mu.Lock()
+		if srv.acceptSem == nil {
+			srv.acceptSem = make(chan struct{}, srv.AcceptQueueLimit)
+		}
+		acceptSem = srv.acceptSem
+		srv.mu.Unlock()
+	}
+
 	ctx := context.WithValue(baseCtx, ServerContextKey, srv)
+	ctx = context.WithValue(ctx, ListenerAddrContextKey, origListener.Addr())
 	for {
+		if acceptSem != nil {
+			// Wait for a place in the queue of new connections.
+			select {
+			case acceptSem <- struct{}{}:
+			case <-srv.getDoneChan():
+				return ErrServerClosed
+			}
+		}
 		rw, err := l.Accept()
 		if err != nil {
+			if acceptSem != nil {
+				<-acceptSem
+			}
 			select {
 			case <-srv.getDoneChan():
 				return ErrServerClosed
@@ -3058,6 +5079,18 @@ func (srv *Server) Serve(l net.Listener) error {
 			}
 			return err
 		}
+		tempDelay = 0
+		setTCPBuffers(rw, srv.TCPSendBuffer, srv.TCPRecvBuffer)
+		if cfg := srv.ConfigureConn; cfg != nil {
+			if err := cfg(rw); err != nil {
+				srv.logf("http: ConfigureConn error for %v: %v", rw.RemoteAddr(), err)
+				rw.Close()
+				if acceptSem != nil {
+					<-acceptSem
+				}
+				continue
+			}
+		}
 		connCtx := ctx
 		if cc := srv.ConnContext; cc != nil {
 			connCtx = cc(connCtx, rw)
@@ -3065,8 +5098,8 @@ func (srv *Server) Serve(l net.Listener) error {
 				panic("ConnContext returned nil")
 			}
 		}
-		tempDelay = 0
 		c := srv.newConn(rw)
+		c.acceptSem = acceptSem
 		c.setState(c.rwc, StateNew, runHooks) // before Serve can return
 		go c.serve(connCtx)
 	}
@@ -3131,6 +5164,10 @@ func (s *Server) trackListener(ln *net.Listener, add bool) bool {
 		if s.shuttingDown() {
 			return false
 		}
+		if s.connStats == nil {
+			s.connStats = new(connCounters)
+			s.respBuf = new(responseBuffer)
+		}
 		s.listeners[ln] = struct{}{}
 	} else {
 		delete(s.listeners, ln)
@@ -3498,9 +5535,10 @@ func (globalOptionsHandler) ServeHTTP(w ResponseWriter, r *Request) {
 // uninitialized fields in its *Request. Such partially-initialized
 // Requests come from ALPN protocol handlers.
 type initALPNRequest struct {
-	ctx context.Context
-	c   *tls.Conn
-	h   serverHandler
+	ctx  context.Context
+	c    *tls.Conn
+	h    serverHandler
+	conn *conn // for counting requests
 }
 
 // BaseContext is an exported but unadvertised http.Handler method
@@ -3520,6 +5558,7 @@ func (h initALPNRequest) ServeHTTP(rw ResponseWriter, req *Request) {
 	if req.RemoteAddr == "" {
 		req.RemoteAddr = h.c.RemoteAddr().String()
 	}
+	atomic.AddInt64(&h.conn.requests, 1)
 	h.h.ServeHTTP(rw, req)
 }
 
@@ -3578,6 +5617,9 @@ func (w checkConnErrorWriter) Write(p []byte) (n int, err error) {
 		w.c.werr = err
 		w.c.cancelCtx()
 	}
+	if w.c.server.MaxBufferedResponseBytes > 0 {
+		w.c.releaseBufferedResponse(int64(n))
+	}
 	return
 }
 
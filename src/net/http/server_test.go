@@ -90,9 +90,24 @@ func BenchmarkServerMatch(b *testing.B) {
 		"/products/", "/products/3/image.jpg"}
 	b.StartTimer()
 	for i := 0; i < b.N; i++ {
-		if h, p := mux.match(paths[i%len(paths)]); h != nil && p == "" {
+		if h, p, _ := mux.match("GET", paths[i%len(paths)]); h != nil && p == "" {
 			b.Error("impossible")
 		}
 	}
 	b.StopTimer()
 }
+
+func TestResponseControllerHTTP2HandlerDone(t *testing.T) {
+	// An http2responseWriter's state is cleared when its handler
+	// returns.
+	rc := NewResponseController(&http2responseWriter{})
+	if err := rc.EarlyHints(Header{"Link": {"</style.css>; rel=preload"}}); err != errHandlerDone {
+		t.Errorf("EarlyHints = %v; want %v", err, errHandlerDone)
+	}
+	if err := rc.WriteError(); err != errHandlerDone {
+		t.Errorf("WriteError = %v; want %v", err, errHandlerDone)
+	}
+	if _, err := rc.NegotiatedProtocol(); err != errHandlerDone {
+		t.Errorf("NegotiatedProtocol = %v; want %v", err, errHandlerDone)
+	}
+}
@@ -0,0 +1,18 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !aix && !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd && !solaris
+
+package http_test
+
+import (
+	"errors"
+	"net"
+)
+
+const canReadTCPBufferSizes = false
+
+func tcpBufferSizes(c net.Conn) (send, recv int, err error) {
+	return 0, 0, errors.New("reading socket buffer sizes is not supported")
+}
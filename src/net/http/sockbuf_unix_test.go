@@ -0,0 +1,39 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build aix || darwin || dragonfly || freebsd || linux || netbsd || openbsd || solaris
+
+package http_test
+
+import (
+	"crypto/tls"
+	"net"
+	"syscall"
+)
+
+const canReadTCPBufferSizes = true
+
+// tcpBufferSizes returns the SO_SNDBUF and SO_RCVBUF sizes of the TCP
+// connection under c.
+func tcpBufferSizes(c net.Conn) (send, recv int, err error) {
+	if tc, ok := c.(*tls.Conn); ok {
+		c = tc.NetConn()
+	}
+	rc, err := c.(*net.TCPConn).SyscallConn()
+	if err != nil {
+		return 0, 0, err
+	}
+	var sendErr, recvErr error
+	err = rc.Control(func(fd uintptr) {
+		send, sendErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+		recv, recvErr = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+	})
+	if err == nil {
+		err = sendErr
+	}
+	if err == nil {
+		err = recvErr
+	}
+	return send, recv, err
+}
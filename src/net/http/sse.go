@@ -0,0 +1,116 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Server-sent events.
+
+package http
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An SSEvent is a server-sent event, sent by an SSEWriter.
+type SSEvent struct {
+	// ID optionally sets the client's last event ID, which it
+	// sends in the Last-Event-ID header when it reconnects.
+	ID string
+
+	// Event optionally names the type of the event.
+	// If empty, the client dispatches it as a "message" event.
+	Event string
+
+	// Data is the event's data. It may contain newlines.
+	// An event with neither Data nor Event only updates the
+	// client's last event ID or reconnection time, and is not
+	// dispatched.
+	Data string
+
+	// Retry optionally sets the time the client waits before
+	// reconnecting if the connection is lost. It is sent in
+	// milliseconds.
+	Retry time.Duration
+}
+
+// An SSEWriter sends server-sent events, as described by the HTML
+// Living Standard, to the client of an HTTP handler.
+type SSEWriter struct {
+	rw ResponseWriter
+	rc *ResponseController
+}
+
+// NewSSEWriter returns an SSEWriter sending events on w. It sets the
+// response's Content-Type to "text/event-stream" and its Cache-Control
+// to "no-cache", and asks HTTP/1 clients to keep the connection alive.
+// The handler may change the header further before the first event is
+// sent, but must not write to w itself.
+//
+// The ResponseWriter should be the original value passed to the
+// Handler.ServeHTTP method, or have an Unwrap method returning the
+// original, as for NewResponseController.
+func NewSSEWriter(w ResponseWriter) *SSEWriter {
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	return &SSEWriter{rw: w, rc: NewResponseController(w)}
+}
+
+// Send sends ev to the client and flushes the response. Multi-line
+// data is sent as one data line per line of ev.Data. Send returns an
+// error, and sends nothing, if ev.ID or ev.Event contains a line break
+// or ev.Retry is negative, or if the ResponseWriter can't be flushed,
+// in which case the error matches ErrNotSupported.
+func (sw *SSEWriter) Send(ev SSEvent) error {
+	if !sw.rc.canFlush() {
+		return errNotSupported()
+	}
+	if strings.ContainsAny(ev.ID, "\r\n\x00") {
+		return errors.New("http: invalid server-sent event ID")
+	}
+	if strings.ContainsAny(ev.Event, "\r\n") {
+		return errors.New("http: invalid server-sent event type")
+	}
+	if ev.Retry < 0 {
+		return errors.New("http: negative server-sent event retry")
+	}
+	var b strings.Builder
+	if ev.ID != "" {
+		b.WriteString("id: ")
+		b.WriteString(ev.ID)
+		b.WriteByte('\n')
+	}
+	if ev.Event != "" {
+		b.WriteString("event: ")
+		b.WriteString(ev.Event)
+		b.WriteByte('\n')
+	}
+	if ev.Retry > 0 {
+		b.WriteString("retry: ")
+		b.WriteString(strconv.FormatInt(ev.Retry.Milliseconds(), 10))
+		b.WriteByte('\n')
+	}
+	if ev.Data != "" || ev.Event != "" {
+		data := strings.ReplaceAll(ev.Data, "\r\n", "\n")
+		data = strings.ReplaceAll(data, "\r", "\n")
+		for _, line := range strings.Split(data, "\n") {
+			b.WriteString("data: ")
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteByte('\n')
+	if _, err := io.WriteString(sw.rw, b.String()); err != nil {
+		return err
+	}
+	return sw.rc.Flush()
+}
+
+// SendData sends an unnamed event with the given data, as Send does.
+func (sw *SSEWriter) SendData(data string) error {
+	return sw.Send(SSEvent{Data: data})
+}
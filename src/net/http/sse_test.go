@@ -0,0 +1,73 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	"errors"
+	. "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestSSEWriter(t *testing.T) {
+	rw := httptest.NewRecorder()
+	sw := NewSSEWriter(rw)
+	for _, ev := range []SSEvent{
+		{Data: "hello"},
+		{ID: "7", Event: "update", Data: "line1\nline2\r\nline3\r", Retry: 1500 * time.Millisecond},
+		{Event: "ping"},
+		{ID: "8"},
+	} {
+		if err := sw.Send(ev); err != nil {
+			t.Fatalf("Send(%+v) = %v", ev, err)
+		}
+	}
+	if err := sw.SendData("bye"); err != nil {
+		t.Fatal(err)
+	}
+	for _, ev := range []SSEvent{
+		{ID: "a\nb"},
+		{Event: "a\rb"},
+		{Data: "x", Retry: -time.Second},
+	} {
+		if err := sw.Send(ev); err == nil {
+			t.Errorf("Send(%+v) succeeded; want error", ev)
+		}
+	}
+	want := "data: hello\n\n" +
+		"id: 7\nevent: update\nretry: 1500\ndata: line1\ndata: line2\ndata: line3\ndata: \n\n" +
+		"event: ping\ndata: \n\n" +
+		"id: 8\n\n" +
+		"data: bye\n\n"
+	if got := rw.Body.String(); got != want {
+		t.Errorf("sent:\n%q\nwant:\n%q", got, want)
+	}
+	if !rw.Flushed {
+		t.Errorf("events were not flushed")
+	}
+	for k, v := range map[string]string{
+		"Content-Type":  "text/event-stream",
+		"Cache-Control": "no-cache",
+		"Connection":    "keep-alive",
+	} {
+		if got := rw.Header().Get(k); got != v {
+			t.Errorf("%s = %q; want %q", k, got, v)
+		}
+	}
+}
+
+type noFlushWriter struct{ ResponseWriter }
+
+func TestSSEWriterNoFlush(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw := NewSSEWriter(noFlushWriter{rec})
+	if err := sw.SendData("x"); !errors.Is(err, ErrNotSupported) {
+		t.Errorf("SendData with a ResponseWriter that can't flush = %v; want ErrNotSupported", err)
+	}
+	if rec.Body.Len() != 0 {
+		t.Errorf("SendData wrote %q before failing; want nothing", rec.Body)
+	}
+}
@@ -0,0 +1,629 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Structured Field Values (RFC 8941).
+
+package http
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/http/httpguts"
+)
+
+// A StructuredToken is a Token value of a structured field, such as
+// the gzip of "a=gzip". A Token differs from a String, which is
+// quoted, in its syntax but may carry the same meaning.
+type StructuredToken string
+
+// A StructuredItem is an Item of a structured field: a bare value,
+// with parameters.
+//
+// The Value of an Item is an int64 for an Integer, a float64 for a
+// Decimal, a string for a String, a StructuredToken for a Token, a
+// []byte for a Byte Sequence, or a bool for a Boolean. The values
+// of parameters have the same types. When formatting, an int is also
+// accepted for an Integer.
+type StructuredItem struct {
+	Value  any
+	Params []StructuredParam
+}
+
+// A StructuredParam is a parameter of an Item or Inner List of a
+// structured field. A parameter without a value, such as the a of
+// "1;a", has the value true.
+type StructuredParam struct {
+	Key   string
+	Value any
+}
+
+// A StructuredInnerList is an Inner List of a structured field, such
+// as "(a b);q=1": a list of Items, with parameters of its own.
+type StructuredInnerList struct {
+	Items  []StructuredItem
+	Params []StructuredParam
+}
+
+// A StructuredList is a List structured field, such as that of a
+// Cache-Status header. Each of its members is a StructuredItem or a
+// StructuredInnerList.
+type StructuredList []any
+
+// A StructuredDict is a Dictionary structured field, such as that of
+// a Priority header, in the order of its keys in the field.
+type StructuredDict []StructuredDictMember
+
+// A StructuredDictMember is a member of a Dictionary structured field.
+// Its Value is a StructuredItem or a StructuredInnerList. A member
+// without a value, such as the a of "a, b=2", has the Item value true,
+// possibly with parameters.
+type StructuredDictMember struct {
+	Key   string
+	Value any
+}
+
+// Get returns the value of the member of d with key, if there is one.
+func (d StructuredDict) Get(key string) (value any, ok bool) {
+	for _, m := range d {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// ParseStructuredItem parses the value of an Item structured field,
+// such as "?1" or `"text";lang=en`.
+//
+// As with the other ParseStructured functions, the value of a field
+// sent in several header lines must be passed as one string, the
+// lines joined by commas, as in
+//
+//	strings.Join(h.Values(key), ",")
+//
+// Parsing follows RFC 8941, so it fails on any syntax error rather
+// than skip what it can't parse; RFC 8941 requires that the whole
+// field then be ignored.
+func ParseStructuredItem(v string) (StructuredItem, error) {
+	p := sfParser{s: v}
+	p.skipSP()
+	it, err := p.item()
+	if err != nil {
+		return StructuredItem{}, err
+	}
+	if err := p.end(); err != nil {
+		return StructuredItem{}, err
+	}
+	return it, nil
+}
+
+// ParseStructuredList parses the value of a List structured field,
+// such as "a, (b c);q=1". An empty value is an empty List.
+func ParseStructuredList(v string) (StructuredList, error) {
+	p := sfParser{s: v}
+	p.skipSP()
+	var l StructuredList
+	for !p.eof() {
+		m, err := p.itemOrInnerList()
+		if err != nil {
+			return nil, err
+		}
+		l = append(l, m)
+		if done, err := p.next(); done || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return l, nil
+}
+
+// ParseStructuredDict parses the value of a Dictionary structured
+// field, such as "u=1, i". An empty value is an empty Dictionary.
+// A key appearing more than once gets its last value, at the position
+// of its first appearance.
+func ParseStructuredDict(v string) (StructuredDict, error) {
+	p := sfParser{s: v}
+	p.skipSP()
+	var d StructuredDict
+	for !p.eof() {
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var m any
+		if p.peek() == '=' {
+			p.i++
+			m, err = p.itemOrInnerList()
+		} else {
+			var params []StructuredParam
+			params, err = p.params()
+			m = StructuredItem{Value: true, Params: params}
+		}
+		if err != nil {
+			return nil, err
+		}
+		d = setDictMember(d, key, m)
+		if done, err := p.next(); done || err != nil {
+			if err != nil {
+				return nil, err
+			}
+			break
+		}
+	}
+	return d, nil
+}
+
+func setDictMember(d StructuredDict, key string, value any) StructuredDict {
+	for i := range d {
+		if d[i].Key == key {
+			d[i].Value = value
+			return d
+		}
+	}
+	return append(d, StructuredDictMember{key, value})
+}
+
+// sfParser parses structured fields, following the algorithms of RFC
+// 8941, section 4.2.
+type sfParser struct {
+	s string
+	i int // offset of the next byte
+}
+
+func (p *sfParser) eof() bool { return p.i >= len(p.s) }
+
+// peek returns the next byte, or 0 at the end.
+func (p *sfParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.i]
+}
+
+func (p *sfParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("http: invalid structured field at offset %d: %s", p.i, fmt.Sprintf(format, args...))
+}
+
+func (p *sfParser) skipSP() {
+	for p.peek() == ' ' {
+		p.i++
+	}
+}
+
+func (p *sfParser) skipOWS() {
+	for c := p.peek(); c == ' ' || c == '\t'; c = p.peek() {
+		p.i++
+	}
+}
+
+// end checks that only spaces are left.
+func (p *sfParser) end() error {
+	p.skipSP()
+	if !p.eof() {
+		return p.errorf("unexpected %q", p.s[p.i])
+	}
+	return nil
+}
+
+// next moves past the comma separating the members of a List or
+// Dictionary, reporting whether there are no more members.
+func (p *sfParser) next() (done bool, err error) {
+	p.skipOWS()
+	if p.eof() {
+		return true, nil
+	}
+	if p.s[p.i] != ',' {
+		return false, p.errorf("expected ',', found %q", p.s[p.i])
+	}
+	p.i++
+	p.skipOWS()
+	if p.eof() {
+		return false, p.errorf("trailing comma")
+	}
+	return false, nil
+}
+
+func (p *sfParser) itemOrInnerList() (any, error) {
+	if p.peek() == '(' {
+		return p.innerList()
+	}
+	return p.item()
+}
+
+func (p *sfParser) innerList() (StructuredInnerList, error) {
+	var l StructuredInnerList
+	p.i++ // '('
+	for {
+		p.skipSP()
+		if p.eof() {
+			return l, p.errorf("unterminated inner list")
+		}
+		if p.s[p.i] == ')' {
+			p.i++
+			var err error
+			l.Params, err = p.params()
+			return l, err
+		}
+		it, err := p.item()
+		if err != nil {
+			return l, err
+		}
+		l.Items = append(l.Items, it)
+		if c := p.peek(); c != ' ' && c != ')' {
+			if p.eof() {
+				return l, p.errorf("unterminated inner list")
+			}
+			return l, p.errorf("expected ' ' or ')', found %q", c)
+		}
+	}
+}
+
+func (p *sfParser) item() (StructuredItem, error) {
+	v, err := p.bareItem()
+	if err != nil {
+		return StructuredItem{}, err
+	}
+	params, err := p.params()
+	if err != nil {
+		return StructuredItem{}, err
+	}
+	return StructuredItem{Value: v, Params: params}, nil
+}
+
+func (p *sfParser) params() ([]StructuredParam, error) {
+	var params []StructuredParam
+	for p.peek() == ';' {
+		p.i++
+		p.skipSP()
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		var v any = true
+		if p.peek() == '=' {
+			p.i++
+			if v, err = p.bareItem(); err != nil {
+				return nil, err
+			}
+		}
+		params = setParam(params, key, v)
+	}
+	return params, nil
+}
+
+func setParam(params []StructuredParam, key string, value any) []StructuredParam {
+	for i := range params {
+		if params[i].Key == key {
+			params[i].Value = value
+			return params
+		}
+	}
+	return append(params, StructuredParam{key, value})
+}
+
+func isLCAlpha(c byte) bool { return 'a' <= c && c <= 'z' }
+func isAlpha(c byte) bool   { return isLCAlpha(c | 0x20) }
+func isDigit(c byte) bool   { return '0' <= c && c <= '9' }
+
+func isKeyChar(c byte) bool {
+	return isLCAlpha(c) || isDigit(c) || c == '_' || c == '-' || c == '.' || c == '*'
+}
+
+func (p *sfParser) key() (string, error) {
+	if c := p.peek(); !isLCAlpha(c) && c != '*' {
+		return "", p.errorf("invalid key")
+	}
+	start := p.i
+	for !p.eof() && isKeyChar(p.s[p.i]) {
+		p.i++
+	}
+	return p.s[start:p.i], nil
+}
+
+func (p *sfParser) bareItem() (any, error) {
+	switch c := p.peek(); {
+	case c == '-' || isDigit(c):
+		return p.number()
+	case c == '"':
+		return p.string()
+	case c == '*' || isAlpha(c):
+		return p.token(), nil
+	case c == ':':
+		return p.byteSequence()
+	case c == '?':
+		return p.boolean()
+	case p.eof():
+		return nil, p.errorf("missing item")
+	default:
+		return nil, p.errorf("unexpected %q", c)
+	}
+}
+
+func (p *sfParser) number() (any, error) {
+	start := p.i
+	if p.peek() == '-' {
+		p.i++
+	}
+	if !isDigit(p.peek()) {
+		return nil, p.errorf("invalid number")
+	}
+	numStart, dot := p.i, -1
+	for !p.eof() {
+		c := p.s[p.i]
+		if c == '.' && dot < 0 {
+			if p.i-numStart > 12 {
+				return nil, p.errorf("decimal out of range")
+			}
+			dot = p.i
+		} else if !isDigit(c) {
+			break
+		}
+		p.i++
+		if n := p.i - numStart; dot < 0 && n > 15 || dot >= 0 && n > 16 {
+			return nil, p.errorf("number out of range")
+		}
+	}
+	num := p.s[start:p.i]
+	if dot < 0 {
+		n, err := strconv.ParseInt(num, 10, 64)
+		if err != nil {
+			return nil, p.errorf("invalid integer")
+		}
+		return n, nil
+	}
+	if frac := p.i - dot - 1; frac < 1 || frac > 3 {
+		return nil, p.errorf("decimal needs 1 to 3 fractional digits")
+	}
+	f, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return nil, p.errorf("invalid decimal")
+	}
+	return f, nil
+}
+
+func (p *sfParser) string() (string, error) {
+	p.i++ // '"'
+	var b strings.Builder
+	for !p.eof() {
+		c := p.s[p.i]
+		p.i++
+		switch {
+		case c == '\\':
+			if c := p.peek(); c != '"' && c != '\\' {
+				return "", p.errorf("invalid escape in string")
+			}
+			b.WriteByte(p.s[p.i])
+			p.i++
+		case c == '"':
+			return b.String(), nil
+		case c < 0x20 || c > 0x7e:
+			return "", p.errorf("invalid character in string")
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", p.errorf("unterminated string")
+}
+
+func isStructuredTokenChar(c byte) bool {
+	return httpguts.IsTokenRune(rune(c)) || c == ':' || c == '/'
+}
+
+func (p *sfParser) token() StructuredToken {
+	start := p.i
+	p.i++ // '*' or ALPHA
+	for !p.eof() && isStructuredTokenChar(p.s[p.i]) {
+		p.i++
+	}
+	return StructuredToken(p.s[start:p.i])
+}
+
+func (p *sfParser) byteSequence() ([]byte, error) {
+	p.i++ // ':'
+	end := strings.IndexByte(p.s[p.i:], ':')
+	if end < 0 {
+		return nil, p.errorf("unterminated byte sequence")
+	}
+	// Accept base64 with or without padding, as RFC 8941 suggests.
+	enc := strings.TrimRight(p.s[p.i:p.i+end], "=")
+	b, err := base64.RawStdEncoding.DecodeString(enc)
+	if err != nil {
+		return nil, p.errorf("invalid base64 in byte sequence")
+	}
+	p.i += end + 1
+	return b, nil
+}
+
+func (p *sfParser) boolean() (bool, error) {
+	p.i++ // '?'
+	switch p.peek() {
+	case '1':
+		p.i++
+		return true, nil
+	case '0':
+		p.i++
+		return false, nil
+	}
+	return false, p.errorf("invalid boolean")
+}
+
+// FormatStructuredItem returns the serialization of an Item
+// structured field, as described by RFC 8941. It returns an error
+// if it holds a value that can't be serialized, such as an Integer of
+// more than 15 digits or a String with a non-ASCII character.
+func FormatStructuredItem(it StructuredItem) (string, error) {
+	var b strings.Builder
+	if err := writeStructuredItem(&b, it); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// FormatStructuredList returns the serialization of a List
+// structured field, as FormatStructuredItem does for an Item.
+func FormatStructuredList(l StructuredList) (string, error) {
+	var b strings.Builder
+	for i, m := range l {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeStructuredMember(&b, m); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+// FormatStructuredDict returns the serialization of a Dictionary
+// structured field, as FormatStructuredItem does for an Item. Members
+// with the Item value true are written without their value, as in
+// "a, b;x=1".
+func FormatStructuredDict(d StructuredDict) (string, error) {
+	var b strings.Builder
+	for i, m := range d {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		if err := writeStructuredKey(&b, m.Key); err != nil {
+			return "", err
+		}
+		if it, ok := m.Value.(StructuredItem); ok && it.Value == true {
+			if err := writeStructuredParams(&b, it.Params); err != nil {
+				return "", err
+			}
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeStructuredMember(&b, m.Value); err != nil {
+			return "", err
+		}
+	}
+	return b.String(), nil
+}
+
+func writeStructuredMember(b *strings.Builder, m any) error {
+	switch m := m.(type) {
+	case StructuredItem:
+		return writeStructuredItem(b, m)
+	case StructuredInnerList:
+		b.WriteByte('(')
+		for i, it := range m.Items {
+			if i > 0 {
+				b.WriteByte(' ')
+			}
+			if err := writeStructuredItem(b, it); err != nil {
+				return err
+			}
+		}
+		b.WriteByte(')')
+		return writeStructuredParams(b, m.Params)
+	}
+	return fmt.Errorf("http: invalid structured field member of type %T", m)
+}
+
+func writeStructuredItem(b *strings.Builder, it StructuredItem) error {
+	if err := writeStructuredBareItem(b, it.Value); err != nil {
+		return err
+	}
+	return writeStructuredParams(b, it.Params)
+}
+
+func writeStructuredParams(b *strings.Builder, params []StructuredParam) error {
+	for _, p := range params {
+		b.WriteByte(';')
+		if err := writeStructuredKey(b, p.Key); err != nil {
+			return err
+		}
+		if p.Value == true {
+			continue
+		}
+		b.WriteByte('=')
+		if err := writeStructuredBareItem(b, p.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeStructuredKey(b *strings.Builder, key string) error {
+	if key == "" || !isLCAlpha(key[0]) && key[0] != '*' {
+		return errors.New("http: invalid structured field key " + strconv.Quote(key))
+	}
+	for i := 1; i < len(key); i++ {
+		if !isKeyChar(key[i]) {
+			return errors.New("http: invalid structured field key " + strconv.Quote(key))
+		}
+	}
+	b.WriteString(key)
+	return nil
+}
+
+// maxStructuredInteger is the largest magnitude of an Integer.
+const maxStructuredInteger = 999_999_999_999_999
+
+func writeStructuredBareItem(b *strings.Builder, v any) error {
+	switch v := v.(type) {
+	case int:
+		return writeStructuredBareItem(b, int64(v))
+	case int64:
+		if v > maxStructuredInteger || v < -maxStructuredInteger {
+			return fmt.Errorf("http: structured field integer %d out of range", v)
+		}
+		b.WriteString(strconv.FormatInt(v, 10))
+	case float64:
+		// Round to three fractional digits, ties to even.
+		r := math.RoundToEven(v*1000) / 1000
+		if math.IsNaN(r) || math.Abs(r) >= 1e12 {
+			return fmt.Errorf("http: structured field decimal %v out of range", v)
+		}
+		s := strconv.FormatFloat(r, 'f', -1, 64)
+		if !strings.Contains(s, ".") {
+			s += ".0"
+		}
+		b.WriteString(s)
+	case string:
+		b.WriteByte('"')
+		for i := 0; i < len(v); i++ {
+			c := v[i]
+			if c < 0x20 || c > 0x7e {
+				return fmt.Errorf("http: invalid character %q in structured field string", c)
+			}
+			if c == '"' || c == '\\' {
+				b.WriteByte('\\')
+			}
+			b.WriteByte(c)
+		}
+		b.WriteByte('"')
+	case StructuredToken:
+		if v == "" || v[0] != '*' && !isAlpha(v[0]) {
+			return errors.New("http: invalid structured field token " + strconv.Quote(string(v)))
+		}
+		for i := 1; i < len(v); i++ {
+			if !isStructuredTokenChar(v[i]) {
+				return errors.New("http: invalid structured field token " + strconv.Quote(string(v)))
+			}
+		}
+		b.WriteString(string(v))
+	case []byte:
+		b.WriteByte(':')
+		b.WriteString(base64.StdEncoding.EncodeToString(v))
+		b.WriteByte(':')
+	case bool:
+		if v {
+			b.WriteString("?1")
+		} else {
+			b.WriteString("?0")
+		}
+	default:
+		return fmt.Errorf("http: invalid structured field value of type %T", v)
+	}
+	return nil
+}
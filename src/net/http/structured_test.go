@@ -0,0 +1,245 @@
+// Copyright 2022 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http_test
+
+import (
+	. "net/http"
+	"reflect"
+	"testing"
+)
+
+var parseStructuredItemTests = []struct {
+	in   string
+	want StructuredItem
+	out  string // serialization, if not in
+}{
+	{"42", StructuredItem{Value: int64(42)}, ""},
+	{"-999999999999999", StructuredItem{Value: int64(-999999999999999)}, ""},
+	{"  4.5  ", StructuredItem{Value: 4.5}, "4.5"},
+	{"-0.125", StructuredItem{Value: -0.125}, ""},
+	{"100.000", StructuredItem{Value: 100.0}, "100.0"},
+	{`"hello \"world\" \\"`, StructuredItem{Value: `hello "world" \`}, ""},
+	{`""`, StructuredItem{Value: ""}, ""},
+	{"*foo123/456:bar", StructuredItem{Value: StructuredToken("*foo123/456:bar")}, ""},
+	{"Gzip", StructuredItem{Value: StructuredToken("Gzip")}, ""},
+	{":cHJldGVuZCB0aGlzIGlzIGJpbmFyeSBjb250ZW50Lg==:", StructuredItem{Value: []byte("pretend this is binary content.")}, ""},
+	{":aGk:", StructuredItem{Value: []byte("hi")}, ":aGk=:"},
+	{"::", StructuredItem{Value: []byte{}}, ""},
+	{"?1", StructuredItem{Value: true}, ""},
+	{"?0", StructuredItem{Value: false}, ""},
+	{`"text";lang=en;q=0.5;x;no=?0`, StructuredItem{
+		Value: "text",
+		Params: []StructuredParam{
+			{"lang", StructuredToken("en")},
+			{"q", 0.5},
+			{"x", true},
+			{"no", false},
+		},
+	}, ""},
+	{"1; a=1;a=2", StructuredItem{Value: int64(1), Params: []StructuredParam{{"a", int64(2)}}}, "1;a=2"},
+}
+
+func TestParseStructuredItem(t *testing.T) {
+	for _, tt := range parseStructuredItemTests {
+		got, err := ParseStructuredItem(tt.in)
+		if err != nil {
+			t.Errorf("ParseStructuredItem(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStructuredItem(%q) = %#v; want %#v", tt.in, got, tt.want)
+		}
+		want := tt.out
+		if want == "" {
+			want = tt.in
+		}
+		if s, err := FormatStructuredItem(got); s != want || err != nil {
+			t.Errorf("FormatStructuredItem(%#v) = %q, %v; want %q", got, s, err, want)
+		}
+	}
+}
+
+func TestParseStructuredItemErrors(t *testing.T) {
+	for _, in := range []string{
+		"",
+		"   ",
+		"1 2",
+		"1,2",
+		"-",
+		"-a",
+		"1234567890123456",
+		"1234567890123.0",
+		"1.",
+		"1.1234",
+		"1.2.3",
+		`"unterminated`,
+		`"bad \n escape"`,
+		"\"tab\tin string\"",
+		"\"non-ascii \xc3\xa9\"",
+		":not base64!:",
+		":abc",
+		"?2",
+		"?",
+		"1;A=1",
+		"1;=1",
+		"1;a=",
+		"(1 2)",
+		"\t1",
+	} {
+		if it, err := ParseStructuredItem(in); err == nil {
+			t.Errorf("ParseStructuredItem(%q) = %#v; want error", in, it)
+		}
+	}
+}
+
+func TestParseStructuredList(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want StructuredList
+		out  string
+	}{
+		{"", nil, ""},
+		{"sugar, tea, rum", StructuredList{
+			StructuredItem{Value: StructuredToken("sugar")},
+			StructuredItem{Value: StructuredToken("tea")},
+			StructuredItem{Value: StructuredToken("rum")},
+		}, ""},
+		{"a,\tb ,c", StructuredList{
+			StructuredItem{Value: StructuredToken("a")},
+			StructuredItem{Value: StructuredToken("b")},
+			StructuredItem{Value: StructuredToken("c")},
+		}, "a, b, c"},
+		{`("foo" "bar");lvl=5, ( ), (1;a  2)`, StructuredList{
+			StructuredInnerList{
+				Items:  []StructuredItem{{Value: "foo"}, {Value: "bar"}},
+				Params: []StructuredParam{{"lvl", int64(5)}},
+			},
+			StructuredInnerList{},
+			StructuredInnerList{Items: []StructuredItem{
+				{Value: int64(1), Params: []StructuredParam{{"a", true}}},
+				{Value: int64(2)},
+			}},
+		}, `("foo" "bar");lvl=5, (), (1;a 2)`},
+	} {
+		got, err := ParseStructuredList(tt.in)
+		if err != nil {
+			t.Errorf("ParseStructuredList(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStructuredList(%q) = %#v; want %#v", tt.in, got, tt.want)
+		}
+		want := tt.out
+		if want == "" {
+			want = tt.in
+		}
+		if s, err := FormatStructuredList(got); s != want || err != nil {
+			t.Errorf("FormatStructuredList(%#v) = %q, %v; want %q", got, s, err, want)
+		}
+	}
+
+	for _, in := range []string{"a,", "a,,b", ",a", "a b", "(a", "(a,b)", "(a)b"} {
+		if l, err := ParseStructuredList(in); err == nil {
+			t.Errorf("ParseStructuredList(%q) = %#v; want error", in, l)
+		}
+	}
+}
+
+func TestParseStructuredDict(t *testing.T) {
+	for _, tt := range []struct {
+		in   string
+		want StructuredDict
+		out  string
+	}{
+		{"", nil, ""},
+		{"u=1, i", StructuredDict{
+			{"u", StructuredItem{Value: int64(1)}},
+			{"i", StructuredItem{Value: true}},
+		}, ""},
+		{"a=?0, b, c;foo=bar", StructuredDict{
+			{"a", StructuredItem{Value: false}},
+			{"b", StructuredItem{Value: true}},
+			{"c", StructuredItem{Value: true, Params: []StructuredParam{{"foo", StructuredToken("bar")}}}},
+		}, ""},
+		{"rating=1.5, feelings=(joy sadness)", StructuredDict{
+			{"rating", StructuredItem{Value: 1.5}},
+			{"feelings", StructuredInnerList{Items: []StructuredItem{
+				{Value: StructuredToken("joy")},
+				{Value: StructuredToken("sadness")},
+			}}},
+		}, ""},
+		{"a=1, b=2, a=3", StructuredDict{
+			{"a", StructuredItem{Value: int64(3)}},
+			{"b", StructuredItem{Value: int64(2)}},
+		}, "a=3, b=2"},
+	} {
+		got, err := ParseStructuredDict(tt.in)
+		if err != nil {
+			t.Errorf("ParseStructuredDict(%q): %v", tt.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseStructuredDict(%q) = %#v; want %#v", tt.in, got, tt.want)
+		}
+		want := tt.out
+		if want == "" {
+			want = tt.in
+		}
+		if s, err := FormatStructuredDict(got); s != want || err != nil {
+			t.Errorf("FormatStructuredDict(%#v) = %q, %v; want %q", got, s, err, want)
+		}
+	}
+
+	d, _ := ParseStructuredDict("u=3, i")
+	if v, ok := d.Get("u"); !ok || v.(StructuredItem).Value != int64(3) {
+		t.Errorf(`Get("u") = %#v, %v; want 3`, v, ok)
+	}
+	if _, ok := d.Get("x"); ok {
+		t.Error(`Get("x") found a member`)
+	}
+
+	for _, in := range []string{"A=1", "a=1,", "a=1 b=2", "1=a", "a=(1"} {
+		if d, err := ParseStructuredDict(in); err == nil {
+			t.Errorf("ParseStructuredDict(%q) = %#v; want error", in, d)
+		}
+	}
+}
+
+func TestFormatStructuredErrors(t *testing.T) {
+	for _, it := range []StructuredItem{
+		{Value: int64(1_000_000_000_000_000)},
+		{Value: 1e12},
+		{Value: "café"},
+		{Value: StructuredToken("1abc")},
+		{Value: StructuredToken("a b")},
+		{Value: 3.0i},
+		{Value: nil},
+		{Value: int64(1), Params: []StructuredParam{{"Upper", true}}},
+	} {
+		if s, err := FormatStructuredItem(it); err == nil {
+			t.Errorf("FormatStructuredItem(%#v) = %q; want error", it, s)
+		}
+	}
+	if s, err := FormatStructuredList(StructuredList{"bare string"}); err == nil {
+		t.Errorf("FormatStructuredList with a bare value = %q; want error", s)
+	}
+
+	// Decimals are rounded to three digits, ties to even, and ints
+	// are accepted as Integers.
+	for _, tt := range []struct {
+		v    any
+		want string
+	}{
+		{0.0625, "0.062"},
+		{0.1875, "0.188"},
+		{1.00001, "1.0"},
+		{-2.5, "-2.5"},
+		{7, "7"},
+	} {
+		if s, err := FormatStructuredItem(StructuredItem{Value: tt.v}); s != tt.want || err != nil {
+			t.Errorf("FormatStructuredItem(%v) = %q, %v; want %q", tt.v, s, err, tt.want)
+		}
+	}
+}
@@ -438,10 +438,12 @@ type transferReader struct {
 	RequestMethod string
 	ProtoMajor    int
 	ProtoMinor    int
+	AllowCodings  []string // Server.AllowTransferEncodings, for requests
 	// Output
 	Body          io.ReadCloser
 	ContentLength int64
 	Chunked       bool
+	Codings       []string // allowed transfer codings applied before chunked
 	Close         bool
 	Trailer       Header
 }
@@ -480,8 +482,10 @@ func suppressedHeaders(status int) []string {
 	return nil
 }
 
-// msg is *Request or *Response.
-func readTransfer(msg any, r *bufio.Reader) (err error) {
+// msg is *Request or *Response. allowCodings lists the transfer
+// codings other than chunked that a request may use; see
+// Server.AllowTransferEncodings.
+func readTransfer(msg any, r *bufio.Reader, allowCodings []string) (err error) {
 	t := &transferReader{RequestMethod: "GET"}
 
 	// Unify input
@@ -506,6 +510,7 @@ func readTransfer(msg any, r *bufio.Reader) (err error) {
 		// Responses with status code 200, responding to a GET method
 		t.StatusCode = 200
 		t.Close = rr.Close
+		t.AllowCodings = allowCodings
 	default:
 		panic("unexpected type")
 	}
@@ -581,7 +586,7 @@ func readTransfer(msg any, r *bufio.Reader) (err error) {
 		rr.Body = t.Body
 		rr.ContentLength = t.ContentLength
 		if t.Chunked {
-			rr.TransferEncoding = []string{"chunked"}
+			rr.TransferEncoding = append(t.Codings, "chunked")
 		}
 		rr.Close = t.Close
 		rr.Trailer = t.Trailer
@@ -634,14 +639,19 @@ func (t *transferReader) parseTransferEncoding() error {
 	}
 
 	// Like nginx, we only support a single Transfer-Encoding header field, and
-	// only if set to "chunked". This is one of the most security sensitive
-	// surfaces in HTTP/1.1 due to the risk of request smuggling, so we keep it
-	// strict and simple.
+	// only if set to "chunked", or to codings the Server allows followed by
+	// "chunked". This is one of the most security sensitive surfaces in
+	// HTTP/1.1 due to the risk of request smuggling, so we keep it strict and
+	// simple.
 	if len(raw) != 1 {
 		return &unsupportedTEError{fmt.Sprintf("too many transfer encodings: %q", raw)}
 	}
 	if !ascii.EqualFold(textproto.TrimString(raw[0]), "chunked") {
-		return &unsupportedTEError{fmt.Sprintf("unsupported transfer encoding: %q", raw[0])}
+		codings, err := parseAllowedCodings(raw[0], t.AllowCodings)
+		if err != nil {
+			return err
+		}
+		t.Codings = codings
 	}
 
 	// RFC 7230 3.3.2 says "A sender MUST NOT send a Content-Length header field
@@ -661,6 +671,36 @@ func (t *transferReader) parseTransferEncoding() error {
 	return nil
 }
 
+// parseAllowedCodings parses a Transfer-Encoding value listing transfer
+// codings followed by "chunked", such as "gzip, chunked", returning the
+// codings before chunked. Each must be "gzip" or its alias "x-gzip",
+// and allowed by allow.
+func parseAllowedCodings(v string, allow []string) ([]string, error) {
+	if len(allow) == 0 {
+		return nil, &unsupportedTEError{fmt.Sprintf("unsupported transfer encoding: %q", v)}
+	}
+	parts := strings.Split(v, ",")
+	if !ascii.EqualFold(textproto.TrimString(parts[len(parts)-1]), "chunked") {
+		return nil, &unsupportedTEError{fmt.Sprintf("transfer encoding does not end in chunked: %q", v)}
+	}
+	var codings []string
+	for _, c := range parts[:len(parts)-1] {
+		c = textproto.TrimString(c)
+		if ascii.EqualFold(c, "x-gzip") {
+			c = "gzip"
+		}
+		ok := false
+		for _, a := range allow {
+			ok = ok || ascii.EqualFold(a, c) && ascii.EqualFold(c, "gzip")
+		}
+		if !ok {
+			return nil, &unsupportedTEError{fmt.Sprintf("unsupported transfer encoding: %q", v)}
+		}
+		codings = append(codings, "gzip")
+	}
+	return codings, nil
+}
+
 // Determine the expected body length, using RFC 7230 Section 3.3. This
 // function is not a method, because ultimately it should be shared by
 // ReadResponse and ReadRequest.
@@ -1010,6 +1050,18 @@ func (b *body) Close() error {
 	return err
 }
 
+// abort closes b without reading the rest of it,
+// as the connection it is read from is about to be closed.
+func (b *body) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.closed {
+		return
+	}
+	b.closed = true
+	b.earlyClose = !b.sawEOF
+}
+
 func (b *body) didEarlyClose() bool {
 	b.mu.Lock()
 	defer b.mu.Unlock()
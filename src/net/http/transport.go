@@ -41,11 +41,8 @@ import (
 // as directed by the $HTTP_PROXY and $NO_PROXY (or $http_proxy and
 // $no_proxy) environment variables.
 var DefaultTransport RoundTripper = &Transport{
-	Proxy: ProxyFromEnvironment,
-	DialContext: defaultTransportDialContext(&net.Dialer{
-		Timeout:   30 * time.Second,
-		KeepAlive: 30 * time.Second,
-	}),
+	Proxy:                 ProxyFromEnvironment,
+	DialContext:           defaultTransportDialContext(),
 	ForceAttemptHTTP2:     true,
 	MaxIdleConns:          100,
 	IdleConnTimeout:       90 * time.Second,
@@ -53,6 +50,20 @@ var DefaultTransport RoundTripper = &Transport{
 	ExpectContinueTimeout: 1 * time.Second,
 }
 
+// defaultDialer is the dialer of DefaultTransport.
+var defaultDialer = &net.Dialer{
+	Timeout:   30 * time.Second,
+	KeepAlive: 30 * time.Second,
+}
+
+// dialDefault dials with defaultDialer. As the DialContext of
+// DefaultTransport and of its clones, it is recognized by
+// Transport.dial, which then makes the connection itself, using
+// DNSCache and FallbackDelay, with the settings of defaultDialer.
+func dialDefault(ctx context.Context, network, addr string) (net.Conn, error) {
+	return defaultDialer.DialContext(ctx, network, addr)
+}
+
 // DefaultMaxIdleConnsPerHost is the default value of Transport's
 // MaxIdleConnsPerHost.
 const DefaultMaxIdleConnsPerHost = 2
@@ -142,6 +153,37 @@ type Transport struct {
 	// If both are set, DialContext takes priority.
 	Dial func(network, addr string) (net.Conn, error)
 
+	// DNSCache optionally specifies a cache of host name lookups
+	// for the Transport's own dialer, so that connections to the
+	// same host don't each wait for a lookup. The addresses it
+	// returns for a host are dialed in order, trying those of the
+	// other IP family in parallel as described for FallbackDelay,
+	// and the host's entry is invalidated if one fails. It is
+	// not used if DialContext or Dial is set, nor for the
+	// connections DialTLSContext or DialTLS dial, except that
+	// the DialContext of DefaultTransport, as copied by Clone,
+	// counts as unset.
+	//
+	// If nil, the host of each connection is looked up as it is
+	// dialed.
+	DNSCache DNSCache
+
+	// FallbackDelay specifies how long the Transport's own dialer
+	// waits for a connection to a host's addresses of one IP
+	// family, those of its first address, before also trying its
+	// addresses of the other family in parallel, as described by
+	// RFC 8305 ("Happy Eyeballs"), so that a host with broken
+	// IPv6 connectivity is reached over IPv4 without waiting for
+	// the IPv6 attempt to time out. The first connection made is
+	// used. Like DNSCache, it does not apply if DialContext or
+	// Dial is set, other than to the DialContext of
+	// DefaultTransport.
+	//
+	// If zero, a default delay of 300ms is used. A negative value
+	// disables the parallel attempts, dialing the addresses one at
+	// a time.
+	FallbackDelay time.Duration
+
 	// DialTLSContext specifies an optional dial function for creating
 	// TLS connections for non-proxied HTTPS requests.
 	//
@@ -186,7 +228,11 @@ type Transport struct {
 	// its own and gets a gzipped response, it's transparently
 	// decoded in the Response.Body. However, if the user
 	// explicitly requested gzip it is not automatically
-	// uncompressed.
+	// uncompressed. Gzip is the only encoding the Transport
+	// requests or decodes; a caller that sets Accept-Encoding to
+	// another coding such as "br" receives the body as sent.
+	// See also DisableAutoDecompress, which does the same for
+	// individual requests.
 	DisableCompression bool
 
 	// MaxIdleConns controls the maximum number of idle (keep-alive)
@@ -205,12 +251,42 @@ type Transport struct {
 	// Zero means no limit.
 	MaxConnsPerHost int
 
+	// MaxConnsPerHostTimeout, if non-zero, specifies the amount of
+	// time a request waits for permission to dial when the host
+	// already has MaxConnsPerHost connections, after which the
+	// request fails with ErrConnLimitTimeout. A request still gets
+	// a connection that becomes idle, or a permission to dial freed
+	// by another connection, while it waits.
+	//
+	// Zero means requests wait until their contexts are done.
+	MaxConnsPerHostTimeout time.Duration
+
+	// DialDisabled, if true, prevents the Transport from dialing
+	// new connections for requests. A request for which no idle
+	// connection is available fails with ErrNoIdleConn instead.
+	// Connections can be established ahead of time with
+	// PrewarmConns.
+	DialDisabled bool
+
 	// IdleConnTimeout is the maximum amount of time an idle
 	// (keep-alive) connection will remain idle before closing
 	// itself.
 	// Zero means no limit.
 	IdleConnTimeout time.Duration
 
+	// IdleConnCheck, if non-nil, is called before an idle HTTP/1
+	// connection is reused for a new request. If it returns false,
+	// the connection is closed and the Transport uses another idle
+	// connection or dials a new one.
+	//
+	// The Transport continues to read from idle connections in the
+	// background to detect closure by the peer, so IdleConnCheck
+	// must not read from or write to conn; it may instead inspect
+	// the underlying socket, for example through SyscallConn.
+	// The connection is taken out of the idle pool while
+	// IdleConnCheck runs, without the pool locked.
+	IdleConnCheck func(conn net.Conn) bool
+
 	// ResponseHeaderTimeout, if non-zero, specifies the amount of
 	// time to wait for a server's response headers after fully
 	// writing the request (including its body, if any). This
@@ -269,6 +345,17 @@ type Transport struct {
 	// If zero, a default (currently 4KB) is used.
 	ReadBufferSize int
 
+	// TCPSendBuffer and TCPRecvBuffer, if positive, set the sizes
+	// of the operating system's send and receive buffers (SO_SNDBUF
+	// and SO_RCVBUF) for the TCP connections the Transport dials.
+	// Larger buffers can improve throughput on links with a high
+	// bandwidth-delay product. The operating system may clamp the
+	// requested sizes. They are ignored for connections that are
+	// not *net.TCPConn, such as those returned by some custom
+	// dialers.
+	TCPSendBuffer int
+	TCPRecvBuffer int
+
 	// nextProtoOnce guards initialization of TLSNextProto and
 	// h2transport (via onceSetNextProtoDefaults)
 	nextProtoOnce      sync.Once
@@ -283,6 +370,43 @@ type Transport struct {
 	ForceAttemptHTTP2 bool
 }
 
+var autoDecompressDisabledKey = &contextKey{"auto-decompress-disabled"}
+
+// DisableAutoDecompress returns a copy of ctx that keeps a Transport
+// from requesting and transparently decoding gzip for requests made
+// with the returned context, as if the Transport had
+// DisableCompression set. The Response.Body of such a request holds
+// the body exactly as the server sent it, which suits proxies that
+// pass responses through unchanged.
+//
+// HTTP/1 requests are sent without an Accept-Encoding header, as with
+// DisableCompression. HTTP/2 requests are sent with
+// "Accept-Encoding: identity" instead, which has the same effect on
+// the Transport and asks the server not to compress the response.
+func DisableAutoDecompress(ctx context.Context) context.Context {
+	return context.WithValue(ctx, autoDecompressDisabledKey, true)
+}
+
+func autoDecompressDisabled(ctx context.Context) bool {
+	disabled, _ := ctx.Value(autoDecompressDisabledKey).(bool)
+	return disabled
+}
+
+// h2Request returns req, or a copy of it asking for the identity
+// encoding if its context disables auto decompression, since the
+// HTTP/2 transport requests gzip for any request without an
+// Accept-Encoding header.
+func (t *Transport) h2Request(req *Request) *Request {
+	if t.DisableCompression || !autoDecompressDisabled(req.Context()) || req.Header.has("Accept-Encoding") {
+		return req
+	}
+	r2 := new(Request)
+	*r2 = *req
+	r2.Header = req.Header.Clone()
+	r2.Header.Set("Accept-Encoding", "identity")
+	return r2
+}
+
 // A cancelKey is the key of the reqCanceler map.
 // We wrap the *Request in this type since we want to use the original request,
 // not any transient one created by roundTrip.
@@ -319,7 +443,12 @@ func (t *Transport) Clone() *Transport {
 		MaxIdleConns:           t.MaxIdleConns,
 		MaxIdleConnsPerHost:    t.MaxIdleConnsPerHost,
 		MaxConnsPerHost:        t.MaxConnsPerHost,
+		MaxConnsPerHostTimeout: t.MaxConnsPerHostTimeout,
+		DNSCache:               t.DNSCache,
+		FallbackDelay:          t.FallbackDelay,
+		DialDisabled:           t.DialDisabled,
 		IdleConnTimeout:        t.IdleConnTimeout,
+		IdleConnCheck:          t.IdleConnCheck,
 		ResponseHeaderTimeout:  t.ResponseHeaderTimeout,
 		ExpectContinueTimeout:  t.ExpectContinueTimeout,
 		ProxyConnectHeader:     t.ProxyConnectHeader.Clone(),
@@ -328,6 +457,8 @@ func (t *Transport) Clone() *Transport {
 		ForceAttemptHTTP2:      t.ForceAttemptHTTP2,
 		WriteBufferSize:        t.WriteBufferSize,
 		ReadBufferSize:         t.ReadBufferSize,
+		TCPSendBuffer:          t.TCPSendBuffer,
+		TCPRecvBuffer:          t.TCPRecvBuffer,
 	}
 	if t.TLSClientConfig != nil {
 		t2.TLSClientConfig = t.TLSClientConfig.Clone()
@@ -402,6 +533,11 @@ func (t *Transport) onceSetNextProtoDefaults() {
 		return
 	}
 	t.h2transport = t2
+	if t.MaxConnsPerHost > 0 {
+		if p, ok := t2.ConnPool.(http2noDialClientConnPool); ok {
+			t2.ConnPool = countingH2ConnPool{p, t}
+		}
+	}
 
 	// Auto-configure the http2.Transport's MaxHeaderListSize from
 	// the http.Transport's MaxResponseHeaderBytes. They don't
@@ -536,7 +672,7 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 	req = setupRewindBody(req)
 
 	if altRT := t.alternateRoundTripper(req); altRT != nil {
-		if resp, err := altRT.RoundTrip(req); err != ErrSkipAltProtocol {
+		if resp, err := altRT.RoundTrip(t.h2Request(req)); err != ErrSkipAltProtocol {
 			return resp, err
 		}
 		var err error
@@ -589,7 +725,7 @@ func (t *Transport) roundTrip(req *Request) (*Response, error) {
 		if pconn.alt != nil {
 			// HTTP/2 path.
 			t.setReqCanceler(cancelKey, nil) // not cancelable with CancelRequest
-			resp, err = pconn.alt.RoundTrip(req)
+			resp, err = pconn.alt.RoundTrip(t.h2Request(req))
 		} else {
 			resp, err = pconn.roundTrip(treq)
 		}
@@ -778,6 +914,108 @@ func (t *Transport) CloseIdleConnections() {
 	}
 }
 
+// ErrNoIdleConn is returned by Transport.RoundTrip when DialDisabled
+// is set and no idle connection is available for the request.
+var ErrNoIdleConn = errors.New("net/http: no idle connection available and dialing is disabled")
+
+// ErrConnLimitTimeout is returned by Transport.RoundTrip when a request
+// waited MaxConnsPerHostTimeout for a connection to a host at its
+// MaxConnsPerHost limit without getting one.
+var ErrConnLimitTimeout = errors.New("net/http: timeout waiting for a connection under MaxConnsPerHost")
+
+// ConnsPerHost reports the number of connections the Transport has to
+// addr, a host and port such as "example.com:443". Active connections
+// are those being dialed or serving a request, and idle connections
+// are those in the pool waiting for a request. An HTTP/2 connection,
+// which can serve many requests at once, is counted as idle while
+// it is in the pool. Connections to addr through different proxies or
+// with different schemes are counted together. Active connections
+// are only counted if MaxConnsPerHost is set; otherwise active is
+// always zero.
+func (t *Transport) ConnsPerHost(addr string) (active, idle int) {
+	t.idleMu.Lock()
+	for key, pconns := range t.idleConn {
+		if key.addr == addr {
+			idle += len(pconns)
+		}
+	}
+	t.idleMu.Unlock()
+
+	total := 0
+	t.connsPerHostMu.Lock()
+	for key, n := range t.connsPerHost {
+		if key.addr == addr {
+			total += n
+		}
+	}
+	t.connsPerHostMu.Unlock()
+	if active = total - idle; active < 0 {
+		active = 0
+	}
+	return active, idle
+}
+
+// PrewarmConns dials n connections to host and adds them to the
+// Transport's pool of idle connections, for use by later requests,
+// such as when DialDisabled is set. The host has the form
+// scheme://host[:port], as in "https://example.com", and the
+// connections are made through the proxy, if any, that the Transport
+// would use for requests to it.
+//
+// Prewarmed connections are subject to MaxIdleConnsPerHost,
+// MaxConnsPerHost, and IdleConnTimeout like any other. As HTTP/2
+// serves concurrent requests on one connection, at most one HTTP/2
+// connection to a host is kept. PrewarmConns returns the first error
+// from dialing or pooling a connection.
+func (t *Transport) PrewarmConns(host string, n int) error {
+	req, err := NewRequest("GET", host, nil)
+	if err != nil {
+		return err
+	}
+	if req.URL.Host == "" {
+		return errors.New("net/http: PrewarmConns host must have the form scheme://host[:port]")
+	}
+	t.nextProtoOnce.Do(t.onceSetNextProtoDefaults)
+	cm, err := t.connectMethodForRequest(&transportRequest{Request: req})
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := t.prewarmConn(cm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prewarmConn dials a connection for cm and adds it to the idle pool.
+func (t *Transport) prewarmConn(cm connectMethod) error {
+	key := cm.key()
+	if t.MaxConnsPerHost > 0 {
+		t.connsPerHostMu.Lock()
+		n := t.connsPerHost[key]
+		if n >= t.MaxConnsPerHost {
+			t.connsPerHostMu.Unlock()
+			return errors.New("net/http: PrewarmConns would exceed MaxConnsPerHost")
+		}
+		if t.connsPerHost == nil {
+			t.connsPerHost = make(map[connectMethodKey]int)
+		}
+		t.connsPerHost[key] = n + 1
+		t.connsPerHostMu.Unlock()
+	}
+	pc, err := t.dialConn(context.Background(), cm)
+	if err != nil {
+		t.decConnsPerHost(key)
+		return err
+	}
+	if err := t.tryPutIdleConn(pc); err != nil {
+		pc.close(err)
+		return err
+	}
+	return nil
+}
+
 // CancelRequest cancels an in-flight request by closing its connection.
 // CancelRequest should only be called after RoundTrip has returned.
 //
@@ -859,6 +1097,7 @@ var (
 	errKeepAlivesDisabled = errors.New("http: putIdleConn: keep alives disabled")
 	errConnBroken         = errors.New("http: putIdleConn: connection is in bad state")
 	errCloseIdle          = errors.New("http: putIdleConn: CloseIdleConnections was called")
+	errIdleConnCheck      = errors.New("http: idle connection rejected by Transport.IdleConnCheck")
 	errTooManyIdle        = errors.New("http: putIdleConn: too many idle connections")
 	errTooManyIdleHost    = errors.New("http: putIdleConn: too many idle connections for host")
 	errCloseIdleConns     = errors.New("http: CloseIdleConnections called")
@@ -1008,7 +1247,32 @@ func (t *Transport) queueForIdleConn(w *wantConn) (delivered bool) {
 	if t.DisableKeepAlives {
 		return false
 	}
+	for {
+		delivered, pconn := t.queueForIdleConnOnce(w)
+		if pconn == nil {
+			return delivered
+		}
+		// pconn was taken out of the idle pool for IdleConnCheck,
+		// which is run without idleMu held.
+		if !t.IdleConnCheck(pconn.conn) {
+			// The caller's check rejected this connection;
+			// look for another.
+			pconn.close(errIdleConnCheck)
+			continue
+		}
+		if w.tryDeliver(pconn, nil) {
+			return true
+		}
+		t.putOrCloseIdleConn(pconn)
+		return false
+	}
+}
 
+// queueForIdleConnOnce does the work of queueForIdleConn with idleMu
+// held. If t.IdleConnCheck is set, it doesn't deliver an idle HTTP/1
+// connection to w but removes it from the idle pool and returns it,
+// for the caller to check and deliver.
+func (t *Transport) queueForIdleConnOnce(w *wantConn) (delivered bool, check *persistConn) {
 	t.idleMu.Lock()
 	defer t.idleMu.Unlock()
 
@@ -1018,7 +1282,7 @@ func (t *Transport) queueForIdleConn(w *wantConn) (delivered bool) {
 
 	if w == nil {
 		// Happens in test hook.
-		return false
+		return false, nil
 	}
 
 	// If IdleConnTimeout is set, calculate the oldest
@@ -1055,6 +1319,14 @@ func (t *Transport) queueForIdleConn(w *wantConn) (delivered bool) {
 				list = list[:len(list)-1]
 				continue
 			}
+			if pconn.alt == nil && t.IdleConnCheck != nil {
+				// Only one client can use an HTTP/1 pconn, so
+				// it can be checked outside the pool.
+				t.idleLRU.remove(pconn)
+				list = list[:len(list)-1]
+				check = pconn
+				break
+			}
 			delivered = w.tryDeliver(pconn, nil)
 			if delivered {
 				if pconn.alt != nil {
@@ -1074,8 +1346,8 @@ func (t *Transport) queueForIdleConn(w *wantConn) (delivered bool) {
 		} else {
 			delete(t.idleConn, w.key)
 		}
-		if stop {
-			return delivered
+		if stop || check != nil {
+			return delivered, check
 		}
 	}
 
@@ -1087,7 +1359,7 @@ func (t *Transport) queueForIdleConn(w *wantConn) (delivered bool) {
 	q.cleanFront()
 	q.pushBack(w)
 	t.idleConnWait[w.key] = q
-	return false
+	return false, nil
 }
 
 // removeIdleConn marks pconn as dead.
@@ -1164,18 +1436,35 @@ func (t *Transport) replaceReqCanceler(key cancelKey, fn func(error)) bool {
 
 var zeroDialer net.Dialer
 
+// isDialDefault reports whether fn is dialDefault, the DialContext
+// of DefaultTransport.
+func isDialDefault(fn func(context.Context, string, string) (net.Conn, error)) bool {
+	return reflect.ValueOf(fn).Pointer() == reflect.ValueOf(dialDefault).Pointer()
+}
+
 func (t *Transport) dial(ctx context.Context, network, addr string) (net.Conn, error) {
-	if t.DialContext != nil {
+	dialer := &zeroDialer
+	switch {
+	case t.DialContext != nil && isDialDefault(t.DialContext):
+		dialer = defaultDialer
+	case t.DialContext != nil:
 		return t.DialContext(ctx, network, addr)
-	}
-	if t.Dial != nil {
+	case t.Dial != nil:
 		c, err := t.Dial(network, addr)
 		if c == nil && err == nil {
 			err = errors.New("net/http: Transport.Dial hook returned (nil, nil)")
 		}
 		return c, err
 	}
-	return zeroDialer.DialContext(ctx, network, addr)
+	if t.DNSCache != nil {
+		return t.dialCached(ctx, dialer, network, addr)
+	}
+	if t.FallbackDelay != 0 {
+		d := *dialer
+		d.FallbackDelay = t.FallbackDelay
+		return d.DialContext(ctx, network, addr)
+	}
+	return dialer.DialContext(ctx, network, addr)
 }
 
 // A wantConn records state about a wanted connection
@@ -1199,6 +1488,13 @@ type wantConn struct {
 	mu  sync.Mutex // protects pc, err, close(ready)
 	pc  *persistConn
 	err error
+
+	// permitted is set when w is given permission to dial after
+	// waiting for it, and limitTimer, if not nil, fails w with
+	// ErrConnLimitTimeout if that takes MaxConnsPerHostTimeout.
+	// Guarded by Transport.connsPerHostMu.
+	permitted  bool
+	limitTimer *time.Timer
 }
 
 // waiting reports whether w is still waiting for an answer (connection or error).
@@ -1365,11 +1661,22 @@ func (t *Transport) getConn(treq *transportRequest, cm connectMethod) (pc *persi
 		return pc, nil
 	}
 
+	if t.DialDisabled {
+		return nil, ErrNoIdleConn
+	}
+
 	cancelc := make(chan error, 1)
 	t.setReqCanceler(treq.cancelKey, func(err error) { cancelc <- err })
 
 	// Queue for permission to dial.
 	t.queueForDial(w)
+	if t.MaxConnsPerHostTimeout > 0 {
+		defer func() {
+			t.connsPerHostMu.Lock()
+			w.stopLimitTimer()
+			t.connsPerHostMu.Unlock()
+		}()
+	}
 
 	// Wait for completion or cancellation.
 	select {
@@ -1438,6 +1745,26 @@ func (t *Transport) queueForDial(w *wantConn) {
 	q.cleanFront()
 	q.pushBack(w)
 	t.connsPerHostWait[w.key] = q
+
+	if d := t.MaxConnsPerHostTimeout; d > 0 {
+		w.limitTimer = time.AfterFunc(d, func() {
+			t.connsPerHostMu.Lock()
+			defer t.connsPerHostMu.Unlock()
+			if !w.permitted {
+				w.tryDeliver(nil, ErrConnLimitTimeout)
+			}
+		})
+	}
+}
+
+// stopLimitTimer stops w's MaxConnsPerHostTimeout timer, if any,
+// once w no longer waits for permission to dial.
+// t.connsPerHostMu must be held.
+func (w *wantConn) stopLimitTimer() {
+	if w.limitTimer != nil {
+		w.limitTimer.Stop()
+		w.limitTimer = nil
+	}
 }
 
 // dialConnFor dials on behalf of w and delivers the result to w.
@@ -1484,6 +1811,8 @@ func (t *Transport) decConnsPerHost(key connectMethodKey) {
 		for q.len() > 0 {
 			w := q.popFront()
 			if w.waiting() {
+				w.permitted = true
+				w.stopLimitTimer()
 				go t.dialConnFor(w)
 				done = true
 				break
@@ -1509,6 +1838,39 @@ func (t *Transport) decConnsPerHost(key connectMethodKey) {
 	}
 }
 
+// countingH2ConnPool is the HTTP/2 connection pool of a Transport with
+// a MaxConnsPerHost limit, which stops counting the connections that
+// the HTTP/2 transport marks dead against the limit.
+type countingH2ConnPool struct {
+	http2noDialClientConnPool
+	t *Transport
+}
+
+func (p countingH2ConnPool) MarkDead(cc *http2ClientConn) {
+	p.http2noDialClientConnPool.MarkDead(cc)
+	p.t.removeDeadH2Conn(cc.tconn)
+}
+
+// removeDeadH2Conn removes the HTTP/2 persistConn for the dead
+// connection c from the idle pool, where it stays while it lives,
+// and stops counting it against MaxConnsPerHost.
+func (t *Transport) removeDeadH2Conn(c net.Conn) {
+	t.idleMu.Lock()
+	var dead *persistConn
+	for _, pconns := range t.idleConn {
+		for _, pconn := range pconns {
+			if pconn.alt != nil && pconn.h2conn == c {
+				dead = pconn
+			}
+		}
+	}
+	removed := dead != nil && t.removeIdleConnLocked(dead)
+	t.idleMu.Unlock()
+	if removed {
+		t.decConnsPerHost(dead.cacheKey)
+	}
+}
+
 // Add TLS to a persistent connection, i.e. negotiate a TLS session. If pconn is already a TLS
 // tunnel, this function establishes a nested TLS session inside the encrypted channel.
 // The remote endpoint's name may be overridden by TLSClientConfig.ServerName.
@@ -1584,6 +1946,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (pconn *pers
 		if err != nil {
 			return nil, wrapErr(err)
 		}
+		setTCPBuffers(pconn.conn, t.TCPSendBuffer, t.TCPRecvBuffer)
 		if tc, ok := pconn.conn.(*tls.Conn); ok {
 			// Handshake here, in case DialTLS didn't. TLSNextProto below
 			// depends on it for knowing the connection state.
@@ -1608,6 +1971,7 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (pconn *pers
 		if err != nil {
 			return nil, wrapErr(err)
 		}
+		setTCPBuffers(conn, t.TCPSendBuffer, t.TCPRecvBuffer)
 		pconn.conn = conn
 		if cm.scheme() == "https" {
 			var firstTLSHost string
@@ -1740,7 +2104,11 @@ func (t *Transport) dialConn(ctx context.Context, cm connectMethod) (pconn *pers
 				// pconn.conn was closed by next (http2configureTransports.upgradeFn).
 				return nil, e.RoundTripErr()
 			}
-			return &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt}, nil
+			pc := &persistConn{t: t, cacheKey: pconn.cacheKey, alt: alt}
+			if t.MaxConnsPerHost > 0 {
+				pc.h2conn = pconn.conn
+			}
+			return pc, nil
 		}
 	}
 
@@ -1875,6 +2243,10 @@ type persistConn struct {
 	// If it's non-nil, the rest of the fields are unused.
 	alt RoundTripper
 
+	// h2conn is the connection of an HTTP/2 alt, set if the
+	// Transport counts connections against MaxConnsPerHost.
+	h2conn net.Conn
+
 	t         *Transport
 	cacheKey  connectMethodKey
 	conn      net.Conn
@@ -2552,6 +2924,7 @@ func (pc *persistConn) roundTrip(req *transportRequest) (resp *Response, err err
 	// requested it.
 	requestedGzip := false
 	if !pc.t.DisableCompression &&
+		!autoDecompressDisabled(req.Context()) &&
 		req.Header.Get("Accept-Encoding") == "" &&
 		req.Header.Get("Range") == "" &&
 		req.Method != "HEAD" {
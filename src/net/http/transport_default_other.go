@@ -12,6 +12,6 @@ import (
 	"net"
 )
 
-func defaultTransportDialContext(dialer *net.Dialer) func(context.Context, string, string) (net.Conn, error) {
-	return dialer.DialContext
+func defaultTransportDialContext() func(context.Context, string, string) (net.Conn, error) {
+	return dialDefault
 }
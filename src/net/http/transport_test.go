@@ -718,6 +718,94 @@ func TestTransportMaxConnsPerHost(t *testing.T) {
 	testMaxConns("http2", ts)
 }
 
+func TestTransportMaxConnsPerHostTimeout(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	entered := make(chan struct{})
+	unblock := make(chan struct{})
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		if r.URL.Path == "/block" {
+			entered <- struct{}{}
+			<-unblock
+		}
+		io.WriteString(w, "foo")
+	}))
+	defer ts.Close()
+	defer close(unblock)
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	tr.MaxConnsPerHost = 1
+	tr.MaxConnsPerHostTimeout = 50 * time.Millisecond
+	addr := ts.Listener.Addr().String()
+
+	get := func(path string) error {
+		res, err := c.Get(ts.URL + path)
+		if err != nil {
+			return err
+		}
+		_, err = io.ReadAll(res.Body)
+		res.Body.Close()
+		return err
+	}
+	errc := make(chan error, 1)
+	go func() { errc <- get("/block") }()
+	<-entered
+	if active, idle := tr.ConnsPerHost(addr); active != 1 || idle != 0 {
+		t.Errorf("ConnsPerHost during request = %d, %d; want 1, 0", active, idle)
+	}
+
+	if err := get("/"); !errors.Is(err, ErrConnLimitTimeout) {
+		t.Errorf("request at MaxConnsPerHost = %v; want ErrConnLimitTimeout", err)
+	}
+
+	unblock <- struct{}{}
+	if err := <-errc; err != nil {
+		t.Fatal(err)
+	}
+	if active, idle := tr.ConnsPerHost(addr); active != 0 || idle != 1 {
+		t.Errorf("ConnsPerHost after request = %d, %d; want 0, 1", active, idle)
+	}
+	if err := get("/"); err != nil {
+		t.Errorf("request with idle connection: %v", err)
+	}
+}
+
+func TestTransportMaxConnsPerHostHTTP2Closed(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	tr.MaxConnsPerHost = 1
+	addr := ts.Listener.Addr().String()
+
+	res, err := c.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if res.ProtoMajor != 2 {
+		t.Fatalf("got HTTP/%d; want HTTP/2", res.ProtoMajor)
+	}
+	if active, idle := tr.ConnsPerHost(addr); active != 0 || idle != 1 {
+		t.Errorf("ConnsPerHost after request = %d, %d; want 0, 1", active, idle)
+	}
+
+	// The closed connection stops counting without waiting
+	// for another request to find it dead.
+	ts.CloseClientConnections()
+	if !waitCondition(5*time.Second, 10*time.Millisecond, func() bool {
+		active, idle := tr.ConnsPerHost(addr)
+		return active == 0 && idle == 0
+	}) {
+		active, idle := tr.ConnsPerHost(addr)
+		t.Errorf("ConnsPerHost after close = %d, %d; want 0, 0", active, idle)
+	}
+}
+
 func TestTransportRemovesDeadIdleConnections(t *testing.T) {
 	setParallel(t)
 	defer afterTest(t)
@@ -1645,6 +1733,91 @@ func TestTransportGzipRecursive(t *testing.T) {
 	}
 }
 
+func TestTransportDisableAutoDecompress_h1(t *testing.T) {
+	testTransportDisableAutoDecompress(t, h1Mode)
+}
+func TestTransportDisableAutoDecompress_h2(t *testing.T) {
+	testTransportDisableAutoDecompress(t, h2Mode)
+}
+
+func testTransportDisableAutoDecompress(t *testing.T, h2 bool) {
+	setParallel(t)
+	defer afterTest(t)
+	cst := newClientServerTest(t, h2, HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Accept-Encoding", r.Header.Get("Accept-Encoding"))
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(rgz)
+	}))
+	defer cst.close()
+	ts, c := cst.ts, cst.c
+
+	for _, disabled := range []bool{false, true} {
+		ctx := context.Background()
+		if disabled {
+			ctx = DisableAutoDecompress(ctx)
+		}
+		req, _ := NewRequestWithContext(ctx, "GET", ts.URL, nil)
+		res, err := c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wantAE := "gzip"
+		if disabled && h2 {
+			wantAE = "identity"
+		} else if disabled {
+			wantAE = ""
+		}
+		if got := res.Header.Get("X-Accept-Encoding"); got != wantAE {
+			t.Errorf("disabled=%v: server got Accept-Encoding %q; want %q", disabled, got, wantAE)
+		}
+		if res.Uncompressed == disabled {
+			t.Errorf("disabled=%v: Response.Uncompressed = %v", disabled, res.Uncompressed)
+		}
+		if disabled && (!bytes.Equal(body, rgz) || res.Header.Get("Content-Encoding") != "gzip") {
+			t.Errorf("disabled=%v: got body %x with Content-Encoding %q; want it unchanged", disabled, body, res.Header.Get("Content-Encoding"))
+		}
+	}
+}
+
+func TestTransportDrainBody(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	ts := httptest.NewServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		w.Header().Set("X-Addr", r.RemoteAddr)
+		n, _ := strconv.Atoi(r.FormValue("n"))
+		w.Write(bytes.Repeat([]byte("x"), n))
+	}))
+	defer ts.Close()
+	c := ts.Client()
+
+	// get makes a request for a body of n bytes, drains it,
+	// and returns the client address the server saw.
+	get := func(n int64) string {
+		t.Helper()
+		res, err := c.Get(ts.URL + "/?n=" + strconv.FormatInt(n, 10))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := DrainBody(res); err != nil {
+			t.Fatalf("DrainBody = %v", err)
+		}
+		return res.Header.Get("X-Addr")
+	}
+	addr1 := get(1 << 10)
+	addr2 := get(MaxDrainBodyBytes + 1<<10)
+	if addr1 != addr2 {
+		t.Errorf("connection not reused after draining a short body")
+	}
+	if addr3 := get(0); addr3 == addr2 {
+		t.Errorf("connection reused after DrainBody gave up on a long body")
+	}
+}
+
 // golang.org/issue/7750: request fails when server replies with
 // a short gzip body
 func TestTransportGzipShort(t *testing.T) {
@@ -4958,6 +5131,182 @@ func testTransportIdleConnTimeout(t *testing.T, h2 bool) {
 	}
 }
 
+func TestTransportDialDisabled(t *testing.T) {
+	setParallel(t)
+	defer afterTest(t)
+	var newConns int32 // accessed atomically
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {}))
+	ts.Config.ConnState = func(c net.Conn, state ConnState) {
+		if state == StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	ts.Start()
+	defer ts.Close()
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	tr.DialDisabled = true
+	defer tr.CloseIdleConnections()
+
+	if _, err := c.Get(ts.URL); !errors.Is(err, ErrNoIdleConn) {
+		t.Fatalf("Get with no idle connections = %v; want ErrNoIdleConn", err)
+	}
+	if err := tr.PrewarmConns(ts.Listener.Addr().String(), 1); err == nil {
+		t.Errorf("PrewarmConns without a scheme succeeded; want error")
+	}
+	if err := tr.PrewarmConns(ts.URL, 2); err != nil {
+		t.Fatalf("PrewarmConns = %v", err)
+	}
+	// Two concurrent requests each get a prewarmed connection.
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res, err := c.Get(ts.URL)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			res.Body.Close()
+		}()
+	}
+	wg.Wait()
+	if n := atomic.LoadInt32(&newConns); n != 2 {
+		t.Errorf("server saw %d connections; want 2", n)
+	}
+}
+
+func TestTCPBuffers(t *testing.T) {
+	if !canReadTCPBufferSizes {
+		t.Skipf("reading socket buffer sizes is not supported on %s", runtime.GOOS)
+	}
+	setParallel(t)
+	defer afterTest(t)
+	// The size is unlike the systems' defaults, so that the sizes read
+	// back below can't match by chance.
+	const size = 48 << 10
+	type sizes struct {
+		send, recv int
+		err        error
+	}
+	// The sizes are read as soon as each side has its connection,
+	// since the server closes it after echoing a large body.
+	serverSizes := make(chan sizes, 1)
+	var clientSizes sizes
+	body := strings.Repeat("x", 1<<20)
+	ts := httptest.NewUnstartedServer(HandlerFunc(func(w ResponseWriter, r *Request) {
+		io.Copy(w, r.Body)
+	}))
+	ts.Config.TCPSendBuffer = size
+	ts.Config.TCPRecvBuffer = size
+	ts.Config.ConnState = func(c net.Conn, state ConnState) {
+		if state == StateNew {
+			var s sizes
+			s.send, s.recv, s.err = tcpBufferSizes(c)
+			serverSizes <- s
+		}
+	}
+	ts.StartTLS()
+	defer ts.Close()
+	c := ts.Client()
+	tr := c.Transport.(*Transport)
+	tr.TCPSendBuffer = size
+	tr.TCPRecvBuffer = size
+
+	req, _ := NewRequest("POST", ts.URL, strings.NewReader(body))
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+		GotConn: func(info httptrace.GotConnInfo) {
+			clientSizes.send, clientSizes.recv, clientSizes.err = tcpBufferSizes(info.Conn)
+		},
+	}))
+	res, err := c.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := io.ReadAll(res.Body)
+	res.Body.Close()
+	if err != nil || string(got) != body {
+		t.Fatalf("got %d bytes, %v; want %d bytes echoed", len(got), err, len(body))
+	}
+
+	for _, side := range []struct {
+		name string
+		sizes
+	}{
+		{"client", clientSizes},
+		{"server", <-serverSizes},
+	} {
+		if side.err != nil {
+			t.Errorf("%s: %v", side.name, side.err)
+			continue
+		}
+		// Linux reports twice the size set, to account for its
+		// bookkeeping overhead.
+		if side.send != size && side.send != 2*size || side.recv != size && side.recv != 2*size {
+			t.Errorf("%s: SO_SNDBUF = %d, SO_RCVBUF = %d; want %d or %d", side.name, side.send, side.recv, size, 2*size)
+		}
+	}
+}
+
+func TestTransportIdleConnCheck(t *testing.T) {
+	defer afterTest(t)
+	cst := newClientServerTest(t, h1Mode, HandlerFunc(func(w ResponseWriter, r *Request) {
+		// No body for convenience.
+	}))
+	defer cst.close()
+
+	var (
+		mu      sync.Mutex
+		checked []net.Conn
+		reject  bool
+	)
+	cst.tr.IdleConnCheck = func(c net.Conn) bool {
+		// The check runs without the idle pool locked, and
+		// with c taken out of it.
+		if idle := cst.tr.IdleConnStrsForTesting(); len(idle) != 0 {
+			t.Errorf("idle conns during IdleConnCheck = %q; want none", idle)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		checked = append(checked, c)
+		return !reject
+	}
+
+	var reused []bool
+	doReq := func() {
+		req, _ := NewRequest("GET", cst.ts.URL, nil)
+		req = req.WithContext(httptrace.WithClientTrace(req.Context(), &httptrace.ClientTrace{
+			GotConn: func(ci httptrace.GotConnInfo) {
+				reused = append(reused, ci.Reused)
+			},
+		}))
+		res, err := cst.c.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res.Body.Close()
+		waitCondition(5*time.Second, 10*time.Millisecond, func() bool {
+			return len(cst.tr.IdleConnStrsForTesting()) == 1
+		})
+	}
+	doReq() // dials
+	doReq() // check passes; connection reused
+	mu.Lock()
+	reject = true
+	mu.Unlock()
+	doReq() // check fails; dials again
+
+	if want := []bool{false, true, false}; !reflect.DeepEqual(reused, want) {
+		t.Errorf("GotConn Reused = %v; want %v", reused, want)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(checked) != 2 {
+		t.Errorf("IdleConnCheck called %d times; want 2", len(checked))
+	}
+}
+
 // Issue 16208: Go 1.7 crashed after Transport.IdleConnTimeout if an
 // HTTP/2 connection was established but its caller no longer
 // wanted it. (Assuming the connection cache was enabled, which it is
@@ -5890,7 +6239,12 @@ func TestTransportClone(t *testing.T) {
 		MaxIdleConns:           1,
 		MaxIdleConnsPerHost:    1,
 		MaxConnsPerHost:        1,
+		MaxConnsPerHostTimeout: time.Second,
+		DNSCache:               new(TTLDNSCache),
+		FallbackDelay:          time.Second,
+		DialDisabled:           true,
 		IdleConnTimeout:        time.Second,
+		IdleConnCheck:          func(net.Conn) bool { panic("") },
 		ResponseHeaderTimeout:  time.Second,
 		ExpectContinueTimeout:  time.Second,
 		ProxyConnectHeader:     Header{},
@@ -5902,6 +6256,8 @@ func TestTransportClone(t *testing.T) {
 		},
 		ReadBufferSize:  1,
 		WriteBufferSize: 1,
+		TCPSendBuffer:   1,
+		TCPRecvBuffer:   1,
 	}
 	tr2 := tr.Clone()
 	rv := reflect.ValueOf(tr2).Elem()
@@ -987,6 +987,67 @@ func (v Values) Encode() string {
 	return buf.String()
 }
 
+// EncodeOptions controls the output of Values.EncodeSorted.
+type EncodeOptions struct {
+	// SortValues sorts the values of each key instead of
+	// keeping them in the order they were added.
+	SortValues bool
+
+	// PercentEncodeSpaces encodes spaces as "%20" instead of "+".
+	PercentEncodeSpaces bool
+}
+
+// EncodeSorted encodes the values into ``URL encoded'' form
+// ("bar=baz&foo=quux") like Encode, as configured by opts.
+//
+// Encode sorts keys before escaping them; EncodeSorted instead sorts
+// keys, and values if opts.SortValues is set, by their encoded form,
+// which can order keys differently when they contain characters that
+// are escaped. Together with
+// opts.PercentEncodeSpaces, this produces the canonical query
+// strings required by request signing schemes such as AWS
+// Signature Version 4.
+func (v Values) EncodeSorted(opts EncodeOptions) string {
+	if v == nil {
+		return ""
+	}
+	escape := QueryEscape
+	if opts.PercentEncodeSpaces {
+		escape = func(s string) string {
+			// QueryEscape escapes '+', so any '+' it returns is a space.
+			return strings.ReplaceAll(QueryEscape(s), "+", "%20")
+		}
+	}
+	type param struct {
+		key  string
+		vals []string
+	}
+	params := make([]param, 0, len(v))
+	for k, vs := range v {
+		p := param{key: escape(k), vals: make([]string, len(vs))}
+		for i, val := range vs {
+			p.vals[i] = escape(val)
+		}
+		if opts.SortValues {
+			sort.Strings(p.vals)
+		}
+		params = append(params, p)
+	}
+	sort.Slice(params, func(i, j int) bool { return params[i].key < params[j].key })
+	var buf strings.Builder
+	for _, p := range params {
+		for _, val := range p.vals {
+			if buf.Len() > 0 {
+				buf.WriteByte('&')
+			}
+			buf.WriteString(p.key)
+			buf.WriteByte('=')
+			buf.WriteString(val)
+		}
+	}
+	return buf.String()
+}
+
 // resolvePath applies special path segments from refs and applies
 // them to base, per RFC 3986.
 func resolvePath(base, ref string) string {
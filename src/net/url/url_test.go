@@ -1089,6 +1089,34 @@ func TestEncodeQuery(t *testing.T) {
 	}
 }
 
+var encodeSortedTests = []struct {
+	m        Values
+	opts     EncodeOptions
+	expected string
+}{
+	{nil, EncodeOptions{}, ""},
+	{Values{"q": {"dogs", "&", "7"}}, EncodeOptions{}, "q=dogs&q=%26&q=7"},
+	{Values{"q": {"dogs", "&", "7"}}, EncodeOptions{SortValues: true}, "q=%26&q=7&q=dogs"},
+	{Values{"q": {"a b+c"}}, EncodeOptions{}, "q=a+b%2Bc"},
+	{Values{"q": {"a b+c"}}, EncodeOptions{PercentEncodeSpaces: true}, "q=a%20b%2Bc"},
+	// Keys are sorted by their encoded form.
+	{Values{"a b": {"1"}, "a!": {"2"}}, EncodeOptions{}, "a%21=2&a+b=1"},
+	{Values{"a b": {"1"}, "a!": {"2"}}, EncodeOptions{PercentEncodeSpaces: true}, "a%20b=1&a%21=2"},
+	{Values{"empty": {}, "k": {""}}, EncodeOptions{}, "k="},
+	{Values{
+		"b": {"b2", "b1"},
+		"a": {"a 2", "a1"},
+	}, EncodeOptions{SortValues: true, PercentEncodeSpaces: true}, "a=a%202&a=a1&b=b1&b=b2"},
+}
+
+func TestEncodeSorted(t *testing.T) {
+	for _, tt := range encodeSortedTests {
+		if q := tt.m.EncodeSorted(tt.opts); q != tt.expected {
+			t.Errorf(`EncodeSorted(%+v, %+v) = %q, want %q`, tt.m, tt.opts, q, tt.expected)
+		}
+	}
+}
+
 var resolvePathTests = []struct {
 	base, ref, expected string
 }{